@@ -610,8 +610,14 @@ var recordSubstateCommand = func() *cli.Command {
 	*c = *importCommand
 	c.Action = func(ctx *cli.Context) error {
 		core.RecordSubstate = true
+		core.RecordBlockSubstate = ctx.Bool(core.RecordBlockSubstateFlag.Name)
+		core.RecordTraces = ctx.Bool(core.RecordTracesFlag.Name)
 		core.SkipCheckReplay = ctx.Bool(core.SkipCheckReplayFlag.Name)
 
+		if err := setRecordChain(ctx); err != nil {
+			return err
+		}
+
 		research.SetSubstateFlags(ctx)
 		research.OpenSubstateDB()
 		defer research.CloseSubstateDB()
@@ -621,9 +627,69 @@ var recordSubstateCommand = func() *cli.Command {
 	c.Name = "record-substate"
 	c.Usage = "(record-replay) Record substates during geth import"
 	c.Flags = flags.Merge(c.Flags, []cli.Flag{
-		research.SubstateDirFlag,
+		research.SubstateDbFlag,
+		core.RecordBlockSubstateFlag,
+		core.RecordTracesFlag,
 		core.SkipCheckReplayFlag,
 		research.AsyncDbWriteFlag,
+		research.ChainFlag,
+		research.GenesisFlag,
 	})
 	return c
 }()
+
+// record-replay: setRecordChain selects the chain of record-substate with --chain
+// (network preset flag) or --genesis (genesis written to the database like geth init)
+func setRecordChain(ctx *cli.Context) error {
+	chain := ctx.String(research.ChainFlag.Name)
+	genesisPath := ctx.Path(research.GenesisFlag.Name)
+	if chain != "" && genesisPath != "" {
+		return fmt.Errorf("--%s and --%s are exclusive", research.ChainFlag.Name, research.GenesisFlag.Name)
+	}
+
+	if chain != "" {
+		if _, err := research.ChainConfigByName(chain); err != nil {
+			return err
+		}
+		networkFlags := map[string]string{
+			"mainnet": utils.MainnetFlag.Name,
+			"sepolia": utils.SepoliaFlag.Name,
+			"holesky": utils.HoleskyFlag.Name,
+			"goerli":  utils.GoerliFlag.Name,
+		}
+		return ctx.Set(networkFlags[chain], "true")
+	}
+
+	if genesisPath != "" {
+		file, err := os.Open(genesisPath)
+		if err != nil {
+			return fmt.Errorf("failed to read genesis file: %v", err)
+		}
+		defer file.Close()
+
+		genesis := new(core.Genesis)
+		if err := json.NewDecoder(file).Decode(genesis); err != nil {
+			return fmt.Errorf("invalid genesis file: %v", err)
+		}
+
+		stack, _ := makeConfigNode(ctx)
+		defer stack.Close()
+
+		chaindb, err := stack.OpenDatabaseWithFreezer("chaindata", 0, 0, ctx.String(utils.AncientFlag.Name), "", false)
+		if err != nil {
+			return fmt.Errorf("failed to open database: %v", err)
+		}
+		defer chaindb.Close()
+
+		triedb := utils.MakeTrieDatabase(ctx, chaindb, ctx.Bool(utils.CachePreimagesFlag.Name), false, genesis.IsVerkle())
+		defer triedb.Close()
+
+		// fails if the database already has a different genesis
+		_, hash, err := core.SetupGenesisBlock(chaindb, triedb, genesis)
+		if err != nil {
+			return fmt.Errorf("failed to write genesis block: %v", err)
+		}
+		log.Info("Successfully wrote genesis state", "database", "chaindata", "hash", hash)
+	}
+	return nil
+}
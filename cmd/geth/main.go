@@ -30,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/console/prompt"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/eth"
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -39,12 +40,16 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/research"
 	"go.uber.org/automaxprocs/maxprocs"
 
 	// Force-load the tracer engines to trigger registration
 	_ "github.com/ethereum/go-ethereum/eth/tracers/js"
 	_ "github.com/ethereum/go-ethereum/eth/tracers/native"
 
+	// Register the sqlite3 substate DB backend for --substate-db
+	_ "github.com/ethereum/go-ethereum/research/sqlitedb"
+
 	"github.com/urfave/cli/v2"
 )
 
@@ -148,6 +153,16 @@ var (
 		utils.LogBacktraceAtFlag,
 	}, utils.NetworkFlags, utils.DatabaseFlags)
 
+	// record-replay: flags to record substates live from a syncing node
+	substateFlags = []cli.Flag{
+		core.RecordSubstateFlag,
+		core.RecordBlockSubstateFlag,
+		core.RecordTracesFlag,
+		research.SubstateDbFlag,
+		core.SkipCheckReplayFlag,
+		research.AsyncDbWriteFlag,
+	}
+
 	rpcFlags = []cli.Flag{
 		utils.HTTPEnabledFlag,
 		utils.HTTPListenAddrFlag,
@@ -250,6 +265,7 @@ func init() {
 		consoleFlags,
 		debug.Flags,
 		metricsFlags,
+		substateFlags,
 	)
 	flags.AutoEnvVars(app.Flags, "GETH")
 
@@ -340,6 +356,24 @@ func geth(ctx *cli.Context) error {
 	}
 
 	prepare(ctx)
+
+	// record-replay: record substates of canonical blocks during full sync,
+	// substate DB is closed after the blockchain is stopped by stack.Close
+	if ctx.Bool(core.RecordSubstateFlag.Name) {
+		if mode := flags.GlobalTextMarshaler(ctx, utils.SyncModeFlag.Name).(*downloader.SyncMode); *mode != downloader.FullSync {
+			return fmt.Errorf("--%s requires --%s full", core.RecordSubstateFlag.Name, utils.SyncModeFlag.Name)
+		}
+		core.RecordSubstate = true
+		core.RecordSubstateLive = true
+		core.RecordBlockSubstate = ctx.Bool(core.RecordBlockSubstateFlag.Name)
+		core.RecordTraces = ctx.Bool(core.RecordTracesFlag.Name)
+		core.SkipCheckReplay = ctx.Bool(core.SkipCheckReplayFlag.Name)
+
+		research.SetSubstateFlags(ctx)
+		research.OpenSubstateDB()
+		defer research.CloseSubstateDB()
+	}
+
 	stack, backend := makeFullNode(ctx)
 	defer stack.Close()
 
@@ -3,7 +3,6 @@ package db
 import (
 	"fmt"
 
-	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/research"
 	cli "github.com/urfave/cli/v2"
 )
@@ -15,14 +14,16 @@ var DbCloneCommand = &cli.Command{
 	Flags: []cli.Flag{
 		research.WorkersFlag,
 		research.BlockSegmentFlag,
-		&cli.PathFlag{
+		research.CheckpointFlag,
+		research.ResumeFlag,
+		&cli.StringFlag{
 			Name:     "src-path",
-			Usage:    "Source DB path",
+			Usage:    "Source substate DB in \"backend,URI\" format or LevelDB path",
 			Required: true,
 		},
-		&cli.PathFlag{
+		&cli.StringFlag{
 			Name:     "dst-path",
-			Usage:    "Destination DB path",
+			Usage:    "Destination substate DB in \"backend,URI\" format or LevelDB path",
 			Required: true,
 		},
 	},
@@ -37,25 +38,33 @@ The dst-path will always store substates in the latest encoding.
 func dbClone(ctx *cli.Context) error {
 	var err error
 
-	srcPath := ctx.Path("src-path")
-	srcBackend, err := rawdb.NewLevelDBDatabase(srcPath, 1024, 100, "srcDB", true)
+	srcPath := ctx.String("src-path")
+	srcDB, err := research.OpenSubstateDBBackend(srcPath, true)
 	if err != nil {
 		return fmt.Errorf("substate-cli db-clone: error opening %s: %w", srcPath, err)
 	}
-	srcDB := research.NewSubstateDB(srcBackend)
 	defer srcDB.Close()
 
 	// Create dst DB
-	dstPath := ctx.Path("dst-path")
-	dstBackend, err := rawdb.NewLevelDBDatabase(dstPath, 1024, 100, "srcDB", false)
+	dstPath := ctx.String("dst-path")
+	dstDB, err := research.OpenSubstateDBBackend(dstPath, false)
 	if err != nil {
 		return fmt.Errorf("substate-cli db-clone: error creating %s: %w", dstPath, err)
 	}
-	dstDB := research.NewSubstateDB(dstBackend)
 	defer dstDB.Close()
 
 	cloneTask := func(block uint64, tx int, substate *research.Substate, taskPool *research.SubstateTaskPool) error {
 		dstDB.PutSubstate(block, tx, substate)
+		if trace := taskPool.DB.GetTxTrace(block, tx); trace != nil {
+			dstDB.PutTxTrace(block, tx, trace)
+		}
+		return nil
+	}
+
+	cloneBlockTask := func(block uint64, blockSubstate *research.BlockSubstate, taskPool *research.SubstateTaskPool) error {
+		if blockSubstate != nil {
+			dstDB.PutBlockSubstate(block, blockSubstate)
+		}
 		return nil
 	}
 
@@ -64,6 +73,8 @@ func dbClone(ctx *cli.Context) error {
 		TaskFunc: cloneTask,
 		Config:   research.NewSubstateTaskConfigCli(ctx),
 
+		BlockTaskFunc: cloneBlockTask,
+
 		DB: srcDB,
 	}
 
@@ -73,6 +84,14 @@ func dbClone(ctx *cli.Context) error {
 	}
 
 	err = taskPool.ExecuteSegment(segment)
+	if err != nil {
+		return err
+	}
+
+	err = research.CopyMetadata(srcDB, dstDB, segment)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-clone: error copying metadata: %w", err)
+	}
 
-	return err
+	return nil
 }
@@ -3,14 +3,12 @@ package db
 import (
 	"fmt"
 	"math"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/research"
-	"github.com/syndtr/goleveldb/leveldb"
-	leveldb_opt "github.com/syndtr/goleveldb/leveldb/opt"
-	leveldb_util "github.com/syndtr/goleveldb/leveldb/util"
 	cli "github.com/urfave/cli/v2"
 )
 
@@ -19,7 +17,7 @@ var DbCompactCommand = &cli.Command{
 	Name:   "db-compact",
 	Usage:  "Run compaction functionality of the backend DB",
 	Flags: []cli.Flag{
-		research.SubstateDirFlag,
+		research.SubstateDbFlag,
 	},
 	Description: `
 The substate-cli db-compact command runs compaction functionality of
@@ -30,18 +28,17 @@ the backend of the given substate DB`,
 func dbCompact(ctx *cli.Context) error {
 	var err error
 
-	dbPath := ctx.Path(research.SubstateDirFlag.Name)
-	dbOpt := &leveldb_opt.Options{
-		BlockCacheCapacity:     1 * leveldb_opt.GiB,
-		OpenFilesCacheCapacity: 50,
-
-		ErrorIfMissing: true,
-		ReadOnly:       false,
+	dbPath := ctx.String(research.SubstateDbFlag.Name)
+	// compaction must not create a new empty substate DB
+	_, uri := research.ParseSubstateDbArg(dbPath)
+	if _, err := os.Stat(uri); err != nil {
+		return fmt.Errorf("substate-cli db-compact: error opening dbPath %s: %w", dbPath, err)
 	}
-	db, err := leveldb.OpenFile(dbPath, dbOpt)
+	db, err := research.OpenBackendDatabase(dbPath, false)
 	if err != nil {
 		return fmt.Errorf("substate-cli db-compact: error opening dbPath %s: %w", dbPath, err)
 	}
+	defer db.Close()
 
 	start := time.Now()
 	fmt.Printf("substate-cli db-compact: compaction begin\n")
@@ -55,7 +52,7 @@ func dbCompact(ctx *cli.Context) error {
 			if b+s >= m {
 				end = research.Stage1SubstateKey(math.MaxUint64, math.MaxInt)
 			}
-			err = db.CompactRange(leveldb_util.Range{Start: start, Limit: end})
+			err = db.Compact(start, end)
 			if err != nil {
 				panic(fmt.Errorf("substate-cli db-compact: error compacting dbPath %s: %w", dbPath, err))
 			}
@@ -68,7 +65,7 @@ func dbCompact(ctx *cli.Context) error {
 			if b == 255 {
 				end = research.Stage1CodeKey(common.MaxHash)
 			}
-			err = db.CompactRange(leveldb_util.Range{Start: start, Limit: end})
+			err = db.Compact(start, end)
 			if err != nil {
 				panic(fmt.Errorf("substate-cli db-compact: error compacting dbPath %s: %w", dbPath, err))
 			}
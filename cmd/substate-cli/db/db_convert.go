@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+)
+
+var DbConvertCommand = &cli.Command{
+	Action: dbConvert,
+	Name:   "db-convert",
+	Usage:  "Convert substate DB of a given block segment to another backend",
+	Flags: []cli.Flag{
+		research.WorkersFlag,
+		research.BlockSegmentFlag,
+		research.CheckpointFlag,
+		research.ResumeFlag,
+		&cli.StringFlag{
+			Name:     "src",
+			Usage:    "Source substate DB in \"backend,URI\" format, e.g., \"leveldb,substate.ethereum\"",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "dst",
+			Usage:    "Destination substate DB in \"backend,URI\" format, e.g., \"pebble,substate.pebble\"",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "Read back each converted substate from dst and compare its Protobuf encoding with src",
+			Value: true,
+		},
+	},
+	Description: `
+substate-cli db-convert copies substates and bytecodes of a given block segment
+from src to dst substate DB which may use a different backend.
+Bytecodes already stored in dst are not written again.
+Substates already stored in dst with the same encoding are skipped,
+so an interrupted db-convert resumes by running the same command again.
+`,
+	Category: "db",
+}
+
+func dbConvert(ctx *cli.Context) error {
+	var err error
+
+	srcArg := ctx.String("src")
+	srcDB, err := research.OpenSubstateDBBackend(srcArg, true)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-convert: error opening %s: %w", srcArg, err)
+	}
+	defer srcDB.Close()
+
+	dstArg := ctx.String("dst")
+	dstDB, err := research.OpenSubstateDBBackend(dstArg, false)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-convert: error creating %s: %w", dstArg, err)
+	}
+	defer dstDB.Close()
+
+	converter := research.NewSubstateConverter(srcDB, dstDB, ctx.Bool("verify"))
+
+	taskPool := &research.SubstateTaskPool{
+		Name:     "substate-cli db-convert",
+		TaskFunc: converter.TaskFunc,
+		Config:   research.NewSubstateTaskConfigCli(ctx),
+
+		BlockTaskFunc: converter.BlockTaskFunc,
+
+		DB: srcDB,
+	}
+
+	segment, err := research.ParseBlockSegment(ctx.String(research.BlockSegmentFlag.Name))
+	if err != nil {
+		return fmt.Errorf("substate-cli db-convert: error parsing block segment: %s", err)
+	}
+
+	err = taskPool.ExecuteSegment(segment)
+
+	fmt.Printf("substate-cli db-convert: %v-%v: converted %v substates, skipped %v existing substates\n", segment.First, segment.Last, converter.NumConverted, converter.NumSkipped)
+	if err != nil {
+		return err
+	}
+
+	err = converter.CopyMetadata(segment)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-convert: error copying metadata: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+)
+
+var DbDeleteCommand = &cli.Command{
+	Action: dbDelete,
+	Name:   "db-delete",
+	Usage:  "Delete substates, block substates and call traces of a given block segment",
+	Flags: []cli.Flag{
+		research.BlockSegmentFlag,
+		research.SubstateDbFlag,
+	},
+	Description: `
+substate-cli db-delete deletes substates, block substates and call traces of
+the given block segment, and removes the blocks from the recorded block ranges
+of the metadata. Bytecodes are kept because substates of other blocks may
+reference them; run substate-cli db-gc afterwards to delete bytecodes that are
+no longer referenced.
+`,
+	Category: "db",
+}
+
+func dbDelete(ctx *cli.Context) error {
+	start := time.Now()
+
+	segment, err := research.ParseBlockSegment(ctx.String(research.BlockSegmentFlag.Name))
+	if err != nil {
+		return fmt.Errorf("substate-cli db-delete: error parsing block segment: %w", err)
+	}
+
+	dbArg := ctx.String(research.SubstateDbFlag.Name)
+	backend, err := research.OpenBackendDatabase(dbArg, false)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-delete: error opening %s: %w", dbArg, err)
+	}
+	defer backend.Close()
+	db := research.NewSubstateDB(backend)
+
+	n, err := db.DeleteSegment(segment)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-delete: %w", err)
+	}
+
+	fmt.Printf("substate-cli db-delete: elapsed time: %v\n", time.Since(start).Round(1*time.Millisecond))
+	fmt.Printf("substate-cli db-delete: deleted %v keys of blocks %v-%v\n", n, segment.First, segment.Last)
+	return nil
+}
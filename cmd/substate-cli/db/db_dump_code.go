@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/research"
 	"github.com/status-im/keycard-go/hexutils"
@@ -21,7 +20,7 @@ var DbDumpCodeCommand = &cli.Command{
 	Name:   "db-dump-code",
 	Usage:  "Dump all bytecodes stored in substate DB",
 	Flags: []cli.Flag{
-		research.SubstateDirFlag,
+		research.SubstateDbFlag,
 		&cli.PathFlag{
 			Name:  "out-dir",
 			Usage: "output directory to save dumped bytecode",
@@ -52,12 +51,13 @@ func dbDumpCode(ctx *cli.Context) error {
 		return err
 	}
 
-	dbPath := ctx.Path(research.SubstateDirFlag.Name)
+	dbPath := ctx.String(research.SubstateDbFlag.Name)
 	fmt.Printf("substate-cli: db-dump-code: dbPath: %s\n", dbPath)
-	db, err := rawdb.NewLevelDBDatabase(dbPath, 1024, 100, "substatedir", true)
+	db, err := research.OpenBackendDatabase(dbPath, true)
 	if err != nil {
 		return fmt.Errorf("substate-cli: db-dump-code: error opening dbPath %s: %v", dbPath, err)
 	}
+	defer db.Close()
 
 	deployedOnly := ctx.Bool("deployed-only")
 
@@ -19,7 +19,9 @@ var DbExportCommand = &cli.Command{
 	Flags: []cli.Flag{
 		research.WorkersFlag,
 		research.BlockSegmentFlag,
-		research.SubstateDirFlag,
+		research.CheckpointFlag,
+		research.ResumeFlag,
+		research.SubstateDbFlag,
 		&cli.PathFlag{
 			Name:  "out-dir",
 			Usage: "output directory to save exported substates",
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/rpc"
+	cli "github.com/urfave/cli/v2"
+)
+
+var FetchCommand = &cli.Command{
+	Action: fetch,
+	Name:   "fetch",
+	Usage:  "Fetch substates of a block segment from substate JSON-RPC of an archive node",
+	Flags: []cli.Flag{
+		research.WorkersFlag,
+		research.BlockSegmentFlag,
+		research.SubstateDbFlag,
+		&cli.StringFlag{
+			Name:     "rpc",
+			Usage:    "JSON-RPC URL of a record-replay geth node with substate namespace (e.g., http://localhost:8545)",
+			Required: true,
+		},
+	},
+	Description: `
+substate-cli fetch calls substate_getBlockSubstates of a record-replay geth
+node (e.g., an archive node with --http.api substate) for each block of the
+block segment and writes the returned substates into the substate DB.
+It also writes metadata with the chain ID, genesis hash and fetched block ranges.
+`,
+	Category: "db",
+}
+
+func fetch(ctx *cli.Context) error {
+	var err error
+
+	segment, err := research.ParseBlockSegment(ctx.String(research.BlockSegmentFlag.Name))
+	if err != nil {
+		return fmt.Errorf("substate-cli fetch: error parsing block segment: %s", err)
+	}
+
+	url := ctx.String("rpc")
+	client, err := rpc.DialContext(ctx.Context, url)
+	if err != nil {
+		return fmt.Errorf("substate-cli fetch: error connecting %s: %w", url, err)
+	}
+	defer client.Close()
+
+	// metadata of the chain served by the node
+	var (
+		chainID       hexutil.Big
+		clientVersion string
+		genesis       struct {
+			Hash common.Hash `json:"hash"`
+		}
+	)
+	if err = client.CallContext(ctx.Context, &chainID, "eth_chainId"); err != nil {
+		return fmt.Errorf("substate-cli fetch: error getting chain ID: %w", err)
+	}
+	if err = client.CallContext(ctx.Context, &genesis, "eth_getBlockByNumber", hexutil.Uint64(0), false); err != nil {
+		return fmt.Errorf("substate-cli fetch: error getting genesis block: %w", err)
+	}
+	if err = client.CallContext(ctx.Context, &clientVersion, "web3_clientVersion"); err != nil {
+		return fmt.Errorf("substate-cli fetch: error getting client version: %w", err)
+	}
+	fmt.Printf("substate-cli fetch: %s, chain ID %v, genesis %s\n", clientVersion, (*big.Int)(&chainID), genesis.Hash.Hex())
+
+	dbArg := ctx.String(research.SubstateDbFlag.Name)
+	db, err := research.OpenSubstateDBBackend(dbArg, false)
+	if err != nil {
+		return fmt.Errorf("substate-cli fetch: error opening %s: %w", dbArg, err)
+	}
+	defer db.Close()
+
+	metadata := db.GetMetadata()
+	if metadata == nil {
+		metadata = &research.SubstateMetadata{}
+	}
+	err = metadata.Merge(&research.SubstateMetadata{
+		RecordReplayVersion: params.VersionMeta,
+		GethVersion:         clientVersion,
+		ChainID:             (*big.Int)(&chainID),
+		GenesisHash:         genesis.Hash,
+	})
+	if err != nil {
+		return fmt.Errorf("substate-cli fetch: %s has substates of another chain: %w", dbArg, err)
+	}
+	if config := research.ChainConfigByID((*big.Int)(&chainID)); config != nil && db.GetChainConfig() == nil {
+		db.PutChainConfig(config)
+	}
+
+	workers := ctx.Int(research.WorkersFlag.Name)
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		fetched []uint64
+		numTx   int64
+
+		blockChan      = make(chan uint64, workers)
+		fetchCtx, stop = context.WithCancel(ctx.Context)
+		errOnce        sync.Once
+		fetchErr       error
+	)
+	defer stop()
+
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := range blockChan {
+				var results []*tracers.SubstateResult
+				err := client.CallContext(fetchCtx, &results, "substate_getBlockSubstates", hexutil.Uint64(block))
+				if err == nil {
+					for _, r := range results {
+						substate, uerr := r.UnmarshalSubstate()
+						if uerr != nil {
+							err = fmt.Errorf("error decoding substate %v_%v: %w", block, r.Tx, uerr)
+							break
+						}
+						db.PutSubstate(block, int(r.Tx), substate)
+					}
+				}
+				if err != nil {
+					errOnce.Do(func() {
+						fetchErr = fmt.Errorf("substate-cli fetch: block %v: %w", block, err)
+						stop()
+					})
+					continue
+				}
+				atomic.AddInt64(&numTx, int64(len(results)))
+				mu.Lock()
+				fetched = append(fetched, block)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	lastSec := time.Now()
+	for block := segment.First; block <= segment.Last; block++ {
+		if fetchCtx.Err() != nil {
+			break
+		}
+		blockChan <- block
+		if time.Since(lastSec) >= 10*time.Second {
+			lastSec = time.Now()
+			fmt.Printf("substate-cli fetch: elapsed time: %v, number = %v, #tx = %v\n", time.Since(start).Round(time.Millisecond), block, atomic.LoadInt64(&numTx))
+		}
+	}
+	close(blockChan)
+	wg.Wait()
+
+	// record ranges of fetched blocks even if fetch failed
+	for _, block := range fetched {
+		metadata.AddRange(block, block)
+	}
+	db.PutMetadata(metadata)
+
+	fmt.Printf("substate-cli fetch: %v-%v: fetched %v blocks, %v txs in %v\n", segment.First, segment.Last, len(fetched), numTx, time.Since(start).Round(time.Millisecond))
+
+	return fetchErr
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+)
+
+var DbGcCommand = &cli.Command{
+	Action: dbGc,
+	Name:   "db-gc",
+	Usage:  "Delete bytecodes that are not referenced by any substate",
+	Flags: []cli.Flag{
+		research.SubstateDbFlag,
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only count orphaned bytecodes and reclaimable bytes without deleting them",
+		},
+	},
+	Description: `
+substate-cli db-gc collects garbage bytecodes of substate DB, e.g., after
+substate-cli db-delete. It marks code hashes referenced by all substates and
+block substates, and sweeps the other bytecodes. With --dry-run, it only reports
+the number of orphaned bytecodes and their key-value bytes.
+
+db-gc fails without deleting anything if a substate cannot be decoded. Do not
+record substates into the substate DB while db-gc is running.
+`,
+	Category: "db",
+}
+
+func dbGc(ctx *cli.Context) error {
+	start := time.Now()
+	dryRun := ctx.Bool("dry-run")
+
+	dbArg := ctx.String(research.SubstateDbFlag.Name)
+	backend, err := research.OpenBackendDatabase(dbArg, dryRun)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-gc: error opening %s: %w", dbArg, err)
+	}
+	defer backend.Close()
+	db := research.NewSubstateDB(backend)
+
+	result, err := db.CollectCodeGarbage(dryRun)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-gc: %w", err)
+	}
+
+	fmt.Printf("substate-cli db-gc: elapsed time: %v\n", time.Since(start).Round(1*time.Millisecond))
+	verb := "reclaimed"
+	if dryRun {
+		verb = "would reclaim"
+	}
+	fmt.Printf("substate-cli db-gc: %v of %v bytecodes are orphaned, %s %v\n",
+		result.NumOrphans, result.NumCodes, verb, result.ReclaimedBytes)
+	return nil
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+)
+
+var DbIndexCommand = &cli.Command{
+	Action: dbIndex,
+	Name:   "db-index",
+	Usage:  "Build the block index of substate DB",
+	Flags: []cli.Flag{
+		research.SubstateDbFlag,
+	},
+	Description: `
+substate-cli db-index iterates all substate and block substate keys to build
+and store the block index, runs of blocks with substates or block substates
+and their number of transactions. The block index is updated whenever
+substates are put or deleted, so db-index is needed only once for substate
+DBs recorded before rr0.5.1, or again for substate DBs that were not closed.
+`,
+	Category: "db",
+}
+
+func dbIndex(ctx *cli.Context) error {
+	start := time.Now()
+
+	dbArg := ctx.String(research.SubstateDbFlag.Name)
+	db, err := research.OpenSubstateDBBackend(dbArg, false)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-index: error opening %s: %w", dbArg, err)
+	}
+	defer db.Close()
+
+	db.BuildBlockIndex()
+
+	ranges := db.BlockRanges()
+	var numBlock uint64
+	var numTx int64
+	for _, r := range ranges {
+		numBlock += r.Last - r.First + 1
+		numTx += db.CountTxs(r)
+	}
+	fmt.Printf("substate-cli db-index: elapsed time: %v\n", time.Since(start).Round(1*time.Millisecond))
+	fmt.Printf("substate-cli db-index: indexed %v blocks in %v ranges, %v txs\n", numBlock, len(ranges), numTx)
+	return nil
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+)
+
+var DbInfoCommand = &cli.Command{
+	Action: dbInfo,
+	Name:   "db-info",
+	Usage:  "Print metadata, key counts and sizes of substate DB",
+	Flags: []cli.Flag{
+		research.SubstateDbFlag,
+	},
+	Description: `
+substate-cli db-info prints metadata written by geth record-substate: versions
+of record-replay and Geth, chain ID, genesis hash and recorded block ranges.
+It prints ranges of blocks with substates or block substates and their number
+of transactions from the block index. It also iterates all keys to print key
+counts and key-value sizes per key prefix, and the on-disk size of the
+substate DB.
+`,
+	Category: "db",
+}
+
+// prefixStats is the number of keys and key-value bytes of a key prefix
+type prefixStats struct {
+	count int64
+	size  common.StorageSize
+}
+
+var prefixDescriptions = map[string]string{
+	research.Stage1SubstatePrefix: "substates",
+	research.Stage1CodePrefix:     "bytecodes",
+	research.Stage1MetadataPrefix: "metadata",
+	research.Stage1BlockPrefix:    "block substates",
+	research.Stage1TracePrefix:    "call traces",
+}
+
+// diskSize returns the total size of files at path, a file or a directory
+func diskSize(path string) (common.StorageSize, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return common.StorageSize(size), err
+}
+
+func dbInfo(ctx *cli.Context) error {
+	dbArg := ctx.String(research.SubstateDbFlag.Name)
+	backend, err := research.OpenBackendDatabase(dbArg, true)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-info: error opening %s: %w", dbArg, err)
+	}
+	defer backend.Close()
+	db := research.NewSubstateDB(backend)
+
+	fmt.Printf("substate DB: %s\n", dbArg)
+
+	if m := db.GetMetadata(); m != nil {
+		fmt.Printf("record-replay version: %s\n", m.RecordReplayVersion)
+		fmt.Printf("geth version: %s\n", m.GethVersion)
+		fmt.Printf("chain ID: %v\n", m.ChainID)
+		fmt.Printf("genesis hash: %s\n", m.GenesisHash.Hex())
+		fmt.Printf("recorded block ranges:\n")
+		for _, r := range m.Ranges {
+			fmt.Printf("  %v-%v\n", r.First, r.Last)
+		}
+	} else {
+		fmt.Printf("metadata: not found (recorded before rr0.5.1 or not by geth record-substate)\n")
+	}
+	if config := db.GetChainConfig(); config != nil {
+		fmt.Printf("chain config: %s\n", config.Description())
+	}
+	if db.HasBlockIndex() {
+		fmt.Printf("blocks with substates or block substates:\n")
+		for _, r := range db.BlockRanges() {
+			fmt.Printf("  %v-%v: %v txs\n", r.First, r.Last, db.CountTxs(r))
+		}
+	} else {
+		fmt.Printf("block index: not found, build it with substate-cli db-index\n")
+	}
+
+	stats := make(map[string]*prefixStats)
+	iter := backend.NewIterator(nil, nil)
+	for iter.Next() {
+		key := iter.Key()
+		prefix := string(key)
+		if len(prefix) > 2 {
+			prefix = prefix[:2]
+		}
+		s, ok := stats[prefix]
+		if !ok {
+			s = &prefixStats{}
+			stats[prefix] = s
+		}
+		s.count++
+		s.size += common.StorageSize(len(key) + len(iter.Value()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("substate-cli db-info: error iterating %s: %w", dbArg, err)
+	}
+
+	prefixes := make([]string, 0, len(stats))
+	for prefix := range stats {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var total prefixStats
+	for _, prefix := range prefixes {
+		s := stats[prefix]
+		description := prefixDescriptions[prefix]
+		if description == "" {
+			description = "unknown"
+		}
+		fmt.Printf("%q (%s): %v keys, %v\n", prefix, description, s.count, s.size)
+		total.count += s.count
+		total.size += s.size
+	}
+	fmt.Printf("total: %v keys, %v\n", total.count, total.size)
+
+	// on-disk size is available if URI is a local path
+	_, uri := research.ParseSubstateDbArg(dbArg)
+	if _, err := os.Stat(uri); err == nil {
+		size, err := diskSize(uri)
+		if err != nil {
+			return fmt.Errorf("substate-cli db-info: error getting size of %s: %w", uri, err)
+		}
+		fmt.Printf("on-disk size: %v\n", size)
+	}
+
+	return nil
+}
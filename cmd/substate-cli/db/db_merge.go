@@ -0,0 +1,107 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+)
+
+var DbMergeCommand = &cli.Command{
+	Action: dbMerge,
+	Name:   "db-merge",
+	Usage:  "Merge substates of multiple substate DBs into a new substate DB",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:     "src",
+			Usage:    "Source substate DB in \"backend,URI\" format or LevelDB path, repeated from the oldest to the newest",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "dst",
+			Usage:    "Destination substate DB in \"backend,URI\" format or LevelDB path, which must have no substates",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "policy",
+			Usage: fmt.Sprintf("Policy for blocks with conflicting substates (%s)", strings.Join(research.MergePolicies, ", ")),
+			Value: research.MergeFail,
+		},
+	},
+	Description: `
+substate-cli db-merge merges substates, block substates, call traces, bytecodes
+and metadata of source substate DBs into the destination substate DB, e.g.,
+block ranges recorded on different machines. Bytecodes shared by source
+substate DBs are stored only once.
+
+A block recorded in more than one source substate DB is merged if its
+substates are the same, and block substates and call traces recorded in only
+one of them are kept. Otherwise the block is conflicting and --policy decides:
+"fail" stops merging, "prefer-first" keeps the block of the earlier --src, and
+"prefer-newer" replaces it with the block of the later --src.
+
+After merging, db-merge verifies that the destination substate DB has every
+block of the source substate DBs except the conflicting blocks that were not
+taken, and checks its integrity like substate-cli db-verify.
+`,
+	Category: "db",
+}
+
+func dbMerge(ctx *cli.Context) error {
+	start := time.Now()
+
+	dstPath := ctx.String("dst")
+	dstDB, err := research.OpenSubstateDBBackend(dstPath, false)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-merge: error creating %s: %w", dstPath, err)
+	}
+	defer dstDB.Close()
+
+	merger, err := research.NewSubstateMerger(dstDB, ctx.String("policy"))
+	if err != nil {
+		return fmt.Errorf("substate-cli db-merge: %w", err)
+	}
+	merger.Report = func(block uint64, conflict string) {
+		fmt.Printf("substate-cli db-merge: block %v, conflict: %s\n", block, conflict)
+	}
+
+	var srcDBs []*research.SubstateDB
+	defer func() {
+		for _, srcDB := range srcDBs {
+			srcDB.Close()
+		}
+	}()
+	for _, srcPath := range ctx.StringSlice("src") {
+		srcDB, err := research.OpenSubstateDBBackend(srcPath, true)
+		if err != nil {
+			return fmt.Errorf("substate-cli db-merge: error opening %s: %w", srcPath, err)
+		}
+		srcDBs = append(srcDBs, srcDB)
+
+		fmt.Printf("substate-cli db-merge: merging %s\n", srcPath)
+		if err := merger.Merge(srcDB); err != nil {
+			return fmt.Errorf("substate-cli db-merge: error merging %s: %w", srcPath, err)
+		}
+	}
+	fmt.Printf("substate-cli db-merge: merged %v blocks, resolved %v conflicts with policy %s\n",
+		merger.NumBlocks, merger.NumConflicts, merger.Policy)
+
+	// bytecodes of replaced blocks may be orphaned
+	if merger.Policy == research.MergePreferNewer && merger.NumConflicts > 0 {
+		result, err := dstDB.CollectCodeGarbage(false)
+		if err != nil {
+			return fmt.Errorf("substate-cli db-merge: %w", err)
+		}
+		fmt.Printf("substate-cli db-merge: deleted %v orphaned bytecodes\n", result.NumOrphans)
+	}
+
+	fmt.Printf("substate-cli db-merge: verifying %s\n", dstPath)
+	if err := merger.Verify(srcDBs); err != nil {
+		return fmt.Errorf("substate-cli db-merge: verification failed: %w", err)
+	}
+
+	fmt.Printf("substate-cli db-merge: elapsed time: %v\n", time.Since(start).Round(1*time.Millisecond))
+	return nil
+}
@@ -10,8 +10,8 @@ import (
 
 	rr03_research "github.com/ethereum/go-ethereum/cmd/substate-cli/rr03/research"
 	"github.com/ethereum/go-ethereum/core"
-	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/research"
 	"github.com/ethereum/go-ethereum/rlp"
 	cli "github.com/urfave/cli/v2"
@@ -28,14 +28,16 @@ var DbRr03ToRr04Command = &cli.Command{
 	Flags: []cli.Flag{
 		research.WorkersFlag,
 		research.BlockSegmentFlag,
-		&cli.PathFlag{
+		research.CheckpointFlag,
+		research.ResumeFlag,
+		&cli.StringFlag{
 			Name:     "old-path",
-			Usage:    "Old rr0.3 substate DB path, e.g., rr0.3.substate.ethereum)",
+			Usage:    "Old rr0.3 substate DB in \"backend,URI\" format or LevelDB path, e.g., rr0.3.substate.ethereum",
 			Required: true,
 		},
-		&cli.PathFlag{
+		&cli.StringFlag{
 			Name:     "new-path",
-			Usage:    "New rr0.4 substate DB path, e.g., rr0.4.substate.ethereum)",
+			Usage:    "New rr0.4 substate DB in \"backend,URI\" format or LevelDB path, e.g., rr0.4.substate.ethereum",
 			Required: true,
 		},
 		&cli.PathFlag{
@@ -103,8 +105,8 @@ func dbRr03ToRr04(ctx *cli.Context) error {
 
 	core.SkipCheckReplay = ctx.Bool(core.SkipCheckReplayFlag.Name)
 
-	oldPath := ctx.Path("old-path")
-	oldBackend, err := rawdb.NewLevelDBDatabase(oldPath, 1024, 100, "srcDB", true)
+	oldPath := ctx.String("old-path")
+	oldBackend, err := research.OpenBackendDatabase(oldPath, true)
 	if err != nil {
 		return fmt.Errorf("substate-cli db-upgrade: error opening %s: %v", oldPath, err)
 	}
@@ -112,13 +114,15 @@ func dbRr03ToRr04(ctx *cli.Context) error {
 	defer oldDB.Close()
 
 	// Create new rr0.4 DB
-	newPath := ctx.Path("new-path")
-	newBackend, err := rawdb.NewLevelDBDatabase(newPath, 1024, 100, "srcDB", false)
+	newPath := ctx.String("new-path")
+	newBackend, err := research.OpenBackendDatabase(newPath, false)
 	if err != nil {
 		return fmt.Errorf("substate-cli db-upgrade: error creating %s: %v", newPath, err)
 	}
 	newDB := research.NewSubstateDB(newBackend)
 	defer newDB.Close()
+	// rr0.3 substates were recorded only on mainnet
+	newDB.PutChainConfig(params.MainnetChainConfig)
 
 	// Read blockchain file and store tx types
 	bcPath := ctx.Path("blockchain")
@@ -159,7 +163,7 @@ func dbRr03ToRr04(ctx *cli.Context) error {
 		s04.Result = upgradeResult(*s03.Result)
 
 		// Check faithful replay with upgraded substate
-		if err := core.CheckReplay(block, tx, s04); err != nil {
+		if err := core.CheckReplay(params.MainnetChainConfig, block, tx, s04); err != nil {
 			return err
 		}
 
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/research/remotedb"
+	cli "github.com/urfave/cli/v2"
+)
+
+var ServeCommand = &cli.Command{
+	Action: serve,
+	Name:   "serve",
+	Usage:  "Serve substate DB read-only over HTTP JSON-RPC for remote replayers",
+	Flags: []cli.Flag{
+		research.SubstateDbFlag,
+		&cli.StringFlag{
+			Name:  "addr",
+			Usage: "HTTP listening address of substate DB server",
+			Value: "localhost:8645",
+		},
+	},
+	Description: `
+substate-cli serve opens the substate DB read-only and serves hashed substates
+and bytecodes in the "substatedb" JSON-RPC namespace over HTTP.
+Replayers on other machines use the server with --substate-db "remote,host:port".
+`,
+	Category: "db",
+}
+
+func serve(ctx *cli.Context) error {
+	var err error
+
+	dbArg := ctx.String(research.SubstateDbFlag.Name)
+	backend, err := research.OpenBackendDatabase(dbArg, true)
+	if err != nil {
+		return fmt.Errorf("substate-cli serve: error opening %s: %w", dbArg, err)
+	}
+	defer backend.Close()
+
+	rpcServer, err := remotedb.NewServer(backend)
+	if err != nil {
+		return fmt.Errorf("substate-cli serve: error creating server: %w", err)
+	}
+	defer rpcServer.Stop()
+
+	addr := ctx.String("addr")
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("substate-cli serve: error listening %s: %w", addr, err)
+	}
+	httpServer := &http.Server{Handler: rpcServer}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	go func() {
+		<-sigChan
+		fmt.Printf("substate-cli serve: shutting down\n")
+		httpServer.Shutdown(context.Background())
+	}()
+
+	fmt.Printf("substate-cli serve: serving %s at http://%s\n", dbArg, listener.Addr())
+	err = httpServer.Serve(listener)
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+
+	return err
+}
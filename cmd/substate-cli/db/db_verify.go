@@ -0,0 +1,83 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+)
+
+var DbVerifyCommand = &cli.Command{
+	Action: dbVerify,
+	Name:   "db-verify",
+	Usage:  "Check structural integrity of substate DB without executing transactions",
+	Flags: []cli.Flag{
+		research.BlockSegmentFlag,
+		research.SubstateDbFlag,
+		&cli.BoolFlag{
+			Name:  "orphaned-code",
+			Usage: "Also read substates of all blocks to find bytecodes referenced by none of them",
+		},
+	},
+	Description: `
+substate-cli db-verify checks substates, block substates and call traces of
+the given block segment without executing the EVM. It reports keys that cannot
+be decoded, values that cannot be decoded or miss required fields, and code
+hashes that have no bytecode in the substate DB. It also checks that every
+bytecode hashes to its key, and with --orphaned-code, finds bytecodes that no
+substate or block substate references.
+
+db-verify prints each issue with its key and exits with an error if any issue
+is found.
+`,
+	Category: "db",
+}
+
+func dbVerify(ctx *cli.Context) error {
+	start := time.Now()
+
+	segment, err := research.ParseBlockSegment(ctx.String(research.BlockSegmentFlag.Name))
+	if err != nil {
+		return fmt.Errorf("substate-cli db-verify: error parsing block segment: %w", err)
+	}
+
+	dbArg := ctx.String(research.SubstateDbFlag.Name)
+	backend, err := research.OpenBackendDatabase(dbArg, true)
+	if err != nil {
+		return fmt.Errorf("substate-cli db-verify: error opening %s: %w", dbArg, err)
+	}
+	defer backend.Close()
+	db := research.NewSubstateDB(backend)
+
+	v := research.NewSubstateVerifier(db)
+	v.Report = func(issue *research.SubstateVerifyIssue) {
+		fmt.Printf("substate-cli db-verify: %s\n", issue)
+	}
+	if err := v.VerifySegment(segment); err != nil {
+		return fmt.Errorf("substate-cli db-verify: %w", err)
+	}
+	if err := v.VerifyCode(ctx.Bool("orphaned-code")); err != nil {
+		return fmt.Errorf("substate-cli db-verify: %w", err)
+	}
+
+	fmt.Printf("substate-cli db-verify: elapsed time: %v\n", time.Since(start).Round(1*time.Millisecond))
+	fmt.Printf("substate-cli db-verify: verified %v substates, %v block substates, %v call traces and %v bytecodes\n",
+		v.NumSubstates, v.NumBlockSubstates, v.NumTraces, v.NumCodes)
+
+	n := v.NumIssues()
+	if n == 0 {
+		fmt.Printf("substate-cli db-verify: no issues found\n")
+		return nil
+	}
+	kinds := make([]string, 0, len(v.Issues))
+	for kind := range v.Issues {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("substate-cli db-verify: %v %s issues\n", v.Issues[kind], kind)
+	}
+	return fmt.Errorf("substate-cli db-verify: %v issues found", n)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/ethereum/go-ethereum/cmd/substate-cli/replay"
 	rr03_db "github.com/ethereum/go-ethereum/cmd/substate-cli/rr03/db"
 	"github.com/ethereum/go-ethereum/internal/flags"
+	_ "github.com/ethereum/go-ethereum/research/sqlitedb" // sqlite3 substate DB backend
 	cli "github.com/urfave/cli/v2"
 )
 
@@ -17,14 +18,31 @@ var (
 
 func init() {
 	app.Flags = []cli.Flag{}
+	// substate DBs in "backend,URI" format have commas, e.g., db-merge --src
+	app.DisableSliceFlagSeparator = true
 	app.Commands = []*cli.Command{
 		replay.ReplayCommand,
 		replay.ReplayForkCommand,
+		replay.ReplayBlockCommand,
+		replay.DiffCommand,
+		replay.AnalyzeCommand,
+		replay.StatsOpcodesCommand,
+		replay.ProfileGasCommand,
+		replay.CoverageCommand,
 		db.DbCloneCommand,
 		db.DbCompactCommand,
+		db.DbConvertCommand,
+		db.DbDeleteCommand,
 		db.DbDumpCodeCommand,
 		db.DbExportCommand,
+		db.DbGcCommand,
+		db.DbIndexCommand,
+		db.DbInfoCommand,
+		db.DbMergeCommand,
 		db.DbRr03ToRr04Command,
+		db.DbVerifyCommand,
+		db.FetchCommand,
+		db.ServeCommand,
 		rr03_db.UpgradeCommand,
 		rr03_db.CloneCommand,
 		rr03_db.CompactCommand,
@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"plugin"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/research/analysis"
+	cli "github.com/urfave/cli/v2"
+)
+
+var AnalysisFlag = &cli.StringFlag{
+	Name:  "analysis",
+	Usage: "Name of a registered analysis to run",
+}
+
+var AnalysisConfigFlag = &cli.StringFlag{
+	Name:  "analysis-config",
+	Usage: "Config of the analysis in JSON",
+}
+
+var PluginFlag = &cli.StringSliceFlag{
+	Name:  "plugin",
+	Usage: "Go plugin (.so) registering analyses, can be repeated",
+}
+
+var AnalysisOutputFlag = &cli.PathFlag{
+	Name:  "output",
+	Usage: "File to write the result of the analysis, or stdout if not set",
+}
+
+// record-replay: substate-cli analyze command
+var AnalyzeCommand = &cli.Command{
+	Action: analyzeAction,
+	Name:   "analyze",
+	Usage:  "run a registered analysis over substates",
+	Flags: []cli.Flag{
+		AnalysisFlag,
+		AnalysisConfigFlag,
+		PluginFlag,
+		AnalysisOutputFlag,
+		research.WorkersFlag,
+		research.SkipTransferTxsFlag,
+		research.SkipCallTxsFlag,
+		research.SkipCreateTxsFlag,
+		research.SubstateDbFlag,
+		replayBlockSegmentFlag,
+		research.TxListFlag,
+		research.ChainFlag,
+		research.GenesisFlag,
+	},
+	Description: `
+substate-cli analyze runs an analysis registered by the research/analysis
+package over transactions in the given block segment. Each worker has its own
+state of the analysis, which receives each transaction substate, its replayed
+substate and tracer events if the analysis replays transactions. The states of
+all workers are merged at the end, and the result is written to --output.
+
+Analyses are built into substate-cli or registered by Go plugins loaded with
+--plugin, which must be built with the same version of substate-cli.
+Without --analysis, substate-cli analyze lists registered analyses.
+
+The chain config to replay substates is selected like substate-cli replay.`,
+	Category: "analysis",
+}
+
+// loadPlugins opens Go plugins, whose init functions register analyses
+func loadPlugins(paths []string) error {
+	for _, path := range paths {
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("error loading plugin %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// analysisReplay is analysis.ReplayFunc of substate-cli analyze
+func analysisReplay(block uint64, tx int, substate *research.Substate, tracer vm.EVMLogger) (*research.Substate, error) {
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.LoadSubstate(substate)
+
+	return replayTxStateTracer(statedb, common.Hash{}, tx, substate, tracer)
+}
+
+// record-replay: func analyzeAction for analyze command
+func analyzeAction(ctx *cli.Context) error {
+	if err := loadPlugins(ctx.StringSlice(PluginFlag.Name)); err != nil {
+		return fmt.Errorf("substate-cli analyze: %w", err)
+	}
+
+	name := ctx.String(AnalysisFlag.Name)
+	if name == "" {
+		fmt.Printf("substate-cli analyze: registered analyses:\n")
+		for _, def := range analysis.Definitions() {
+			fmt.Printf("  %s: %s\n", def.Name, def.Usage)
+		}
+		return nil
+	}
+	def, ok := analysis.Lookup(name)
+	if !ok {
+		var names []string
+		for _, def := range analysis.Definitions() {
+			names = append(names, def.Name)
+		}
+		return fmt.Errorf("substate-cli analyze: unknown analysis %q, registered analyses: %s", name, strings.Join(names, ", "))
+	}
+
+	var config json.RawMessage
+	if s := ctx.String(AnalysisConfigFlag.Name); s != "" {
+		config = json.RawMessage(s)
+	}
+	return runAnalysisCli("substate-cli analyze", def, config, ctx)
+}
+
+// runAnalysisCli runs an analysis with the config over --block-segment and
+// writes its result to --output
+func runAnalysisCli(name string, def *analysis.Definition, config json.RawMessage, ctx *cli.Context) (err error) {
+	if !ctx.IsSet(research.BlockSegmentFlag.Name) {
+		return fmt.Errorf("%s: --%s is required", name, research.BlockSegmentFlag.Name)
+	}
+	segment, err := research.ParseBlockSegment(ctx.String(research.BlockSegmentFlag.Name))
+	if err != nil {
+		return fmt.Errorf("%s: error parsing block segment: %w", name, err)
+	}
+
+	runner, err := analysis.NewRunner(def, config, analysisReplay)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	var out io.Writer = os.Stdout
+	if path := ctx.Path(AnalysisOutputFlag.Name); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("%s: error creating output file: %w", name, err)
+		}
+		defer func() {
+			if closeErr := f.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("%s: error closing output file: %w", name, closeErr)
+			}
+		}()
+		out = f
+	}
+
+	research.SetSubstateFlags(ctx)
+	research.OpenSubstateDBReadOnly()
+	defer research.CloseSubstateDB()
+
+	if def.Replay {
+		ReplayChainConfig, err = research.NewChainConfigCli(name, ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	taskPool := research.NewSubstateTaskPoolCli(name, runner.Task, ctx)
+	if err := taskPool.ExecuteSegment(segment); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: merging %v workers of %s\n", name, runner.NumWorkers(), def.Name)
+	if err := runner.Finish(out); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/research/analysis"
+	cli "github.com/urfave/cli/v2"
+)
+
+var CoverageDirFlag = &cli.PathFlag{
+	Name:  "coverage-dir",
+	Usage: "Directory to write coverage files named by code hash",
+	Value: "coverage",
+}
+
+var SourceMapsFlag = &cli.PathFlag{
+	Name:  "source-maps",
+	Usage: "Directory of solc runtime source maps named by code hash (e.g., 0x<code hash>.srcmap) to map coverage to source",
+}
+
+// record-replay: substate-cli coverage command
+var CoverageCommand = &cli.Command{
+	Action: coverageAction,
+	Name:   "coverage",
+	Usage:  "replay transactions and record code coverage of contract bytecode",
+	Flags: []cli.Flag{
+		CoverageDirFlag,
+		SourceMapsFlag,
+		AnalysisOutputFlag,
+		research.WorkersFlag,
+		research.SkipTransferTxsFlag,
+		research.SkipCallTxsFlag,
+		research.SkipCreateTxsFlag,
+		research.SubstateDbFlag,
+		research.BlockSegmentFlag,
+		research.TxListFlag,
+		research.ChainFlag,
+		research.GenesisFlag,
+	},
+	Description: `
+substate-cli coverage replays transactions in the given block segment, and
+records executed PCs and taken branches of JUMPI of each contract code hash.
+It writes a coverage file of each code hash, 0x<code hash>.coverage.json like
+0x<code hash>.hex of substate-cli db-dump-code, in --coverage-dir, and a summary
+to --output or stdout.
+
+With --source-maps, executed instructions and JUMPIs are mapped to source
+locations of the solc runtime source map of the code hash if it exists.
+It runs the "coverage" analysis of substate-cli analyze.`,
+	Category: "analysis",
+}
+
+// record-replay: func coverageAction for coverage command
+func coverageAction(ctx *cli.Context) error {
+	config, err := json.Marshal(&analysis.CoverageConfig{
+		Dir:        ctx.Path(CoverageDirFlag.Name),
+		SourceMaps: ctx.Path(SourceMapsFlag.Name),
+	})
+	if err != nil {
+		return fmt.Errorf("substate-cli coverage: %w", err)
+	}
+	def, _ := analysis.Lookup("coverage")
+	return runAnalysisCli("substate-cli coverage", def, config, ctx)
+}
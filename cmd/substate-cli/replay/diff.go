@@ -0,0 +1,82 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// record-replay: substate-cli diff command
+var DiffCommand = &cli.Command{
+	Action:    diffAction,
+	Name:      "diff",
+	Usage:     "Compare two substate files and print differences",
+	ArgsUsage: "<file1> <file2>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print differences in JSON instead of text",
+		},
+	},
+	Description: `
+substate-cli diff reads two substate files in binary (Protobuf) or JSON,
+e.g., files from substate-cli db-export or record/replay_substate_*.json files
+from substate-cli replay, and prints differences of accounts, storage, block
+environment, tx message and result. It exits with an error if the substates
+are different.`,
+	Category: "replay",
+}
+
+// readSubstateFile reads a substate in JSON if it starts with '{', otherwise in binary
+func readSubstateFile(path string) (*research.Substate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	substate := &research.Substate{}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		err = protojson.Unmarshal(b, substate)
+	} else {
+		err = proto.Unmarshal(b, substate)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return substate, nil
+}
+
+func diffAction(ctx *cli.Context) error {
+	if ctx.Args().Len() != 2 {
+		return fmt.Errorf("substate-cli diff: two substate files are required")
+	}
+
+	a, err := readSubstateFile(ctx.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("substate-cli diff: %w", err)
+	}
+	b, err := readSubstateFile(ctx.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("substate-cli diff: %w", err)
+	}
+
+	diff := research.DiffSubstate(a, b)
+	if ctx.Bool("json") {
+		out, err := diff.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Print(diff)
+	}
+
+	if !diff.Equal() {
+		return fmt.Errorf("substate-cli diff: %v differences", len(diff))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/research/analysis"
+	cli "github.com/urfave/cli/v2"
+)
+
+var TopFlag = &cli.IntFlag{
+	Name:  "top",
+	Usage: "Number of contracts in the report, -1 for all contracts",
+	Value: 20,
+}
+
+var SortByFlag = &cli.StringFlag{
+	Name:  "sort-by",
+	Usage: "Rank contracts by \"gas\", \"time\" or \"invocations\"",
+	Value: analysis.SortByGas,
+}
+
+var PprofFlag = &cli.StringFlag{
+	Name:  "pprof",
+	Usage: "Path of the pprof profile of gas by contract and PC, no profile if empty",
+	Value: "gas.pprof",
+}
+
+// record-replay: substate-cli profile-gas command
+var ProfileGasCommand = &cli.Command{
+	Action: profileGasAction,
+	Name:   "profile-gas",
+	Usage:  "replay transactions and profile gas and execution time of contracts",
+	Flags: []cli.Flag{
+		TopFlag,
+		SortByFlag,
+		PprofFlag,
+		AnalysisOutputFlag,
+		research.WorkersFlag,
+		research.SkipTransferTxsFlag,
+		research.SkipCallTxsFlag,
+		research.SkipCreateTxsFlag,
+		research.SubstateDbFlag,
+		research.BlockSegmentFlag,
+		research.TxListFlag,
+		research.ChainFlag,
+		research.GenesisFlag,
+	},
+	Description: `
+substate-cli profile-gas replays transactions in the given block segment, and
+accumulates the invocations, gas and distribution of execution time of each
+contract code hash. It writes the top contracts ranked by --sort-by to --output
+or stdout, and a pprof profile of gas by contract and PC to --pprof, which can
+be viewed with "go tool pprof".
+
+Execution time is measured during replay and includes the overhead of tracing.
+It runs the "gas-profile" analysis of substate-cli analyze.`,
+	Category: "analysis",
+}
+
+// record-replay: func profileGasAction for profile-gas command
+func profileGasAction(ctx *cli.Context) error {
+	config, err := json.Marshal(&analysis.GasProfileConfig{
+		Top:    ctx.Int(TopFlag.Name),
+		SortBy: ctx.String(SortByFlag.Name),
+		Pprof:  ctx.String(PprofFlag.Name),
+	})
+	if err != nil {
+		return fmt.Errorf("substate-cli profile-gas: %w", err)
+	}
+	def, _ := analysis.Lookup("gas-profile")
+	return runAnalysisCli("substate-cli profile-gas", def, config, ctx)
+}
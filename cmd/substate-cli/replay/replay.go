@@ -3,6 +3,7 @@ package replay
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
@@ -27,22 +28,207 @@ var ReplayCommand = &cli.Command{
 		research.SkipTransferTxsFlag,
 		research.SkipCallTxsFlag,
 		research.SkipCreateTxsFlag,
-		research.SubstateDirFlag,
-		research.BlockSegmentFlag,
+		research.SubstateDbFlag,
+		replayBlockSegmentFlag,
 		research.TxListFlag,
+		research.CheckpointFlag,
+		research.ResumeFlag,
+		KeepGoingFlag,
+		FailureDirFlag,
+		research.CoordinatorFlag,
+		research.JoinFlag,
+		research.RangeSizeFlag,
+		research.LeaseTimeoutFlag,
+		research.ChainFlag,
+		research.GenesisFlag,
+		ChainedFlag,
+		TracerFlag,
+		TracerConfigFlag,
+		TracerSinkFlag,
+		TracerDirFlag,
 	},
 	Description: `
 substate-cli replay executes transactions in the given block segment
-and check output consistency for faithful replaying.`,
+and check output consistency for faithful replaying. Call traces of
+transactions recorded with --record-traces are also replayed and checked.
+
+With --keep-going, substate-cli replay continues after failures and saves
+substates of each failed transaction in --failure-dir/<block>_<tx>. At the end,
+it writes --failure-dir/tx-list.txt to replay failed transactions again with
+--tx-list, and --failure-dir/summary.txt with categorized reasons.
+
+With --coordinator, substate-cli replay splits the block segment into block
+ranges of --range-size blocks and hands them out to workers started with
+--join on other machines. Each worker replays the ranges on its own
+--substate-db, and the coordinator reports total counts, throughput and
+the first failure.
+
+With --chained, substate-cli replay keeps a single in-memory StateDB within
+each block (--chained block) or across the whole block segment with one worker
+(--chained segment), and replays transactions of a block in order on it.
+Accounts and storage slots are loaded from the input alloc when they are read
+for the first time, and the input alloc of each substate must be the same as
+the accumulated state; otherwise the exact accounts and storage slots are
+reported as differences from the accumulated state to the input alloc.
+--chained segment needs block substates for state changes between blocks.
+
+With --tracer, substate-cli replay sets vm.Config.Tracer to a new tracer for
+each transaction: a tracer of eth/tracers like callTracer, prestateTracer or
+4byteTracer, structLogger, JS code, or a .js file with JS code. --tracer-config
+is passed to the tracer in JSON. Tracer results are saved in --tracer-dir as
+worker-<pid>-<n>.jsonl files with one line per transaction (--tracer-sink
+jsonl) or as <block>_<tx>.json files (--tracer-sink tx).
+
+The chain config to replay substates is selected by --genesis, --chain, the
+chain config recorded in --substate-db, or mainnet, in that order.`,
 	Category: "replay",
 }
 
+// replayBlockSegmentFlag is --block-segment which workers with --join and
+// substate-cli analyze listing analyses don't need
+var replayBlockSegmentFlag = func() *cli.StringFlag {
+	flag := *research.BlockSegmentFlag
+	flag.Required = false
+	return &flag
+}()
+
 // replayTask replays a transaction substate
 func replayTask(block uint64, tx int, substate *research.Substate, taskPool *research.SubstateTaskPool) error {
+	replaySubstate, err := replayTx(tx, substate)
+	if err != nil {
+		return err
+	}
+
+	eqSubstate := proto.Equal(substate, replaySubstate)
+
+	if !eqSubstate {
+		fmt.Printf("block %v, tx %v, inconsistent output\n", block, tx)
+		fmt.Print(research.DiffSubstate(substate, replaySubstate))
+		saveSubstateJSON(".", fmt.Sprintf("_%v_%v", block, tx), substate, replaySubstate)
+		fmt.Printf("Saved record/replay_substate_*.json files (bytes in base64)\n")
+
+		return fmt.Errorf("not faithful replay - inconsistent output")
+	}
+
+	trace, replayTrace, err := replayTxTrace(block, tx, substate)
+	if err != nil {
+		return err
+	}
+
+	if !proto.Equal(trace, replayTrace) {
+		fmt.Printf("block %v, tx %v, inconsistent call trace\n", block, tx)
+		fmt.Print(research.DiffTxTrace(trace, replayTrace))
+		saveTraceJSON(".", fmt.Sprintf("_%v_%v", block, tx), trace, replayTrace)
+		fmt.Printf("Saved record/replay_trace_*.json files (bytes in base64)\n")
+
+		return fmt.Errorf("not faithful replay - inconsistent call trace")
+	}
+
+	return nil
+}
+
+// checkTraces is whether replay checks call traces, i.e., the substate DB has
+// call traces recorded with --record-traces
+var checkTraces bool
+
+// replayTxTrace replays the call trace of a transaction substate recorded with
+// --record-traces, and returns the recorded and replayed call traces. Both are
+// nil if the transaction has no recorded call trace or checkTraces is false.
+func replayTxTrace(block uint64, tx int, substate *research.Substate) (*research.TxTrace, *research.TxTrace, error) {
+	if !checkTraces {
+		return nil, nil, nil
+	}
+	trace := research.GetTxTrace(block, tx)
+	if trace == nil {
+		return nil, nil, nil
+	}
+	replayTrace, err := core.ReplayTxTrace(ReplayChainConfig, tx, substate)
+	if err != nil {
+		return nil, nil, err
+	}
+	return trace, replayTrace, nil
+}
+
+// saveTraceJSON saves recorded and replayed call traces in dir as
+// record_trace<suffix>.json and replay_trace<suffix>.json
+func saveTraceJSON(dir string, suffix string, trace, replayTrace *research.TxTrace) {
+	jm := protojson.MarshalOptions{
+		Indent: "  ",
+	}
+
+	var b []byte
+
+	b, _ = jm.Marshal(trace)
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("record_trace%s.json", suffix)), b, 0644)
+	b, _ = jm.Marshal(replayTrace)
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("replay_trace%s.json", suffix)), b, 0644)
+}
+
+// saveSubstateJSON saves recorded and replayed substates in dir as
+// record_substate<suffix>.json, replay_substate<suffix>.json, and their hashed versions
+func saveSubstateJSON(dir string, suffix string, substate, replaySubstate *research.Substate) {
+	jm := protojson.MarshalOptions{
+		Indent: "  ",
+	}
+
+	var b []byte
+
+	b, _ = jm.Marshal(substate)
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("record_substate%s.json", suffix)), b, 0644)
+	b, _ = jm.Marshal(substate.HashedCopy())
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("record_substate%s_hashed.json", suffix)), b, 0644)
+
+	// no replayed substate if replay failed with an error
+	if replaySubstate == nil {
+		return
+	}
+
+	b, _ = jm.Marshal(replaySubstate)
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("replay_substate%s.json", suffix)), b, 0644)
+	b, _ = jm.Marshal(replaySubstate.HashedCopy())
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("replay_substate%s_hashed.json", suffix)), b, 0644)
+}
+
+// chain config of substate-cli replay selected by --chain, --genesis or substate DB
+var ReplayChainConfig *params.ChainConfig = research.ReplayChainConfig(params.MainnetChainConfig)
+
+// replayTx executes a transaction substate and returns the replayed substate
+func replayTx(tx int, substate *research.Substate) (*research.Substate, error) {
 	// InputAlloc
 	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
 	statedb.LoadSubstate(substate)
 
+	return replayTxState(statedb, common.Hash{}, tx, substate)
+}
+
+// replayTxState executes a transaction substate on statedb which already has
+// its input alloc, and returns the replayed substate. txHash separates logs of
+// transactions executed on the same statedb. With --tracer, the transaction is
+// traced and the tracer result is saved.
+func replayTxState(statedb *state.StateDB, txHash common.Hash, tx int, substate *research.Substate) (*research.Substate, error) {
+	if tracing == nil {
+		return replayTxStateTracer(statedb, txHash, tx, substate, nil)
+	}
+
+	block := substate.GetBlockEnv().GetNumber()
+	tracer, err := tracing.newTracer(block, tx, txHash)
+	if err != nil {
+		return nil, err
+	}
+	replaySubstate, err := replayTxStateTracer(statedb, txHash, tx, substate, tracer)
+	if err != nil {
+		return nil, err
+	}
+
+	// tracer results are saved before checking output consistency
+	if err := tracing.write(block, tx, tracer); err != nil {
+		return nil, err
+	}
+	return replaySubstate, nil
+}
+
+// replayTxStateTracer is replayTxState with tracer as vm.Config.Tracer if not nil
+func replayTxStateTracer(statedb *state.StateDB, txHash common.Hash, tx int, substate *research.Substate, tracer vm.EVMLogger) (*research.Substate, error) {
 	// BlockEnv
 	blockContext := &vm.BlockContext{
 		CanTransfer: core.CanTransfer,
@@ -55,16 +241,15 @@ func replayTask(block uint64, tx int, substate *research.Substate, taskPool *res
 	txMessage := &core.Message{}
 	txMessage.LoadSubstate(substate)
 
-	chainConfig := &params.ChainConfig{}
-	*chainConfig = *params.MainnetChainConfig
-	// disable DAOForkSupport, otherwise account states will be overwritten
-	chainConfig.DAOForkSupport = false
+	chainConfig := ReplayChainConfig
 
-	vmConfig := vm.Config{}
+	vmConfig := vm.Config{
+		Tracer: tracer,
+	}
 
 	evm := vm.NewEVM(*blockContext, vm.TxContext{}, statedb, chainConfig, vmConfig)
 
-	statedb.SetTxContext(common.Hash{}, tx)
+	statedb.SetTxContext(txHash, tx)
 
 	txContext := core.NewEVMTxContext(txMessage)
 	evm.Reset(txContext, statedb)
@@ -73,7 +258,7 @@ func replayTask(block uint64, tx int, substate *research.Substate, taskPool *res
 
 	result, err := core.ApplyMessage(evm, txMessage, gaspool)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if chainConfig.IsByzantium(blockNumber) {
@@ -97,55 +282,105 @@ func replayTask(block uint64, tx int, substate *research.Substate, taskPool *res
 	} else {
 		rr.Status = types.ReceiptStatusSuccessful
 	}
-	rr.Logs = statedb.GetLogs(common.Hash{}, blockContext.BlockNumber.Uint64(), common.Hash{})
+	rr.Logs = statedb.GetLogs(txHash, blockContext.BlockNumber.Uint64(), common.Hash{})
 	rr.Bloom = types.CreateBloom(types.Receipts{&types.Receipt{Logs: rr.Logs}})
 	rr.GasUsed = result.UsedGas
 	rr.SaveSubstate(replaySubstate)
 
-	eqSubstate := proto.Equal(substate, replaySubstate)
+	return replaySubstate, nil
+}
 
-	if !eqSubstate {
-		fmt.Printf("block %v, tx %v, inconsistent output\n", block, tx)
-		jm := protojson.MarshalOptions{
-			Indent: "  ",
-		}
+// record-replay: func replayAction for replay command
+func replayAction(ctx *cli.Context) (err error) {
+	join := ctx.String(research.JoinFlag.Name)
+	if join != "" && ctx.IsSet(research.CoordinatorFlag.Name) {
+		return fmt.Errorf("substate-cli replay: --coordinator and --join are exclusive")
+	}
 
-		var b []byte
+	var segment *research.BlockSegment
+	if join == "" {
+		if !ctx.IsSet(research.BlockSegmentFlag.Name) {
+			return fmt.Errorf("substate-cli replay: --block-segment is required without --join")
+		}
+		segment, err = research.ParseBlockSegment(ctx.String(research.BlockSegmentFlag.Name))
+		if err != nil {
+			return fmt.Errorf("substate-cli replay: error parsing block segment: %w", err)
+		}
+	}
 
-		b, _ = jm.Marshal(substate)
-		os.WriteFile(fmt.Sprintf("record_substate_%v_%v.json", block, tx), b, 0644)
-		b, _ = jm.Marshal(substate.HashedCopy())
-		os.WriteFile(fmt.Sprintf("record_substate_%v_%v_hashed.json", block, tx), b, 0644)
+	// coordinator doesn't replay and doesn't need substate DB
+	if addr := ctx.String(research.CoordinatorFlag.Name); addr != "" {
+		coordinator := research.NewSubstateCoordinatorCli("substate-cli replay", segment, ctx)
+		return coordinator.Run(addr)
+	}
 
-		b, _ = jm.Marshal(replaySubstate)
-		os.WriteFile(fmt.Sprintf("replay_substate_%v_%v.json", block, tx), b, 0644)
-		b, _ = jm.Marshal(replaySubstate.HashedCopy())
-		os.WriteFile(fmt.Sprintf("replay_substate_%v_%v_hashed.json", block, tx), b, 0644)
+	research.SetSubstateFlags(ctx)
+	research.OpenSubstateDBReadOnly()
+	defer research.CloseSubstateDB()
 
-		fmt.Printf("Saved record/replay_substate_*.json files (bytes in base64)\n")
+	ReplayChainConfig, err = research.NewChainConfigCli("substate-cli replay", ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli replay: %w", err)
+	}
 
-		return fmt.Errorf("not faithful replay - inconsistent output")
+	// warn if substate DB metadata says some blocks were not recorded
+	if m := research.GetMetadata(); m != nil && segment != nil {
+		for _, r := range m.MissingRanges(segment) {
+			fmt.Printf("substate-cli replay: warning: blocks %v-%v are not recorded in substate DB\n", r.First, r.Last)
+		}
 	}
 
-	return nil
-}
+	// don't look up call traces of each tx if none was recorded
+	checkTraces = research.HasTxTraces()
 
-// record-replay: func replayAction for replay command
-func replayAction(ctx *cli.Context) error {
-	var err error
+	chained, err := newChainedReplayCli(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli replay: %w", err)
+	}
 
-	research.SetSubstateFlags(ctx)
-	research.OpenSubstateDBReadOnly()
-	defer research.CloseSubstateDB()
+	tracing, err = newReplayTracingCli(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli replay: %w", err)
+	}
+	if tracing != nil {
+		defer func() {
+			if closeErr := tracing.close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("substate-cli replay: error closing tracer results: %w", closeErr)
+			}
+			fmt.Printf("substate-cli replay: saved %v tracer results in %s\n", tracing.written, tracing.dir)
+		}()
+	}
 
 	taskPool := research.NewSubstateTaskPoolCli("substate-cli replay", replayTask, ctx)
+	if chained != nil {
+		taskPool.TaskFunc = chained.skipTask
+		taskPool.BlockTaskFunc = chained.blockTask
+		// blocks are executed in order with one worker
+		if chained.mode == chainedSegment {
+			taskPool.Config.Workers = 1
+		}
+	}
 
-	segment, err := research.ParseBlockSegment(ctx.String(research.BlockSegmentFlag.Name))
-	if err != nil {
-		return fmt.Errorf("substate-cli replay: error parsing block segment: %w", err)
+	var failures *replayFailures
+	if ctx.Bool(KeepGoingFlag.Name) {
+		failures, err = newReplayFailures(ctx.Path(FailureDirFlag.Name))
+		if err != nil {
+			return fmt.Errorf("substate-cli replay: error creating failure directory: %w", err)
+		}
+		taskPool.TaskFunc = failures.task
+		// failures collected before an error of the task pool are reported too
+		defer func() {
+			if reportErr := failures.report(); err == nil {
+				err = reportErr
+			}
+		}()
 	}
 
-	err = taskPool.ExecuteSegment(segment)
+	if join != "" {
+		err = taskPool.ExecuteJoin(join)
+	} else {
+		err = taskPool.ExecuteSegment(segment)
+	}
 
 	return err
 }
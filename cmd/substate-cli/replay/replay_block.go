@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/beacon"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// record-replay: substate-cli replay-block command
+var ReplayBlockCommand = &cli.Command{
+	Action: replayBlockAction,
+	Name:   "replay-block",
+	Usage:  "replay block substates and transactions and check output consistency",
+	Flags: []cli.Flag{
+		research.WorkersFlag,
+		research.SubstateDbFlag,
+		research.BlockSegmentFlag,
+		research.TxListFlag,
+		research.CheckpointFlag,
+		research.ResumeFlag,
+		research.ChainFlag,
+		research.GenesisFlag,
+	},
+	Description: `
+substate-cli replay-block replays block substates recorded with
+geth record-substate --record-block-substate, i.e., state changes outside
+transactions from the DAO hard fork, the beacon block root system call, block
+and uncle rewards, and withdrawals, and checks output consistency. It also
+replays all transaction substates of each block like substate-cli replay.
+
+Blocks without block substates are reported as errors. The chain config is
+selected like substate-cli replay.`,
+	Category: "replay",
+}
+
+// engine of substate-cli replay-block for block and uncle rewards and withdrawals
+var replayEngine consensus.Engine
+
+// newReplayEngine returns a consensus engine that finalizes blocks like geth
+func newReplayEngine(config *params.ChainConfig) consensus.Engine {
+	if config.Clique != nil {
+		return beacon.New(clique.New(config.Clique, rawdb.NewMemoryDatabase()))
+	}
+	return beacon.New(ethash.NewFaker())
+}
+
+// replayBlockTask replays a block substate
+func replayBlockTask(block uint64, blockSubstate *research.BlockSubstate, taskPool *research.SubstateTaskPool) error {
+	if blockSubstate == nil {
+		return fmt.Errorf("block substate not found, record it with --%s", core.RecordBlockSubstateFlag.Name)
+	}
+
+	replayBlockSubstate := core.ReplayBlockSubstate(ReplayChainConfig, replayEngine, blockSubstate)
+
+	if !proto.Equal(blockSubstate, replayBlockSubstate) {
+		fmt.Printf("block %v, inconsistent block substate output\n", block)
+		fmt.Print(research.DiffBlockSubstate(blockSubstate, replayBlockSubstate))
+
+		jm := protojson.MarshalOptions{
+			Indent: "  ",
+		}
+		b, _ := jm.Marshal(blockSubstate)
+		os.WriteFile(fmt.Sprintf("record_block_substate_%v.json", block), b, 0644)
+		b, _ = jm.Marshal(replayBlockSubstate)
+		os.WriteFile(fmt.Sprintf("replay_block_substate_%v.json", block), b, 0644)
+		fmt.Printf("Saved record/replay_block_substate_%v.json files (bytes in base64)\n", block)
+
+		return fmt.Errorf("not faithful replay - inconsistent block substate output")
+	}
+
+	return nil
+}
+
+// record-replay: func replayBlockAction for replay-block command
+func replayBlockAction(ctx *cli.Context) error {
+	var err error
+
+	segment, err := research.ParseBlockSegment(ctx.String(research.BlockSegmentFlag.Name))
+	if err != nil {
+		return fmt.Errorf("substate-cli replay-block: error parsing block segment: %w", err)
+	}
+
+	research.SetSubstateFlags(ctx)
+	research.OpenSubstateDBReadOnly()
+	defer research.CloseSubstateDB()
+
+	ReplayChainConfig, err = research.NewChainConfigCli("substate-cli replay-block", ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli replay-block: %w", err)
+	}
+	replayEngine = newReplayEngine(ReplayChainConfig)
+	checkTraces = research.HasTxTraces()
+
+	taskPool := research.NewSubstateTaskPoolCli("substate-cli replay-block", replayTask, ctx)
+	taskPool.BlockTaskFunc = replayBlockTask
+
+	return taskPool.ExecuteSegment(segment)
+}
@@ -0,0 +1,269 @@
+package replay
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Modes of --chained
+const (
+	chainedBlock   = "block"   // chain substates within each block
+	chainedSegment = "segment" // chain substates across the whole block segment
+)
+
+var ChainedFlag = &cli.StringFlag{
+	Name:  "chained",
+	Usage: "Replay substates on a single StateDB chained within each \"block\" or across the whole \"segment\"",
+}
+
+// chainedAccount is what the chained state knows about an account
+type chainedAccount struct {
+	exists     bool
+	storage    map[common.Hash]struct{} // known storage slots
+	allStorage bool                     // all storage slots are known after creation or deletion
+}
+
+// chainedState is a single StateDB accumulating output allocs of substates.
+// Accounts and storage slots read for the first time are loaded from input
+// allocs, and the others are checked against input allocs.
+type chainedState struct {
+	statedb  *state.StateDB
+	accounts map[common.Address]*chainedAccount
+}
+
+func newChainedState() *chainedState {
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	return &chainedState{
+		statedb:  statedb,
+		accounts: make(map[common.Address]*chainedAccount),
+	}
+}
+
+// load checks the input alloc against the chained state and returns the
+// differences, where a is the chained state and b is the input alloc. If there
+// is no difference, accounts and storage slots unknown to the chained state
+// are loaded from the input alloc.
+func (s *chainedState) load(part string, input *research.Substate_Alloc) research.SubstateDiff {
+	chained := &research.Substate_Alloc{}
+	for _, entry := range input.GetAlloc() {
+		addr := *research.BytesToAddress(entry.Address)
+		ca := s.accounts[addr]
+		if ca == nil {
+			chained.Alloc = append(chained.Alloc, entry)
+			continue
+		}
+		if !ca.exists {
+			continue
+		}
+		account := &research.Substate_Account{
+			Nonce:    proto.Uint64(s.statedb.GetNonce(addr)),
+			Balance:  research.Uint256ToBytes(s.statedb.GetBalance(addr)),
+			Contract: &research.Substate_Account_Code{Code: s.statedb.GetCode(addr)},
+		}
+		for _, slot := range entry.Account.Storage {
+			key := *research.BytesToHash(slot.Key)
+			if _, ok := ca.storage[key]; !ok && !ca.allStorage {
+				account.Storage = append(account.Storage, slot)
+				continue
+			}
+			value := s.statedb.GetState(addr, key)
+			account.Storage = append(account.Storage, &research.Substate_Account_StorageEntry{
+				Key:   slot.Key,
+				Value: research.HashToBytes(&value),
+			})
+		}
+		chained.Alloc = append(chained.Alloc, &research.Substate_AllocEntry{Address: entry.Address, Account: account})
+	}
+
+	if d := research.DiffAlloc(part, chained, input); !d.Equal() {
+		return d
+	}
+
+	for _, entry := range input.GetAlloc() {
+		addr := *research.BytesToAddress(entry.Address)
+		ca := s.accounts[addr]
+		if ca == nil {
+			s.statedb.SetCode(addr, entry.Account.GetCode())
+			s.statedb.SetNonce(addr, entry.Account.GetNonce())
+			s.statedb.SetBalance(addr, research.BytesToUint256(entry.Account.Balance))
+			ca = &chainedAccount{exists: true, storage: make(map[common.Hash]struct{})}
+			s.accounts[addr] = ca
+		}
+		for _, slot := range entry.Account.Storage {
+			key := *research.BytesToHash(slot.Key)
+			if _, ok := ca.storage[key]; !ok && !ca.allStorage {
+				s.statedb.SetState(addr, key, *research.BytesToHash(slot.Value))
+				ca.storage[key] = struct{}{}
+			}
+		}
+	}
+	// Finalise so that loaded values are original values of the next substate
+	s.statedb.Finalise(false)
+
+	return nil
+}
+
+// update marks accounts and storage slots of the input and output allocs as
+// known after the chained state executed or applied them
+func (s *chainedState) update(input, output *research.Substate_Alloc) {
+	outputs := make(map[common.Address]struct{})
+	for _, entry := range output.GetAlloc() {
+		addr := *research.BytesToAddress(entry.Address)
+		outputs[addr] = struct{}{}
+		ca := s.accounts[addr]
+		if ca == nil {
+			// created from a non-existent account
+			ca = &chainedAccount{storage: make(map[common.Hash]struct{}), allStorage: true}
+			s.accounts[addr] = ca
+		}
+		ca.exists = true
+		for _, slot := range entry.Account.Storage {
+			ca.storage[*research.BytesToHash(slot.Key)] = struct{}{}
+		}
+	}
+	for _, entry := range input.GetAlloc() {
+		addr := *research.BytesToAddress(entry.Address)
+		if _, ok := outputs[addr]; !ok {
+			s.accounts[addr] = &chainedAccount{storage: make(map[common.Hash]struct{}), allStorage: true}
+		}
+	}
+}
+
+// apply sets the output alloc of a block substate to the chained state without
+// executing it, block substates are checked by substate-cli replay-block
+func (s *chainedState) apply(input, output *research.Substate_Alloc) {
+	outputs := make(map[common.Address]struct{})
+	for _, entry := range output.GetAlloc() {
+		addr := *research.BytesToAddress(entry.Address)
+		outputs[addr] = struct{}{}
+		s.statedb.SetCode(addr, entry.Account.GetCode())
+		s.statedb.SetNonce(addr, entry.Account.GetNonce())
+		s.statedb.SetBalance(addr, research.BytesToUint256(entry.Account.Balance))
+		for _, slot := range entry.Account.Storage {
+			s.statedb.SetState(addr, *research.BytesToHash(slot.Key), *research.BytesToHash(slot.Value))
+		}
+	}
+	for _, entry := range input.GetAlloc() {
+		addr := *research.BytesToAddress(entry.Address)
+		if _, ok := outputs[addr]; !ok {
+			s.statedb.SelfDestruct(addr)
+		}
+	}
+	s.statedb.Finalise(false)
+	s.update(input, output)
+}
+
+// chainedReplay replays substates of blocks on chained states with --chained
+type chainedReplay struct {
+	mode  string
+	state *chainedState // chained state of the segment with --chained=segment
+}
+
+// newChainedReplayCli returns chainedReplay of --chained, or nil without --chained
+func newChainedReplayCli(ctx *cli.Context) (*chainedReplay, error) {
+	mode := ctx.String(ChainedFlag.Name)
+	switch mode {
+	case "":
+		return nil, nil
+	case chainedBlock, chainedSegment:
+	default:
+		return nil, fmt.Errorf("--%s must be %q or %q", ChainedFlag.Name, chainedBlock, chainedSegment)
+	}
+
+	// all transactions of a block are needed to chain their substates
+	for _, flag := range []cli.Flag{
+		research.TxListFlag,
+		research.SkipTransferTxsFlag,
+		research.SkipCallTxsFlag,
+		research.SkipCreateTxsFlag,
+		KeepGoingFlag,
+	} {
+		if name := flag.Names()[0]; ctx.IsSet(name) {
+			return nil, fmt.Errorf("--%s and --%s are exclusive", ChainedFlag.Name, name)
+		}
+	}
+	if mode == chainedSegment {
+		for _, flag := range []cli.Flag{research.CoordinatorFlag, research.JoinFlag} {
+			if name := flag.Names()[0]; ctx.IsSet(name) {
+				return nil, fmt.Errorf("--%s=%s and --%s are exclusive", ChainedFlag.Name, chainedSegment, name)
+			}
+		}
+	}
+
+	return &chainedReplay{mode: mode, state: newChainedState()}, nil
+}
+
+// skipTask is TaskFunc of --chained, transactions are replayed by blockTask
+func (c *chainedReplay) skipTask(block uint64, tx int, substate *research.Substate, taskPool *research.SubstateTaskPool) error {
+	return nil
+}
+
+// blockTask replays all substates of a block in order on the chained state
+func (c *chainedReplay) blockTask(block uint64, blockSubstate *research.BlockSubstate, taskPool *research.SubstateTaskPool) error {
+	s := c.state
+	if c.mode == chainedBlock {
+		s = newChainedState()
+	} else if blockSubstate == nil {
+		// block and uncle rewards and withdrawals are needed between blocks
+		return fmt.Errorf("block substate not found, record it with --%s", core.RecordBlockSubstateFlag.Name)
+	}
+
+	inconsistent := func(tx string, d research.SubstateDiff) error {
+		fmt.Printf("block %v, %s, input alloc inconsistent with chained state\n", block, tx)
+		fmt.Print(d)
+		return fmt.Errorf("not faithful chained replay - inconsistent input alloc")
+	}
+
+	if blockSubstate != nil {
+		if d := s.load("preTxInputAlloc", blockSubstate.PreTxInputAlloc); d != nil {
+			return inconsistent("pre-tx", d)
+		}
+		s.apply(blockSubstate.PreTxInputAlloc, blockSubstate.PreTxOutputAlloc)
+	}
+
+	substates := taskPool.DB.GetBlockSubstates(block)
+	txs := make([]int, 0, len(substates))
+	for tx := range substates {
+		txs = append(txs, tx)
+	}
+	sort.Ints(txs)
+	for _, tx := range txs {
+		substate := substates[tx]
+		if d := s.load("inputAlloc", substate.InputAlloc); d != nil {
+			return inconsistent(fmt.Sprintf("tx %v", tx), d)
+		}
+
+		txHash := common.BytesToHash(research.Stage1SubstateKey(block, tx))
+		replaySubstate, err := replayTxState(s.statedb, txHash, tx, substate)
+		if err != nil {
+			return fmt.Errorf("%v_%v: %w", block, tx, err)
+		}
+		if !proto.Equal(substate, replaySubstate) {
+			fmt.Printf("block %v, tx %v, inconsistent output of chained replay\n", block, tx)
+			fmt.Print(research.DiffSubstate(substate, replaySubstate))
+			saveSubstateJSON(".", fmt.Sprintf("_%v_%v", block, tx), substate, replaySubstate)
+			fmt.Printf("Saved record/replay_substate_*.json files (bytes in base64)\n")
+
+			return fmt.Errorf("not faithful chained replay - inconsistent output")
+		}
+		s.update(substate.InputAlloc, substate.OutputAlloc)
+	}
+
+	if blockSubstate != nil {
+		if d := s.load("postTxInputAlloc", blockSubstate.PostTxInputAlloc); d != nil {
+			return inconsistent("post-tx", d)
+		}
+		s.apply(blockSubstate.PostTxInputAlloc, blockSubstate.PostTxOutputAlloc)
+	}
+
+	return nil
+}
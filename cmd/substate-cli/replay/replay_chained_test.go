@@ -0,0 +1,180 @@
+package replay
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/research"
+	"google.golang.org/protobuf/proto"
+)
+
+// chainTransfer returns transferSubstate of block and tx on the given input
+// alloc, e.g., the output alloc of the previous transaction
+func chainTransfer(t *testing.T, block uint64, tx int, input *research.Substate_Alloc) *research.Substate {
+	substate := transferSubstate(t, block, tx)
+	substate.InputAlloc = proto.Clone(input).(*research.Substate_Alloc)
+	for _, entry := range input.Alloc {
+		if common.BytesToAddress(entry.Address) == common.BytesToAddress(substate.TxMessage.From) {
+			substate.TxMessage.Nonce = proto.Uint64(entry.Account.GetNonce())
+		}
+	}
+	return recordSubstate(t, tx, substate)
+}
+
+// account returns an alloc entry of an account with nonce, balance and storage slots
+func account(addr byte, nonce uint64, balance byte, storage ...byte) *research.Substate_AllocEntry {
+	a := &research.Substate_Account{
+		Nonce:    proto.Uint64(nonce),
+		Balance:  []byte{balance},
+		Contract: &research.Substate_Account_Code{Code: []byte{}},
+	}
+	for i := 0; i+1 < len(storage); i += 2 {
+		a.Storage = append(a.Storage, &research.Substate_Account_StorageEntry{
+			Key:   common.BytesToHash([]byte{storage[i]}).Bytes(),
+			Value: common.BytesToHash([]byte{storage[i+1]}).Bytes(),
+		})
+	}
+	return &research.Substate_AllocEntry{Address: common.BytesToAddress([]byte{addr}).Bytes(), Account: a}
+}
+
+func alloc(entries ...*research.Substate_AllocEntry) *research.Substate_Alloc {
+	return &research.Substate_Alloc{Alloc: entries}
+}
+
+func newChainedTaskPool(t *testing.T, substates map[uint64][]*research.Substate) *research.SubstateTaskPool {
+	backend, _ := research.OpenBackendDatabase("memory,", false)
+	db := research.NewSubstateDB(backend)
+	t.Cleanup(func() { db.Close() })
+	for block, txs := range substates {
+		for tx, substate := range txs {
+			db.PutSubstate(block, tx, substate)
+		}
+	}
+	return &research.SubstateTaskPool{Name: "test", DB: db}
+}
+
+func TestChainedReplayBlock(t *testing.T) {
+	// tx 1 sends the value received by 0xbb in tx 0 back to 0xaa
+	tx0 := transferSubstate(t, 1, 0)
+	tx1 := transferSubstate(t, 1, 1)
+	tx1.InputAlloc = proto.Clone(tx0.OutputAlloc).(*research.Substate_Alloc)
+	tx1.TxMessage.From = []byte{0xbb}
+	tx1.TxMessage.To.Value = []byte{0xaa}
+	tx1.TxMessage.Nonce = proto.Uint64(0)
+	tx1 = recordSubstate(t, 1, tx1)
+	tx2 := chainTransfer(t, 1, 2, tx1.OutputAlloc)
+
+	c := &chainedReplay{mode: chainedBlock}
+	pool := newChainedTaskPool(t, map[uint64][]*research.Substate{1: {tx0, tx1, tx2}})
+	if err := c.blockTask(1, nil, pool); err != nil {
+		t.Fatalf("consistent chain of txs failed: %v", err)
+	}
+}
+
+func TestChainedReplayInconsistentBalance(t *testing.T) {
+	tx0 := transferSubstate(t, 1, 0)
+	input := proto.Clone(tx0.OutputAlloc).(*research.Substate_Alloc)
+	for _, entry := range input.Alloc {
+		if common.BytesToAddress(entry.Address) == common.BytesToAddress([]byte{0xaa}) {
+			entry.Account.Balance = []byte{0x10}
+		}
+	}
+	tx1 := chainTransfer(t, 1, 1, input)
+
+	c := &chainedReplay{mode: chainedBlock}
+	pool := newChainedTaskPool(t, map[uint64][]*research.Substate{1: {tx0, tx1}})
+	if err := c.blockTask(1, nil, pool); err == nil || !strings.Contains(err.Error(), "inconsistent input alloc") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	s := newChainedState()
+	if d := s.load("inputAlloc", tx0.InputAlloc); d != nil {
+		t.Fatal(d)
+	}
+	s.apply(tx0.InputAlloc, tx0.OutputAlloc)
+	d := s.load("inputAlloc", tx1.InputAlloc)
+	if len(d) != 1 || d[0].Field != "balance" || d[0].A != "99" || d[0].B != "16" {
+		t.Fatalf("unexpected differences\n%v", d)
+	}
+}
+
+func TestChainedStateStorage(t *testing.T) {
+	s := newChainedState()
+
+	// slot 1 is loaded, and slot 2 is unknown
+	if d := s.load("inputAlloc", alloc(account(0xdd, 0, 0, 1, 1))); d != nil {
+		t.Fatal(d)
+	}
+	s.apply(alloc(account(0xdd, 0, 0, 1, 1)), alloc(account(0xdd, 0, 0, 1, 2)))
+	if d := s.load("inputAlloc", alloc(account(0xdd, 0, 0, 1, 2, 2, 5))); d != nil {
+		t.Fatal(d)
+	}
+	d := s.load("inputAlloc", alloc(account(0xdd, 0, 0, 1, 3)))
+	if len(d) != 1 || d[0].Field != "storage" || !strings.HasSuffix(d[0].Path, ".storage[0x0000000000000000000000000000000000000000000000000000000000000001]") {
+		t.Fatalf("unexpected differences\n%v", d)
+	}
+
+	// all slots of 0xee created in a tx are known in the next tx, e.g., slot 2 is zero
+	s.apply(alloc(), alloc(account(0xee, 1, 0, 1, 1)))
+	if d := s.load("inputAlloc", alloc(account(0xee, 1, 0, 1, 1, 2, 0))); d != nil {
+		t.Fatal(d)
+	}
+	d = s.load("inputAlloc", alloc(account(0xee, 1, 0, 2, 1)))
+	if len(d) != 1 || d[0].Field != "storage" || d[0].A != (common.Hash{}).Hex() {
+		t.Fatalf("unexpected differences\n%v", d)
+	}
+}
+
+func TestChainedStateSelfDestruct(t *testing.T) {
+	s := newChainedState()
+	if d := s.load("inputAlloc", alloc(account(0xdd, 1, 5, 1, 1))); d != nil {
+		t.Fatal(d)
+	}
+
+	// 0xdd is in the input alloc but not in the output alloc
+	s.apply(alloc(account(0xdd, 1, 5, 1, 1)), alloc(account(0xaa, 0, 5)))
+	if s.statedb.Exist(common.BytesToAddress([]byte{0xdd})) {
+		t.Fatal("self-destructed account exists")
+	}
+	d := s.load("inputAlloc", alloc(account(0xdd, 1, 5, 1, 1)))
+	if len(d) != 1 || d[0].Field != "account" || d[0].Kind != research.DiffAdded {
+		t.Fatalf("unexpected differences\n%v", d)
+	}
+
+	// 0xdd created again has no storage
+	s.apply(alloc(), alloc(account(0xdd, 1, 0)))
+	if d := s.load("inputAlloc", alloc(account(0xdd, 1, 0, 1, 0))); d != nil {
+		t.Fatal(d)
+	}
+	d = s.load("inputAlloc", alloc(account(0xdd, 1, 0, 1, 1)))
+	if len(d) != 1 || d[0].Field != "storage" {
+		t.Fatalf("unexpected differences\n%v", d)
+	}
+}
+
+func TestChainedReplaySegment(t *testing.T) {
+	tx0 := transferSubstate(t, 1, 0)
+	tx1 := chainTransfer(t, 2, 0, tx0.OutputAlloc)
+
+	c := &chainedReplay{mode: chainedSegment, state: newChainedState()}
+	pool := newChainedTaskPool(t, map[uint64][]*research.Substate{1: {tx0}, 2: {tx1}})
+	err := c.blockTask(1, nil, pool)
+	if err == nil || !strings.Contains(err.Error(), "block substate not found") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// block substates have no state changes before and after transactions
+	blockSubstate := &research.BlockSubstate{
+		PreTxInputAlloc:   alloc(),
+		PreTxOutputAlloc:  alloc(),
+		PostTxInputAlloc:  alloc(),
+		PostTxOutputAlloc: alloc(),
+	}
+	c = &chainedReplay{mode: chainedSegment, state: newChainedState()}
+	for block := uint64(1); block <= 2; block++ {
+		if err := c.blockTask(block, blockSubstate, pool); err != nil {
+			t.Fatalf("block %v: %v", block, err)
+		}
+	}
+}
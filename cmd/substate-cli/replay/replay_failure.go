@@ -0,0 +1,219 @@
+package replay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+var KeepGoingFlag = &cli.BoolFlag{
+	Name:  "keep-going",
+	Usage: "Continue replaying after inconsistent outputs and errors, then report all failures",
+}
+
+var FailureDirFlag = &cli.PathFlag{
+	Name:  "failure-dir",
+	Usage: "Directory to save failed substates and failure report with --keep-going",
+	Value: "replay-failures",
+}
+
+// Failure categories of --keep-going
+const (
+	failureApplyMessage = "apply-message" // core.ApplyMessage returned an error
+	failurePanic        = "panic"         // replay panicked
+	failureInputAlloc   = "input-alloc"
+	failureOutputAlloc  = "output-alloc"
+	failureBlockEnv     = "block-env"
+	failureTxMessage    = "tx-message"
+	failureStatus       = "status"
+	failureGasUsed      = "gas-used"
+	failureLogs         = "logs"
+	failureBloom        = "bloom"
+	failureCallTrace    = "call-trace" // call trace recorded with --record-traces
+)
+
+// replayFailure is a transaction that failed to be replayed faithfully
+type replayFailure struct {
+	block    uint64
+	tx       int
+	category string // comma-separated failure categories
+	err      error
+}
+
+// replayFailures collects failures of substate-cli replay --keep-going
+type replayFailures struct {
+	dir string
+
+	mu       sync.Mutex
+	failures []replayFailure
+}
+
+func newReplayFailures(dir string) (*replayFailures, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &replayFailures{dir: dir}, nil
+}
+
+// categorizeFailure returns comma-separated parts of substates which are not equal
+func categorizeFailure(diff research.SubstateDiff) string {
+	var categories []string
+	seen := make(map[string]bool)
+	for _, e := range diff {
+		category := e.Part
+		if e.Part == "result" {
+			switch {
+			case e.Field == "status":
+				category = failureStatus
+			case e.Field == "gasUsed":
+				category = failureGasUsed
+			case e.Field == "bloom":
+				category = failureBloom
+			case strings.HasPrefix(e.Path, "logs"):
+				category = failureLogs
+			}
+		} else {
+			category = map[string]string{
+				"inputAlloc":  failureInputAlloc,
+				"outputAlloc": failureOutputAlloc,
+				"blockEnv":    failureBlockEnv,
+				"txMessage":   failureTxMessage,
+			}[e.Part]
+		}
+		if !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	return strings.Join(categories, ",")
+}
+
+// task replays a transaction substate like replayTask, but it saves the failure
+// in its own directory and returns nil to continue replaying
+func (f *replayFailures) task(block uint64, tx int, substate *research.Substate, taskPool *research.SubstateTaskPool) (err error) {
+	var replaySubstate *research.Substate
+
+	defer func() {
+		if r := recover(); r != nil {
+			f.add(block, tx, failurePanic, fmt.Errorf("%v", r), substate, nil)
+			err = nil
+		}
+	}()
+
+	replaySubstate, err = replayTx(tx, substate)
+	if err != nil {
+		f.add(block, tx, failureApplyMessage, err, substate, nil)
+		return nil
+	}
+
+	if !proto.Equal(substate, replaySubstate) {
+		diff := research.DiffSubstate(substate, replaySubstate)
+		f.add(block, tx, categorizeFailure(diff), fmt.Errorf("not faithful replay - inconsistent output"), substate, replaySubstate)
+		return nil
+	}
+
+	trace, replayTrace, err := replayTxTrace(block, tx, substate)
+	if err != nil {
+		f.add(block, tx, failureCallTrace, err, substate, nil)
+		return nil
+	}
+
+	if !proto.Equal(trace, replayTrace) {
+		f.add(block, tx, failureCallTrace, fmt.Errorf("not faithful replay - inconsistent call trace"), substate, replaySubstate)
+		txDir := filepath.Join(f.dir, fmt.Sprintf("%v_%v", block, tx))
+		saveTraceJSON(txDir, "", trace, replayTrace)
+		b, _ := research.DiffTxTrace(trace, replayTrace).JSON()
+		os.WriteFile(filepath.Join(txDir, "trace-diff.json"), b, 0644)
+	}
+
+	return nil
+}
+
+// add records a failure and saves substates into <dir>/<block>_<tx>
+func (f *replayFailures) add(block uint64, tx int, category string, err error, substate, replaySubstate *research.Substate) {
+	fmt.Printf("block %v, tx %v, %s: %v\n", block, tx, category, err)
+
+	txDir := filepath.Join(f.dir, fmt.Sprintf("%v_%v", block, tx))
+	if mkdirErr := os.MkdirAll(txDir, 0755); mkdirErr != nil {
+		fmt.Printf("substate-cli replay: error creating %s: %v\n", txDir, mkdirErr)
+	} else {
+		saveSubstateJSON(txDir, "", substate, replaySubstate)
+		reason := fmt.Sprintf("%s: %v\n", category, err)
+		if replaySubstate != nil {
+			diff := research.DiffSubstate(substate, replaySubstate)
+			reason += diff.String()
+			b, _ := diff.JSON()
+			os.WriteFile(filepath.Join(txDir, "diff.json"), b, 0644)
+		}
+		os.WriteFile(filepath.Join(txDir, "reason.txt"), []byte(reason), 0644)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, replayFailure{block: block, tx: tx, category: category, err: err})
+}
+
+// report saves <dir>/tx-list.txt to replay failed transactions again with --tx-list,
+// and <dir>/summary.txt with the number of failures of each category and the
+// reason of each failure. It returns an error if any transaction failed.
+func (f *replayFailures) report() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sort.Slice(f.failures, func(i, j int) bool {
+		a, b := f.failures[i], f.failures[j]
+		return a.block < b.block || (a.block == b.block && a.tx < b.tx)
+	})
+
+	txList := &strings.Builder{}
+	reasons := &strings.Builder{}
+	counts := make(map[string]int)
+	for _, failure := range f.failures {
+		fmt.Fprintf(txList, "%v_%v\n", failure.block, failure.tx)
+		fmt.Fprintf(reasons, "%v_%v %s: %v\n", failure.block, failure.tx, failure.category, failure.err)
+		for _, category := range strings.Split(failure.category, ",") {
+			counts[category]++
+		}
+	}
+
+	categories := make([]string, 0, len(counts))
+	for category := range counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	summary := &strings.Builder{}
+	for _, category := range categories {
+		fmt.Fprintf(summary, "%s: %v txs\n", category, counts[category])
+	}
+	if len(categories) > 0 {
+		summary.WriteString("\n")
+	}
+	summary.WriteString(reasons.String())
+
+	txListPath := filepath.Join(f.dir, "tx-list.txt")
+	if err := os.WriteFile(txListPath, []byte(txList.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(f.dir, "summary.txt"), []byte(summary.String()), 0644); err != nil {
+		return err
+	}
+
+	if len(f.failures) == 0 {
+		fmt.Printf("substate-cli replay: no failures\n")
+		return nil
+	}
+
+	for _, category := range categories {
+		fmt.Printf("substate-cli replay: %s: %v txs\n", category, counts[category])
+	}
+	fmt.Printf("substate-cli replay: saved failed substates in %s, replay them with --tx-list %s\n", f.dir, txListPath)
+
+	return fmt.Errorf("substate-cli replay: %v txs failed to replay faithfully", len(f.failures))
+}
@@ -0,0 +1,170 @@
+package replay
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// transferSubstate returns a substate of a value transfer from 0xaa to 0xbb
+// whose output alloc and result are recorded by replaying it
+func transferSubstate(t *testing.T, block uint64, tx int) *research.Substate {
+	from, to := []byte{0xaa}, []byte{0xbb}
+	substate := &research.Substate{
+		InputAlloc: &research.Substate_Alloc{
+			Alloc: []*research.Substate_AllocEntry{
+				{Address: from, Account: &research.Substate_Account{Nonce: proto.Uint64(uint64(tx)), Balance: []byte{0x64}}},
+			},
+		},
+		BlockEnv: &research.Substate_BlockEnv{
+			Coinbase:   []byte{0xcc},
+			Difficulty: []byte{},
+			GasLimit:   proto.Uint64(30_000_000),
+			Number:     proto.Uint64(block),
+			Timestamp:  proto.Uint64(block * 12),
+		},
+		TxMessage: &research.Substate_TxMessage{
+			Nonce:    proto.Uint64(uint64(tx)),
+			GasPrice: []byte{},
+			Gas:      proto.Uint64(21_000),
+			From:     from,
+			To:       wrapperspb.Bytes(to),
+			Value:    []byte{0x01},
+			Input:    &research.Substate_TxMessage_Data{Data: []byte{}},
+			TxType:   research.Substate_TxMessage_TXTYPE_LEGACY.Enum(),
+		},
+	}
+	return recordSubstate(t, tx, substate)
+}
+
+// recordSubstate returns substate with the output alloc and result of replaying it
+func recordSubstate(t *testing.T, tx int, substate *research.Substate) *research.Substate {
+	ReplayChainConfig = params.MainnetChainConfig
+	recorded, err := replayTx(tx, substate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorded
+}
+
+func TestReplayKeepGoing(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "substate")
+	backend, err := research.OpenBackendDatabase(dbPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := research.NewSubstateDB(backend)
+	for block := uint64(1); block <= 3; block++ {
+		for tx := 0; tx < 2; tx++ {
+			substate := transferSubstate(t, block, tx)
+			if block == 2 && tx == 1 {
+				// recorded gas used and balance of 0xbb are not replayed
+				substate.Result.GasUsed = proto.Uint64(21_001)
+				for _, entry := range substate.OutputAlloc.Alloc {
+					if entry.Address[len(entry.Address)-1] == 0xbb {
+						entry.Account.Balance = []byte{0x02}
+					}
+				}
+			}
+			db.PutSubstate(block, tx, substate)
+		}
+	}
+	db.Close()
+
+	failureDir := filepath.Join(dir, "replay-failures")
+	app := &cli.App{Commands: []*cli.Command{ReplayCommand}}
+	err = app.Run([]string{"substate-cli", "replay",
+		"--substate-db", dbPath,
+		"--block-segment", "1-3",
+		"--workers", "2",
+		"--keep-going",
+		"--failure-dir", failureDir,
+	})
+	if err == nil {
+		t.Fatal("replay of an inconsistent tx succeeded")
+	}
+
+	txList, err := os.ReadFile(filepath.Join(failureDir, "tx-list.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(txList), "2_1\n"; got != want {
+		t.Fatalf("tx-list.txt = %q, want %q", got, want)
+	}
+
+	summary, err := os.ReadFile(filepath.Join(failureDir, "summary.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "gas-used: 1 txs\n" +
+		"output-alloc: 1 txs\n" +
+		"\n" +
+		"2_1 output-alloc,gas-used: not faithful replay - inconsistent output\n"
+	if got := string(summary); got != want {
+		t.Fatalf("summary.txt =\n%s\nwant\n%s", got, want)
+	}
+
+	// other txs replayed after the failure are not saved
+	entries, err := os.ReadDir(failureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != "2_1" {
+			t.Errorf("unexpected failure directory %s", entry.Name())
+		}
+	}
+	for _, name := range []string{"reason.txt", "diff.json", "record_substate.json", "replay_substate.json"} {
+		if _, err := os.Stat(filepath.Join(failureDir, "2_1", name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestReplayCheckTraces(t *testing.T) {
+	dbPath := newTransferDB(t, 3, 2)
+	if err := runReplay(t, dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if checkTraces {
+		t.Fatal("call traces are checked without recorded call traces")
+	}
+
+	// recorded call trace of 2_1 has a wrong gas used
+	backend, err := research.OpenBackendDatabase(dbPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := research.NewSubstateDB(backend)
+	trace, err := core.ReplayTxTrace(ReplayChainConfig, 1, transferSubstate(t, 2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace.Call.GasUsed = proto.Uint64(1)
+	db.PutTxTrace(2, 1, trace)
+	db.Close()
+
+	failureDir := filepath.Join(t.TempDir(), "replay-failures")
+	if err := runReplay(t, dbPath, "--keep-going", "--failure-dir", failureDir); err == nil {
+		t.Fatal("replay of an inconsistent call trace succeeded")
+	}
+	if !checkTraces {
+		t.Fatal("call traces are not checked")
+	}
+	summary, err := os.ReadFile(filepath.Join(failureDir, "summary.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(summary), "2_1 call-trace: not faithful replay - inconsistent call trace\n") {
+		t.Fatalf("unexpected summary.txt\n%s", summary)
+	}
+}
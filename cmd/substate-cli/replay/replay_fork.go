@@ -1,7 +1,6 @@
 package replay
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
@@ -18,7 +17,6 @@ import (
 	"github.com/ethereum/go-ethereum/research"
 	"github.com/ethereum/go-ethereum/tests"
 	cli "github.com/urfave/cli/v2"
-	"google.golang.org/protobuf/proto"
 )
 
 // record-replay: replay-fork command
@@ -32,9 +30,11 @@ var ReplayForkCommand = &cli.Command{
 		research.SkipCallTxsFlag,
 		research.SkipCreateTxsFlag,
 		HardForkFlag,
-		research.SubstateDirFlag,
+		research.SubstateDbFlag,
 		research.BlockSegmentFlag,
 		research.TxListFlag,
+		research.CheckpointFlag,
+		research.ResumeFlag,
 	},
 	Description: `
 substate-cli replay executes transactions in the given block segment
@@ -195,9 +195,9 @@ func replayForkTask(block uint64, tx int, substate *research.Substate, taskPool
 	rr.GasUsed = result.UsedGas
 	rr.SaveSubstate(replaySubstate)
 
-	eqAlloc := proto.Equal(substate.InputAlloc, replaySubstate.InputAlloc) &&
-		proto.Equal(substate.OutputAlloc, replaySubstate.OutputAlloc)
-	eqResult := proto.Equal(substate.Result, replaySubstate.Result)
+	eqAlloc := research.EqualAlloc(substate.InputAlloc, replaySubstate.InputAlloc) &&
+		research.EqualAlloc(substate.OutputAlloc, replaySubstate.OutputAlloc)
+	eqResult := research.EqualResult(substate.Result, replaySubstate.Result)
 
 	if eqAlloc && eqResult {
 		// same transaction output state and result
@@ -208,14 +208,8 @@ func replayForkTask(block uint64, tx int, substate *research.Substate, taskPool
 		return nil
 	}
 
-	outputAlloc := make(map[common.Address]*research.Substate_Account)
-	for _, entry := range substate.OutputAlloc.Alloc {
-		outputAlloc[*research.BytesToAddress(entry.Address)] = entry.Account
-	}
-	evmAlloc := make(map[common.Address]*research.Substate_Account)
-	for _, entry := range replaySubstate.OutputAlloc.Alloc {
-		evmAlloc[*research.BytesToAddress(entry.Address)] = entry.Account
-	}
+	diff := research.DiffSubstate(substate, replaySubstate)
+	fmt.Printf("block %v, tx %v, different output with the hard fork\n%s", block, tx, diff)
 
 	outputResult := substate.Result
 	evmResult := replaySubstate.Result
@@ -235,68 +229,31 @@ func replayForkTask(block uint64, tx int, substate *research.Substate, taskPool
 		*evmResult.Status == types.ReceiptStatusSuccessful {
 		// when both output and evm were successful, check alloc and gas usage
 
-		// check account states
-		if len(outputAlloc) != len(evmAlloc) {
+		// check account states, balances differ by gas usage
+		var removed, added, invalid int
+		for _, e := range diff.Part("outputAlloc") {
+			switch {
+			case e.Field == "account" && e.Kind == research.DiffRemoved:
+				removed++
+			case e.Field == "account" && e.Kind == research.DiffAdded:
+				added++
+			case e.Field != "balance":
+				invalid++
+			}
+		}
+		if removed != added {
 			stat = &ReplayForkStat{
 				Count:  1,
 				ErrStr: ReplayForkResult_OutOfGas,
 			}
 			return nil
 		}
-		for addr := range outputAlloc {
-			account1 := outputAlloc[addr]
-			account2 := evmAlloc[addr]
-			if account2 == nil {
-				stat = &ReplayForkStat{
-					Count:  1,
-					ErrStr: ReplayForkResult_InvalidAlloc,
-				}
-				return nil
-			}
-
-			// check nonce
-			if account1.Nonce != account2.Nonce {
-				stat = &ReplayForkStat{
-					Count:  1,
-					ErrStr: ReplayForkResult_InvalidAlloc,
-				}
-				return nil
-			}
-
-			// check code
-			if !bytes.Equal(account1.GetCode(), account2.GetCode()) {
-				stat = &ReplayForkStat{
-					Count:  1,
-					ErrStr: ReplayForkResult_InvalidAlloc,
-				}
-				return nil
-			}
-
-			// check storage
-			storage1 := make(map[common.Hash]common.Hash)
-			for _, entry := range account1.Storage {
-				storage1[*research.BytesToHash(entry.Key)] = *research.BytesToHash(entry.Value)
-			}
-			storage2 := make(map[common.Hash]common.Hash)
-			for _, entry := range account2.Storage {
-				storage2[*research.BytesToHash(entry.Key)] = *research.BytesToHash(entry.Value)
-			}
-			if len(storage1) != len(storage2) {
-				stat = &ReplayForkStat{
-					Count:  1,
-					ErrStr: ReplayForkResult_InvalidAlloc,
-				}
-				return nil
-			}
-			for k, v1 := range storage1 {
-				if v2, exist := storage2[k]; !exist || v1 != v2 {
-					stat = &ReplayForkStat{
-						Count:  1,
-						ErrStr: ReplayForkResult_InvalidAlloc,
-					}
-					return nil
-				}
+		if removed > 0 || invalid > 0 {
+			stat = &ReplayForkStat{
+				Count:  1,
+				ErrStr: ReplayForkResult_InvalidAlloc,
 			}
+			return nil
 		}
 
 		// more gas
@@ -0,0 +1,224 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	_ "github.com/ethereum/go-ethereum/eth/tracers/js"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	_ "github.com/ethereum/go-ethereum/eth/tracers/native"
+	cli "github.com/urfave/cli/v2"
+)
+
+var TracerFlag = &cli.StringFlag{
+	Name:  "tracer",
+	Usage: "Trace transactions with a tracer of eth/tracers (callTracer, prestateTracer, 4byteTracer, ...), \"structLogger\", JS code or a path to a .js file",
+}
+
+var TracerConfigFlag = &cli.StringFlag{
+	Name:  "tracer-config",
+	Usage: "Tracer config in JSON, or logger.Config of \"structLogger\"",
+}
+
+// Sinks of --tracer-sink
+const (
+	tracerSinkJSONL = "jsonl" // one JSONL file per worker
+	tracerSinkTx    = "tx"    // one JSON file per transaction
+)
+
+var TracerSinkFlag = &cli.StringFlag{
+	Name:  "tracer-sink",
+	Usage: "Save tracer results in one JSONL file per worker (\"jsonl\") or one JSON file per transaction (\"tx\")",
+	Value: tracerSinkJSONL,
+}
+
+var TracerDirFlag = &cli.PathFlag{
+	Name:  "tracer-dir",
+	Usage: "Directory to save tracer results with --tracer",
+	Value: "replay-traces",
+}
+
+// structLoggerName is --tracer of logger.StructLogger, which is the default
+// tracer of debug_traceTransaction without a name in eth/tracers
+const structLoggerName = "structLogger"
+
+// tracerResult is a line of --tracer-sink jsonl
+type tracerResult struct {
+	Block  uint64          `json:"block"`
+	Tx     int             `json:"tx"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// replayTracing creates a tracer of --tracer for each replayed transaction and
+// saves its result in --tracer-dir
+type replayTracing struct {
+	name   string
+	config json.RawMessage
+	sink   string
+	dir    string
+
+	mu      sync.Mutex
+	idle    []*tracerFile // JSONL files not used by any worker
+	files   []*tracerFile
+	written int
+}
+
+// tracerFile is a JSONL file used by one worker at a time
+type tracerFile struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+// tracing of --tracer used by replayTxState, or nil without --tracer
+var tracing *replayTracing
+
+// newReplayTracingCli returns replayTracing of --tracer, or nil without --tracer
+func newReplayTracingCli(ctx *cli.Context) (*replayTracing, error) {
+	name := ctx.String(TracerFlag.Name)
+	if name == "" {
+		for _, flag := range []cli.Flag{TracerConfigFlag, TracerSinkFlag, TracerDirFlag} {
+			if n := flag.Names()[0]; ctx.IsSet(n) {
+				return nil, fmt.Errorf("--%s requires --%s", n, TracerFlag.Name)
+			}
+		}
+		return nil, nil
+	}
+	// JS tracer in a file
+	if strings.HasSuffix(name, ".js") {
+		code, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading --%s: %w", TracerFlag.Name, err)
+		}
+		name = string(code)
+	}
+
+	t := &replayTracing{
+		name: name,
+		sink: ctx.String(TracerSinkFlag.Name),
+		dir:  ctx.Path(TracerDirFlag.Name),
+	}
+	if config := ctx.String(TracerConfigFlag.Name); config != "" {
+		t.config = json.RawMessage(config)
+	}
+	if t.sink != tracerSinkJSONL && t.sink != tracerSinkTx {
+		return nil, fmt.Errorf("--%s must be %q or %q", TracerSinkFlag.Name, tracerSinkJSONL, tracerSinkTx)
+	}
+
+	// check the tracer name and config before replaying
+	if _, err := t.newTracer(0, 0, common.Hash{}); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating tracer directory: %w", err)
+	}
+	return t, nil
+}
+
+// newTracer returns a new tracer for a transaction
+func (t *replayTracing) newTracer(block uint64, tx int, txHash common.Hash) (tracers.Tracer, error) {
+	if t.name == structLoggerName {
+		config := &logger.Config{}
+		if t.config != nil {
+			if err := json.Unmarshal(t.config, config); err != nil {
+				return nil, fmt.Errorf("error decoding --%s: %w", TracerConfigFlag.Name, err)
+			}
+		}
+		return logger.NewStructLogger(config), nil
+	}
+
+	tracer, err := tracers.DefaultDirectory.New(t.name, &tracers.Context{
+		BlockNumber: new(big.Int).SetUint64(block),
+		TxIndex:     tx,
+		TxHash:      txHash,
+	}, t.config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating tracer: %w", err)
+	}
+	return tracer, nil
+}
+
+// write saves the result of the tracer of a transaction
+func (t *replayTracing) write(block uint64, tx int, tracer tracers.Tracer) error {
+	r := &tracerResult{Block: block, Tx: tx}
+	result, err := tracer.GetResult()
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.Result = result
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error encoding tracer result: %w", err)
+	}
+
+	if t.sink == tracerSinkTx {
+		err = os.WriteFile(filepath.Join(t.dir, fmt.Sprintf("%v_%v.json", block, tx)), b, 0644)
+	} else {
+		err = t.writeLine(b)
+	}
+	if err != nil {
+		return fmt.Errorf("error saving tracer result: %w", err)
+	}
+
+	t.mu.Lock()
+	t.written++
+	t.mu.Unlock()
+	return nil
+}
+
+// writeLine appends a line to an idle JSONL file, or to a new one if all
+// JSONL files are used by other workers
+func (t *replayTracing) writeLine(b []byte) error {
+	t.mu.Lock()
+	var f *tracerFile
+	if n := len(t.idle); n > 0 {
+		f, t.idle = t.idle[n-1], t.idle[:n-1]
+	} else {
+		// files of other processes, e.g., before --resume or with --join, are
+		// not truncated
+		name := fmt.Sprintf("worker-%v-%v.jsonl", os.Getpid(), len(t.files))
+		file, err := os.OpenFile(filepath.Join(t.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.mu.Unlock()
+			return err
+		}
+		f = &tracerFile{file: file, w: bufio.NewWriter(file)}
+		t.files = append(t.files, f)
+	}
+	t.mu.Unlock()
+
+	_, err := f.w.Write(append(b, '\n'))
+
+	t.mu.Lock()
+	t.idle = append(t.idle, f)
+	t.mu.Unlock()
+	return err
+}
+
+// close flushes and closes JSONL files
+func (t *replayTracing) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+	for _, f := range t.files {
+		if flushErr := f.w.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+		if closeErr := f.file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	t.files, t.idle = nil, nil
+	return err
+}
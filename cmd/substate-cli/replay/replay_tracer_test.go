@@ -0,0 +1,202 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/research"
+	cli "github.com/urfave/cli/v2"
+)
+
+// newTransferDB returns the path of a substate DB with transferSubstate of
+// txs transactions in each of blocks 1 to blocks
+func newTransferDB(t *testing.T, blocks uint64, txs int) string {
+	dbPath := filepath.Join(t.TempDir(), "substate")
+	backend, err := research.OpenBackendDatabase(dbPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := research.NewSubstateDB(backend)
+	for block := uint64(1); block <= blocks; block++ {
+		for tx := 0; tx < txs; tx++ {
+			db.PutSubstate(block, tx, transferSubstate(t, block, tx))
+		}
+	}
+	db.Close()
+	return dbPath
+}
+
+// runReplay runs substate-cli replay of blocks 1-3 with args
+func runReplay(t *testing.T, dbPath string, args ...string) error {
+	// tracing of --tracer is left for replayTx of later tests otherwise
+	t.Cleanup(func() { tracing = nil })
+	app := &cli.App{Commands: []*cli.Command{ReplayCommand}}
+	return app.Run(append([]string{"substate-cli", "replay",
+		"--substate-db", dbPath,
+		"--block-segment", "1-3",
+	}, args...))
+}
+
+// readTracerResults returns tracer results of JSONL files by block_tx
+func readTracerResults(t *testing.T, files []string) map[string]*tracerResult {
+	results := make(map[string]*tracerResult)
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			r := &tracerResult{}
+			if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+				t.Fatalf("%s: invalid line %q: %v", name, scanner.Text(), err)
+			}
+			key := fmt.Sprintf("%v_%v", r.Block, r.Tx)
+			if results[key] != nil {
+				t.Fatalf("%s: duplicate tracer result of %s", name, key)
+			}
+			results[key] = r
+		}
+		f.Close()
+	}
+	return results
+}
+
+func TestReplayTracerSinkTx(t *testing.T) {
+	dbPath := newTransferDB(t, 3, 2)
+	dir := filepath.Join(t.TempDir(), "traces")
+	if err := runReplay(t, dbPath, "--tracer", "callTracer", "--tracer-sink", "tx", "--tracer-dir", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 {
+		t.Fatalf("%v tracer results, want 6", len(entries))
+	}
+	for block := uint64(1); block <= 3; block++ {
+		for tx := 0; tx < 2; tx++ {
+			b, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%v_%v.json", block, tx)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &tracerResult{}
+			if err := json.Unmarshal(b, r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Block != block || r.Tx != tx || r.Error != "" || !strings.Contains(string(r.Result), `"type":"CALL"`) {
+				t.Fatalf("unexpected tracer result of %v_%v: %s", block, tx, b)
+			}
+		}
+	}
+}
+
+func TestReplayTracerSinkJSONL(t *testing.T) {
+	dbPath := newTransferDB(t, 3, 2)
+	dir := filepath.Join(t.TempDir(), "traces")
+	if err := runReplay(t, dbPath, "--tracer", "callTracer", "--tracer-dir", dir, "--workers", "4"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := fmt.Sprintf("worker-%v-", os.Getpid())
+	for _, name := range files {
+		if base := filepath.Base(name); !strings.HasPrefix(base, prefix) || !strings.HasSuffix(base, ".jsonl") {
+			t.Fatalf("unexpected tracer file %s", base)
+		}
+	}
+	if len(files) == 0 || len(files) > 4 {
+		t.Fatalf("%v JSONL files for 4 workers", len(files))
+	}
+	if results := readTracerResults(t, files); len(results) != 6 {
+		t.Fatalf("%v tracer results, want 6", len(results))
+	}
+}
+
+func TestReplayTracingConcurrentWorkers(t *testing.T) {
+	tracing := &replayTracing{name: structLoggerName, sink: tracerSinkJSONL, dir: t.TempDir()}
+	tracer, err := tracing.newTracer(0, 0, common.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a worker writing to worker-<pid>-0.jsonl doesn't share it with another worker
+	if err := tracing.write(1, 0, tracer); err != nil {
+		t.Fatal(err)
+	}
+	busy := tracing.idle[0]
+	tracing.idle = nil
+	if err := tracing.write(1, 1, tracer); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracing.files) != 2 || tracing.files[1] == busy {
+		t.Fatalf("%v JSONL files, want a new file for the second worker", len(tracing.files))
+	}
+	tracing.idle = append(tracing.idle, busy)
+
+	const workers, txs = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(block uint64) {
+			defer wg.Done()
+			for tx := 0; tx < txs; tx++ {
+				if err := tracing.write(block, tx, tracer); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(uint64(w + 2))
+	}
+	wg.Wait()
+	if len(tracing.files) > workers {
+		t.Fatalf("%v JSONL files for %v workers", len(tracing.files), workers)
+	}
+	if err := tracing.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := 2 + workers*txs; tracing.written != want {
+		t.Fatalf("written = %v, want %v", tracing.written, want)
+	}
+	var files []string
+	for i := 0; i < workers; i++ {
+		name := filepath.Join(tracing.dir, fmt.Sprintf("worker-%v-%v.jsonl", os.Getpid(), i))
+		if _, err := os.Stat(name); err == nil {
+			files = append(files, name)
+		}
+	}
+	if results := readTracerResults(t, files); len(results) != tracing.written {
+		t.Fatalf("%v tracer results in JSONL files, want %v", len(results), tracing.written)
+	}
+}
+
+func TestReplayTracerUnknown(t *testing.T) {
+	tracing := &replayTracing{name: "noSuchTracer"}
+	if _, err := tracing.newTracer(0, 0, common.Hash{}); err == nil {
+		t.Fatal("unknown tracer is created")
+	}
+
+	// replay fails before replaying transactions or creating --tracer-dir
+	dbPath := newTransferDB(t, 1, 1)
+	dir := filepath.Join(t.TempDir(), "traces")
+	err := runReplay(t, dbPath, "--tracer", "noSuchTracer", "--tracer-dir", dir)
+	if err == nil || !strings.Contains(err.Error(), "error creating tracer") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("--tracer-dir is created for an unknown tracer: %v", err)
+	}
+}
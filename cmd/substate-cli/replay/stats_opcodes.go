@@ -0,0 +1,66 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/research/analysis"
+	cli "github.com/urfave/cli/v2"
+)
+
+var GroupByFlag = &cli.StringFlag{
+	Name:  "group-by",
+	Usage: "Break down statistics by \"none\", hard fork era (\"fork\"), or code hash of executed contracts (\"code-hash\")",
+	Value: analysis.GroupByNone,
+}
+
+var FormatFlag = &cli.StringFlag{
+	Name:  "format",
+	Usage: "Output format, \"json\" or \"csv\"",
+	Value: analysis.FormatJSON,
+}
+
+// record-replay: substate-cli stats-opcodes command
+var StatsOpcodesCommand = &cli.Command{
+	Action: statsOpcodesAction,
+	Name:   "stats-opcodes",
+	Usage:  "replay transactions and aggregate opcode and precompile usage statistics",
+	Flags: []cli.Flag{
+		GroupByFlag,
+		FormatFlag,
+		AnalysisOutputFlag,
+		research.WorkersFlag,
+		research.SkipTransferTxsFlag,
+		research.SkipCallTxsFlag,
+		research.SkipCreateTxsFlag,
+		research.SubstateDbFlag,
+		research.BlockSegmentFlag,
+		research.TxListFlag,
+		research.ChainFlag,
+		research.GenesisFlag,
+	},
+	Description: `
+substate-cli stats-opcodes replays transactions in the given block segment with
+a lightweight EVM logger, and aggregates the number of executions and gas of
+each opcode, the number of calls and gas of each precompiled contract, and the
+number of transactions by their max call depth. Gas given to callees of CALL,
+CALLCODE, DELEGATECALL and STATICCALL is counted by the callees instead.
+
+Statistics are broken down by --group-by, and written to --output or stdout
+in --format. It runs the "opcodes" analysis of substate-cli analyze.`,
+	Category: "analysis",
+}
+
+// record-replay: func statsOpcodesAction for stats-opcodes command
+func statsOpcodesAction(ctx *cli.Context) error {
+	config, err := json.Marshal(&analysis.OpcodesConfig{
+		GroupBy: ctx.String(GroupByFlag.Name),
+		Format:  ctx.String(FormatFlag.Name),
+	})
+	if err != nil {
+		return fmt.Errorf("substate-cli stats-opcodes: %w", err)
+	}
+	def, _ := analysis.Lookup("opcodes")
+	return runAnalysisCli("substate-cli stats-opcodes", def, config, ctx)
+}
@@ -75,7 +75,7 @@ type BackendDatabase interface {
 	ethdb.KeyValueWriter
 	ethdb.Batcher
 	ethdb.Iteratee
-	ethdb.Stater
+	ethdb.KeyValueStater
 	ethdb.Compacter
 	io.Closer
 }
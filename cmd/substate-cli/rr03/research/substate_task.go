@@ -10,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum/research"
 	"github.com/shirou/gopsutil/cpu"
 	cli "github.com/urfave/cli/v2"
 )
@@ -117,6 +118,10 @@ type SubstateTaskConfig struct {
 	SkipTransferTxs bool
 	SkipCallTxs     bool
 	SkipCreateTxs   bool
+
+	// checkpoint file of the latest research package
+	CheckpointPath string
+	Resume         bool
 }
 
 func NewSubstateTaskConfigCli(ctx *cli.Context) *SubstateTaskConfig {
@@ -126,6 +131,9 @@ func NewSubstateTaskConfigCli(ctx *cli.Context) *SubstateTaskConfig {
 		SkipTransferTxs: ctx.Bool(SkipTransferTxsFlag.Name),
 		SkipCallTxs:     ctx.Bool(SkipCallTxsFlag.Name),
 		SkipCreateTxs:   ctx.Bool(SkipCreateTxsFlag.Name),
+
+		CheckpointPath: ctx.Path(research.CheckpointFlag.Name),
+		Resume:         ctx.Bool(research.ResumeFlag.Name),
 	}
 }
 
@@ -213,6 +221,35 @@ func (pool *SubstateTaskPool) ExecuteBlock(block uint64) (numTx int64, err error
 func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 	start := time.Now()
 
+	// first block to execute, and save checkpoint of contiguously completed blocks
+	first := segment.First
+	if pool.Config.Resume && pool.Config.CheckpointPath == "" {
+		return fmt.Errorf("%s: --resume requires --checkpoint", pool.Name)
+	}
+	if pool.Config.CheckpointPath != "" {
+		var err error
+		first, err = research.ResumeSegment(pool.Config.CheckpointPath, pool.Config.Resume, pool.Name, research.NewBlockSegment(segment.First, segment.Last))
+		if err != nil {
+			return err
+		}
+		if first > segment.Last {
+			fmt.Printf("%s: block segment %v-%v is already completed\n", pool.Name, segment.First, segment.Last)
+			return nil
+		}
+	}
+	checkpoint := &research.Checkpoint{Name: pool.Name, First: segment.First, Last: segment.Last, Next: first}
+	lastCheckpoint := start
+	saveCheckpoint := func(next uint64) {
+		if pool.Config.CheckpointPath == "" || next == checkpoint.Next {
+			return
+		}
+		checkpoint.Next = next
+		if err := checkpoint.Save(pool.Config.CheckpointPath); err != nil {
+			fmt.Printf("%s: error saving checkpoint %s: %v\n", pool.Name, pool.Config.CheckpointPath, err)
+		}
+		lastCheckpoint = time.Now()
+	}
+
 	var totalNumBlock, totalNumTx int64
 	defer func() {
 		duration := time.Since(start) + 1*time.Nanosecond
@@ -287,7 +324,7 @@ func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 	go func() {
 		defer wg.Done()
 
-		for block := segment.First; block <= segment.Last; block++ {
+		for block := first; block <= segment.Last; block++ {
 			select {
 
 			case workChan <- block:
@@ -304,13 +341,16 @@ func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 	var lastSec float64
 	var lastNumBlock, lastNumTx int64
 	waitMap := make(map[uint64]struct{})
-	for block := segment.First; block <= segment.Last; {
+	for block := first; block <= segment.Last; {
 
 		// Count finshed blocks from waitMap in order
 		if _, ok := waitMap[block]; ok {
 			delete(waitMap, block)
 
 			block++
+			if block > segment.Last || time.Since(lastCheckpoint) > research.CheckpointInterval {
+				saveCheckpoint(block)
+			}
 			continue
 		}
 
@@ -339,6 +379,7 @@ func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 
 		case error:
 			err := data.(error)
+			saveCheckpoint(block)
 			return err
 
 		default:
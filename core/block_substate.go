@@ -0,0 +1,186 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/research"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// record-replay: saveAllocs returns deep copies of StateDB.Research* since the
+// last SetTxContext as input and output allocs
+func saveAllocs(statedb *state.StateDB) (*research.Substate_Alloc, *research.Substate_Alloc) {
+	substate := &research.Substate{}
+	statedb.SaveSubstate(substate)
+	substate = substate.ProtoClone()
+	return substate.InputAlloc, substate.OutputAlloc
+}
+
+// record-replay: saveBlockSubstatePreTx saves the block env, beacon block root
+// and state changes before the first transaction of the block
+func saveBlockSubstatePreTx(blockSubstate *research.BlockSubstate, statedb *state.StateDB, vmenv *vm.EVM, block *types.Block) {
+	substate := &research.Substate{}
+	vmenv.Context.ResearchBlockHashes = nil
+	vmenv.Context.SaveSubstate(substate)
+	blockSubstate.BlockEnv = substate.BlockEnv
+
+	blockSubstate.BeaconRoot = research.HashToBytesValue(block.BeaconRoot())
+	blockSubstate.PreTxInputAlloc, blockSubstate.PreTxOutputAlloc = saveAllocs(statedb)
+}
+
+// record-replay: saveBlockSubstatePostTx saves uncles, withdrawals and state
+// changes of consensus.Engine.Finalize after the last transaction of the block
+func saveBlockSubstatePostTx(config *params.ChainConfig, blockSubstate *research.BlockSubstate, statedb *state.StateDB, block *types.Block) {
+	for _, uncle := range block.Uncles() {
+		blockSubstate.Uncles = append(blockSubstate.Uncles, &research.BlockSubstate_Uncle{
+			Coinbase: research.AddressToBytes(&uncle.Coinbase),
+			Number:   proto.Uint64(uncle.Number.Uint64()),
+		})
+	}
+	for _, w := range block.Withdrawals() {
+		blockSubstate.Withdrawals = append(blockSubstate.Withdrawals, &research.BlockSubstate_Withdrawal{
+			Index:     proto.Uint64(w.Index),
+			Validator: proto.Uint64(w.Validator),
+			Address:   research.AddressToBytes(&w.Address),
+			Amount:    proto.Uint64(w.Amount),
+		})
+	}
+
+	// same as Finalise in IntermediateRoot of block validation
+	statedb.Finalise(config.IsEIP158(block.Number()))
+	blockSubstate.PostTxInputAlloc, blockSubstate.PostTxOutputAlloc = saveAllocs(statedb)
+}
+
+// replayChainReader is consensus.ChainHeaderReader of block substate replay,
+// which has no headers except the chain config for block rewards
+type replayChainReader struct {
+	config *params.ChainConfig
+}
+
+func (r replayChainReader) Config() *params.ChainConfig                    { return r.config }
+func (r replayChainReader) CurrentHeader() *types.Header                   { return nil }
+func (r replayChainReader) GetHeader(common.Hash, uint64) *types.Header    { return nil }
+func (r replayChainReader) GetHeaderByNumber(uint64) *types.Header         { return nil }
+func (r replayChainReader) GetHeaderByHash(common.Hash) *types.Header      { return nil }
+func (r replayChainReader) GetTd(hash common.Hash, number uint64) *big.Int { return nil }
+
+// record-replay: newReplayStateDB returns an in-memory StateDB with the alloc
+// and reset StateDB.Research* to save the replayed alloc
+func newReplayStateDB(alloc *research.Substate_Alloc) *state.StateDB {
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.LoadSubstate(&research.Substate{InputAlloc: alloc})
+	statedb.SetTxContext(common.Hash{}, 0)
+	return statedb
+}
+
+// ReplayBlockSubstate executes state changes of a block outside its transactions
+// from the input allocs of the block substate, and returns the replayed block
+// substate. The engine applies block and uncle rewards and withdrawals like
+// Process, e.g., beacon.New(ethash.NewFaker()) for mainnet.
+func ReplayBlockSubstate(config *params.ChainConfig, engine consensus.Engine, blockSubstate *research.BlockSubstate) *research.BlockSubstate {
+	replayBlockSubstate := &research.BlockSubstate{
+		DaoHardFork: blockSubstate.DaoHardFork,
+		BeaconRoot:  blockSubstate.BeaconRoot,
+		Uncles:      blockSubstate.Uncles,
+		Withdrawals: blockSubstate.Withdrawals,
+	}
+
+	// BlockEnv
+	substate := &research.Substate{BlockEnv: blockSubstate.BlockEnv}
+	blockContext := &vm.BlockContext{
+		CanTransfer: CanTransfer,
+		Transfer:    Transfer,
+	}
+	blockContext.LoadSubstate(substate)
+	blockNumber := blockContext.BlockNumber
+	blockContext.SaveSubstate(substate)
+	replayBlockSubstate.BlockEnv = substate.BlockEnv
+
+	// DAO hard fork and beacon block root before the first transaction
+	statedb := newReplayStateDB(blockSubstate.PreTxInputAlloc)
+	if blockSubstate.GetDaoHardFork() {
+		misc.ApplyDAOHardFork(statedb)
+		if config.IsByzantium(blockNumber) {
+			statedb.Finalise(true)
+		} else {
+			statedb.Finalise(config.IsEIP158(blockNumber))
+		}
+	}
+	if beaconRoot := research.BytesValueToHash(blockSubstate.BeaconRoot); beaconRoot != nil {
+		vmenv := vm.NewEVM(*blockContext, vm.TxContext{}, statedb, config, vm.Config{})
+		ProcessBeaconBlockRoot(*beaconRoot, vmenv, statedb)
+	}
+	replayBlockSubstate.PreTxInputAlloc, replayBlockSubstate.PreTxOutputAlloc = saveAllocs(statedb)
+
+	// block and uncle rewards and withdrawals after the last transaction
+	statedb = newReplayStateDB(blockSubstate.PostTxInputAlloc)
+	header := &types.Header{
+		Coinbase:   blockContext.Coinbase,
+		Difficulty: blockContext.Difficulty,
+		Number:     blockNumber,
+		GasLimit:   blockContext.GasLimit,
+		Time:       blockContext.Time,
+		BaseFee:    blockContext.BaseFee,
+	}
+	var uncles []*types.Header
+	for _, uncle := range blockSubstate.Uncles {
+		uncles = append(uncles, &types.Header{
+			Coinbase: *research.BytesToAddress(uncle.Coinbase),
+			Number:   new(big.Int).SetUint64(uncle.GetNumber()),
+		})
+	}
+	var withdrawals []*types.Withdrawal
+	for _, w := range blockSubstate.Withdrawals {
+		withdrawals = append(withdrawals, &types.Withdrawal{
+			Index:     w.GetIndex(),
+			Validator: w.GetValidator(),
+			Address:   *research.BytesToAddress(w.Address),
+			Amount:    w.GetAmount(),
+		})
+	}
+	engine.Finalize(replayChainReader{config}, header, statedb, nil, uncles, withdrawals)
+	statedb.Finalise(config.IsEIP158(blockNumber))
+	replayBlockSubstate.PostTxInputAlloc, replayBlockSubstate.PostTxOutputAlloc = saveAllocs(statedb)
+
+	return replayBlockSubstate
+}
+
+// CheckBlockReplay checks faithful replay of the block substate like CheckReplay,
+// and stores json files of block substates if the outputs are different.
+// This function immediately returns nil if SkipCheckReplay is true.
+func CheckBlockReplay(config *params.ChainConfig, engine consensus.Engine, block uint64, blockSubstate *research.BlockSubstate) error {
+	if SkipCheckReplay {
+		return nil
+	}
+
+	replayBlockSubstate := ReplayBlockSubstate(config, engine, blockSubstate)
+	if !proto.Equal(blockSubstate, replayBlockSubstate) {
+		fmt.Printf("block %v, inconsistent block substate output\n", block)
+		fmt.Print(research.DiffBlockSubstate(blockSubstate, replayBlockSubstate))
+		jm := protojson.MarshalOptions{
+			Indent: "  ",
+		}
+
+		var b []byte
+
+		b, _ = jm.Marshal(blockSubstate)
+		os.WriteFile(fmt.Sprintf("record_block_substate_%v.json", block), b, 0644)
+		b, _ = jm.Marshal(replayBlockSubstate)
+		os.WriteFile(fmt.Sprintf("replay_block_substate_%v.json", block), b, 0644)
+
+		return fmt.Errorf("not faithful replay of block substate - inconsistent output")
+	}
+
+	return nil
+}
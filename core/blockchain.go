@@ -46,6 +46,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/research"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/triedb"
 	"github.com/ethereum/go-ethereum/triedb/hashdb"
@@ -944,6 +945,17 @@ func (bc *BlockChain) writeHeadBlock(block *types.Block) {
 
 	bc.currentBlock.Store(block.Header())
 	headBlockGauge.Update(int64(block.NumberU64()))
+
+	// record-replay: write buffered substates of the new canonical block. The
+	// block has none if it was processed long ago or before a restart, then
+	// substates at its height are deleted and the block is not recorded.
+	if RecordSubstate && RecordSubstateLive {
+		if !research.CommitBlockSubstates(block.Hash(), block.NumberU64()) {
+			log.Warn("record-replay: no substates buffered for canonical block", "number", block.NumberU64(), "hash", block.Hash())
+			research.DeleteBlockSubstates(block.NumberU64())
+			research.UnrecordBlock(block.NumberU64())
+		}
+	}
 }
 
 // stopWithoutSaving stops the blockchain service. If any imports are currently in progress
@@ -1765,7 +1777,17 @@ func (bc *BlockChain) insertChain(chain types.Blocks, setHead bool) (int, error)
 
 		// Process block using the parent state as reference point
 		pstart := time.Now()
-		receipts, logs, usedGas, err := bc.processor.Process(block, statedb, bc.vmConfig)
+		var (
+			receipts types.Receipts
+			logs     []*types.Log
+			usedGas  uint64
+		)
+		// record-replay: record substates of inserted blocks only
+		if processor, ok := bc.processor.(*StateProcessor); ok {
+			receipts, logs, usedGas, err = processor.ProcessRecord(block, statedb, bc.vmConfig, RecordSubstate)
+		} else {
+			receipts, logs, usedGas, err = bc.processor.Process(block, statedb, bc.vmConfig)
+		}
 		if err != nil {
 			bc.reportBlock(block, receipts, err)
 			followupInterrupt.Store(true)
@@ -2188,6 +2210,13 @@ func (bc *BlockChain) reorg(oldHead *types.Header, newHead *types.Block) error {
 		// rewind the canonical chain to a lower point.
 		log.Error("Impossible reorg, please file an issue", "oldnum", oldBlock.Number(), "oldhash", oldBlock.Hash(), "oldblocks", len(oldChain), "newnum", newBlock.Number(), "newhash", newBlock.Hash(), "newblocks", len(newChain))
 	}
+	// record-replay: delete substates of blocks that are no longer canonical,
+	// substates of the new chain are written by writeHeadBlock
+	if RecordSubstate && RecordSubstateLive {
+		for _, block := range oldChain {
+			research.RevertBlockSubstates(block.NumberU64())
+		}
+	}
 	// Reset the tx lookup cache in case to clear stale txlookups.
 	// This is done before writing any new chain data to avoid the
 	// weird scenario that canonical chain is changed while the
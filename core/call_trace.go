@@ -0,0 +1,238 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/research"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// record-replay: CallTracer is vm.EVMLogger building the call frame tree of a
+// transaction like the native callTracer of eth/tracers, which cannot be
+// imported by core. A CallTracer traces only one transaction.
+type CallTracer struct {
+	callstack []*research.TxTrace_CallFrame
+	gasLimit  uint64
+}
+
+// NewCallTracer returns a CallTracer for a transaction
+func NewCallTracer() *CallTracer {
+	return &CallTracer{}
+}
+
+// TxTrace returns the call trace of the traced transaction, or nil if the
+// tracer is nil or the transaction did not start a top call frame
+func (t *CallTracer) TxTrace() *research.TxTrace {
+	if t == nil || len(t.callstack) == 0 {
+		return nil
+	}
+	return &research.TxTrace{Call: t.callstack[0]}
+}
+
+func newCallFrame(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) *research.TxTrace_CallFrame {
+	return &research.TxTrace_CallFrame{
+		Type:  proto.String(typ.String()),
+		From:  research.AddressToBytes(&from),
+		To:    research.AddressToBytesValue(&to),
+		Value: research.BigIntToBytesValue(value),
+		Gas:   proto.Uint64(gas),
+		// required bytes must not be nil
+		Input:  append([]byte{}, input...),
+		Output: []byte{},
+	}
+}
+
+// processCallFrameOutput sets output, error and revert reason of the call
+// frame like callFrame.processOutput of the native callTracer
+func processCallFrameOutput(f *research.TxTrace_CallFrame, output []byte, err error) {
+	if err == nil {
+		f.Output = append([]byte{}, output...)
+		return
+	}
+	f.Error = proto.String(err.Error())
+	if typ := f.GetType(); typ == vm.CREATE.String() || typ == vm.CREATE2.String() {
+		f.To = nil
+	}
+	if !errors.Is(err, vm.ErrExecutionReverted) || len(output) == 0 {
+		return
+	}
+	f.Output = append([]byte{}, output...)
+	if len(output) < 4 {
+		return
+	}
+	if unpacked, err := abi.UnpackRevert(output); err == nil {
+		f.RevertReason = proto.String(unpacked)
+	}
+}
+
+func (t *CallTracer) CaptureTxStart(gasLimit uint64) {
+	t.gasLimit = gasLimit
+}
+
+func (t *CallTracer) CaptureTxEnd(restGas uint64) {
+	if len(t.callstack) == 0 {
+		return
+	}
+	t.callstack[0].GasUsed = proto.Uint64(t.gasLimit - restGas)
+}
+
+func (t *CallTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.callstack = []*research.TxTrace_CallFrame{newCallFrame(typ, from, to, input, t.gasLimit, value)}
+	t.callstack[0].GasUsed = proto.Uint64(0)
+}
+
+func (t *CallTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	processCallFrameOutput(t.callstack[0], output, err)
+}
+
+func (t *CallTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.callstack = append(t.callstack, newCallFrame(typ, from, to, input, gas, value))
+}
+
+func (t *CallTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	size := len(t.callstack)
+	if size <= 1 {
+		return
+	}
+	call := t.callstack[size-1]
+	t.callstack = t.callstack[:size-1]
+
+	call.GasUsed = proto.Uint64(gasUsed)
+	processCallFrameOutput(call, output, err)
+	parent := t.callstack[size-2]
+	parent.Calls = append(parent.Calls, call)
+}
+
+func (t *CallTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+
+func (t *CallTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// record-replay: muxLogger is vm.EVMLogger calling all of its loggers, e.g.,
+// CallTracer recording call traces along with vm.Config.Tracer
+type muxLogger []vm.EVMLogger
+
+func (m muxLogger) CaptureTxStart(gasLimit uint64) {
+	for _, l := range m {
+		l.CaptureTxStart(gasLimit)
+	}
+}
+
+func (m muxLogger) CaptureTxEnd(restGas uint64) {
+	for _, l := range m {
+		l.CaptureTxEnd(restGas)
+	}
+}
+
+func (m muxLogger) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	for _, l := range m {
+		l.CaptureStart(env, from, to, create, input, gas, value)
+	}
+}
+
+func (m muxLogger) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	for _, l := range m {
+		l.CaptureEnd(output, gasUsed, err)
+	}
+}
+
+func (m muxLogger) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	for _, l := range m {
+		l.CaptureEnter(typ, from, to, input, gas, value)
+	}
+}
+
+func (m muxLogger) CaptureExit(output []byte, gasUsed uint64, err error) {
+	for _, l := range m {
+		l.CaptureExit(output, gasUsed, err)
+	}
+}
+
+func (m muxLogger) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	for _, l := range m {
+		l.CaptureState(pc, op, gas, cost, scope, rData, depth, err)
+	}
+}
+
+func (m muxLogger) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	for _, l := range m {
+		l.CaptureFault(pc, op, gas, cost, scope, depth, err)
+	}
+}
+
+// ReplayTxTrace executes a transaction substate with CallTracer and returns the
+// replayed call trace. The chain config is used as is, e.g., the return value
+// of research.ReplayChainConfig.
+func ReplayTxTrace(chainConfig *params.ChainConfig, tx int, substate *research.Substate) (*research.TxTrace, error) {
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.LoadSubstate(substate)
+
+	blockContext := &vm.BlockContext{
+		CanTransfer: CanTransfer,
+		Transfer:    Transfer,
+	}
+	blockContext.LoadSubstate(substate)
+
+	txMessage := &Message{}
+	txMessage.LoadSubstate(substate)
+
+	tracer := NewCallTracer()
+	evm := vm.NewEVM(*blockContext, NewEVMTxContext(txMessage), statedb, chainConfig, vm.Config{Tracer: tracer})
+	statedb.SetTxContext(common.Hash{}, tx)
+
+	gaspool := new(GasPool).AddGas(blockContext.GasLimit)
+	if _, err := ApplyMessage(evm, txMessage, gaspool); err != nil {
+		return nil, err
+	}
+	return tracer.TxTrace(), nil
+}
+
+// CheckTraceReplay checks the call trace of a transaction is replayed from its
+// substate like CheckReplay, and stores json files of call traces if they are
+// different. This function immediately returns nil if SkipCheckReplay is true.
+func CheckTraceReplay(config *params.ChainConfig, block uint64, tx int, substate *research.Substate, trace *research.TxTrace) error {
+	if SkipCheckReplay {
+		return nil
+	}
+
+	// disable DAOForkSupport, otherwise account states will be overwritten
+	replayTrace, err := ReplayTxTrace(research.ReplayChainConfig(config), tx, substate)
+	if err != nil {
+		return err
+	}
+
+	if !proto.Equal(trace, replayTrace) {
+		fmt.Printf("block %v, tx %v, inconsistent call trace\n", block, tx)
+		fmt.Print(research.DiffTxTrace(trace, replayTrace))
+		jm := protojson.MarshalOptions{
+			Indent: "  ",
+		}
+
+		var b []byte
+
+		b, _ = jm.Marshal(trace)
+		os.WriteFile(fmt.Sprintf("record_trace_%v_%v.json", block, tx), b, 0644)
+		b, _ = jm.Marshal(replayTrace)
+		os.WriteFile(fmt.Sprintf("replay_trace_%v_%v.json", block, tx), b, 0644)
+
+		return fmt.Errorf("not faithful replay of call trace - inconsistent output")
+	}
+
+	return nil
+}
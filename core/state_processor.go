@@ -30,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/flags"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/research"
 	cli "github.com/urfave/cli/v2"
@@ -40,6 +41,17 @@ import (
 // record-replay: record substates when true
 var RecordSubstate = false
 
+// record-replay: buffer substates of each block until the block becomes
+// canonical when recording substates live from a syncing node
+var RecordSubstateLive = false
+
+// record-replay: record block substates of state changes outside transactions
+// when true with RecordSubstate
+var RecordBlockSubstate = false
+
+// record-replay: record call traces of transactions when true with RecordSubstate
+var RecordTraces = false
+
 // StateProcessor is a basic Processor, which takes care of transitioning
 // state from one point to another.
 //
@@ -67,6 +79,14 @@ func NewStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consen
 // returns the amount of gas that was used in the process. If any of the
 // transactions failed to execute due to insufficient gas it will return an error.
 func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
+	return p.ProcessRecord(block, statedb, cfg, false)
+}
+
+// record-replay: ProcessRecord is Process recording substates of the block if
+// record is true with RecordSubstate. BlockChain records the blocks it inserts,
+// but not the blocks executed again to regenerate states, e.g., debug_trace*.
+func (p *StateProcessor) ProcessRecord(block *types.Block, statedb *state.StateDB, cfg vm.Config, record bool) (types.Receipts, []*types.Log, uint64, error) {
+	record = record && RecordSubstate
 	var (
 		receipts    types.Receipts
 		usedGas     = new(uint64)
@@ -76,12 +96,21 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		allLogs     []*types.Log
 		gp          = new(GasPool).AddGas(block.GasLimit())
 	)
+	// record-replay: block substate of state changes outside transactions
+	var blockSubstate *research.BlockSubstate
+	if record && RecordBlockSubstate {
+		blockSubstate = &research.BlockSubstate{}
+		statedb.SetTxContext(common.Hash{}, 0)
+	}
 	// Mutate the block and state according to any hard-fork specs
 	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
 		misc.ApplyDAOHardFork(statedb)
+		if blockSubstate != nil {
+			blockSubstate.DaoHardFork = proto.Bool(true)
+		}
 
 		// record-replay: Finalise all DAO accounts, don't save them in substate
-		if RecordSubstate {
+		if record {
 			if config := p.config; config.IsByzantium(header.Number) {
 				statedb.Finalise(true)
 			} else {
@@ -90,6 +119,14 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		}
 
 	}
+	// record-replay: record chain config, versions and genesis hash in substate DB
+	if record {
+		var genesisHash common.Hash
+		if p.bc != nil {
+			genesisHash = p.bc.Genesis().Hash()
+		}
+		research.RecordMetadata(p.config, genesisHash)
+	}
 	var (
 		context = NewEVMBlockContext(header, p.bc, nil)
 		vmenv   = vm.NewEVM(context, vm.TxContext{}, statedb, p.config, cfg)
@@ -98,6 +135,16 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 	if beaconRoot := block.BeaconRoot(); beaconRoot != nil {
 		ProcessBeaconBlockRoot(*beaconRoot, vmenv, statedb)
 	}
+	// record-replay: save state changes before the first transaction
+	if blockSubstate != nil {
+		saveBlockSubstatePreTx(blockSubstate, statedb, vmenv, block)
+	}
+	// record-replay: substates and call traces of the block buffered until it becomes canonical
+	blockSubstates := make(map[int]*research.Substate)
+	var blockTraces map[int]*research.TxTrace
+	if record && RecordTraces {
+		blockTraces = make(map[int]*research.TxTrace)
+	}
 	// Iterate over and process the individual transactions
 	for i, tx := range block.Transactions() {
 		msg, err := TransactionToMessage(tx, signer, header.BaseFee)
@@ -110,41 +157,62 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		// reset blockContext.ResearchBlockHashes manually
 		vmenv.Context.ResearchBlockHashes = nil
 
+		// record-replay: trace calls of the transaction along with cfg.Tracer
+		var callTracer *CallTracer
+		if blockTraces != nil {
+			callTracer = NewCallTracer()
+			if cfg.Tracer != nil {
+				vmenv.Config.Tracer = muxLogger{cfg.Tracer, callTracer}
+			} else {
+				vmenv.Config.Tracer = callTracer
+			}
+		}
+
 		receipt, err := applyTransaction(msg, p.config, gp, statedb, blockNumber, blockHash, tx, usedGas, vmenv)
+		if callTracer != nil {
+			vmenv.Config.Tracer = cfg.Tracer
+		}
 		if err != nil {
 			return nil, nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
 		}
 
 		// record-replay: save tx substate into DBs, merge block hashes to env
-		if RecordSubstate {
-			substate := &research.Substate{}
-			statedb.SaveSubstate(substate)
-
-			// load blockContext again from vmenv because it does not hold a pointer
-			blockContext := &vmenv.Context
-			blockContext.SaveSubstate(substate)
-
-			// nothing to reset
-			msg.SaveSubstate(substate)
+		if record {
+			substate := SaveTxSubstate(statedb, vmenv, msg, receipt)
 
-			// convert *types.Receipt to *research.Receipt for SaveSubstae method
-			rr := research.NewResearchReceipt(receipt)
-			rr.SaveSubstate(substate)
-
-			// Deepcopy of substate for thread-safety
-			substate = substate.ProtoClone()
-
-			research.PutSubstate(block.NumberU64(), i, substate)
+			if RecordSubstateLive {
+				blockSubstates[i] = substate
+			} else {
+				research.PutSubstate(block.NumberU64(), i, substate)
+			}
 
 			if !SkipCheckReplay {
 				// check substate works for faithful replay
 				go func(block uint64, tx int, substate *research.Substate) {
-					err := CheckReplay(block, tx, substate)
+					err := CheckReplay(p.config, block, tx, substate)
 					if err != nil {
 						panic(err)
 					}
 				}(block.NumberU64(), i, substate)
 			}
+
+			if trace := callTracer.TxTrace(); trace != nil {
+				if RecordSubstateLive {
+					blockTraces[i] = trace
+				} else {
+					research.PutTxTrace(block.NumberU64(), i, trace)
+				}
+
+				if !SkipCheckReplay {
+					// check the call trace is replayed from the substate
+					go func(block uint64, tx int, substate *research.Substate, trace *research.TxTrace) {
+						err := CheckTraceReplay(p.config, block, tx, substate, trace)
+						if err != nil {
+							panic(err)
+						}
+					}(block.NumberU64(), i, substate, trace)
+				}
+			}
 		}
 
 		receipts = append(receipts, receipt)
@@ -155,9 +223,38 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 	if len(withdrawals) > 0 && !p.config.IsShanghai(block.Number(), block.Time()) {
 		return nil, nil, 0, errors.New("withdrawals before shanghai")
 	}
+	// record-replay: reset StateDB.Research* to save state changes after the last transaction
+	if blockSubstate != nil {
+		statedb.SetTxContext(common.Hash{}, len(block.Transactions()))
+	}
 	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
 	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles(), withdrawals)
 
+	// record-replay: save the block substate, check it works for faithful replay
+	if blockSubstate != nil {
+		saveBlockSubstatePostTx(p.config, blockSubstate, statedb, block)
+		if !RecordSubstateLive {
+			research.PutBlockSubstate(block.NumberU64(), blockSubstate)
+		}
+		if !SkipCheckReplay {
+			go func(block uint64, blockSubstate *research.BlockSubstate) {
+				err := CheckBlockReplay(p.config, p.engine, block, blockSubstate)
+				if err != nil {
+					panic(err)
+				}
+			}(block.NumberU64(), blockSubstate)
+		}
+	}
+
+	// record-replay: add the block to recorded block ranges
+	if record {
+		if RecordSubstateLive {
+			research.BufferBlockSubstates(blockHash, block.NumberU64(), blockSubstates, blockSubstate, blockTraces)
+		} else {
+			research.RecordBlock(block.NumberU64())
+		}
+	}
+
 	return receipts, allLogs, *usedGas, nil
 }
 
@@ -211,6 +308,76 @@ func applyTransaction(msg *Message, config *params.ChainConfig, gp *GasPool, sta
 	return receipt, err
 }
 
+// record-replay: SaveTxSubstate returns the substate of a transaction after
+// applyTransaction with the statedb and EVM
+func SaveTxSubstate(statedb *state.StateDB, vmenv *vm.EVM, msg *Message, receipt *types.Receipt) *research.Substate {
+	substate := &research.Substate{}
+	statedb.SaveSubstate(substate)
+
+	// load blockContext again from vmenv because it does not hold a pointer
+	blockContext := &vmenv.Context
+	blockContext.SaveSubstate(substate)
+
+	// nothing to reset
+	msg.SaveSubstate(substate)
+
+	// convert *types.Receipt to *research.Receipt for SaveSubstae method
+	rr := research.NewResearchReceipt(receipt)
+	rr.SaveSubstate(substate)
+
+	// Deepcopy of substate for thread-safety
+	return substate.ProtoClone()
+}
+
+// record-replay: RecordBlockSubstates executes transactions of a block from tx 0
+// to tx last on statedb of its parent block like Process, and returns their
+// substates. It is used to record substates of historical blocks on archive nodes.
+func RecordBlockSubstates(config *params.ChainConfig, bc ChainContext, block *types.Block, statedb *state.StateDB, last int) ([]*research.Substate, error) {
+	var (
+		usedGas     = new(uint64)
+		header      = block.Header()
+		blockHash   = block.Hash()
+		blockNumber = block.Number()
+		gp          = new(GasPool).AddGas(block.GasLimit())
+	)
+	// DAO accounts are finalised, don't save them in substate
+	if config.DAOForkSupport && config.DAOForkBlock != nil && config.DAOForkBlock.Cmp(blockNumber) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+		if config.IsByzantium(blockNumber) {
+			statedb.Finalise(true)
+		} else {
+			statedb.Finalise(config.IsEIP158(blockNumber))
+		}
+	}
+	var (
+		context = NewEVMBlockContext(header, bc, nil)
+		vmenv   = vm.NewEVM(context, vm.TxContext{}, statedb, config, vm.Config{})
+		signer  = types.MakeSigner(config, header.Number, header.Time)
+	)
+	if beaconRoot := block.BeaconRoot(); beaconRoot != nil {
+		ProcessBeaconBlockRoot(*beaconRoot, vmenv, statedb)
+	}
+	var substates []*research.Substate
+	for i, tx := range block.Transactions() {
+		if i > last {
+			break
+		}
+		msg, err := TransactionToMessage(tx, signer, header.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		}
+		statedb.SetTxContext(tx.Hash(), i)
+		vmenv.Context.ResearchBlockHashes = nil
+
+		receipt, err := applyTransaction(msg, config, gp, statedb, blockNumber, blockHash, tx, usedGas, vmenv)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		}
+		substates = append(substates, SaveTxSubstate(statedb, vmenv, msg, receipt))
+	}
+	return substates, nil
+}
+
 // ApplyTransaction attempts to apply a transaction to the given state database
 // and uses the input parameters for its environment. It returns the receipt
 // for the transaction, gas used and an error if the transaction failed,
@@ -247,6 +414,27 @@ func ProcessBeaconBlockRoot(beaconRoot common.Hash, vmenv *vm.EVM, statedb *stat
 	statedb.Finalise(true)
 }
 
+// record-replay: --record-substate flag of geth node
+var RecordSubstateFlag = &cli.BoolFlag{
+	Name:     "record-substate",
+	Usage:    "(record-replay) Record substates of canonical blocks during full sync (requires --syncmode full)",
+	Category: flags.EthCategory,
+}
+
+// record-replay: --record-block-substate flag of geth record-substate and geth node
+var RecordBlockSubstateFlag = &cli.BoolFlag{
+	Name:     "record-block-substate",
+	Usage:    "(record-replay) Record block substates of the DAO hard fork, beacon block root, rewards and withdrawals",
+	Category: flags.EthCategory,
+}
+
+// record-replay: --record-traces flag of geth record-substate and geth node
+var RecordTracesFlag = &cli.BoolFlag{
+	Name:     "record-traces",
+	Usage:    "(record-replay) Record call traces with return data and revert reasons of transactions",
+	Category: flags.EthCategory,
+}
+
 // record-replay: --skip-check-replay flag
 var (
 	SkipCheckReplayFlag = &cli.BoolFlag{
@@ -257,10 +445,10 @@ var (
 	SkipCheckReplay = SkipCheckReplayFlag.Value
 )
 
-// CheckReplay checks faithful transaction replay with the given substate
-// and store json files of substates if execution results are different.
+// CheckReplay checks faithful transaction replay with the given chain config and
+// substate and store json files of substates if execution results are different.
 // This function immediately returns nil if SkipCheckReplay is true.
-func CheckReplay(block uint64, tx int, substate *research.Substate) error {
+func CheckReplay(config *params.ChainConfig, block uint64, tx int, substate *research.Substate) error {
 	if SkipCheckReplay {
 		return nil
 	}
@@ -281,10 +469,8 @@ func CheckReplay(block uint64, tx int, substate *research.Substate) error {
 	txMessage := &Message{}
 	txMessage.LoadSubstate(substate)
 
-	chainConfig := &params.ChainConfig{}
-	*chainConfig = *params.MainnetChainConfig
 	// disable DAOForkSupport, otherwise account states will be overwritten
-	chainConfig.DAOForkSupport = false
+	chainConfig := research.ReplayChainConfig(config)
 
 	vmConfig := vm.Config{}
 
@@ -332,6 +518,7 @@ func CheckReplay(block uint64, tx int, substate *research.Substate) error {
 
 	if !eqSubstate {
 		fmt.Printf("block %v, tx %v, inconsistent output\n", block, tx)
+		fmt.Print(research.DiffSubstate(substate, replaySubstate))
 		jm := protojson.MarshalOptions{
 			Indent: "  ",
 		}
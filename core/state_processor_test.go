@@ -17,6 +17,7 @@
 package core
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"math/big"
 	"os"
@@ -40,6 +41,7 @@ import (
 	"github.com/holiman/uint256"
 	"golang.org/x/crypto/sha3"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 func u64(val uint64) *uint64 { return &val }
@@ -434,7 +436,7 @@ func TestCheckReplayJson(t *testing.T) {
 	}
 	x := &research.Substate{}
 	protojson.Unmarshal(b, x)
-	err = CheckReplay(*x.BlockEnv.Number, 0, x)
+	err = CheckReplay(params.MainnetChainConfig, *x.BlockEnv.Number, 0, x)
 	if err != nil {
 		panic(err)
 	}
@@ -475,3 +477,284 @@ func TestSaveSubstateAccessList(t *testing.T) {
 		t.Errorf("access list is different: %q, %q", msg1.AccessList, msg2.AccessList)
 	}
 }
+
+// TestRecordSubstateLiveReorg checks that substates of blocks are written when
+// they become canonical, and deleted when they are reorganised away.
+func TestRecordSubstateLiveReorg(t *testing.T) {
+	research.OpenFakeSubstateDB()
+	defer research.CloseFakeSubstateDB()
+	RecordSubstate, RecordSubstateLive, SkipCheckReplay = true, true, true
+	defer func() {
+		RecordSubstate, RecordSubstateLive, SkipCheckReplay = false, false, false
+	}()
+
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		genesis = &Genesis{
+			Config:  params.AllEthashProtocolChanges,
+			BaseFee: big.NewInt(params.InitialBaseFee),
+			Alloc:   types.GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+		}
+		signer = types.LatestSigner(genesis.Config)
+	)
+	// a long easy chain with 1 tx per block and a short difficult chain with 2 txs per block
+	makeChain := func(n int, offset int64, txs int) []*types.Block {
+		_, blocks, _ := GenerateChainWithGenesis(genesis, ethash.NewFaker(), n, func(i int, b *BlockGen) {
+			b.OffsetTime(offset)
+			for j := 0; j < txs; j++ {
+				tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0x01}, big.NewInt(1), params.TxGas, b.BaseFee(), nil), signer, key)
+				b.AddTx(tx)
+			}
+		})
+		return blocks
+	}
+	easyBlocks := makeChain(96, 60, 1)
+	diffBlocks := makeChain(95, -9, 2)
+
+	chain, _ := NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(easyBlocks); err != nil {
+		t.Fatalf("failed to insert easy chain: %v", err)
+	}
+	if n := len(research.GetBlockSubstates(96)); n != 1 {
+		t.Fatalf("block 96 of easy chain has %v substates, want 1", n)
+	}
+
+	if _, err := chain.InsertChain(diffBlocks); err != nil {
+		t.Fatalf("failed to insert difficult chain: %v", err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != diffBlocks[len(diffBlocks)-1].Hash() {
+		t.Fatalf("head is not the difficult chain")
+	}
+	for _, block := range []uint64{1, 50, 95} {
+		if n := len(research.GetBlockSubstates(block)); n != 2 {
+			t.Errorf("block %v of difficult chain has %v substates, want 2", block, n)
+		}
+	}
+	if n := len(research.GetBlockSubstates(96)); n != 0 {
+		t.Errorf("non-canonical block 96 has %v substates, want 0", n)
+	}
+
+	// blocks executed again to regenerate states are not recorded
+	block := diffBlocks[len(diffBlocks)-1]
+	statedb, err := chain.StateAt(chain.GetHeaderByHash(block.ParentHash()).Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := chain.Processor().Process(block, statedb, vm.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if research.CommitBlockSubstates(block.Hash(), block.NumberU64()) {
+		t.Error("substates of a regenerated block are buffered")
+	}
+}
+
+func TestRecordBlockSubstate(t *testing.T) {
+	research.OpenFakeSubstateDB()
+	defer research.CloseFakeSubstateDB()
+	RecordSubstate, RecordBlockSubstate, SkipCheckReplay = true, true, true
+	defer func() {
+		RecordSubstate, RecordBlockSubstate, SkipCheckReplay = false, false, false
+	}()
+
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+	)
+	// insertChain records block substates of n blocks with a transfer per block
+	insertChain := func(genesis *Genesis, engine consensus.Engine, n int, gen func(i int, b *BlockGen)) {
+		signer := types.LatestSigner(genesis.Config)
+		_, blocks, _ := GenerateChainWithGenesis(genesis, engine, n, func(i int, b *BlockGen) {
+			gen(i, b)
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0x01}, big.NewInt(1), params.TxGas, big.NewInt(params.InitialBaseFee), nil), signer, key)
+			b.AddTx(tx)
+		})
+		chain, _ := NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
+		defer chain.Stop()
+		if _, err := chain.InsertChain(blocks); err != nil {
+			t.Fatalf("failed to insert chain: %v", err)
+		}
+	}
+	// checkReplay replays the block substate and returns it
+	checkReplay := func(config *params.ChainConfig, block uint64) *research.BlockSubstate {
+		blockSubstate := research.GetBlockSubstate(block)
+		if blockSubstate == nil {
+			t.Fatalf("block %v has no block substate", block)
+		}
+		replayBlockSubstate := ReplayBlockSubstate(config, beacon.New(ethash.NewFaker()), blockSubstate)
+		if d := research.DiffBlockSubstate(blockSubstate, replayBlockSubstate); !d.Equal() {
+			t.Fatalf("block %v, inconsistent block substate output:\n%s", block, d)
+		}
+		return blockSubstate
+	}
+	balance := func(alloc *research.Substate_Alloc, addr common.Address) *big.Int {
+		for _, entry := range alloc.GetAlloc() {
+			if common.BytesToAddress(entry.Address) == addr {
+				return new(big.Int).SetBytes(entry.Account.Balance)
+			}
+		}
+		return nil
+	}
+
+	// DAO hard fork, block and uncle rewards before EIP-158
+	config := params.ChainConfig{
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: big.NewInt(0),
+		DAOForkBlock:   big.NewInt(2),
+		DAOForkSupport: true,
+		Ethash:         new(params.EthashConfig),
+	}
+	insertChain(&Genesis{
+		Config: &config,
+		Alloc:  types.GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+	}, ethash.NewFaker(), 3, func(i int, b *BlockGen) {
+		if i == 2 {
+			uncle := b.PrevBlock(1).Header()
+			uncle.Coinbase = common.Address{0xcc}
+			b.AddUncle(uncle)
+		}
+	})
+	if blockSubstate := checkReplay(&config, 2); !blockSubstate.GetDaoHardFork() {
+		t.Errorf("block 2 has no DAO hard fork")
+	}
+	blockSubstate := checkReplay(&config, 3)
+	if len(blockSubstate.Uncles) != 1 || balance(blockSubstate.PostTxOutputAlloc, common.Address{0xcc}) == nil {
+		t.Errorf("block 3 has no uncle reward")
+	}
+	if n := len(research.GetBlockSubstates(3)); n != 1 {
+		t.Errorf("block 3 has %v substates, want 1", n)
+	}
+
+	// beacon block root and withdrawals
+	research.CloseFakeSubstateDB()
+	research.OpenFakeSubstateDB()
+	config = *params.AllEthashProtocolChanges
+	config.TerminalTotalDifficulty = common.Big0
+	config.TerminalTotalDifficultyPassed = true
+	config.ShanghaiTime = u64(0)
+	config.CancunTime = u64(0)
+	asm4788 := common.Hex2Bytes("3373fffffffffffffffffffffffffffffffffffffffe14604d57602036146024575f5ffd5b5f35801560495762001fff810690815414603c575f5ffd5b62001fff01545f5260205ff35b5f5ffd5b62001fff42064281555f359062001fff015500")
+	insertChain(&Genesis{
+		Config:     &config,
+		BaseFee:    big.NewInt(params.InitialBaseFee),
+		Difficulty: common.Big1,
+		Alloc: types.GenesisAlloc{
+			addr:                             {Balance: big.NewInt(params.Ether)},
+			params.BeaconRootsStorageAddress: {Balance: common.Big0, Code: asm4788},
+		},
+	}, beacon.NewFaker(), 2, func(i int, b *BlockGen) {
+		b.SetParentBeaconRoot(common.Hash{byte(i + 1)})
+		b.AddWithdrawal(&types.Withdrawal{Validator: 42, Address: common.Address{0xee}, Amount: 1337})
+	})
+	blockSubstate = checkReplay(&config, 2)
+	if root := research.BytesValueToHash(blockSubstate.BeaconRoot); root == nil || *root != (common.Hash{0x02}) {
+		t.Errorf("block 2 has beacon root %v, want 0x02", root)
+	}
+	if len(blockSubstate.PreTxOutputAlloc.GetAlloc()) == 0 {
+		t.Errorf("block 2 has no state changes of beacon root")
+	}
+	want := new(big.Int).Mul(big.NewInt(2*1337), big.NewInt(params.GWei))
+	if got := balance(blockSubstate.PostTxOutputAlloc, common.Address{0xee}); got == nil || got.Cmp(want) != 0 {
+		t.Errorf("block 2 withdrawal balance = %v, want %v", got, want)
+	}
+}
+
+func TestRecordTraces(t *testing.T) {
+	research.OpenFakeSubstateDB()
+	defer research.CloseFakeSubstateDB()
+	RecordSubstate, RecordTraces, SkipCheckReplay = true, true, true
+	defer func() {
+		RecordSubstate, RecordTraces, SkipCheckReplay = false, false, false
+	}()
+
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		config = params.TestChainConfig
+		signer = types.LatestSigner(config)
+		caller = common.Address{0xaa}
+		callee = common.BytesToAddress([]byte{0xbb})
+		// revert data of Error("no")
+		revertData = common.Hex2Bytes("08c379a0" +
+			"0000000000000000000000000000000000000000000000000000000000000020" +
+			"0000000000000000000000000000000000000000000000000000000000000002" +
+			"6e6f000000000000000000000000000000000000000000000000000000000000")
+	)
+	// caller calls callee and returns 32 zero bytes, callee reverts with revertData
+	callerCode := common.Hex2Bytes("600060006000600060006000" + "60bb5af1" + "600052" + "60206000f3")
+	calleeCode := append(common.Hex2Bytes("6064600c600039"+"60646000fd"), revertData...)
+
+	genesis := &Genesis{
+		Config: config,
+		Alloc: types.GenesisAlloc{
+			addr:   {Balance: big.NewInt(params.Ether)},
+			caller: {Balance: common.Big0, Code: callerCode},
+			callee: {Balance: common.Big0, Code: calleeCode},
+		},
+	}
+	gasPrice := big.NewInt(2 * params.InitialBaseFee)
+	_, blocks, _ := GenerateChainWithGenesis(genesis, ethash.NewFaker(), 1, func(i int, b *BlockGen) {
+		for _, tx := range []*types.Transaction{
+			types.NewTransaction(b.TxNonce(addr), caller, common.Big0, 100000, gasPrice, nil),
+			types.NewTransaction(b.TxNonce(addr)+1, callee, common.Big0, 100000, gasPrice, nil),
+			types.NewContractCreation(b.TxNonce(addr)+2, common.Big0, 100000, gasPrice, []byte{0x00}),
+		} {
+			tx, _ = types.SignTx(tx, signer, key)
+			b.AddTx(tx)
+		}
+	})
+	// the tracer of the chain still traces transactions, the last one at the end
+	tracer := NewCallTracer()
+	chain, _ := NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, ethash.NewFaker(), vm.Config{Tracer: tracer}, nil, nil)
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+
+	substates := research.GetBlockSubstates(1)
+	if !proto.Equal(tracer.TxTrace(), research.GetTxTrace(1, 2)) {
+		t.Errorf("vm.Config.Tracer traced %v, want %v", tracer.TxTrace(), research.GetTxTrace(1, 2))
+	}
+	SkipCheckReplay = false
+	traces := make([]*research.TxTrace, len(substates))
+	for tx := range traces {
+		traces[tx] = research.GetTxTrace(1, tx)
+		if traces[tx] == nil {
+			t.Fatalf("tx %v has no call trace", tx)
+		}
+		if err := CheckTraceReplay(config, 1, tx, substates[tx], traces[tx]); err != nil {
+			t.Fatalf("tx %v: %v", tx, err)
+		}
+	}
+
+	// nested call reverted with a reason string
+	call := traces[0].GetCall()
+	if call.GetType() != "CALL" || call.Error != nil || len(traces[0].ReturnData()) != 32 {
+		t.Errorf("unexpected top call frame of tx 0: %v", call)
+	}
+	if len(call.Calls) != 1 || call.Calls[0].GetError() != vm.ErrExecutionReverted.Error() || call.Calls[0].GetRevertReason() != "no" {
+		t.Errorf("unexpected nested call frames of tx 0: %v", call.Calls)
+	}
+	if call.GetGasUsed() != substates[0].Result.GetGasUsed() {
+		t.Errorf("tx 0 gas used = %v, want %v", call.GetGasUsed(), substates[0].Result.GetGasUsed())
+	}
+	// top call frame reverted with a reason string
+	if traces[1].RevertReason() != "no" || !bytes.Equal(traces[1].ReturnData(), revertData) {
+		t.Errorf("tx 1 has revert reason %q and return data %x", traces[1].RevertReason(), traces[1].ReturnData())
+	}
+	// contract creation
+	if created := traces[2].ContractAddress(); created == nil || *created != crypto.CreateAddress(addr, 2) {
+		t.Errorf("tx 2 created %v, want %v", created, crypto.CreateAddress(addr, 2))
+	}
+
+	// replay check fails if the recorded call tree is different
+	traces[0].Call.Calls[0].RevertReason = proto.String("yes")
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	if err := CheckTraceReplay(config, 1, 0, substates[0], traces[0]); err == nil {
+		t.Error("inconsistent call trace is not detected")
+	}
+}
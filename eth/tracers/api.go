@@ -987,6 +987,11 @@ func APIs(backend Backend) []rpc.API {
 			Namespace: "debug",
 			Service:   NewAPI(backend),
 		},
+		// record-replay: substate namespace
+		{
+			Namespace: SubstateNamespace,
+			Service:   NewSubstateAPI(backend),
+		},
 	}
 }
 
@@ -0,0 +1,113 @@
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/rpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// record-replay: SubstateNamespace is the RPC namespace of SubstateAPI
+const SubstateNamespace = "substate"
+
+// SubstateResult is a substate of a transaction in protobuf-JSON
+type SubstateResult struct {
+	Block    hexutil.Uint64  `json:"block"`
+	Tx       hexutil.Uint64  `json:"tx"`
+	TxHash   common.Hash     `json:"txHash"`
+	Substate json.RawMessage `json:"substate"`
+}
+
+// SubstateAPI records substates of historical blocks by re-executing them on
+// states regenerated like debug_traceBlock, e.g., on archive nodes.
+type SubstateAPI struct {
+	api *API
+}
+
+// NewSubstateAPI creates the substate namespace APIs
+func NewSubstateAPI(backend Backend) *SubstateAPI {
+	return &SubstateAPI{api: NewAPI(backend)}
+}
+
+// recordBlock returns substates of transactions of the block from tx 0 to tx last
+func (s *SubstateAPI) recordBlock(ctx context.Context, block *types.Block, last int) ([]*SubstateResult, error) {
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis is not traceable")
+	}
+	parent, err := s.api.blockByNumberAndHash(ctx, rpc.BlockNumber(block.NumberU64()-1), block.ParentHash())
+	if err != nil {
+		return nil, err
+	}
+	statedb, release, err := s.api.backend.StateAtBlock(ctx, parent, defaultTraceReexec, nil, true, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	substates, err := core.RecordBlockSubstates(s.api.backend.ChainConfig(), s.api.chainContext(ctx), block, statedb, last)
+	if err != nil {
+		return nil, err
+	}
+
+	txs := block.Transactions()
+	results := make([]*SubstateResult, len(substates))
+	for i, substate := range substates {
+		b, err := protojson.Marshal(substate)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = &SubstateResult{
+			Block:    hexutil.Uint64(block.NumberU64()),
+			Tx:       hexutil.Uint64(i),
+			TxHash:   txs[i].Hash(),
+			Substate: b,
+		}
+	}
+	return results, nil
+}
+
+// GetBlockSubstates returns substates of all transactions of the block
+func (s *SubstateAPI) GetBlockSubstates(ctx context.Context, number rpc.BlockNumber) ([]*SubstateResult, error) {
+	block, err := s.api.blockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return s.recordBlock(ctx, block, len(block.Transactions())-1)
+}
+
+// GetTxSubstate returns the substate of the transaction
+func (s *SubstateAPI) GetTxSubstate(ctx context.Context, hash common.Hash) (*SubstateResult, error) {
+	found, _, blockHash, blockNumber, index, err := s.api.backend.GetTransaction(ctx, hash)
+	if err != nil {
+		return nil, ethapi.NewTxIndexingError()
+	}
+	if !found {
+		return nil, errTxNotFound
+	}
+	block, err := s.api.blockByNumberAndHash(ctx, rpc.BlockNumber(blockNumber), blockHash)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.recordBlock(ctx, block, int(index))
+	if err != nil {
+		return nil, err
+	}
+	return results[index], nil
+}
+
+// UnmarshalSubstate decodes the protobuf-JSON substate of the result
+func (r *SubstateResult) UnmarshalSubstate() (*research.Substate, error) {
+	substate := &research.Substate{}
+	if err := protojson.Unmarshal(r.Substate, substate); err != nil {
+		return nil, err
+	}
+	return substate, nil
+}
@@ -0,0 +1,87 @@
+package tracers
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSubstateAPI(t *testing.T) {
+	t.Parallel()
+
+	accounts := newAccounts(2)
+	genesis := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: types.GenesisAlloc{
+			accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+		},
+	}
+	// init code: SSTORE(0, BLOCKHASH(NUMBER - 1))
+	initCode := common.FromHex("0x600143034060005500")
+	signer := types.HomesteadSigner{}
+	var createTxHash common.Hash
+	backend := newTestBackend(t, 3, genesis, func(i int, b *core.BlockGen) {
+		nonce := b.TxNonce(accounts[0].addr)
+		tx, _ := types.SignTx(types.NewTransaction(nonce, accounts[1].addr, big.NewInt(1000), params.TxGas, b.BaseFee(), nil), signer, accounts[0].key)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewContractCreation(nonce+1, big.NewInt(0), 100_000, b.BaseFee(), initCode), signer, accounts[0].key)
+		b.AddTx(tx)
+		createTxHash = tx.Hash()
+	})
+	defer backend.teardown()
+
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName(SubstateNamespace, NewSubstateAPI(backend)); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	var results []*SubstateResult
+	if err := client.Call(&results, "substate_getBlockSubstates", hexutil.Uint64(3)); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %v substates, want 2", len(results))
+	}
+	for i, r := range results {
+		substate, err := r.UnmarshalSubstate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(r.Block) != 3 || int(r.Tx) != i {
+			t.Fatalf("unexpected substate %v_%v", r.Block, r.Tx)
+		}
+		if err := core.CheckReplay(backend.chainConfig, 3, i, substate); err != nil {
+			t.Fatalf("substate 3_%v is not replayed faithfully: %v", i, err)
+		}
+	}
+	create, _ := results[1].UnmarshalSubstate()
+	if len(create.BlockEnv.BlockHashes) != 1 {
+		t.Fatalf("BLOCKHASH is not recorded in block environment: %v", create.BlockEnv.BlockHashes)
+	}
+
+	var result *SubstateResult
+	if err := client.Call(&result, "substate_getTxSubstate", createTxHash); err != nil {
+		t.Fatal(err)
+	}
+	substate, err := result.UnmarshalSubstate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(substate, create) || result.TxHash != createTxHash {
+		t.Fatal("substate_getTxSubstate is different from substate_getBlockSubstates")
+	}
+
+	if err := client.Call(&results, "substate_getBlockSubstates", hexutil.Uint64(0)); err == nil {
+		t.Fatal("error is not raised for genesis block")
+	}
+}
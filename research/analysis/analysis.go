@@ -0,0 +1,220 @@
+// Package analysis is the API of substate-cli analyze to run custom analyses
+// over substates without modifying substate-cli.
+//
+// An analysis is registered by Register in an init function of a package
+// imported by substate-cli or of a Go plugin loaded with --plugin. It is run as
+// map-reduce: every worker of SubstateTaskPool gets its own Worker, which
+// analyzes transactions one at a time, and all workers are merged into the
+// Analysis at the end.
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/research"
+)
+
+// Tx is a transaction substate given to Worker.Analyze
+type Tx struct {
+	Block    uint64
+	Tx       int
+	Substate *research.Substate
+
+	// ReplaySubstate is the replayed substate if Definition.Replay is true,
+	// or nil if replay failed with ReplayErr
+	ReplaySubstate *research.Substate
+	ReplayErr      error
+}
+
+// Worker is the state of an analysis in a worker. A worker analyzes one
+// transaction at a time, so its state needs no locks.
+type Worker interface {
+	Analyze(tx *Tx) error
+}
+
+// TracingWorker is a Worker receiving tracer events of replayed transactions.
+// Tracer is called before a transaction is replayed, and Analyze is called
+// with the same transaction after it is replayed. Its Definition must have
+// Replay set.
+type TracingWorker interface {
+	Worker
+	Tracer(block uint64, tx int) vm.EVMLogger
+}
+
+// Analysis reduces all workers into its result
+type Analysis interface {
+	// NewWorker returns the state of the analysis for a new worker
+	NewWorker() (Worker, error)
+	// Merge merges a worker after all transactions are analyzed, it is
+	// called with one worker at a time
+	Merge(w Worker) error
+	// WriteResult writes the result after all workers are merged
+	WriteResult(w io.Writer) error
+}
+
+// Definition is a named analysis registered by Register
+type Definition struct {
+	Name  string
+	Usage string
+
+	// Replay replays each transaction before Worker.Analyze
+	Replay bool
+
+	// New returns a new analysis with config of --analysis-config in JSON,
+	// which is nil if --analysis-config is not set
+	New func(config json.RawMessage) (Analysis, error)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*Definition)
+)
+
+// Register registers an analysis, it panics if the name is already registered
+func Register(def *Definition) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if def.Name == "" || def.New == nil {
+		panic(fmt.Errorf("record-replay: analysis must have Name and New"))
+	}
+	if _, ok := registry[def.Name]; ok {
+		panic(fmt.Errorf("record-replay: analysis %q is already registered", def.Name))
+	}
+	registry[def.Name] = def
+}
+
+// Lookup returns the registered analysis of the name
+func Lookup(name string) (*Definition, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	def, ok := registry[name]
+	return def, ok
+}
+
+// Definitions returns all registered analyses sorted by their names
+func Definitions() []*Definition {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	defs := make([]*Definition, 0, len(registry))
+	for _, def := range registry {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// ReplayFunc replays a transaction substate with an optional tracer, and
+// returns the replayed substate
+type ReplayFunc func(block uint64, tx int, substate *research.Substate, tracer vm.EVMLogger) (*research.Substate, error)
+
+// Runner runs an analysis with SubstateTaskPool. Task is its SubstateTaskFunc,
+// and Finish merges workers and writes the result.
+type Runner struct {
+	Definition *Definition
+	Analysis   Analysis
+
+	replay ReplayFunc
+
+	mu      sync.Mutex
+	idle    []Worker // workers not analyzing any transaction
+	workers []Worker
+}
+
+// NewRunner returns a runner of the analysis with the config. replay is
+// required if the analysis replays transactions.
+func NewRunner(def *Definition, config json.RawMessage, replay ReplayFunc) (*Runner, error) {
+	if def.Replay && replay == nil {
+		return nil, fmt.Errorf("analysis %q replays transactions without a replay function", def.Name)
+	}
+	a, err := def.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating analysis %q: %w", def.Name, err)
+	}
+	return &Runner{
+		Definition: def,
+		Analysis:   a,
+		replay:     replay,
+	}, nil
+}
+
+// acquire returns an idle worker, or a new one if all workers are analyzing
+// transactions. At most as many workers as those of SubstateTaskPool exist.
+func (r *Runner) acquire() (Worker, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n := len(r.idle); n > 0 {
+		w := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		return w, nil
+	}
+	w, err := r.Analysis.NewWorker()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := w.(TracingWorker); ok && !r.Definition.Replay {
+		return nil, fmt.Errorf("analysis %q has a tracing worker without Replay", r.Definition.Name)
+	}
+	r.workers = append(r.workers, w)
+	return w, nil
+}
+
+func (r *Runner) release(w Worker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.idle = append(r.idle, w)
+}
+
+// Task analyzes a transaction substate with a worker
+func (r *Runner) Task(block uint64, tx int, substate *research.Substate, taskPool *research.SubstateTaskPool) error {
+	w, err := r.acquire()
+	if err != nil {
+		return err
+	}
+	defer r.release(w)
+
+	t := &Tx{Block: block, Tx: tx, Substate: substate}
+	if r.Definition.Replay {
+		var tracer vm.EVMLogger
+		if tw, ok := w.(TracingWorker); ok {
+			tracer = tw.Tracer(block, tx)
+		}
+		t.ReplaySubstate, t.ReplayErr = r.replay(block, tx, substate, tracer)
+	}
+	return w.Analyze(t)
+}
+
+// NumWorkers returns the number of workers created so far
+func (r *Runner) NumWorkers() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.workers)
+}
+
+// Finish merges all workers into the analysis and writes its result
+func (r *Runner) Finish(w io.Writer) error {
+	r.mu.Lock()
+	workers := r.workers
+	r.workers, r.idle = nil, nil
+	r.mu.Unlock()
+
+	for _, worker := range workers {
+		if err := r.Analysis.Merge(worker); err != nil {
+			return fmt.Errorf("error merging analysis %q: %w", r.Definition.Name, err)
+		}
+	}
+	if err := r.Analysis.WriteResult(w); err != nil {
+		return fmt.Errorf("error writing result of analysis %q: %w", r.Definition.Name, err)
+	}
+	return nil
+}
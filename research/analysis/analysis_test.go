@@ -0,0 +1,255 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/research"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newTestSubstate returns a transaction substate to an account with code, or
+// a create transaction if to is nil
+func newTestSubstate(block uint64, to []byte, code []byte, status uint64) *research.Substate {
+	from := []byte{0x01}
+	input := &research.Substate_Alloc{
+		Alloc: []*research.Substate_AllocEntry{
+			{Address: from, Account: &research.Substate_Account{Nonce: proto.Uint64(0), Balance: []byte{0x10}}},
+		},
+	}
+	msg := &research.Substate_TxMessage{
+		Nonce:    proto.Uint64(0),
+		GasPrice: []byte{0x01},
+		Gas:      proto.Uint64(100_000),
+		From:     from,
+		Value:    []byte{},
+		Input:    &research.Substate_TxMessage_Data{Data: []byte{}},
+		TxType:   research.Substate_TxMessage_TXTYPE_LEGACY.Enum(),
+	}
+	if to != nil {
+		msg.To = wrapperspb.Bytes(to)
+		input.Alloc = append(input.Alloc, &research.Substate_AllocEntry{
+			Address: to,
+			Account: &research.Substate_Account{
+				Nonce:    proto.Uint64(0),
+				Balance:  []byte{},
+				Contract: &research.Substate_Account_Code{Code: code},
+			},
+		})
+	}
+	return &research.Substate{
+		InputAlloc:  input,
+		OutputAlloc: input,
+		BlockEnv: &research.Substate_BlockEnv{
+			Coinbase:   []byte{0x03},
+			Difficulty: []byte{},
+			GasLimit:   proto.Uint64(30_000_000),
+			Number:     proto.Uint64(block),
+			Timestamp:  proto.Uint64(1),
+		},
+		TxMessage: msg,
+		Result: &research.Substate_Result{
+			Status:  proto.Uint64(status),
+			Bloom:   make([]byte, 256),
+			GasUsed: proto.Uint64(21_000 + block),
+		},
+	}
+}
+
+func newTestDB() *research.SubstateDB {
+	db := research.NewSubstateDB(rawdb.NewMemoryDatabase())
+	for block := uint64(1); block <= 20; block++ {
+		db.PutSubstate(block, 0, newTestSubstate(block, []byte{0x02}, nil, 1))
+		db.PutSubstate(block, 1, newTestSubstate(block, []byte{0x04}, []byte{0x00}, block%2))
+		if block%5 == 0 {
+			db.PutSubstate(block, 2, newTestSubstate(block, nil, nil, 1))
+		}
+	}
+	return db
+}
+
+func runAnalysis(t *testing.T, db *research.SubstateDB, def *Definition, config json.RawMessage, replay ReplayFunc) string {
+	t.Helper()
+	runner, err := NewRunner(def, config, replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := &research.SubstateTaskPool{
+		Name:     "test",
+		TaskFunc: runner.Task,
+		Config:   &research.SubstateTaskConfig{Workers: 4},
+		DB:       db,
+	}
+	if err := pool.ExecuteSegment(research.NewBlockSegment(1, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if n := runner.NumWorkers(); n < 1 || n > 4 {
+		t.Fatalf("%v workers for 4 workers of SubstateTaskPool", n)
+	}
+	var b bytes.Buffer
+	if err := runner.Finish(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestTxSummary(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	def, ok := Lookup("tx-summary")
+	if !ok {
+		t.Fatal("tx-summary is not registered")
+	}
+	if _, err := NewRunner(def, json.RawMessage(`{}`), nil); err == nil {
+		t.Fatal("tx-summary accepted a config")
+	}
+
+	s := &txSummary{}
+	if err := json.Unmarshal([]byte(runAnalysis(t, db, def, nil, nil)), s); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name string
+		got  txSummaryCount
+		want txSummaryCount
+	}{
+		{"transfer", s.Transfer, txSummaryCount{Txs: 20, GasUsed: 20*21_000 + 210}},
+		{"call", s.Call, txSummaryCount{Txs: 20, Failed: 10, GasUsed: 20*21_000 + 210}},
+		{"create", s.Create, txSummaryCount{Txs: 4, GasUsed: 4*21_000 + 50}},
+		{"total", s.Total, txSummaryCount{Txs: 44, Failed: 10, GasUsed: 44*21_000 + 470}},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: %+v, want %+v", c.name, c.got, c.want)
+		}
+	}
+}
+
+// testTracer counts CaptureStart of a transaction
+type testTracer struct {
+	vm.EVMLogger
+	block  uint64
+	tx     int
+	starts int
+}
+
+// testWorker checks that each transaction is replayed with its own tracer
+type testWorker struct {
+	tracer *testTracer
+	txs    int
+	err    error
+}
+
+func (w *testWorker) Tracer(block uint64, tx int) vm.EVMLogger {
+	w.tracer = &testTracer{block: block, tx: tx}
+	return w.tracer
+}
+
+func (w *testWorker) Analyze(tx *Tx) error {
+	switch {
+	case tx.ReplayErr != nil:
+		return tx.ReplayErr
+	case w.tracer == nil || w.tracer.block != tx.Block || w.tracer.tx != tx.Tx || w.tracer.starts != 1:
+		return fmt.Errorf("tracer %+v for tx %v_%v", w.tracer, tx.Block, tx.Tx)
+	case !proto.Equal(tx.Substate, tx.ReplaySubstate):
+		return fmt.Errorf("replayed substate of tx %v_%v is not given", tx.Block, tx.Tx)
+	}
+	w.tracer = nil
+	w.txs++
+	return nil
+}
+
+type testAnalysis struct {
+	workers int
+	txs     int
+}
+
+func (a *testAnalysis) NewWorker() (Worker, error) {
+	return &testWorker{}, nil
+}
+
+func (a *testAnalysis) Merge(w Worker) error {
+	a.workers++
+	a.txs += w.(*testWorker).txs
+	return nil
+}
+
+func (a *testAnalysis) WriteResult(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%v txs", a.txs)
+	return err
+}
+
+func TestRunnerReplay(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	def := &Definition{
+		Name:   "test-replay",
+		Replay: true,
+		New: func(config json.RawMessage) (Analysis, error) {
+			return &testAnalysis{}, nil
+		},
+	}
+	if _, err := NewRunner(def, nil, nil); err == nil {
+		t.Fatal("runner of a replaying analysis created without a replay function")
+	}
+
+	replay := func(block uint64, tx int, substate *research.Substate, tracer vm.EVMLogger) (*research.Substate, error) {
+		tracer.(*testTracer).starts++
+		return proto.Clone(substate).(*research.Substate), nil
+	}
+	if got := runAnalysis(t, db, def, nil, replay); got != "44 txs" {
+		t.Fatalf("result %q", got)
+	}
+
+	// errors of replay are given to workers
+	replayErr := func(block uint64, tx int, substate *research.Substate, tracer vm.EVMLogger) (*research.Substate, error) {
+		return nil, fmt.Errorf("replay error")
+	}
+	runner, _ := NewRunner(def, nil, replayErr)
+	err := runner.Task(1, 0, newTestSubstate(1, nil, nil, 1), nil)
+	if err == nil || !strings.Contains(err.Error(), "replay error") {
+		t.Fatalf("error %v, want replay error", err)
+	}
+
+	// tracing workers need Replay
+	def.Replay = false
+	runner, _ = NewRunner(def, nil, nil)
+	if err := runner.Task(1, 0, newTestSubstate(1, nil, nil, 1), nil); err == nil {
+		t.Fatal("tracing worker without Replay")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	def := &Definition{
+		Name: "test-register",
+		New: func(config json.RawMessage) (Analysis, error) {
+			return &testAnalysis{}, nil
+		},
+	}
+	Register(def)
+	if got, ok := Lookup(def.Name); !ok || got != def {
+		t.Fatal("registered analysis not found")
+	}
+	found := false
+	for _, d := range Definitions() {
+		found = found || d == def
+	}
+	if !found {
+		t.Fatal("registered analysis not in Definitions")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic registering the same name twice")
+		}
+	}()
+	Register(def)
+}
@@ -0,0 +1,352 @@
+package analysis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// CoverageConfig is the config of the coverage analysis
+type CoverageConfig struct {
+	Dir string `json:"dir"` // directory of coverage files, "coverage" by default
+	// SourceMaps is the directory of solc runtime source maps named by code
+	// hashes like coverage files, e.g., 0x<code hash>.srcmap, no source
+	// mapping if empty
+	SourceMaps string `json:"sourceMaps"`
+}
+
+func init() {
+	Register(&Definition{
+		Name:   "coverage",
+		Usage:  "Record executed PCs and taken JUMPI branches of each code hash, and write coverage files",
+		Replay: true,
+		New: func(config json.RawMessage) (Analysis, error) {
+			c := &CoverageConfig{}
+			if config != nil {
+				if err := json.Unmarshal(config, c); err != nil {
+					return nil, fmt.Errorf("error decoding coverage config: %w", err)
+				}
+			}
+			return NewCoverage(c)
+		},
+	})
+}
+
+// CoverageFileName returns the name of the coverage file of a code hash,
+// which has the same prefix as the file of substate-cli db-dump-code
+func CoverageFileName(codeHash common.Hash) string {
+	return codeHash.Hex() + ".coverage.json"
+}
+
+// SourceMapFileName returns the name of the solc source map file of a code
+// hash in CoverageConfig.SourceMaps
+func SourceMapFileName(codeHash common.Hash) string {
+	return codeHash.Hex() + ".srcmap"
+}
+
+// CoverageFile is the content of a coverage file of a code hash. Instructions
+// are indexed in the order of solc source maps, i.e., PUSH data is skipped.
+type CoverageFile struct {
+	CodeHash             common.Hash `json:"codeHash"`
+	CodeSize             int         `json:"codeSize"`
+	Instructions         int         `json:"instructions"`
+	ExecutedInstructions int         `json:"executedInstructions"`
+	Branches             int         `json:"branches"` // two branches of each JUMPI
+	TakenBranches        int         `json:"takenBranches"`
+
+	Executed []*CoveredInstruction `json:"executed"`
+	Jumpis   []*CoveredJumpi       `json:"jumpis"` // all JUMPIs in the code
+}
+
+// CoveredInstruction is an executed instruction
+type CoveredInstruction struct {
+	PC    uint64 `json:"pc"`
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	Count uint64 `json:"count"`
+	Src   string `json:"src,omitempty"` // "start:length:file" of the source map
+}
+
+// CoveredJumpi is a JUMPI with the number of times each branch was taken
+type CoveredJumpi struct {
+	PC       uint64 `json:"pc"`
+	Index    int    `json:"index"`
+	Taken    uint64 `json:"taken"`    // jumped to the destination
+	NotTaken uint64 `json:"notTaken"` // continued to the next instruction
+	Src      string `json:"src,omitempty"`
+}
+
+// jumpiCount is the number of times each branch of a JUMPI was taken
+type jumpiCount struct {
+	taken    uint64
+	notTaken uint64
+}
+
+// codeCoverage is the coverage of a code hash
+type codeCoverage struct {
+	code   []byte
+	pcs    map[uint64]uint64 // number of executions of each PC
+	jumpis map[uint64]*jumpiCount
+}
+
+func newCodeCoverage(code []byte) *codeCoverage {
+	return &codeCoverage{
+		code:   common.CopyBytes(code),
+		pcs:    make(map[uint64]uint64),
+		jumpis: make(map[uint64]*jumpiCount),
+	}
+}
+
+func (c *codeCoverage) jumpi(pc uint64) *jumpiCount {
+	j := c.jumpis[pc]
+	if j == nil {
+		j = &jumpiCount{}
+		c.jumpis[pc] = j
+	}
+	return j
+}
+
+func (c *codeCoverage) add(d *codeCoverage) {
+	for pc, n := range d.pcs {
+		c.pcs[pc] += n
+	}
+	for pc, dj := range d.jumpis {
+		j := c.jumpi(pc)
+		j.taken += dj.taken
+		j.notTaken += dj.notTaken
+	}
+}
+
+// file returns the coverage file with source locations of srcmap
+func (c *codeCoverage) file(codeHash common.Hash, srcmap []string) *CoverageFile {
+	f := &CoverageFile{
+		CodeHash: codeHash,
+		CodeSize: len(c.code),
+		Executed: []*CoveredInstruction{},
+		Jumpis:   []*CoveredJumpi{},
+	}
+	for pc, index := uint64(0), 0; pc < uint64(len(c.code)); index++ {
+		op := vm.OpCode(c.code[pc])
+		src := ""
+		if index < len(srcmap) {
+			src = srcmap[index]
+		}
+		if n, ok := c.pcs[pc]; ok {
+			f.ExecutedInstructions++
+			f.Executed = append(f.Executed, &CoveredInstruction{PC: pc, Index: index, Op: op.String(), Count: n, Src: src})
+		}
+		if op == vm.JUMPI {
+			j := &CoveredJumpi{PC: pc, Index: index, Src: src}
+			if count := c.jumpis[pc]; count != nil {
+				j.Taken, j.NotTaken = count.taken, count.notTaken
+			}
+			f.Branches += 2
+			if j.Taken > 0 {
+				f.TakenBranches++
+			}
+			if j.NotTaken > 0 {
+				f.TakenBranches++
+			}
+			f.Jumpis = append(f.Jumpis, j)
+		}
+		f.Instructions++
+		pc++
+		if op.IsPush() {
+			pc += uint64(op - vm.PUSH0)
+		}
+	}
+	return f
+}
+
+// ParseSourceMap decompresses a solc source map into "start:length:file" of
+// each instruction
+func ParseSourceMap(srcmap string) []string {
+	srcmap = strings.TrimSpace(srcmap)
+	if srcmap == "" {
+		return nil
+	}
+	entries := strings.Split(srcmap, ";")
+	locations := make([]string, len(entries))
+	last := []string{"", "", ""}
+	for i, entry := range entries {
+		for j, field := range strings.SplitN(entry, ":", 4) {
+			if j < len(last) && field != "" {
+				last[j] = field
+			}
+		}
+		locations[i] = strings.Join(last, ":")
+	}
+	return locations
+}
+
+// Coverage is the coverage analysis. It records executed PCs and taken JUMPI
+// branches of each code hash, and writes a coverage file of each code hash.
+type Coverage struct {
+	config    CoverageConfig
+	contracts map[common.Hash]*codeCoverage
+}
+
+// NewCoverage returns the coverage analysis with the config
+func NewCoverage(config *CoverageConfig) (*Coverage, error) {
+	c := *config
+	if c.Dir == "" {
+		c.Dir = "coverage"
+	}
+	return &Coverage{
+		config:    c,
+		contracts: make(map[common.Hash]*codeCoverage),
+	}, nil
+}
+
+func (a *Coverage) NewWorker() (Worker, error) {
+	return &coverageWorker{
+		contracts: make(map[common.Hash]*codeCoverage),
+	}, nil
+}
+
+func (a *Coverage) Merge(w Worker) error {
+	for codeHash, d := range w.(*coverageWorker).contracts {
+		c := a.contracts[codeHash]
+		if c == nil {
+			a.contracts[codeHash] = d
+			continue
+		}
+		c.add(d)
+	}
+	return nil
+}
+
+// WriteResult writes coverage files in the directory of the config, and a
+// summary of the coverage of each code hash
+func (a *Coverage) WriteResult(w io.Writer) error {
+	if err := os.MkdirAll(a.config.Dir, 0o755); err != nil {
+		return err
+	}
+	codeHashes := make([]common.Hash, 0, len(a.contracts))
+	for codeHash := range a.contracts {
+		codeHashes = append(codeHashes, codeHash)
+	}
+	sort.Slice(codeHashes, func(i, j int) bool { return codeHashes[i].Cmp(codeHashes[j]) < 0 })
+
+	fmt.Fprintf(w, "contracts: %v, coverage files in %s\n\n", len(codeHashes), a.config.Dir)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "code hash\tinstructions\texecuted\t%%\tbranches\ttaken\t%%\tsource map\t\n")
+	for _, codeHash := range codeHashes {
+		var srcmap []string
+		if a.config.SourceMaps != "" {
+			b, err := os.ReadFile(filepath.Join(a.config.SourceMaps, SourceMapFileName(codeHash)))
+			switch {
+			case err == nil:
+				srcmap = ParseSourceMap(string(b))
+			case !errors.Is(err, os.ErrNotExist):
+				return err
+			}
+		}
+
+		f := a.contracts[codeHash].file(codeHash, srcmap)
+		b, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(a.config.Dir, CoverageFileName(codeHash)), append(b, '\n'), 0o644); err != nil {
+			return err
+		}
+
+		percent := func(n, total int) float64 {
+			if total == 0 {
+				return 0
+			}
+			return 100 * float64(n) / float64(total)
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%.2f\t%v\t%v\t%.2f\t%v\t\n",
+			codeHash.Hex(), f.Instructions, f.ExecutedInstructions, percent(f.ExecutedInstructions, f.Instructions),
+			f.Branches, f.TakenBranches, percent(f.TakenBranches, f.Branches), srcmap != nil)
+	}
+	return tw.Flush()
+}
+
+// coverageWorker is a worker of the coverage analysis and the tracer of its
+// transactions
+type coverageWorker struct {
+	contracts map[common.Hash]*codeCoverage
+
+	// state of the current transaction
+	contract       *vm.Contract // contract of the last opcode
+	coverage       *codeCoverage
+	initCodeHashes map[*vm.Contract]common.Hash
+}
+
+func (w *coverageWorker) Tracer(block uint64, tx int) vm.EVMLogger {
+	w.contract = nil
+	w.coverage = nil
+	w.initCodeHashes = nil
+	return w
+}
+
+func (w *coverageWorker) Analyze(tx *Tx) error {
+	return tx.ReplayErr
+}
+
+// codeCoverage returns the coverage of the code of the contract
+func (w *coverageWorker) codeCoverage(contract *vm.Contract) *codeCoverage {
+	if contract == w.contract {
+		return w.coverage
+	}
+	codeHash := contractCodeHash(contract, &w.initCodeHashes)
+	c := w.contracts[codeHash]
+	if c == nil {
+		c = newCodeCoverage(contract.Code)
+		w.contracts[codeHash] = c
+	}
+	w.contract, w.coverage = contract, c
+	return c
+}
+
+func (w *coverageWorker) CaptureTxStart(gasLimit uint64) {}
+
+func (w *coverageWorker) CaptureTxEnd(restGas uint64) {}
+
+func (w *coverageWorker) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (w *coverageWorker) CaptureEnd(output []byte, gasUsed uint64, err error) {}
+
+func (w *coverageWorker) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+func (w *coverageWorker) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+func (w *coverageWorker) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	// the opcode failed before execution, e.g., out of gas
+	if err != nil {
+		return
+	}
+	c := w.codeCoverage(scope.Contract)
+	c.pcs[pc]++
+	if op == vm.JUMPI {
+		if scope.Stack.Back(1).IsZero() {
+			c.jumpi(pc).notTaken++
+		} else {
+			c.jumpi(pc).taken++
+		}
+	}
+}
+
+func (w *coverageWorker) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	// JUMPI to an invalid destination did not take the branch
+	if op == vm.JUMPI && errors.Is(err, vm.ErrInvalidJump) {
+		if j := w.codeCoverage(scope.Contract).jumpis[pc]; j != nil && j.taken > 0 {
+			j.taken--
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestCoverage(t *testing.T) {
+	var (
+		a = common.HexToAddress("0xaa")
+		b = common.HexToAddress("0xbb")
+	)
+	// JUMPI to PC 8 if the first word of input is not zero
+	codeA := hexutil.MustDecode("0x" + "600035" + "6008" + "57" + "00" + "fe" + "5b" + "00")
+	// JUMPI to PC 7 out of the code
+	codeB := hexutil.MustDecode("0x" + "6001" + "6007" + "57" + "00")
+	hashA, hashB := crypto.Keccak256Hash(codeA), crypto.Keccak256Hash(codeB)
+
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(a, codeA)
+	statedb.SetCode(b, codeB)
+
+	dir := t.TempDir()
+	srcmaps := filepath.Join(dir, "srcmaps")
+	if err := os.Mkdir(srcmaps, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcmaps, SourceMapFileName(hashA)), []byte("0:40:0:-;;:5;10:2;;;;30:10:1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCoverage(&CoverageConfig{Dir: filepath.Join(dir, "coverage"), SourceMaps: srcmaps})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := []struct {
+		to    common.Address
+		input []byte
+	}{
+		{a, common.LeftPadBytes([]byte{1}, 32)},
+		{a, nil},
+		{a, common.LeftPadBytes([]byte{2}, 32)},
+		{b, nil},
+	}
+	for i, call := range calls {
+		w, _ := c.NewWorker()
+		tracer := w.(TracingWorker).Tracer(1, i)
+		runtime.Call(call.to, call.input, &runtime.Config{
+			State:     statedb.Copy(),
+			EVMConfig: vm.Config{Tracer: tracer},
+		})
+		if err := w.Analyze(&Tx{Block: 1, Tx: i}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Merge(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteResult(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), hashA.Hex()) || !strings.Contains(buf.String(), hashB.Hex()) {
+		t.Fatalf("summary\n%s", buf.String())
+	}
+	read := func(codeHash common.Hash) *CoverageFile {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(dir, "coverage", CoverageFileName(codeHash)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := &CoverageFile{}
+		if err := json.Unmarshal(b, f); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	// all instructions but INVALID are executed, and both branches are taken
+	f := read(hashA)
+	if f.CodeSize != len(codeA) || f.Instructions != 8 || f.ExecutedInstructions != 7 || f.Branches != 2 || f.TakenBranches != 2 {
+		t.Fatalf("coverage of a %+v", f)
+	}
+	var pcs []uint64
+	for _, i := range f.Executed {
+		pcs = append(pcs, i.PC)
+	}
+	if !reflect.DeepEqual(pcs, []uint64{0, 2, 3, 5, 6, 8, 9}) {
+		t.Fatalf("executed PCs of a %v", pcs)
+	}
+	if i := f.Executed[3]; i.Index != 3 || i.Op != "JUMPI" || i.Count != 3 || i.Src != "10:2:0" {
+		t.Fatalf("JUMPI of a %+v", i)
+	}
+	if i := f.Executed[6]; i.Index != 7 || i.Count != 2 || i.Src != "30:10:1" {
+		t.Fatalf("STOP at PC 9 of a %+v", i)
+	}
+	if len(f.Jumpis) != 1 || *f.Jumpis[0] != (CoveredJumpi{PC: 5, Index: 3, Taken: 2, NotTaken: 1, Src: "10:2:0"}) {
+		t.Fatalf("JUMPIs of a %+v", f.Jumpis)
+	}
+
+	// JUMPI to an invalid destination takes no branch
+	f = read(hashB)
+	if f.Instructions != 4 || f.ExecutedInstructions != 3 || f.Branches != 2 || f.TakenBranches != 0 || f.Executed[0].Src != "" {
+		t.Fatalf("coverage of b %+v", f)
+	}
+}
+
+func TestParseSourceMap(t *testing.T) {
+	got := ParseSourceMap("1:2:0:-;:3;;4::1:i:0;5:6:-1\n")
+	want := []string{"1:2:0", "1:3:0", "1:3:0", "4:3:1", "5:6:-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("source map %v, want %v", got, want)
+	}
+	if got := ParseSourceMap(""); got != nil {
+		t.Fatalf("empty source map %v", got)
+	}
+}
@@ -0,0 +1,76 @@
+package analysis
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// gasCall is a call whose gas cost includes the gas given to the callee
+type gasCall struct {
+	acc   *uint64
+	depth int
+	gas   uint64 // gas before the call
+	cost  uint64
+}
+
+// gasAttribution attributes the gas used by a transaction to its opcodes.
+// Gas given to the callee of a CALL, CALLCODE, DELEGATECALL or STATICCALL,
+// including the stipend of a value transfer, is excluded from the call and
+// counted by opcodes of the callee or by the precompiled contract, like the
+// init code of CREATE and CREATE2. Remaining gas consumed by a failed call
+// frame is attributed to the failed opcode. Gas of all opcodes and precompiled
+// contracts adds up to the gas used by the transaction except intrinsic gas,
+// refunds and code deposit of created contracts.
+type gasAttribution struct {
+	call *gasCall
+}
+
+func (g *gasAttribution) reset() {
+	g.call = nil
+}
+
+// state adds the gas of an opcode of CaptureState to acc
+func (g *gasAttribution) state(acc *uint64, op vm.OpCode, gas, cost uint64, depth int, err error) {
+	// a call that returned without entering the callee, e.g., with
+	// insufficient balance, spent gas before this opcode
+	if c := g.call; c != nil {
+		if c.depth == depth && c.gas >= gas && c.gas-gas < c.cost {
+			*c.acc -= c.cost - (c.gas - gas)
+		}
+		g.call = nil
+	}
+
+	if err != nil {
+		// the opcode failed before execution, e.g., out of gas, and the call
+		// frame consumed all remaining gas
+		*acc += gas
+		return
+	}
+	*acc += cost
+
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		g.call = &gasCall{acc: acc, depth: depth, gas: gas, cost: cost}
+	}
+}
+
+// enter excludes the gas given to the callee of CaptureEnter from the call
+func (g *gasAttribution) enter(gas uint64) {
+	if c := g.call; c != nil {
+		if gas <= c.cost {
+			*c.acc -= gas
+		}
+		g.call = nil
+	}
+}
+
+// fault adds the remaining gas consumed by an opcode of CaptureFault to acc
+func (g *gasAttribution) fault(acc *uint64, gas, cost uint64, err error) {
+	// the opcode failed during execution, and the call frame consumed all
+	// remaining gas unless it reverted
+	if errors.Is(err, vm.ErrExecutionReverted) || gas < cost {
+		return
+	}
+	*acc += gas - cost
+}
@@ -0,0 +1,519 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/google/pprof/profile"
+)
+
+// Rankings of the gas-profile report
+const (
+	SortByGas         = "gas"         // gas used by opcodes of the contract
+	SortByTime        = "time"        // total execution time of invocations
+	SortByInvocations = "invocations" // number of invocations
+)
+
+// GasProfileConfig is the config of the gas-profile analysis
+type GasProfileConfig struct {
+	Top    int    `json:"top"`    // number of contracts in the report, 20 by default, -1 for all
+	SortBy string `json:"sortBy"` // SortByGas by default
+	Pprof  string `json:"pprof"`  // path of the pprof profile, no profile if empty
+}
+
+func init() {
+	Register(&Definition{
+		Name:   "gas-profile",
+		Usage:  "Rank contracts by gas, execution time or invocations, and write a pprof profile of gas by contract and PC",
+		Replay: true,
+		New: func(config json.RawMessage) (Analysis, error) {
+			c := &GasProfileConfig{}
+			if config != nil {
+				if err := json.Unmarshal(config, c); err != nil {
+					return nil, fmt.Errorf("error decoding gas-profile config: %w", err)
+				}
+			}
+			return NewGasProfile(c)
+		},
+	})
+}
+
+// contractProfile is the profile of invocations of a contract code hash.
+// Inclusive gas and execution time are of outermost invocations, which are not
+// nested in another invocation of the same contract, so that recursive and
+// re-entrant invocations are not counted twice.
+type contractProfile struct {
+	Invocations      int64
+	OuterInvocations int64
+	InclusiveGas     uint64 // gas used by outermost invocations including their callees
+	Time             time.Duration
+	MinTime          time.Duration
+	MaxTime          time.Duration
+	// TimeHist[i] is the number of outermost invocations that took [2^(i-1), 2^i) ns
+	TimeHist [65]int64
+
+	gas uint64 // gas used by opcodes of the contract, summed from profile nodes
+}
+
+// observe adds an outermost invocation that took d and used gasUsed
+func (c *contractProfile) observe(d time.Duration, gasUsed uint64) {
+	if c.OuterInvocations == 0 || d < c.MinTime {
+		c.MinTime = d
+	}
+	if d > c.MaxTime {
+		c.MaxTime = d
+	}
+	c.OuterInvocations++
+	c.InclusiveGas += gasUsed
+	c.Time += d
+	c.TimeHist[bits.Len64(uint64(d))]++
+}
+
+func (c *contractProfile) add(d *contractProfile) {
+	c.Invocations += d.Invocations
+	if d.OuterInvocations == 0 {
+		return
+	}
+	if c.OuterInvocations == 0 || d.MinTime < c.MinTime {
+		c.MinTime = d.MinTime
+	}
+	if d.MaxTime > c.MaxTime {
+		c.MaxTime = d.MaxTime
+	}
+	c.OuterInvocations += d.OuterInvocations
+	c.InclusiveGas += d.InclusiveGas
+	c.Time += d.Time
+	for i, n := range d.TimeHist {
+		c.TimeHist[i] += n
+	}
+}
+
+// percentile returns the upper bound of the time histogram bucket with the
+// p-th percentile outermost invocation, bounded by the min and max time
+func (c *contractProfile) percentile(p float64) time.Duration {
+	rank := int64(p*float64(c.OuterInvocations) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, count := range c.TimeHist {
+		n += count
+		if n < rank {
+			continue
+		}
+		if i >= 63 || time.Duration(1)<<i > c.MaxTime {
+			return c.MaxTime
+		}
+		if time.Duration(1)<<i < c.MinTime {
+			return c.MinTime
+		}
+		return time.Duration(1) << i
+	}
+	return c.MaxTime
+}
+
+// profileKey is a callee of a profile node, called at callPC of the node
+type profileKey struct {
+	callPC   uint64
+	codeHash common.Hash
+}
+
+// profileCell is the number of executions and gas of an opcode at a PC
+type profileCell struct {
+	count uint64
+	gas   uint64
+}
+
+// profileNode is a contract in a call stack of contracts, and the root node
+// has no contract
+type profileNode struct {
+	codeHash common.Hash
+	callPC   uint64 // PC of the call in the parent node
+	parent   *profileNode
+	children map[profileKey]*profileNode
+	cells    map[uint64]*profileCell
+}
+
+func newProfileNode(parent *profileNode, key profileKey) *profileNode {
+	return &profileNode{
+		codeHash: key.codeHash,
+		callPC:   key.callPC,
+		parent:   parent,
+		children: make(map[profileKey]*profileNode),
+		cells:    make(map[uint64]*profileCell),
+	}
+}
+
+func (n *profileNode) child(key profileKey) *profileNode {
+	c := n.children[key]
+	if c == nil {
+		c = newProfileNode(n, key)
+		n.children[key] = c
+	}
+	return c
+}
+
+func (n *profileNode) cell(pc uint64) *profileCell {
+	c := n.cells[pc]
+	if c == nil {
+		c = &profileCell{}
+		n.cells[pc] = c
+	}
+	return c
+}
+
+func (n *profileNode) merge(d *profileNode) {
+	for pc, dc := range d.cells {
+		c := n.cell(pc)
+		c.count += dc.count
+		c.gas += dc.gas
+	}
+	for key, dchild := range d.children {
+		n.child(key).merge(dchild)
+	}
+}
+
+// walk calls fn with all nodes except the root in depth-first order
+func (n *profileNode) walk(fn func(n *profileNode)) {
+	keys := make([]profileKey, 0, len(n.children))
+	for key := range n.children {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].callPC != keys[j].callPC {
+			return keys[i].callPC < keys[j].callPC
+		}
+		return keys[i].codeHash.Cmp(keys[j].codeHash) < 0
+	})
+	for _, key := range keys {
+		child := n.children[key]
+		fn(child)
+		child.walk(fn)
+	}
+}
+
+// GasProfile is the gas-profile analysis. It accumulates invocations, gas and
+// execution time of each contract code hash, and gas of each PC in each call
+// stack of contracts. Gas of opcodes is attributed like gasAttribution.
+//
+// Execution time of an invocation is the wall-clock time from entering to
+// exiting the call frame measured during replay, which includes its callees.
+// It is measured with the tracer attached, so it also includes the overhead
+// of CaptureState of each opcode. Inclusive gas and execution time are counted
+// only for outermost invocations of each contract.
+type GasProfile struct {
+	config    GasProfileConfig
+	root      *profileNode
+	contracts map[common.Hash]*contractProfile
+}
+
+// NewGasProfile returns the gas-profile analysis with the config
+func NewGasProfile(config *GasProfileConfig) (*GasProfile, error) {
+	c := *config
+	switch {
+	case c.Top == 0:
+		c.Top = 20
+	case c.Top < -1:
+		return nil, fmt.Errorf("top must be positive or -1 for all contracts")
+	}
+	switch c.SortBy {
+	case "":
+		c.SortBy = SortByGas
+	case SortByGas, SortByTime, SortByInvocations:
+	default:
+		return nil, fmt.Errorf("sortBy must be %q, %q or %q", SortByGas, SortByTime, SortByInvocations)
+	}
+	return &GasProfile{
+		config:    c,
+		root:      newProfileNode(nil, profileKey{}),
+		contracts: make(map[common.Hash]*contractProfile),
+	}, nil
+}
+
+func (a *GasProfile) NewWorker() (Worker, error) {
+	return &gasProfileWorker{
+		root:      newProfileNode(nil, profileKey{}),
+		contracts: make(map[common.Hash]*contractProfile),
+	}, nil
+}
+
+func (a *GasProfile) Merge(w Worker) error {
+	pw := w.(*gasProfileWorker)
+	a.root.merge(pw.root)
+	for codeHash, d := range pw.contracts {
+		c := a.contracts[codeHash]
+		if c == nil {
+			c = &contractProfile{}
+			a.contracts[codeHash] = c
+		}
+		c.add(d)
+	}
+	return nil
+}
+
+// WriteResult writes the report of top contracts, and the pprof profile if
+// the config has its path
+func (a *GasProfile) WriteResult(w io.Writer) error {
+	if a.config.Pprof != "" {
+		f, err := os.Create(a.config.Pprof)
+		if err != nil {
+			return err
+		}
+		err = a.WritePprof(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("error writing pprof profile: %w", err)
+		}
+	}
+	return a.WriteReport(w)
+}
+
+// WriteReport writes the ranking of top contracts
+func (a *GasProfile) WriteReport(w io.Writer) error {
+	for _, c := range a.contracts {
+		c.gas = 0
+	}
+	a.root.walk(func(n *profileNode) {
+		c := a.contracts[n.codeHash]
+		for _, cell := range n.cells {
+			c.gas += cell.gas
+		}
+	})
+
+	var (
+		totalGas         uint64
+		totalInvocations int64
+	)
+	codeHashes := make([]common.Hash, 0, len(a.contracts))
+	for codeHash, c := range a.contracts {
+		codeHashes = append(codeHashes, codeHash)
+		totalGas += c.gas
+		totalInvocations += c.Invocations
+	}
+	sort.Slice(codeHashes, func(i, j int) bool {
+		ci, cj := a.contracts[codeHashes[i]], a.contracts[codeHashes[j]]
+		var less, greater bool
+		switch a.config.SortBy {
+		case SortByTime:
+			less, greater = ci.Time < cj.Time, ci.Time > cj.Time
+		case SortByInvocations:
+			less, greater = ci.Invocations < cj.Invocations, ci.Invocations > cj.Invocations
+		default:
+			less, greater = ci.gas < cj.gas, ci.gas > cj.gas
+		}
+		if less || greater {
+			return greater
+		}
+		return codeHashes[i].Cmp(codeHashes[j]) < 0
+	})
+	if top := a.config.Top; top >= 0 && top < len(codeHashes) {
+		codeHashes = codeHashes[:top]
+	}
+
+	fmt.Fprintf(w, "contracts: %v, invocations: %v, gas: %v, sorted by %s\n\n", len(a.contracts), totalInvocations, totalGas, a.config.SortBy)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "rank\tcode hash\tinvocations\tgas\tgas %%\tinclusive gas\ttime\tmean\tmin\tp50\tp90\tp99\tmax\t\n")
+	for i, codeHash := range codeHashes {
+		c := a.contracts[codeHash]
+		share := 0.0
+		if totalGas > 0 {
+			share = 100 * float64(c.gas) / float64(totalGas)
+		}
+		var mean time.Duration
+		if c.OuterInvocations > 0 {
+			mean = c.Time / time.Duration(c.OuterInvocations)
+		}
+		fmt.Fprintf(tw, "%v\t%s\t%v\t%v\t%.2f\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
+			i+1, codeHash.Hex(), c.Invocations, c.gas, share, c.InclusiveGas,
+			c.Time, mean, c.MinTime, c.percentile(0.5), c.percentile(0.9), c.percentile(0.99), c.MaxTime)
+	}
+	return tw.Flush()
+}
+
+// WritePprof writes a pprof profile of executions and gas of each PC of each
+// contract, where call stacks consist of contracts and PCs of their calls.
+// Functions are named by code hashes, and their line numbers are PCs.
+func (a *GasProfile) WritePprof(w io.Writer) error {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "executions", Unit: "count"},
+			{Type: "gas", Unit: "count"},
+		},
+		DefaultSampleType: "gas",
+		PeriodType:        &profile.ValueType{Type: "gas", Unit: "count"},
+		Period:            1,
+	}
+	functions := make(map[common.Hash]*profile.Function)
+	locations := make(map[profileKey]*profile.Location)
+	location := func(codeHash common.Hash, pc uint64) *profile.Location {
+		key := profileKey{callPC: pc, codeHash: codeHash}
+		if l := locations[key]; l != nil {
+			return l
+		}
+		f := functions[codeHash]
+		if f == nil {
+			f = &profile.Function{
+				ID:       uint64(len(p.Function) + 1),
+				Name:     codeHash.Hex(),
+				Filename: codeHash.Hex() + ".hex", // file name of substate-cli db-dump-code
+			}
+			functions[codeHash] = f
+			p.Function = append(p.Function, f)
+		}
+		l := &profile.Location{
+			ID:      uint64(len(p.Location) + 1),
+			Address: pc,
+			Line:    []profile.Line{{Function: f, Line: int64(pc)}},
+		}
+		locations[key] = l
+		p.Location = append(p.Location, l)
+		return l
+	}
+
+	a.root.walk(func(n *profileNode) {
+		var callers []*profile.Location
+		for c := n; c.parent != nil && c.parent.parent != nil; c = c.parent {
+			callers = append(callers, location(c.parent.codeHash, c.callPC))
+		}
+		pcs := make([]uint64, 0, len(n.cells))
+		for pc := range n.cells {
+			pcs = append(pcs, pc)
+		}
+		sort.Slice(pcs, func(i, j int) bool { return pcs[i] < pcs[j] })
+		for _, pc := range pcs {
+			cell := n.cells[pc]
+			p.Sample = append(p.Sample, &profile.Sample{
+				Location: append([]*profile.Location{location(n.codeHash, pc)}, callers...),
+				Value:    []int64{int64(cell.count), int64(cell.gas)},
+			})
+		}
+	})
+
+	if err := p.CheckValid(); err != nil {
+		return err
+	}
+	return p.Write(w)
+}
+
+// gasProfileFrame is a call frame, node and contract are nil until the frame
+// executes code
+type gasProfileFrame struct {
+	node     *profileNode
+	contract *contractProfile
+	start    time.Time
+	pc       uint64 // PC of the last opcode
+}
+
+// gasProfileWorker is a worker of the gas-profile analysis and the tracer of
+// its transactions
+type gasProfileWorker struct {
+	root      *profileNode
+	contracts map[common.Hash]*contractProfile
+
+	// state of the current transaction
+	frames         []gasProfileFrame
+	gas            gasAttribution
+	initCodeHashes map[*vm.Contract]common.Hash
+	active         map[*contractProfile]int // number of frames of each contract
+}
+
+func (w *gasProfileWorker) Tracer(block uint64, tx int) vm.EVMLogger {
+	w.frames = w.frames[:0]
+	w.gas.reset()
+	w.initCodeHashes = nil
+	w.active = make(map[*contractProfile]int)
+	return w
+}
+
+func (w *gasProfileWorker) Analyze(tx *Tx) error {
+	return tx.ReplayErr
+}
+
+func (w *gasProfileWorker) enter() {
+	w.frames = append(w.frames, gasProfileFrame{start: time.Now()})
+}
+
+func (w *gasProfileWorker) exit(gasUsed uint64) {
+	n := len(w.frames)
+	if n == 0 {
+		return
+	}
+	if f := &w.frames[n-1]; f.contract != nil {
+		f.contract.Invocations++
+		// recursive and re-entrant invocations are included in the outermost one
+		if w.active[f.contract]--; w.active[f.contract] == 0 {
+			f.contract.observe(time.Since(f.start), gasUsed)
+		}
+	}
+	w.frames = w.frames[:n-1]
+}
+
+func (w *gasProfileWorker) CaptureTxStart(gasLimit uint64) {}
+
+func (w *gasProfileWorker) CaptureTxEnd(restGas uint64) {}
+
+func (w *gasProfileWorker) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	w.enter()
+}
+
+func (w *gasProfileWorker) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	w.exit(gasUsed)
+}
+
+func (w *gasProfileWorker) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	w.gas.enter(gas)
+	w.enter()
+}
+
+func (w *gasProfileWorker) CaptureExit(output []byte, gasUsed uint64, err error) {
+	w.exit(gasUsed)
+}
+
+// cell returns the profile cell of the PC in the current frame
+func (w *gasProfileWorker) cell(pc uint64, scope *vm.ScopeContext) *profileCell {
+	n := len(w.frames)
+	f := &w.frames[n-1]
+	if f.node == nil {
+		parent, callPC := w.root, uint64(0)
+		if n > 1 && w.frames[n-2].node != nil {
+			parent, callPC = w.frames[n-2].node, w.frames[n-2].pc
+		}
+		codeHash := contractCodeHash(scope.Contract, &w.initCodeHashes)
+		f.node = parent.child(profileKey{callPC: callPC, codeHash: codeHash})
+		f.contract = w.contracts[codeHash]
+		if f.contract == nil {
+			f.contract = &contractProfile{}
+			w.contracts[codeHash] = f.contract
+		}
+		w.active[f.contract]++
+	}
+	f.pc = pc
+	return f.node.cell(pc)
+}
+
+func (w *gasProfileWorker) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if len(w.frames) == 0 {
+		return
+	}
+	c := w.cell(pc, scope)
+	c.count++
+	w.gas.state(&c.gas, op, gas, cost, depth, err)
+}
+
+func (w *gasProfileWorker) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	if len(w.frames) == 0 {
+		return
+	}
+	w.gas.fault(&w.cell(pc, scope).gas, gas, cost, err)
+}
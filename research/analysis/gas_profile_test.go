@@ -0,0 +1,166 @@
+package analysis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/pprof/profile"
+)
+
+func TestGasProfile(t *testing.T) {
+	var (
+		a = common.HexToAddress("0xaa")
+		b = common.HexToAddress("0xbb")
+	)
+	codeA := hexutil.MustDecode("0x" +
+		// CALL b twice at PC 15 and 32
+		"60006000600060006000" + "60bb" + "61ffff" + "f1" + "50" +
+		"60006000600060006000" + "60bb" + "61ffff" + "f1" + "50" +
+		"00")
+	codeB := hexutil.MustDecode("0x6000545000") // SLOAD
+	hashA, hashB := crypto.Keccak256Hash(codeA), crypto.Keccak256Hash(codeB)
+
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(a, codeA)
+	statedb.SetCode(b, codeB)
+
+	g, err := NewGasProfile(&GasProfileConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gasUsed uint64
+	for i := 0; i < 2; i++ {
+		w, _ := g.NewWorker()
+		tracer := w.(TracingWorker).Tracer(1, 0)
+		gasLimit := uint64(1_000_000)
+		_, leftOverGas, err := runtime.Call(a, nil, &runtime.Config{
+			State:     statedb.Copy(),
+			GasLimit:  gasLimit,
+			EVMConfig: vm.Config{Tracer: tracer},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Analyze(&Tx{Block: 1}); err != nil {
+			t.Fatal(err)
+		}
+		gasUsed += gasLimit - leftOverGas
+		if err := g.Merge(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c := g.contracts[hashA]; c == nil || c.Invocations != 2 || c.InclusiveGas != gasUsed || c.MaxTime < c.MinTime {
+		t.Fatalf("profile of a %+v", c)
+	}
+	if c := g.contracts[hashB]; c == nil || c.Invocations != 4 {
+		t.Fatalf("profile of b %+v", c)
+	}
+	nodeA := g.root.children[profileKey{codeHash: hashA}]
+	if nodeA == nil || len(g.root.children) != 1 || len(nodeA.children) != 2 {
+		t.Fatalf("profile tree %+v", g.root)
+	}
+	nodeB := nodeA.children[profileKey{callPC: 15, codeHash: hashB}]
+	if nodeB == nil || nodeA.children[profileKey{callPC: 32, codeHash: hashB}] == nil {
+		t.Fatalf("callees of a %+v", nodeA.children)
+	}
+	// cold SLOAD of b called at PC 15
+	if c := nodeB.cells[2]; c == nil || c.count != 2 || c.gas != 2*2100 {
+		t.Fatalf("SLOAD of b %+v", c)
+	}
+
+	// the report ranks a above b by gas, and b above a by invocations
+	var buf bytes.Buffer
+	if err := g.WriteResult(&buf); err != nil {
+		t.Fatal(err)
+	}
+	report := buf.String()
+	if i, j := strings.Index(report, hashA.Hex()), strings.Index(report, hashB.Hex()); i < 0 || j < 0 || i > j {
+		t.Fatalf("report by gas\n%s", report)
+	}
+	g.config.SortBy = SortByInvocations
+	g.config.Top = 1
+	buf.Reset()
+	if err := g.WriteResult(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if report := buf.String(); !strings.Contains(report, hashB.Hex()) || strings.Contains(report, hashA.Hex()) {
+		t.Fatalf("top report by invocations\n%s", report)
+	}
+
+	// gas of the pprof profile is the gas used by the calls, and SLOAD of b
+	// is cold when called at PC 15 and warm at PC 32
+	buf.Reset()
+	if err := g.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gas, gasB int64
+	for _, s := range p.Sample {
+		gas += s.Value[1]
+		if leaf := s.Location[0].Line[0]; leaf.Function.Name == hashB.Hex() && leaf.Line == 2 {
+			if len(s.Location) != 2 || s.Location[1].Line[0].Function.Name != hashA.Hex() {
+				t.Fatalf("stack of SLOAD %+v", s.Location)
+			}
+			gasB += s.Value[1]
+		}
+	}
+	if gas != int64(gasUsed) || gasB != 2*2100+2*100 {
+		t.Fatalf("pprof gas %v, want %v, SLOAD gas %v", gas, gasUsed, gasB)
+	}
+
+	if _, err := NewGasProfile(&GasProfileConfig{SortBy: "size"}); err == nil {
+		t.Fatal("invalid sortBy")
+	}
+}
+
+func TestGasProfileRecursion(t *testing.T) {
+	r := common.HexToAddress("0xcc")
+	// r calls itself unless called by itself
+	codeR := hexutil.MustDecode("0x" +
+		"303314" + "6016" + "57" +
+		"60006000600060006000" + "30" + "61ffff" + "f1" + "50" +
+		"5b" + "00")
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(r, codeR)
+
+	g, err := NewGasProfile(&GasProfileConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := g.NewWorker()
+	tracer := w.(TracingWorker).Tracer(1, 0)
+	gasLimit := uint64(1_000_000)
+	_, leftOverGas, err := runtime.Call(r, nil, &runtime.Config{
+		State:     statedb,
+		GasLimit:  gasLimit,
+		EVMConfig: vm.Config{Tracer: tracer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Merge(w); err != nil {
+		t.Fatal(err)
+	}
+
+	// inclusive gas and time of the nested invocation are not counted again
+	c := g.contracts[crypto.Keccak256Hash(codeR)]
+	if c == nil || c.Invocations != 2 || c.OuterInvocations != 1 || c.InclusiveGas != gasLimit-leftOverGas {
+		t.Fatalf("profile of r %+v", c)
+	}
+	if c.Time != c.MinTime || c.Time != c.MaxTime {
+		t.Fatalf("time of r %v, min %v, max %v", c.Time, c.MinTime, c.MaxTime)
+	}
+}
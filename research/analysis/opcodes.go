@@ -0,0 +1,442 @@
+package analysis
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Breakdowns of the opcodes analysis
+const (
+	GroupByNone     = "none"      // one group of all transactions
+	GroupByFork     = "fork"      // hard fork era of the block of each transaction
+	GroupByCodeHash = "code-hash" // code hash of the executed contract
+)
+
+// Output formats of the opcodes analysis
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// OpcodesConfig is the config of the opcodes analysis
+type OpcodesConfig struct {
+	GroupBy string `json:"groupBy"` // GroupByNone by default
+	Format  string `json:"format"`  // FormatJSON by default
+}
+
+func init() {
+	Register(&Definition{
+		Name:   "opcodes",
+		Usage:  "Count opcodes, gas per opcode, precompile calls and max call depths of transactions",
+		Replay: true,
+		New: func(config json.RawMessage) (Analysis, error) {
+			c := &OpcodesConfig{}
+			if config != nil {
+				if err := json.Unmarshal(config, c); err != nil {
+					return nil, fmt.Errorf("error decoding opcodes config: %w", err)
+				}
+			}
+			return NewOpcodes(c)
+		},
+	})
+}
+
+// opcodeCount is the number of executions of an opcode and its gas
+type opcodeCount struct {
+	Count int64  `json:"count"`
+	Gas   uint64 `json:"gas"`
+}
+
+// precompileCount is the number of calls to a precompiled contract and its gas
+type precompileCount struct {
+	Calls int64  `json:"calls"`
+	Gas   uint64 `json:"gas"`
+}
+
+// opcodeStats is the statistics of a group
+type opcodeStats struct {
+	Txs         int64
+	Opcodes     [256]opcodeCount
+	Precompiles map[common.Address]*precompileCount
+	CallDepths  map[int]int64 // number of transactions by their max call depth
+}
+
+func newOpcodeStats() *opcodeStats {
+	return &opcodeStats{
+		Precompiles: make(map[common.Address]*precompileCount),
+		CallDepths:  make(map[int]int64),
+	}
+}
+
+func (s *opcodeStats) add(d *opcodeStats) {
+	s.Txs += d.Txs
+	for op := range d.Opcodes {
+		s.Opcodes[op].Count += d.Opcodes[op].Count
+		s.Opcodes[op].Gas += d.Opcodes[op].Gas
+	}
+	for addr, dp := range d.Precompiles {
+		p := s.Precompiles[addr]
+		if p == nil {
+			p = &precompileCount{}
+			s.Precompiles[addr] = p
+		}
+		p.Calls += dp.Calls
+		p.Gas += dp.Gas
+	}
+	for depth, n := range d.CallDepths {
+		s.CallDepths[depth] += n
+	}
+}
+
+// forkName returns the name of the latest hard fork of the rules
+func forkName(rules *params.Rules) string {
+	switch {
+	case rules.IsPrague:
+		return "Prague"
+	case rules.IsCancun:
+		return "Cancun"
+	case rules.IsShanghai:
+		return "Shanghai"
+	case rules.IsMerge:
+		return "Paris"
+	case rules.IsLondon:
+		return "London"
+	case rules.IsBerlin:
+		return "Berlin"
+	case rules.IsIstanbul:
+		return "Istanbul"
+	case rules.IsPetersburg:
+		return "Petersburg"
+	case rules.IsConstantinople:
+		return "Constantinople"
+	case rules.IsByzantium:
+		return "Byzantium"
+	case rules.IsEIP158:
+		return "Spurious Dragon"
+	case rules.IsEIP150:
+		return "Tangerine Whistle"
+	case rules.IsHomestead:
+		return "Homestead"
+	default:
+		return "Frontier"
+	}
+}
+
+// Opcodes is the opcodes analysis, which aggregates opcode frequencies, gas
+// per opcode, precompile calls and max call depths of transactions.
+//
+// Gas of opcodes is attributed like gasAttribution.
+type Opcodes struct {
+	config OpcodesConfig
+	groups map[string]*opcodeStats
+}
+
+// NewOpcodes returns the opcodes analysis with the config
+func NewOpcodes(config *OpcodesConfig) (*Opcodes, error) {
+	c := *config
+	switch c.GroupBy {
+	case "":
+		c.GroupBy = GroupByNone
+	case GroupByNone, GroupByFork, GroupByCodeHash:
+	default:
+		return nil, fmt.Errorf("groupBy must be %q, %q or %q", GroupByNone, GroupByFork, GroupByCodeHash)
+	}
+	switch c.Format {
+	case "":
+		c.Format = FormatJSON
+	case FormatJSON, FormatCSV:
+	default:
+		return nil, fmt.Errorf("format must be %q or %q", FormatJSON, FormatCSV)
+	}
+	return &Opcodes{config: c, groups: make(map[string]*opcodeStats)}, nil
+}
+
+func (a *Opcodes) NewWorker() (Worker, error) {
+	return &opcodesWorker{groupBy: a.config.GroupBy, groups: make(map[string]*opcodeStats)}, nil
+}
+
+func (a *Opcodes) Merge(w Worker) error {
+	for key, d := range w.(*opcodesWorker).groups {
+		s := a.groups[key]
+		if s == nil {
+			s = newOpcodeStats()
+			a.groups[key] = s
+		}
+		s.add(d)
+	}
+	return nil
+}
+
+// groupName returns the printable name of a group key
+func (a *Opcodes) groupName(key string) string {
+	if a.config.GroupBy == GroupByCodeHash {
+		return common.BytesToHash([]byte(key)).Hex()
+	}
+	return key
+}
+
+// opcodesGroup is a group of the JSON result of the opcodes analysis
+type opcodesGroup struct {
+	Txs         int64                       `json:"txs"`
+	Opcodes     map[string]*opcodeCount     `json:"opcodes"`
+	Precompiles map[string]*precompileCount `json:"precompiles"`
+	CallDepths  map[string]int64            `json:"callDepths"`
+}
+
+func (a *Opcodes) WriteResult(w io.Writer) error {
+	keys := make([]string, 0, len(a.groups))
+	for key := range a.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if a.config.Format == FormatCSV {
+		return a.writeCSV(w, keys)
+	}
+
+	result := struct {
+		GroupBy string                   `json:"groupBy"`
+		Groups  map[string]*opcodesGroup `json:"groups"`
+	}{
+		GroupBy: a.config.GroupBy,
+		Groups:  make(map[string]*opcodesGroup),
+	}
+	for _, key := range keys {
+		s := a.groups[key]
+		g := &opcodesGroup{
+			Txs:         s.Txs,
+			Opcodes:     make(map[string]*opcodeCount),
+			Precompiles: make(map[string]*precompileCount),
+			CallDepths:  make(map[string]int64),
+		}
+		for op := range s.Opcodes {
+			if c := s.Opcodes[op]; c.Count > 0 {
+				g.Opcodes[vm.OpCode(op).String()] = &c
+			}
+		}
+		for addr, p := range s.Precompiles {
+			g.Precompiles[addr.Hex()] = p
+		}
+		for depth, n := range s.CallDepths {
+			g.CallDepths[strconv.Itoa(depth)] = n
+		}
+		result.Groups[a.groupName(key)] = g
+	}
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// writeCSV writes the result in rows of group, kind (txs, opcode, precompile
+// or call-depth), key, count and gas
+func (a *Opcodes) writeCSV(w io.Writer, keys []string) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"group", "kind", "key", "count", "gas"})
+	for _, key := range keys {
+		s := a.groups[key]
+		group := a.groupName(key)
+		cw.Write([]string{group, "txs", "", strconv.FormatInt(s.Txs, 10), ""})
+		for op := range s.Opcodes {
+			if c := s.Opcodes[op]; c.Count > 0 {
+				cw.Write([]string{group, "opcode", vm.OpCode(op).String(), strconv.FormatInt(c.Count, 10), strconv.FormatUint(c.Gas, 10)})
+			}
+		}
+		addrs := make([]common.Address, 0, len(s.Precompiles))
+		for addr := range s.Precompiles {
+			addrs = append(addrs, addr)
+		}
+		sort.Slice(addrs, func(i, j int) bool { return addrs[i].Cmp(addrs[j]) < 0 })
+		for _, addr := range addrs {
+			p := s.Precompiles[addr]
+			cw.Write([]string{group, "precompile", addr.Hex(), strconv.FormatInt(p.Calls, 10), strconv.FormatUint(p.Gas, 10)})
+		}
+		depths := make([]int, 0, len(s.CallDepths))
+		for depth := range s.CallDepths {
+			depths = append(depths, depth)
+		}
+		sort.Ints(depths)
+		for _, depth := range depths {
+			cw.Write([]string{group, "call-depth", strconv.Itoa(depth), strconv.FormatInt(s.CallDepths[depth], 10), ""})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// opcodesFrame is a call frame, precompile is not nil if the callee is a
+// precompiled contract
+type opcodesFrame struct {
+	precompile *precompileCount
+}
+
+// contractCodeHash returns the code hash of a contract, which is not set for
+// init code of CREATE and CREATE2. Code hashes of init code are cached in
+// hashes until the end of the transaction.
+func contractCodeHash(contract *vm.Contract, hashes *map[*vm.Contract]common.Hash) common.Hash {
+	if contract.CodeHash != (common.Hash{}) {
+		return contract.CodeHash
+	}
+	if *hashes == nil {
+		*hashes = make(map[*vm.Contract]common.Hash)
+	}
+	h, ok := (*hashes)[contract]
+	if !ok {
+		h = crypto.Keccak256Hash(contract.Code)
+		(*hashes)[contract] = h
+	}
+	return h
+}
+
+// opcodesWorker is a worker of the opcodes analysis and the tracer of its
+// transactions
+type opcodesWorker struct {
+	groupBy string
+	groups  map[string]*opcodeStats
+
+	// state of the current transaction
+	fork        string
+	precompiles map[common.Address]struct{}
+	txKey       *string     // group key of the transaction
+	codeHash    common.Hash // code hash of the contract executed last
+	frames      []opcodesFrame
+	maxDepth    int
+	gas         gasAttribution
+
+	initCodeHashes map[*vm.Contract]common.Hash
+}
+
+func (w *opcodesWorker) group(key string) *opcodeStats {
+	s := w.groups[key]
+	if s == nil {
+		s = newOpcodeStats()
+		w.groups[key] = s
+	}
+	return s
+}
+
+// key returns the group key of a contract with the code hash
+func (w *opcodesWorker) key(codeHash common.Hash) string {
+	switch w.groupBy {
+	case GroupByFork:
+		return w.fork
+	case GroupByCodeHash:
+		return string(codeHash[:])
+	default:
+		return GroupByNone
+	}
+}
+
+func (w *opcodesWorker) Tracer(block uint64, tx int) vm.EVMLogger {
+	w.fork = ""
+	w.precompiles = nil
+	w.txKey = nil
+	w.codeHash = types.EmptyCodeHash
+	w.frames = w.frames[:0]
+	w.maxDepth = 0
+	w.gas.reset()
+	w.initCodeHashes = nil
+	return w
+}
+
+func (w *opcodesWorker) Analyze(tx *Tx) error {
+	if tx.ReplayErr != nil {
+		return tx.ReplayErr
+	}
+	key := w.key(types.EmptyCodeHash)
+	if w.txKey != nil {
+		key = *w.txKey
+	}
+	s := w.group(key)
+	s.Txs++
+	s.CallDepths[w.maxDepth]++
+	return nil
+}
+
+func (w *opcodesWorker) CaptureTxStart(gasLimit uint64) {}
+
+func (w *opcodesWorker) CaptureTxEnd(restGas uint64) {}
+
+func (w *opcodesWorker) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	rules := env.ChainConfig().Rules(env.Context.BlockNumber, env.Context.Random != nil, env.Context.Time)
+	w.fork = forkName(&rules)
+	w.precompiles = make(map[common.Address]struct{})
+	for _, addr := range vm.ActivePrecompiles(rules) {
+		w.precompiles[addr] = struct{}{}
+	}
+	w.enter(to)
+}
+
+func (w *opcodesWorker) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	w.exit(gasUsed)
+}
+
+func (w *opcodesWorker) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	w.gas.enter(gas)
+	w.enter(to)
+}
+
+func (w *opcodesWorker) CaptureExit(output []byte, gasUsed uint64, err error) {
+	w.exit(gasUsed)
+}
+
+// enter pushes a call frame to the address, and counts the call if the
+// address is a precompiled contract
+func (w *opcodesWorker) enter(to common.Address) {
+	frame := opcodesFrame{}
+	if _, ok := w.precompiles[to]; ok {
+		p := w.group(w.key(w.codeHash)).Precompiles
+		frame.precompile = p[to]
+		if frame.precompile == nil {
+			frame.precompile = &precompileCount{}
+			p[to] = frame.precompile
+		}
+		frame.precompile.Calls++
+	}
+	w.frames = append(w.frames, frame)
+	if len(w.frames) > w.maxDepth {
+		w.maxDepth = len(w.frames)
+	}
+}
+
+// exit pops a call frame, and counts its gas if the callee is a precompiled
+// contract
+func (w *opcodesWorker) exit(gasUsed uint64) {
+	n := len(w.frames)
+	if n == 0 {
+		return
+	}
+	if p := w.frames[n-1].precompile; p != nil {
+		p.Gas += gasUsed
+	}
+	w.frames = w.frames[:n-1]
+}
+
+func (w *opcodesWorker) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	w.codeHash = contractCodeHash(scope.Contract, &w.initCodeHashes)
+	key := w.key(w.codeHash)
+	if w.txKey == nil {
+		w.txKey = &key
+	}
+	count := &w.group(key).Opcodes[op]
+	count.Count++
+	w.gas.state(&count.Gas, op, gas, cost, depth, err)
+}
+
+func (w *opcodesWorker) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	key := w.key(contractCodeHash(scope.Contract, &w.initCodeHashes))
+	w.gas.fault(&w.group(key).Opcodes[op].Gas, gas, cost, err)
+}
@@ -0,0 +1,164 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+func TestOpcodes(t *testing.T) {
+	var (
+		a = common.HexToAddress("0xaa")
+		b = common.HexToAddress("0xbb")
+		d = common.HexToAddress("0xdd")
+
+		identity = common.BytesToAddress([]byte{0x04})
+	)
+	codeA := hexutil.MustDecode("0x" +
+		// CALL the identity precompile with 32 bytes
+		"60206000602060006000" + "6004" + "61ffff" + "f1" + "50" +
+		// CALL b with value 1
+		"60006000600060006001" + "60bb" + "61ffff" + "f1" + "50" +
+		// CALL 0xcc with more value than the balance of a
+		"600060006000600061ffff" + "60cc" + "61ffff" + "f1" + "50" +
+		// CALL d failing with INVALID
+		"60006000600060006000" + "60dd" + "61ffff" + "f1" + "50" +
+		"00")
+	codeB := hexutil.MustDecode("0x6000545000") // SLOAD
+	codeD := hexutil.MustDecode("0xfe")         // INVALID
+
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(a, codeA)
+	statedb.SetBalance(a, uint256.NewInt(10))
+	statedb.SetCode(b, codeB)
+	statedb.SetCode(d, codeD)
+
+	// call a twice with each breakdown
+	run := func(groupBy string) *Opcodes {
+		t.Helper()
+		o, err := NewOpcodes(&OpcodesConfig{GroupBy: groupBy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, _ := o.NewWorker()
+		for tx := 0; tx < 2; tx++ {
+			tracer := w.(TracingWorker).Tracer(1, tx)
+			gasLimit := uint64(1_000_000)
+			_, leftOverGas, err := runtime.Call(a, nil, &runtime.Config{
+				State:     statedb.Copy(),
+				GasLimit:  gasLimit,
+				EVMConfig: vm.Config{Tracer: tracer},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Analyze(&Tx{Block: 1, Tx: tx}); err != nil {
+				t.Fatal(err)
+			}
+
+			// gas of opcodes and precompiles is the gas used by the call
+			var gas uint64
+			for _, s := range w.(*opcodesWorker).groups {
+				for _, c := range s.Opcodes {
+					gas += c.Gas
+				}
+				for _, p := range s.Precompiles {
+					gas += p.Gas
+				}
+			}
+			if want := (gasLimit - leftOverGas) * uint64(tx+1); gas != want {
+				t.Fatalf("%s: gas of opcodes and precompiles %v, want %v", groupBy, gas, want)
+			}
+		}
+		if err := o.Merge(w); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+
+	o := run(GroupByNone)
+	s := o.groups[GroupByNone]
+	if s == nil || len(o.groups) != 1 {
+		t.Fatalf("groups %v", o.groups)
+	}
+	if s.Txs != 2 || len(s.CallDepths) != 1 || s.CallDepths[2] != 2 {
+		t.Fatalf("txs %v, call depths %v", s.Txs, s.CallDepths)
+	}
+	// warm identity 100 + memory 3, cold b 2600 + value 9000 - stipend 2300,
+	// cold 0xcc 2600 + value 9000 + new account 25000 - stipend 2300 returned
+	// with insufficient balance, cold d 2600
+	if c := s.Opcodes[vm.CALL]; c.Count != 8 || c.Gas != 2*(103+9300+34300+2600) {
+		t.Fatalf("CALL %+v", c)
+	}
+	// INVALID consumes all 0xffff gas given to d
+	if c := s.Opcodes[vm.INVALID]; c.Count != 2 || c.Gas != 2*0xffff {
+		t.Fatalf("INVALID %+v", c)
+	}
+	if p := s.Precompiles[identity]; p == nil || p.Calls != 2 || p.Gas != 2*18 {
+		t.Fatalf("identity %+v", p)
+	}
+
+	o = run(GroupByFork)
+	if s := o.groups["London"]; s == nil || len(o.groups) != 1 || s.Txs != 2 {
+		t.Fatalf("groups %v", o.groups)
+	}
+
+	o = run(GroupByCodeHash)
+	hashA, hashB := crypto.Keccak256Hash(codeA), crypto.Keccak256Hash(codeB)
+	if s := o.groups[string(hashA[:])]; s == nil || s.Txs != 2 || s.Precompiles[identity] == nil || s.Opcodes[vm.CALL].Count != 8 {
+		t.Fatalf("group of a %+v", s)
+	}
+	if s := o.groups[string(hashB[:])]; s == nil || s.Txs != 0 || s.Opcodes[vm.SLOAD].Count != 2 {
+		t.Fatalf("group of b %+v", s)
+	}
+
+	// JSON and CSV results
+	var buf bytes.Buffer
+	if err := o.WriteResult(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Groups map[string]*opcodesGroup `json:"groups"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if g := result.Groups[hashB.Hex()]; g == nil || g.Opcodes["SLOAD"] == nil || g.Opcodes["SLOAD"].Count != 2 {
+		t.Fatalf("JSON group of b %+v", g)
+	}
+
+	o.config.Format = FormatCSV
+	buf.Reset()
+	if err := o.WriteResult(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, row := range rows {
+		found = found || (row[0] == hashA.Hex() && row[1] == "precompile" && row[2] == identity.Hex() && row[3] == "2" && row[4] == "36")
+	}
+	if !found {
+		t.Fatalf("no precompile row of a in CSV %v", rows)
+	}
+
+	if _, err := NewOpcodes(&OpcodesConfig{GroupBy: "block"}); err == nil {
+		t.Fatal("invalid groupBy")
+	}
+	if _, err := NewOpcodes(&OpcodesConfig{Format: "xml"}); err == nil {
+		t.Fatal("invalid format")
+	}
+}
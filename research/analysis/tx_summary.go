@@ -0,0 +1,87 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+func init() {
+	Register(&Definition{
+		Name:  "tx-summary",
+		Usage: "Count transfer, call and create transactions with their recorded status and gas used",
+		New: func(config json.RawMessage) (Analysis, error) {
+			if config != nil {
+				return nil, fmt.Errorf("tx-summary has no config")
+			}
+			return &txSummary{}, nil
+		},
+	})
+}
+
+// txSummaryCount is the number of transactions of a kind
+type txSummaryCount struct {
+	Txs     int64  `json:"txs"`
+	Failed  int64  `json:"failed"`
+	GasUsed uint64 `json:"gasUsed"`
+}
+
+func (c *txSummaryCount) add(d *txSummaryCount) {
+	c.Txs += d.Txs
+	c.Failed += d.Failed
+	c.GasUsed += d.GasUsed
+}
+
+// txSummary is the result and the worker of the tx-summary analysis
+type txSummary struct {
+	Transfer txSummaryCount `json:"transfer"`
+	Call     txSummaryCount `json:"call"`
+	Create   txSummaryCount `json:"create"`
+	Total    txSummaryCount `json:"total"`
+}
+
+func (s *txSummary) NewWorker() (Worker, error) {
+	return &txSummary{}, nil
+}
+
+func (s *txSummary) Merge(w Worker) error {
+	ws := w.(*txSummary)
+	s.Transfer.add(&ws.Transfer)
+	s.Call.add(&ws.Call)
+	s.Create.add(&ws.Create)
+	s.Total.add(&ws.Total)
+	return nil
+}
+
+func (s *txSummary) WriteResult(w io.Writer) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Analyze classifies a transaction like --skip-transfer-txs, --skip-call-txs
+// and --skip-create-txs of SubstateTaskPool
+func (s *txSummary) Analyze(tx *Tx) error {
+	d := &txSummaryCount{Txs: 1, GasUsed: tx.Substate.GetResult().GetGasUsed()}
+	if tx.Substate.GetResult().GetStatus() == 0 {
+		d.Failed = 1
+	}
+
+	c := &s.Create
+	if to := tx.Substate.GetTxMessage().GetTo(); to != nil {
+		c = &s.Transfer
+		for _, entry := range tx.Substate.GetInputAlloc().GetAlloc() {
+			if bytes.Equal(entry.Address, to.Value) && len(entry.GetAccount().GetCode()) > 0 {
+				c = &s.Call
+				break
+			}
+		}
+	}
+	c.add(d)
+	s.Total.add(d)
+	return nil
+}
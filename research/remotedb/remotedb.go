@@ -0,0 +1,317 @@
+// Package remotedb implements a read-only substate DB backend served by
+// `substate-cli serve` over JSON-RPC, e.g., --substate-db "remote,host:port".
+package remotedb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var errReadOnly = errors.New("remotedb: substate DB is read-only")
+
+func init() {
+	research.RegisterBackend("remote", func(uri string, readOnly bool) (research.BackendDatabase, error) {
+		if !readOnly {
+			return nil, errReadOnly
+		}
+		return New(uri)
+	})
+}
+
+// Database is a read-only substate DB backend on a substate DB server
+type Database struct {
+	client   *rpc.Client
+	pageSize int
+
+	// bytecode is immutable for its code hash
+	codeCache   lru.BasicLRU[common.Hash, []byte]
+	codeCacheMu sync.Mutex
+}
+
+// New connects to a substate DB server, uri without scheme is a HTTP endpoint
+func New(uri string) (*Database, error) {
+	if !strings.Contains(uri, "://") {
+		uri = "http://" + uri
+	}
+	client, err := rpc.Dial(uri)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithClient(client), nil
+}
+
+// NewWithClient returns a substate DB backend with the given RPC client
+func NewWithClient(client *rpc.Client) *Database {
+	return &Database{
+		client:    client,
+		pageSize:  MaxPageSize,
+		codeCache: lru.NewBasicLRU[common.Hash, []byte](4096),
+	}
+}
+
+func (db *Database) call(result interface{}, method string, args ...interface{}) error {
+	return db.client.CallContext(context.Background(), result, Namespace+"_"+method, args...)
+}
+
+// isSubstateKey returns whether the key is "1s"+block+tx
+func isSubstateKey(key []byte) bool {
+	_, _, err := research.DecodeStage1SubstateKey(key)
+	return err == nil
+}
+
+// isCodeKey returns whether the key is "1c"+codeHash
+func isCodeKey(key []byte) bool {
+	_, err := research.DecodeStage1CodeKey(key)
+	return err == nil
+}
+
+func (db *Database) Has(key []byte) (bool, error) {
+	var has bool
+	err := db.call(&has, "has", hexutil.Bytes(key))
+	return has, err
+}
+
+func (db *Database) Get(key []byte) ([]byte, error) {
+	var value hexutil.Bytes
+	var err error
+	switch {
+	case isSubstateKey(key):
+		block, tx, _ := research.DecodeStage1SubstateKey(key)
+		err = db.call(&value, "getSubstate", block, tx)
+	case isCodeKey(key):
+		codeHash, _ := research.DecodeStage1CodeKey(key)
+		db.codeCacheMu.Lock()
+		code, ok := db.codeCache.Get(codeHash)
+		db.codeCacheMu.Unlock()
+		if ok {
+			return code, nil
+		}
+		err = db.call(&value, "getCode", codeHash)
+		if err == nil {
+			db.codeCacheMu.Lock()
+			db.codeCache.Add(codeHash, value)
+			db.codeCacheMu.Unlock()
+		}
+	default:
+		err = db.call(&value, "get", hexutil.Bytes(key))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+func (db *Database) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+func (db *Database) Delete(key []byte) error {
+	return errReadOnly
+}
+
+func (db *Database) NewBatch() ethdb.Batch {
+	return &batch{}
+}
+
+func (db *Database) NewBatchWithSize(size int) ethdb.Batch {
+	return &batch{}
+}
+
+// Stat is not supported by remote backend
+func (db *Database) Stat(property string) (string, error) {
+	return "", errors.New("remotedb: stat is not supported")
+}
+
+func (db *Database) Compact(start []byte, limit []byte) error {
+	return errReadOnly
+}
+
+func (db *Database) Close() error {
+	db.client.Close()
+	return nil
+}
+
+// batch of read-only substate DB fails to write
+type batch struct {
+	size int
+}
+
+func (b *batch) Put(key, value []byte) error {
+	b.size += len(key) + len(value)
+	return nil
+}
+
+func (b *batch) Delete(key []byte) error {
+	b.size += len(key)
+	return nil
+}
+
+func (b *batch) ValueSize() int {
+	return b.size
+}
+
+func (b *batch) Write() error {
+	return errReadOnly
+}
+
+func (b *batch) Reset() {
+	b.size = 0
+}
+
+func (b *batch) Replay(w ethdb.KeyValueWriter) error {
+	return errReadOnly
+}
+
+func (db *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	it := &iterator{
+		db:     db,
+		prefix: common.CopyBytes(prefix),
+		start:  common.CopyBytes(start),
+	}
+
+	// one request for all substates of a block which is the most common case
+	substatePrefix := []byte(research.Stage1SubstatePrefix)
+	if len(start) == 0 && len(prefix) == len(substatePrefix)+8 && bytes.HasPrefix(prefix, substatePrefix) {
+		it.block = binary.BigEndian.Uint64(prefix[len(substatePrefix):])
+		it.blockPrefix = true
+	}
+
+	// substates in order are streamed by ranged requests, and any start
+	// shorter than block+tx is the same lower bound padded with zeros
+	if bytes.Equal(prefix, substatePrefix) && len(start) <= 16 {
+		blockTx := make([]byte, 16)
+		copy(blockTx, start)
+		block := binary.BigEndian.Uint64(blockTx[0:8])
+		tx := binary.BigEndian.Uint64(blockTx[8:16])
+		if tx <= math.MaxInt {
+			it.block, it.tx = block, int(tx)
+			it.substateRange = true
+		}
+	}
+
+	return it
+}
+
+// iterator fetches key-value pairs page by page from the server
+type iterator struct {
+	db          *Database
+	prefix      []byte
+	start       []byte
+	blockPrefix bool   // prefix is "1s"+block
+	block       uint64 // block number of prefix if blockPrefix, or next block if substateRange
+
+	substateRange bool // prefix is "1s"
+	tx            int  // next tx of block if substateRange
+
+	page []KeyValue
+	pos  int
+	last bool
+	err  error
+}
+
+func (it *iterator) fetch() {
+	if it.blockPrefix {
+		var substates []TxSubstate
+		if it.err = it.db.call(&substates, "getBlockSubstates", it.block); it.err != nil {
+			return
+		}
+		it.page = make([]KeyValue, len(substates))
+		for i, s := range substates {
+			it.page[i] = KeyValue{Key: research.Stage1SubstateKey(s.Block, s.Tx), Value: s.Substate}
+		}
+		it.last = true
+		return
+	}
+
+	if it.substateRange {
+		var substates []TxSubstate
+		if it.err = it.db.call(&substates, "getSubstateRange", it.block, it.tx, uint64(math.MaxUint64), it.db.pageSize); it.err != nil {
+			return
+		}
+		it.page = make([]KeyValue, len(substates))
+		for i, s := range substates {
+			it.page[i] = KeyValue{Key: research.Stage1SubstateKey(s.Block, s.Tx), Value: s.Substate}
+		}
+		if len(substates) < it.db.pageSize {
+			it.last = true
+			return
+		}
+		// next page starts from the next tx of the last substate
+		lastSubstate := substates[len(substates)-1]
+		it.block, it.tx = lastSubstate.Block, lastSubstate.Tx+1
+		return
+	}
+
+	var kvs []KeyValue
+	if it.err = it.db.call(&kvs, "iterate", hexutil.Bytes(it.prefix), hexutil.Bytes(it.start), it.db.pageSize); it.err != nil {
+		return
+	}
+	it.page = kvs
+	if len(kvs) < it.db.pageSize {
+		it.last = true
+		return
+	}
+	// next page starts from the smallest key after the last key
+	lastKey := kvs[len(kvs)-1].Key
+	it.start = append(common.CopyBytes(lastKey[len(it.prefix):]), 0)
+}
+
+func (it *iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.page != nil && it.pos+1 < len(it.page) {
+		it.pos++
+		return true
+	}
+	for !it.last {
+		it.fetch()
+		if it.err != nil {
+			return false
+		}
+		it.pos = 0
+		if len(it.page) > 0 {
+			return true
+		}
+	}
+	it.page = nil
+	return false
+}
+
+func (it *iterator) Error() error {
+	return it.err
+}
+
+func (it *iterator) Key() []byte {
+	if it.page == nil || it.pos >= len(it.page) {
+		return nil
+	}
+	return it.page[it.pos].Key
+}
+
+func (it *iterator) Value() []byte {
+	if it.page == nil || it.pos >= len(it.page) {
+		return nil
+	}
+	return it.page[it.pos].Value
+}
+
+func (it *iterator) Release() {
+	it.page = nil
+	it.last = true
+}
@@ -0,0 +1,105 @@
+package remotedb
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/research/researchtest"
+	"github.com/ethereum/go-ethereum/rpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRemoteSubstateDB(t *testing.T) {
+	backend, _ := research.OpenBackendDatabase("memory,", false)
+	serverDB := research.NewSubstateDB(backend)
+	code := []byte{0x60, 0x00, 0x60, 0x00}
+	for block := uint64(1); block <= 5; block++ {
+		for tx := 0; tx < 3; tx++ {
+			serverDB.PutSubstate(block, tx, researchtest.NewSubstate(block, code))
+		}
+	}
+
+	server, err := NewServer(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// in-process client stands in for HTTP
+	remote := NewWithClient(rpc.DialInProc(server))
+	remote.pageSize = 4
+	db := research.NewSubstateDB(remote)
+	defer db.Close()
+
+	if !db.HasSubstate(3, 2) || db.HasSubstate(6, 0) {
+		t.Fatal("unexpected HasSubstate result")
+	}
+	if got, want := db.GetSubstate(3, 2), researchtest.NewSubstate(3, code); !proto.Equal(got, want) {
+		t.Fatalf("substate mismatch\n got: %v\nwant: %v", got, want)
+	}
+	if n := len(db.GetBlockSubstates(4)); n != 3 {
+		t.Fatalf("GetBlockSubstates(4) returned %v substates, want 3", n)
+	}
+
+	// iterate all substates over multiple pages
+	var n int
+	var lastBlock uint64
+	var lastTx int = -1
+	iter := remote.NewIterator([]byte(research.Stage1SubstatePrefix), nil)
+	for iter.Next() {
+		block, tx, err := research.DecodeStage1SubstateKey(iter.Key())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block < lastBlock || (block == lastBlock && tx <= lastTx) {
+			t.Fatalf("unordered substate %v_%v after %v_%v", block, tx, lastBlock, lastTx)
+		}
+		lastBlock, lastTx = block, tx
+		n++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 15 {
+		t.Fatalf("iterated %v substates, want 15", n)
+	}
+
+	// iterate substates from a start over multiple pages
+	for _, tt := range []struct {
+		start []byte
+		want  int
+	}{
+		{research.Stage1SubstateKey(3, 1)[2:], 8},
+		{research.Stage1SubstateBlockPrefix(2)[2:], 12},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 3}, 0},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 4, 0xff}, 3},
+		{append(research.Stage1SubstateKey(1, 0)[2:], 0), 14},
+	} {
+		var n int
+		iter := remote.NewIterator([]byte(research.Stage1SubstatePrefix), tt.start)
+		for iter.Next() {
+			n++
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.want {
+			t.Errorf("iterated %v substates from %x, want %v", n, tt.start, tt.want)
+		}
+	}
+
+	// ranged streaming by the server API
+	var substates []TxSubstate
+	if err := remote.call(&substates, "getSubstateRange", 2, 1, 3, 100); err != nil {
+		t.Fatal(err)
+	}
+	if len(substates) != 5 || substates[0].Block != 2 || substates[0].Tx != 1 {
+		t.Fatalf("unexpected substate range: %v substates", len(substates))
+	}
+
+	if err := remote.Put([]byte("key"), []byte("value")); err == nil {
+		t.Fatal("remote substate DB is not read-only")
+	}
+}
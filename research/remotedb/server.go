@@ -0,0 +1,132 @@
+package remotedb
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Namespace is the JSON-RPC namespace of substate DB server
+const Namespace = "substatedb"
+
+// MaxPageSize is the maximum number of entries returned by one ranged request
+const MaxPageSize = 1000
+
+// TxSubstate is a hashed substate encoded in Protobuf with its block and tx
+type TxSubstate struct {
+	Block    uint64        `json:"block"`
+	Tx       int           `json:"tx"`
+	Substate hexutil.Bytes `json:"substate"`
+}
+
+// KeyValue is a key-value pair of the substate DB layout
+type KeyValue struct {
+	Key   hexutil.Bytes `json:"key"`
+	Value hexutil.Bytes `json:"value"`
+}
+
+// API serves a substate DB backend read-only in the substate namespace.
+// Substates are hashed substates encoded in Protobuf (substate.proto),
+// and clients get bytecode separately by code hash.
+type API struct {
+	db research.BackendDatabase
+}
+
+// NewServer returns a JSON-RPC server serving db read-only
+func NewServer(db research.BackendDatabase) (*rpc.Server, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName(Namespace, &API{db: db}); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 || limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
+
+// GetSubstate returns a hashed substate of the given block and tx
+func (api *API) GetSubstate(block uint64, tx int) (hexutil.Bytes, error) {
+	return api.db.Get(research.Stage1SubstateKey(block, tx))
+}
+
+// GetBlockSubstates returns all hashed substates of the given block
+func (api *API) GetBlockSubstates(block uint64) ([]TxSubstate, error) {
+	var substates []TxSubstate
+
+	iter := api.db.NewIterator(research.Stage1SubstateBlockPrefix(block), nil)
+	defer iter.Release()
+	for iter.Next() {
+		b, tx, err := research.DecodeStage1SubstateKey(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		substates = append(substates, TxSubstate{
+			Block:    b,
+			Tx:       tx,
+			Substate: common.CopyBytes(iter.Value()),
+		})
+	}
+	return substates, iter.Error()
+}
+
+// GetSubstateRange returns at most limit hashed substates from (fromBlock, fromTx)
+// to the end of toBlock in order. Clients stream a block segment by calling
+// GetSubstateRange again from the next tx of the last returned substate.
+func (api *API) GetSubstateRange(fromBlock uint64, fromTx int, toBlock uint64, limit int) ([]TxSubstate, error) {
+	var substates []TxSubstate
+
+	prefix := []byte(research.Stage1SubstatePrefix)
+	start := research.Stage1SubstateKey(fromBlock, fromTx)[len(prefix):]
+	iter := api.db.NewIterator(prefix, start)
+	defer iter.Release()
+	for n := pageSize(limit); len(substates) < n && iter.Next(); {
+		block, tx, err := research.DecodeStage1SubstateKey(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if block > toBlock {
+			break
+		}
+		substates = append(substates, TxSubstate{
+			Block:    block,
+			Tx:       tx,
+			Substate: common.CopyBytes(iter.Value()),
+		})
+	}
+	return substates, iter.Error()
+}
+
+// GetCode returns bytecode of the given code hash
+func (api *API) GetCode(codeHash common.Hash) (hexutil.Bytes, error) {
+	return api.db.Get(research.Stage1CodeKey(codeHash))
+}
+
+// Has returns whether the key exists in the substate DB
+func (api *API) Has(key hexutil.Bytes) (bool, error) {
+	return api.db.Has(key)
+}
+
+// Get returns the value of the key in the substate DB
+func (api *API) Get(key hexutil.Bytes) (hexutil.Bytes, error) {
+	return api.db.Get(key)
+}
+
+// Iterate returns at most limit key-value pairs with the prefix from prefix+start
+func (api *API) Iterate(prefix hexutil.Bytes, start hexutil.Bytes, limit int) ([]KeyValue, error) {
+	var kvs []KeyValue
+
+	iter := api.db.NewIterator(prefix, start)
+	defer iter.Release()
+	for n := pageSize(limit); len(kvs) < n && iter.Next(); {
+		kvs = append(kvs, KeyValue{
+			Key:   common.CopyBytes(iter.Key()),
+			Value: common.CopyBytes(iter.Value()),
+		})
+	}
+	return kvs, iter.Error()
+}
@@ -0,0 +1,84 @@
+// Package researchtest provides fixtures for tests of substate DB backends
+// outside the research package.
+package researchtest
+
+import (
+	"github.com/ethereum/go-ethereum/research"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// NewSubstate returns a small unhashed substate with one contract call to
+// round-trip every substate field through substate DB backends
+func NewSubstate(block uint64, code []byte) *research.Substate {
+	from := []byte{0x01}
+	to := []byte{0x02}
+	return &research.Substate{
+		InputAlloc: &research.Substate_Alloc{
+			Alloc: []*research.Substate_AllocEntry{
+				{
+					Address: from,
+					Account: &research.Substate_Account{
+						Nonce:   proto.Uint64(1),
+						Balance: []byte{0x10},
+					},
+				},
+				{
+					Address: to,
+					Account: &research.Substate_Account{
+						Nonce:   proto.Uint64(0),
+						Balance: []byte{},
+						Storage: []*research.Substate_Account_StorageEntry{
+							{Key: []byte{0x01}, Value: []byte{0x02}},
+						},
+						Contract: &research.Substate_Account_Code{Code: code},
+					},
+				},
+			},
+		},
+		OutputAlloc: &research.Substate_Alloc{
+			Alloc: []*research.Substate_AllocEntry{
+				{
+					Address: from,
+					Account: &research.Substate_Account{
+						Nonce:   proto.Uint64(2),
+						Balance: []byte{0x0f},
+					},
+				},
+				{
+					Address: to,
+					Account: &research.Substate_Account{
+						Nonce:   proto.Uint64(0),
+						Balance: []byte{0x01},
+						Storage: []*research.Substate_Account_StorageEntry{
+							{Key: []byte{0x01}, Value: []byte{0x03}},
+						},
+						Contract: &research.Substate_Account_Code{Code: code},
+					},
+				},
+			},
+		},
+		BlockEnv: &research.Substate_BlockEnv{
+			Coinbase:   []byte{0x03},
+			Difficulty: []byte{},
+			GasLimit:   proto.Uint64(30_000_000),
+			Number:     proto.Uint64(block),
+			Timestamp:  proto.Uint64(1),
+		},
+		TxMessage: &research.Substate_TxMessage{
+			Nonce:    proto.Uint64(1),
+			GasPrice: []byte{0x01},
+			Gas:      proto.Uint64(21_000),
+			From:     from,
+			To:       wrapperspb.Bytes(to),
+			Value:    []byte{0x01},
+			Input:    &research.Substate_TxMessage_Data{Data: []byte{}},
+			TxType:   research.Substate_TxMessage_TXTYPE_LEGACY.Enum(),
+		},
+		Result: &research.Substate_Result{
+			Status:  proto.Uint64(1),
+			Bloom:   make([]byte, 256),
+			GasUsed: proto.Uint64(21_000),
+		},
+	}
+}
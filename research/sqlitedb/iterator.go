@@ -0,0 +1,260 @@
+package sqlitedb
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/research"
+)
+
+// upperBound returns the smallest key larger than all keys with the prefix,
+// or nil if there is no such key.
+func upperBound(prefix []byte) []byte {
+	limit := common.CopyBytes(prefix)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return limit[:i+1]
+		}
+	}
+	return nil
+}
+
+// tableRange converts key range [lo, hi) of the substate DB layout to
+// key range of a table whose keys are tablePrefix + fixed-length suffix.
+// from and to are suffixes padded to suffixLen, nil if unbounded.
+// ok is false if no key of the table can be in the range.
+func tableRange(tablePrefix []byte, suffixLen int, lo, hi []byte) (from, to []byte, ok bool) {
+	if hi != nil && bytes.Compare(hi, tablePrefix) <= 0 {
+		return nil, nil, false
+	}
+	if end := upperBound(tablePrefix); lo != nil && end != nil && bytes.Compare(lo, end) >= 0 {
+		return nil, nil, false
+	}
+	pad := func(key []byte) []byte {
+		suffix := make([]byte, suffixLen)
+		copy(suffix, key[len(tablePrefix):])
+		return suffix
+	}
+	if lo != nil && bytes.HasPrefix(lo, tablePrefix) {
+		from = pad(lo)
+	}
+	if hi != nil && bytes.HasPrefix(hi, tablePrefix) {
+		to = pad(hi)
+	}
+	return from, to, true
+}
+
+// substateConds returns SQL conditions of substate table rows in the range
+// [from, to) of block+tx suffixes. Block and tx of rows are SQLite3 INTEGERs
+// less than 2^63, so larger bounds are compared before converting to int64.
+// ok is false if no row can be in the range.
+func substateConds(from, to []byte, ok bool) ([]string, []interface{}, bool) {
+	if !ok {
+		return nil, nil, false
+	}
+	var conds []string
+	var args []interface{}
+	if from != nil {
+		b, tx := binary.BigEndian.Uint64(from[0:8]), binary.BigEndian.Uint64(from[8:16])
+		switch {
+		case b > math.MaxInt64:
+			return nil, nil, false
+		case tx > math.MaxInt64:
+			conds = append(conds, "block > ?")
+			args = append(args, int64(b))
+		default:
+			conds = append(conds, "(block > ? OR (block = ? AND tx >= ?))")
+			args = append(args, int64(b), int64(b), int64(tx))
+		}
+	}
+	if to != nil {
+		b, tx := binary.BigEndian.Uint64(to[0:8]), binary.BigEndian.Uint64(to[8:16])
+		switch {
+		case b > math.MaxInt64:
+			// every row is below the bound
+		case tx > math.MaxInt64:
+			conds = append(conds, "block <= ?")
+			args = append(args, int64(b))
+		default:
+			conds = append(conds, "(block < ? OR (block = ? AND tx < ?))")
+			args = append(args, int64(b), int64(b), int64(tx))
+		}
+	}
+	return conds, args, true
+}
+
+// source is a table iterator ordered by keys of the substate DB layout
+type source struct {
+	rows  *sql.Rows
+	scan  func(rows *sql.Rows) (key, value []byte, err error)
+	key   []byte
+	value []byte
+	done  bool
+}
+
+func (s *source) next() error {
+	if s.done {
+		return nil
+	}
+	if !s.rows.Next() {
+		s.done = true
+		return s.rows.Err()
+	}
+	var err error
+	s.key, s.value, err = s.scan(s.rows)
+	return err
+}
+
+// iterator merges ordered rows of substate, code and kv tables
+type iterator struct {
+	sources []*source
+	cur     *source
+	started bool
+	err     error
+}
+
+func (db *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	lo := append(common.CopyBytes(prefix), start...)
+	hi := upperBound(prefix)
+	if len(lo) == 0 {
+		lo = nil
+	}
+
+	it := &iterator{}
+	add := func(query string, args []interface{}, scan func(rows *sql.Rows) (key, value []byte, err error)) {
+		if it.err != nil {
+			return
+		}
+		rows, err := db.db.Query(query, args...)
+		if err != nil {
+			it.err = err
+			return
+		}
+		it.sources = append(it.sources, &source{rows: rows, scan: scan})
+	}
+
+	// substate table
+	if conds, args, ok := substateConds(tableRange(substatePrefix, 16, lo, hi)); ok {
+		add("SELECT block, tx, substate FROM substate"+where(conds)+" ORDER BY block, tx", args, func(rows *sql.Rows) ([]byte, []byte, error) {
+			var block int64
+			var tx int
+			var value []byte
+			if err := rows.Scan(&block, &tx, &value); err != nil {
+				return nil, nil, err
+			}
+			return research.Stage1SubstateKey(uint64(block), tx), value, nil
+		})
+	}
+
+	// code table
+	if from, to, ok := tableRange(codePrefix, common.HashLength, lo, hi); ok {
+		var conds []string
+		var args []interface{}
+		if from != nil {
+			conds = append(conds, "code_hash >= ?")
+			args = append(args, from)
+		}
+		if to != nil {
+			conds = append(conds, "code_hash < ?")
+			args = append(args, to)
+		}
+		add("SELECT code_hash, code FROM code"+where(conds)+" ORDER BY code_hash", args, func(rows *sql.Rows) ([]byte, []byte, error) {
+			var codeHash, code []byte
+			if err := rows.Scan(&codeHash, &code); err != nil {
+				return nil, nil, err
+			}
+			return research.Stage1CodeKey(common.BytesToHash(codeHash)), nonNil(code), nil
+		})
+	}
+
+	// kv table
+	{
+		var conds []string
+		var args []interface{}
+		if lo != nil {
+			conds = append(conds, "key >= ?")
+			args = append(args, lo)
+		}
+		if hi != nil {
+			conds = append(conds, "key < ?")
+			args = append(args, hi)
+		}
+		add("SELECT key, value FROM kv"+where(conds)+" ORDER BY key", args, func(rows *sql.Rows) ([]byte, []byte, error) {
+			var key, value []byte
+			if err := rows.Scan(&key, &value); err != nil {
+				return nil, nil, err
+			}
+			return key, nonNil(value), nil
+		})
+	}
+
+	return it
+}
+
+func where(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+func (it *iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.started {
+		it.started = true
+		for _, s := range it.sources {
+			if it.err = s.next(); it.err != nil {
+				return false
+			}
+		}
+	} else if it.cur != nil {
+		if it.err = it.cur.next(); it.err != nil {
+			return false
+		}
+	}
+
+	it.cur = nil
+	for _, s := range it.sources {
+		if s.done {
+			continue
+		}
+		if it.cur == nil || bytes.Compare(s.key, it.cur.key) < 0 {
+			it.cur = s
+		}
+	}
+	return it.cur != nil
+}
+
+func (it *iterator) Error() error {
+	return it.err
+}
+
+func (it *iterator) Key() []byte {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.key
+}
+
+func (it *iterator) Value() []byte {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.value
+}
+
+func (it *iterator) Release() {
+	for _, s := range it.sources {
+		s.rows.Close()
+	}
+	it.sources = nil
+	it.cur = nil
+}
@@ -0,0 +1,441 @@
+// Package sqlitedb implements a substate DB backend on an embedded SQLite3
+// file with a relational schema, so that substates can be read with SQL from
+// languages other than Go.
+//
+// Keys of the substate DB layout are mapped to tables as follows:
+//   - "1s"+block+tx: substate table keyed by (block, tx), plus normalized
+//     alloc and storage tables decoded from the substate
+//   - "1c"+codeHash: code table keyed by code_hash
+//   - other keys: kv table
+package sqlitedb
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/research"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/proto"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS substate (
+	block     INTEGER NOT NULL,
+	tx        INTEGER NOT NULL,
+	tx_type   INTEGER NOT NULL,
+	sender    BLOB    NOT NULL,
+	recipient BLOB,             -- NULL for contract creation
+	status    INTEGER NOT NULL,
+	gas_used  INTEGER NOT NULL,
+	substate  BLOB    NOT NULL, -- hashed substate in Protobuf
+	PRIMARY KEY (block, tx)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS code (
+	code_hash BLOB NOT NULL PRIMARY KEY,
+	code      BLOB NOT NULL
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS alloc (
+	block     INTEGER NOT NULL,
+	tx        INTEGER NOT NULL,
+	io        TEXT    NOT NULL, -- 'input' or 'output'
+	address   BLOB    NOT NULL,
+	nonce     INTEGER NOT NULL,
+	balance   BLOB    NOT NULL,
+	code_hash BLOB,             -- NULL for accounts without code
+	PRIMARY KEY (block, tx, io, address)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS storage (
+	block   INTEGER NOT NULL,
+	tx      INTEGER NOT NULL,
+	io      TEXT    NOT NULL, -- 'input' or 'output'
+	address BLOB    NOT NULL,
+	key     BLOB    NOT NULL,
+	value   BLOB    NOT NULL,
+	PRIMARY KEY (block, tx, io, address, key)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS kv (
+	key   BLOB NOT NULL PRIMARY KEY,
+	value BLOB NOT NULL
+) WITHOUT ROWID;
+`
+
+var (
+	errNotFound = errors.New("not found")
+
+	substatePrefix = []byte(research.Stage1SubstatePrefix)
+	codePrefix     = []byte(research.Stage1CodePrefix)
+)
+
+func init() {
+	open := func(uri string, readOnly bool) (research.BackendDatabase, error) {
+		return New(uri, readOnly)
+	}
+	research.RegisterBackend("sqlite3", open)
+	research.RegisterBackend("sqlite", open)
+}
+
+// Database is a substate DB backend on SQLite3
+type Database struct {
+	db *sql.DB
+
+	closeOnce sync.Once
+}
+
+// dsn returns a data source name of go-sqlite3 from a path or a file: URI
+func dsn(uri string, readOnly bool) string {
+	if !strings.HasPrefix(uri, "file:") {
+		uri = "file:" + uri
+	}
+	params := []string{"_busy_timeout=10000", "_journal_mode=WAL", "_synchronous=NORMAL"}
+	if readOnly {
+		params = append(params, "mode=ro")
+	}
+	sep := "?"
+	if strings.Contains(uri, "?") {
+		sep = "&"
+	}
+	return uri + sep + strings.Join(params, "&")
+}
+
+// New opens a SQLite3 file at uri and creates tables if they do not exist
+func New(uri string, readOnly bool) (*Database, error) {
+	db, err := sql.Open("sqlite3", dsn(uri, readOnly))
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if !readOnly {
+		if _, err = db.Exec(schema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error creating tables: %w", err)
+		}
+	}
+	return &Database{db: db}, nil
+}
+
+// DB returns the underlying *sql.DB for SQL queries
+func (db *Database) DB() *sql.DB {
+	return db.db
+}
+
+func (db *Database) Close() error {
+	var err error
+	db.closeOnce.Do(func() {
+		err = db.db.Close()
+	})
+	return err
+}
+
+// isSubstateKey returns whether the key is "1s"+block+tx
+func isSubstateKey(key []byte) bool {
+	return len(key) == len(substatePrefix)+16 && bytes.HasPrefix(key, substatePrefix)
+}
+
+// substateKey decodes block and tx of a "1s"+block+tx key as SQLite3 INTEGERs.
+// ok is false if block or tx is not less than 2^63, which no substate table
+// row can have.
+func substateKey(key []byte) (block int64, tx int64, ok bool) {
+	b := binary.BigEndian.Uint64(key[len(substatePrefix):])
+	t := binary.BigEndian.Uint64(key[len(substatePrefix)+8:])
+	if b > math.MaxInt64 || t > math.MaxInt64 {
+		return 0, 0, false
+	}
+	return int64(b), int64(t), true
+}
+
+// isCodeKey returns whether the key is "1c"+codeHash
+func isCodeKey(key []byte) bool {
+	return len(key) == len(codePrefix)+common.HashLength && bytes.HasPrefix(key, codePrefix)
+}
+
+func (db *Database) Has(key []byte) (bool, error) {
+	var row *sql.Row
+	switch {
+	case isSubstateKey(key):
+		block, tx, ok := substateKey(key)
+		if !ok {
+			return false, nil
+		}
+		row = db.db.QueryRow("SELECT 1 FROM substate WHERE block = ? AND tx = ?", block, tx)
+	case isCodeKey(key):
+		row = db.db.QueryRow("SELECT 1 FROM code WHERE code_hash = ?", key[len(codePrefix):])
+	default:
+		row = db.db.QueryRow("SELECT 1 FROM kv WHERE key = ?", key)
+	}
+	var one int
+	err := row.Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (db *Database) Get(key []byte) ([]byte, error) {
+	var row *sql.Row
+	switch {
+	case isSubstateKey(key):
+		block, tx, ok := substateKey(key)
+		if !ok {
+			return nil, errNotFound
+		}
+		row = db.db.QueryRow("SELECT substate FROM substate WHERE block = ? AND tx = ?", block, tx)
+	case isCodeKey(key):
+		row = db.db.QueryRow("SELECT code FROM code WHERE code_hash = ?", key[len(codePrefix):])
+	default:
+		row = db.db.QueryRow("SELECT value FROM kv WHERE key = ?", key)
+	}
+	var value []byte
+	err := row.Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+func (db *Database) Put(key []byte, value []byte) error {
+	return db.update(func(tx *sql.Tx) error {
+		return put(tx, key, value)
+	})
+}
+
+func (db *Database) Delete(key []byte) error {
+	return db.update(func(tx *sql.Tx) error {
+		return del(tx, key)
+	})
+}
+
+// update runs fn in a SQL transaction
+func (db *Database) update(fn func(tx *sql.Tx) error) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func put(tx *sql.Tx, key []byte, value []byte) error {
+	switch {
+	case isSubstateKey(key):
+		return putSubstate(tx, key, value)
+	case isCodeKey(key):
+		_, err := tx.Exec("INSERT OR REPLACE INTO code (code_hash, code) VALUES (?, ?)", key[len(codePrefix):], nonNil(value))
+		return err
+	default:
+		_, err := tx.Exec("INSERT OR REPLACE INTO kv (key, value) VALUES (?, ?)", key, nonNil(value))
+		return err
+	}
+}
+
+func del(tx *sql.Tx, key []byte) error {
+	switch {
+	case isSubstateKey(key):
+		block, txIdx, ok := substateKey(key)
+		if !ok {
+			return nil
+		}
+		return deleteSubstate(tx, block, txIdx)
+	case isCodeKey(key):
+		_, err := tx.Exec("DELETE FROM code WHERE code_hash = ?", key[len(codePrefix):])
+		return err
+	default:
+		_, err := tx.Exec("DELETE FROM kv WHERE key = ?", key)
+		return err
+	}
+}
+
+func deleteSubstate(tx *sql.Tx, block int64, txIdx int64) error {
+	for _, table := range []string{"substate", "alloc", "storage"} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE block = ? AND tx = ?", block, txIdx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putSubstate decodes a hashed substate and writes it to the substate table
+// and the normalized alloc and storage tables.
+func putSubstate(tx *sql.Tx, key []byte, value []byte) error {
+	block, txIdx, ok := substateKey(key)
+	if !ok {
+		return fmt.Errorf("block or tx of substate key %x exceeds SQLite3 INTEGER", key)
+	}
+
+	substate := &research.Substate{}
+	if err := proto.Unmarshal(value, substate); err != nil {
+		return fmt.Errorf("error decoding substate %v_%v: %w", block, txIdx, err)
+	}
+
+	if err := deleteSubstate(tx, block, txIdx); err != nil {
+		return err
+	}
+
+	msg := substate.TxMessage
+	_, err := tx.Exec("INSERT INTO substate (block, tx, tx_type, sender, recipient, status, gas_used, substate) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		block, txIdx, int64(msg.GetTxType()), nonNil(msg.GetFrom()), msg.GetTo().GetValue(),
+		int64(substate.Result.GetStatus()), int64(substate.Result.GetGasUsed()), value)
+	if err != nil {
+		return err
+	}
+
+	allocs := []struct {
+		io    string
+		alloc *research.Substate_Alloc
+	}{
+		{"input", substate.InputAlloc},
+		{"output", substate.OutputAlloc},
+	}
+	for _, a := range allocs {
+		for _, entry := range a.alloc.GetAlloc() {
+			account := entry.Account
+
+			var codeHash []byte
+			if h := account.GetCodeHash(); h != nil {
+				codeHash = h
+			} else if code := account.GetCode(); code != nil {
+				codeHash = research.CodeHash(code).Bytes()
+			}
+			_, err = tx.Exec("INSERT OR REPLACE INTO alloc (block, tx, io, address, nonce, balance, code_hash) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				block, txIdx, a.io, entry.Address, int64(account.GetNonce()), nonNil(account.GetBalance()), codeHash)
+			if err != nil {
+				return err
+			}
+
+			for _, storage := range account.Storage {
+				_, err = tx.Exec("INSERT OR REPLACE INTO storage (block, tx, io, address, key, value) VALUES (?, ?, ?, ?, ?, ?)",
+					block, txIdx, a.io, entry.Address, storage.Key, nonNil(storage.Value))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// nonNil prevents inserting NULL into BLOB NOT NULL columns
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// Stat is not supported by SQLite3 backend
+func (db *Database) Stat(property string) (string, error) {
+	return "", errors.New("sqlitedb: stat is not supported")
+}
+
+// Compact runs VACUUM only for the entire key range, i.e., nil start and nil
+// limit. It ignores any other key range and returns nil because SQLite3 has no
+// ranged compaction.
+func (db *Database) Compact(start []byte, limit []byte) error {
+	if start != nil || limit != nil {
+		return nil
+	}
+	_, err := db.db.Exec("VACUUM")
+	return err
+}
+
+func (db *Database) NewBatch() ethdb.Batch {
+	return &batch{db: db}
+}
+
+func (db *Database) NewBatchWithSize(size int) ethdb.Batch {
+	return &batch{db: db, writes: make([]keyvalue, 0, size)}
+}
+
+type keyvalue struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// batch buffers writes and applies them in a single SQL transaction
+type batch struct {
+	db     *Database
+	writes []keyvalue
+	size   int
+}
+
+func (b *batch) Put(key, value []byte) error {
+	b.writes = append(b.writes, keyvalue{common.CopyBytes(key), common.CopyBytes(value), false})
+	b.size += len(key) + len(value)
+	return nil
+}
+
+func (b *batch) Delete(key []byte) error {
+	b.writes = append(b.writes, keyvalue{common.CopyBytes(key), nil, true})
+	b.size += len(key)
+	return nil
+}
+
+func (b *batch) ValueSize() int {
+	return b.size
+}
+
+func (b *batch) Write() error {
+	return b.db.update(func(tx *sql.Tx) error {
+		for _, kv := range b.writes {
+			var err error
+			if kv.delete {
+				err = del(tx, kv.key)
+			} else {
+				err = put(tx, kv.key, kv.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *batch) Reset() {
+	b.writes = b.writes[:0]
+	b.size = 0
+}
+
+func (b *batch) Replay(w ethdb.KeyValueWriter) error {
+	for _, kv := range b.writes {
+		if kv.delete {
+			if err := w.Delete(kv.key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.Put(kv.key, kv.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
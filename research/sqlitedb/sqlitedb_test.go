@@ -0,0 +1,185 @@
+package sqlitedb
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/research/researchtest"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSubstateDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "substate.sqlite3")
+	backend, err := research.OpenBackendDatabase("sqlite3,"+path, false)
+	if err != nil {
+		t.Fatalf("error opening sqlite3 backend: %v", err)
+	}
+	db := research.NewSubstateDB(backend)
+
+	code := []byte{0x60, 0x00, 0x60, 0x00}
+	for block := uint64(1); block <= 3; block++ {
+		for tx := 0; tx < 2; tx++ {
+			db.PutSubstate(block, tx, researchtest.NewSubstate(block, code))
+		}
+	}
+	// overwrite must not duplicate normalized rows
+	db.PutSubstate(2, 1, researchtest.NewSubstate(2, code))
+
+	if got, want := db.GetSubstate(2, 1), researchtest.NewSubstate(2, code); !proto.Equal(got, want) {
+		t.Fatalf("substate mismatch\n got: %v\nwant: %v", got, want)
+	}
+	if n := len(db.GetBlockSubstates(2)); n != 2 {
+		t.Fatalf("GetBlockSubstates(2) returned %v substates, want 2", n)
+	}
+
+	var numAlloc, numStorage int
+	sqlDB := backend.(*Database).DB()
+	if err := sqlDB.QueryRow("SELECT COUNT(*) FROM alloc WHERE block = 2").Scan(&numAlloc); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlDB.QueryRow("SELECT COUNT(*) FROM storage WHERE block = 2 AND io = 'output'").Scan(&numStorage); err != nil {
+		t.Fatal(err)
+	}
+	if numAlloc != 8 || numStorage != 2 {
+		t.Fatalf("unexpected normalized rows: alloc %v, storage %v", numAlloc, numStorage)
+	}
+
+	db.DeleteSubstate(2, 1)
+	if db.HasSubstate(2, 1) {
+		t.Fatal("substate 2_1 is not deleted")
+	}
+	if err := sqlDB.QueryRow("SELECT COUNT(*) FROM alloc WHERE block = 2").Scan(&numAlloc); err != nil {
+		t.Fatal(err)
+	}
+	if numAlloc != 4 {
+		t.Fatalf("alloc rows of deleted substate remain: %v", numAlloc)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// replayer opens substate DB read-only
+	db, err = research.OpenSubstateDBBackend("sqlite3,"+path, true)
+	if err != nil {
+		t.Fatalf("error opening sqlite3 backend read-only: %v", err)
+	}
+	defer db.Close()
+	if !db.HasSubstate(3, 1) || db.HasSubstate(2, 1) {
+		t.Fatal("unexpected substates after reopening")
+	}
+}
+
+func TestIterator(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "substate.sqlite3"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	substate := researchtest.NewSubstate(1, nil)
+	value, _ := proto.Marshal(substate)
+	keys := [][]byte{
+		[]byte("0other"),
+		research.Stage1CodeKey(research.CodeHash([]byte{0x01})),
+		research.Stage1CodeKey(research.CodeHash([]byte{0x02})),
+		[]byte("1m-metadata"),
+		research.Stage1SubstateKey(1, 0),
+		research.Stage1SubstateKey(1, 1),
+		research.Stage1SubstateKey(2, 0),
+		research.Stage1SubstateKey(256, 0),
+		research.Stage1SubstateKey(math.MaxInt64, 0),
+		[]byte("2other"),
+	}
+	for _, key := range keys {
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(prefix, start []byte) [][]byte {
+		var got [][]byte
+		it := db.NewIterator(prefix, start)
+		defer it.Release()
+		for it.Next() {
+			got = append(got, append([]byte{}, it.Key()...))
+		}
+		if err := it.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	sorted := func(keys [][]byte) [][]byte {
+		for i := 1; i < len(keys); i++ {
+			if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+				t.Fatalf("keys are not sorted: %x >= %x", keys[i-1], keys[i])
+			}
+		}
+		return keys
+	}
+
+	tests := []struct {
+		prefix, start []byte
+		want          int
+	}{
+		{nil, nil, len(keys)},
+		{[]byte("1"), nil, 8},
+		{[]byte(research.Stage1CodePrefix), nil, 2},
+		{[]byte(research.Stage1SubstatePrefix), nil, 5},
+		{research.Stage1SubstateBlockPrefix(1), nil, 2},
+		{research.Stage1SubstateBlockPrefix(1), []byte{0, 0, 0, 0, 0, 0, 0, 1}, 1},
+		{[]byte(research.Stage1SubstatePrefix), research.Stage1SubstateBlockPrefix(2)[2:], 3},
+		{[]byte("3"), nil, 0},
+		// bounds of block and tx not less than 2^63
+		{append([]byte(research.Stage1SubstatePrefix), 0x7f), nil, 1},
+		{[]byte(research.Stage1SubstatePrefix), research.Stage1SubstateBlockPrefix(1 << 63)[2:], 0},
+		{[]byte(research.Stage1SubstatePrefix), research.Stage1SubstateKey(256, 0)[2:10], 2},
+		{research.Stage1SubstateBlockPrefix(256), []byte{0x80, 0, 0, 0, 0, 0, 0, 0}, 0},
+		{[]byte(research.Stage1SubstatePrefix), research.Stage1SubstateKey(math.MaxUint64, math.MaxInt)[2:], 0},
+	}
+	for i, tt := range tests {
+		if got := sorted(collect(tt.prefix, tt.start)); len(got) != tt.want {
+			t.Errorf("test %d: iterated %d keys, want %d: %q", i, len(got), tt.want, got)
+		}
+	}
+}
+
+func TestSubstateKeyRange(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "substate.sqlite3"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// SQLite3 INTEGER cannot store block 2^63 and above
+	value, _ := proto.Marshal(researchtest.NewSubstate(1, nil))
+	for _, key := range [][]byte{
+		research.Stage1SubstateKey(1<<63, 0),
+		research.Stage1SubstateKey(math.MaxUint64, 0),
+		research.Stage1SubstateKey(1, -1),
+	} {
+		if err := db.Put(key, value); err == nil {
+			t.Errorf("substate %x is put", key)
+		}
+		if has, err := db.Has(key); has || err != nil {
+			t.Errorf("Has(%x) = %v, %v", key, has, err)
+		}
+		if _, err := db.Get(key); err == nil {
+			t.Errorf("substate %x is found", key)
+		}
+		if err := db.Delete(key); err != nil {
+			t.Errorf("Delete(%x) = %v", key, err)
+		}
+	}
+
+	// Compact ignores key ranges other than the entire key range
+	if err := db.Compact(research.Stage1SubstateKey(0, 0), research.Stage1SubstateKey(math.MaxUint64, math.MaxInt)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -13,6 +13,11 @@ type putSubstateTask struct {
 	block    uint64
 	tx       int
 	substate *Substate
+
+	blockSubstate *BlockSubstate    // put the block substate instead
+	trace         *TxTrace          // put the call trace of the tx instead
+	deleteBlock   bool              // delete all substates of the block instead
+	metadata      *SubstateMetadata // put the metadata instead
 }
 
 var putSubstateChan chan *putSubstateTask
@@ -20,12 +25,18 @@ var putSubstateWg *sync.WaitGroup
 
 func OpenSubstateDB() {
 	fmt.Println("record-replay: OpenSubstateDB")
-	backend, err := rawdb.NewLevelDBDatabase(substateDir, 1024, 100, "substatedir", false)
+	backend, err := OpenBackendDatabase(substateDb, false)
 	if err != nil {
-		panic(fmt.Errorf("error opening substate leveldb %s: %v", substateDir, err))
+		panic(fmt.Errorf("error opening substate DB %s: %v", substateDb, err))
 	}
 	staticSubstateDB = NewSubstateDB(backend)
 
+	// substates put without a block index are not indexed until it is built
+	if !staticSubstateDB.HasBlockIndex() {
+		fmt.Println("record-replay: building block index of substate DB")
+		staticSubstateDB.BuildBlockIndex()
+	}
+
 	if asyncDbWrite {
 		putSubstateChan = make(chan *putSubstateTask, 1000)
 		putSubstateWg = &sync.WaitGroup{}
@@ -34,6 +45,22 @@ func OpenSubstateDB() {
 		go func() {
 			defer putSubstateWg.Done()
 			for task := range putSubstateChan {
+				if task.metadata != nil {
+					staticSubstateDB.PutMetadata(task.metadata)
+					continue
+				}
+				if task.deleteBlock {
+					staticSubstateDB.DeleteBlockSubstates(task.block)
+					continue
+				}
+				if task.blockSubstate != nil {
+					staticSubstateDB.PutBlockSubstate(task.block, task.blockSubstate)
+					continue
+				}
+				if task.trace != nil {
+					staticSubstateDB.PutTxTrace(task.block, task.tx, task.trace)
+					continue
+				}
 				staticSubstateDB.PutSubstate(task.block, task.tx, task.substate)
 			}
 		}()
@@ -43,9 +70,9 @@ func OpenSubstateDB() {
 
 func OpenSubstateDBReadOnly() {
 	fmt.Println("record-replay: OpenSubstateDB")
-	backend, err := rawdb.NewLevelDBDatabase(substateDir, 1024, 100, "substatedir", true)
+	backend, err := OpenBackendDatabase(substateDb, true)
 	if err != nil {
-		panic(fmt.Errorf("error opening substate leveldb %s: %v", substateDir, err))
+		panic(fmt.Errorf("error opening substate DB %s: %v", substateDb, err))
 	}
 	staticSubstateDB = NewSubstateDB(backend)
 }
@@ -60,7 +87,7 @@ func CloseSubstateDB() {
 
 	err := staticSubstateDB.Close()
 	if err != nil {
-		panic(fmt.Errorf("error closing substate leveldb %s: %v", substateDir, err))
+		panic(fmt.Errorf("error closing substate DB %s: %v", substateDb, err))
 	}
 }
 
@@ -70,13 +97,16 @@ func CompactSubstateDB() {
 	// compact entire DB
 	err := staticSubstateDB.Compact(nil, nil)
 	if err != nil {
-		panic(fmt.Errorf("error compacting substate leveldb %s: %v", substateDir, err))
+		panic(fmt.Errorf("error compacting substate DB %s: %v", substateDb, err))
 	}
 }
 
+// OpenFakeSubstateDB opens an in-memory static substate DB for tests, which
+// writes substates synchronously.
 func OpenFakeSubstateDB() {
 	backend := rawdb.NewMemoryDatabase()
 	staticSubstateDB = NewSubstateDB(backend)
+	asyncDbWrite = false
 }
 
 func CloseFakeSubstateDB() {
@@ -84,8 +114,8 @@ func CloseFakeSubstateDB() {
 }
 
 func SetSubstateFlags(ctx *cli.Context) {
-	substateDir = ctx.Path(SubstateDirFlag.Name)
-	fmt.Printf("record-replay: --substatedir=%s\n", substateDir)
+	substateDb = ctx.String(SubstateDbFlag.Name)
+	fmt.Printf("record-replay: --substate-db=%s\n", substateDb)
 	asyncDbWrite = ctx.Bool(AsyncDbWriteFlag.Name)
 	fmt.Printf("record-replay: --async-db-write=%v\n", asyncDbWrite)
 }
@@ -126,6 +156,64 @@ func PutSubstate(block uint64, tx int, substate *Substate) {
 	}
 }
 
+func GetBlockSubstate(block uint64) *BlockSubstate {
+	return staticSubstateDB.GetBlockSubstate(block)
+}
+
+func PutBlockSubstate(block uint64, blockSubstate *BlockSubstate) {
+	if asyncDbWrite {
+		putSubstateChan <- &putSubstateTask{
+			block:         block,
+			blockSubstate: blockSubstate,
+		}
+	} else {
+		staticSubstateDB.PutBlockSubstate(block, blockSubstate)
+	}
+}
+
+func HasTxTraces() bool {
+	return staticSubstateDB.HasTxTraces()
+}
+
+func GetTxTrace(block uint64, tx int) *TxTrace {
+	return staticSubstateDB.GetTxTrace(block, tx)
+}
+
+func PutTxTrace(block uint64, tx int, trace *TxTrace) {
+	if asyncDbWrite {
+		putSubstateChan <- &putSubstateTask{
+			block: block,
+			tx:    tx,
+			trace: trace,
+		}
+	} else {
+		staticSubstateDB.PutTxTrace(block, tx, trace)
+	}
+}
+
 func DeleteSubstate(block uint64, tx int) {
 	staticSubstateDB.DeleteSubstate(block, tx)
 }
+
+// putMetadata puts the metadata after substates waiting to be written
+func putMetadata(m *SubstateMetadata) {
+	if asyncDbWrite {
+		putSubstateChan <- &putSubstateTask{
+			metadata: m,
+		}
+	} else {
+		staticSubstateDB.PutMetadata(m)
+	}
+}
+
+func DeleteBlockSubstates(block uint64) {
+	if asyncDbWrite {
+		// keep the order with substates waiting to be written
+		putSubstateChan <- &putSubstateTask{
+			block:       block,
+			deleteBlock: true,
+		}
+	} else {
+		staticSubstateDB.DeleteBlockSubstates(block)
+	}
+}
@@ -165,6 +165,173 @@ func (x *Substate) GetResult() *Substate_Result {
 	return nil
 }
 
+// BlockSubstate has state changes of a block outside its transactions
+type BlockSubstate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// block_hashes are empty because no BLOCKHASH is executed
+	BlockEnv *Substate_BlockEnv `protobuf:"bytes,1,req,name=block_env,json=blockEnv" json:"block_env,omitempty"`
+	// State changes before the first transaction: the DAO hard fork and
+	// the beacon block root system call
+	PreTxInputAlloc  *Substate_Alloc `protobuf:"bytes,2,req,name=pre_tx_input_alloc,json=preTxInputAlloc" json:"pre_tx_input_alloc,omitempty"`
+	PreTxOutputAlloc *Substate_Alloc `protobuf:"bytes,3,req,name=pre_tx_output_alloc,json=preTxOutputAlloc" json:"pre_tx_output_alloc,omitempty"`
+	// DAO hard fork moved balances of DAO accounts to the refund contract
+	DaoHardFork *bool `protobuf:"varint,4,opt,name=dao_hard_fork,json=daoHardFork" json:"dao_hard_fork,omitempty"`
+	// Cancun hard fork introduced EIP-4788 beacon block root system call
+	BeaconRoot *wrapperspb.BytesValue `protobuf:"bytes,5,opt,name=beacon_root,json=beaconRoot" json:"beacon_root,omitempty"`
+	// State changes after the last transaction: block and uncle rewards,
+	// and withdrawals
+	PostTxInputAlloc  *Substate_Alloc             `protobuf:"bytes,6,req,name=post_tx_input_alloc,json=postTxInputAlloc" json:"post_tx_input_alloc,omitempty"`
+	PostTxOutputAlloc *Substate_Alloc             `protobuf:"bytes,7,req,name=post_tx_output_alloc,json=postTxOutputAlloc" json:"post_tx_output_alloc,omitempty"`
+	Uncles            []*BlockSubstate_Uncle      `protobuf:"bytes,8,rep,name=uncles" json:"uncles,omitempty"`
+	Withdrawals       []*BlockSubstate_Withdrawal `protobuf:"bytes,9,rep,name=withdrawals" json:"withdrawals,omitempty"`
+}
+
+func (x *BlockSubstate) Reset() {
+	*x = BlockSubstate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_substate_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlockSubstate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockSubstate) ProtoMessage() {}
+
+func (x *BlockSubstate) ProtoReflect() protoreflect.Message {
+	mi := &file_substate_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockSubstate.ProtoReflect.Descriptor instead.
+func (*BlockSubstate) Descriptor() ([]byte, []int) {
+	return file_substate_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *BlockSubstate) GetBlockEnv() *Substate_BlockEnv {
+	if x != nil {
+		return x.BlockEnv
+	}
+	return nil
+}
+
+func (x *BlockSubstate) GetPreTxInputAlloc() *Substate_Alloc {
+	if x != nil {
+		return x.PreTxInputAlloc
+	}
+	return nil
+}
+
+func (x *BlockSubstate) GetPreTxOutputAlloc() *Substate_Alloc {
+	if x != nil {
+		return x.PreTxOutputAlloc
+	}
+	return nil
+}
+
+func (x *BlockSubstate) GetDaoHardFork() bool {
+	if x != nil && x.DaoHardFork != nil {
+		return *x.DaoHardFork
+	}
+	return false
+}
+
+func (x *BlockSubstate) GetBeaconRoot() *wrapperspb.BytesValue {
+	if x != nil {
+		return x.BeaconRoot
+	}
+	return nil
+}
+
+func (x *BlockSubstate) GetPostTxInputAlloc() *Substate_Alloc {
+	if x != nil {
+		return x.PostTxInputAlloc
+	}
+	return nil
+}
+
+func (x *BlockSubstate) GetPostTxOutputAlloc() *Substate_Alloc {
+	if x != nil {
+		return x.PostTxOutputAlloc
+	}
+	return nil
+}
+
+func (x *BlockSubstate) GetUncles() []*BlockSubstate_Uncle {
+	if x != nil {
+		return x.Uncles
+	}
+	return nil
+}
+
+func (x *BlockSubstate) GetWithdrawals() []*BlockSubstate_Withdrawal {
+	if x != nil {
+		return x.Withdrawals
+	}
+	return nil
+}
+
+// TxTrace has the call frame tree of a transaction like the native callTracer
+type TxTrace struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Call *TxTrace_CallFrame `protobuf:"bytes,1,req,name=call" json:"call,omitempty"`
+}
+
+func (x *TxTrace) Reset() {
+	*x = TxTrace{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_substate_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TxTrace) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxTrace) ProtoMessage() {}
+
+func (x *TxTrace) ProtoReflect() protoreflect.Message {
+	mi := &file_substate_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxTrace.ProtoReflect.Descriptor instead.
+func (*TxTrace) Descriptor() ([]byte, []int) {
+	return file_substate_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TxTrace) GetCall() *TxTrace_CallFrame {
+	if x != nil {
+		return x.Call
+	}
+	return nil
+}
+
 type Substate_Account struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -183,7 +350,7 @@ type Substate_Account struct {
 func (x *Substate_Account) Reset() {
 	*x = Substate_Account{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[1]
+		mi := &file_substate_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -196,7 +363,7 @@ func (x *Substate_Account) String() string {
 func (*Substate_Account) ProtoMessage() {}
 
 func (x *Substate_Account) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[1]
+	mi := &file_substate_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -282,7 +449,7 @@ type Substate_AllocEntry struct {
 func (x *Substate_AllocEntry) Reset() {
 	*x = Substate_AllocEntry{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[2]
+		mi := &file_substate_proto_msgTypes[4]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -295,7 +462,7 @@ func (x *Substate_AllocEntry) String() string {
 func (*Substate_AllocEntry) ProtoMessage() {}
 
 func (x *Substate_AllocEntry) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[2]
+	mi := &file_substate_proto_msgTypes[4]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -336,7 +503,7 @@ type Substate_Alloc struct {
 func (x *Substate_Alloc) Reset() {
 	*x = Substate_Alloc{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[3]
+		mi := &file_substate_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -349,7 +516,7 @@ func (x *Substate_Alloc) String() string {
 func (*Substate_Alloc) ProtoMessage() {}
 
 func (x *Substate_Alloc) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[3]
+	mi := &file_substate_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -394,7 +561,7 @@ type Substate_BlockEnv struct {
 func (x *Substate_BlockEnv) Reset() {
 	*x = Substate_BlockEnv{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[4]
+		mi := &file_substate_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -407,7 +574,7 @@ func (x *Substate_BlockEnv) String() string {
 func (*Substate_BlockEnv) ProtoMessage() {}
 
 func (x *Substate_BlockEnv) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[4]
+	mi := &file_substate_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -518,7 +685,7 @@ type Substate_TxMessage struct {
 func (x *Substate_TxMessage) Reset() {
 	*x = Substate_TxMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[5]
+		mi := &file_substate_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -531,7 +698,7 @@ func (x *Substate_TxMessage) String() string {
 func (*Substate_TxMessage) ProtoMessage() {}
 
 func (x *Substate_TxMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[5]
+	mi := &file_substate_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -682,7 +849,7 @@ type Substate_Result struct {
 func (x *Substate_Result) Reset() {
 	*x = Substate_Result{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[6]
+		mi := &file_substate_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -695,7 +862,7 @@ func (x *Substate_Result) String() string {
 func (*Substate_Result) ProtoMessage() {}
 
 func (x *Substate_Result) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[6]
+	mi := &file_substate_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -751,7 +918,7 @@ type Substate_Account_StorageEntry struct {
 func (x *Substate_Account_StorageEntry) Reset() {
 	*x = Substate_Account_StorageEntry{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[7]
+		mi := &file_substate_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -764,7 +931,7 @@ func (x *Substate_Account_StorageEntry) String() string {
 func (*Substate_Account_StorageEntry) ProtoMessage() {}
 
 func (x *Substate_Account_StorageEntry) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[7]
+	mi := &file_substate_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -806,7 +973,7 @@ type Substate_BlockEnv_BlockHashEntry struct {
 func (x *Substate_BlockEnv_BlockHashEntry) Reset() {
 	*x = Substate_BlockEnv_BlockHashEntry{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[8]
+		mi := &file_substate_proto_msgTypes[10]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -819,7 +986,7 @@ func (x *Substate_BlockEnv_BlockHashEntry) String() string {
 func (*Substate_BlockEnv_BlockHashEntry) ProtoMessage() {}
 
 func (x *Substate_BlockEnv_BlockHashEntry) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[8]
+	mi := &file_substate_proto_msgTypes[10]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -863,7 +1030,7 @@ type Substate_TxMessage_AccessListEntry struct {
 func (x *Substate_TxMessage_AccessListEntry) Reset() {
 	*x = Substate_TxMessage_AccessListEntry{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[9]
+		mi := &file_substate_proto_msgTypes[11]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -876,7 +1043,7 @@ func (x *Substate_TxMessage_AccessListEntry) String() string {
 func (*Substate_TxMessage_AccessListEntry) ProtoMessage() {}
 
 func (x *Substate_TxMessage_AccessListEntry) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[9]
+	mi := &file_substate_proto_msgTypes[11]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -919,7 +1086,7 @@ type Substate_Result_Log struct {
 func (x *Substate_Result_Log) Reset() {
 	*x = Substate_Result_Log{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_substate_proto_msgTypes[10]
+		mi := &file_substate_proto_msgTypes[12]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -932,7 +1099,7 @@ func (x *Substate_Result_Log) String() string {
 func (*Substate_Result_Log) ProtoMessage() {}
 
 func (x *Substate_Result_Log) ProtoReflect() protoreflect.Message {
-	mi := &file_substate_proto_msgTypes[10]
+	mi := &file_substate_proto_msgTypes[12]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -969,6 +1136,266 @@ func (x *Substate_Result_Log) GetData() []byte {
 	return nil
 }
 
+type BlockSubstate_Uncle struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Coinbase []byte  `protobuf:"bytes,1,req,name=coinbase" json:"coinbase,omitempty"`
+	Number   *uint64 `protobuf:"varint,2,req,name=number" json:"number,omitempty"`
+}
+
+func (x *BlockSubstate_Uncle) Reset() {
+	*x = BlockSubstate_Uncle{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_substate_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlockSubstate_Uncle) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockSubstate_Uncle) ProtoMessage() {}
+
+func (x *BlockSubstate_Uncle) ProtoReflect() protoreflect.Message {
+	mi := &file_substate_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockSubstate_Uncle.ProtoReflect.Descriptor instead.
+func (*BlockSubstate_Uncle) Descriptor() ([]byte, []int) {
+	return file_substate_proto_rawDescGZIP(), []int{1, 0}
+}
+
+func (x *BlockSubstate_Uncle) GetCoinbase() []byte {
+	if x != nil {
+		return x.Coinbase
+	}
+	return nil
+}
+
+func (x *BlockSubstate_Uncle) GetNumber() uint64 {
+	if x != nil && x.Number != nil {
+		return *x.Number
+	}
+	return 0
+}
+
+// Shanghai hard fork introduced withdrawals of the consensus layer
+type BlockSubstate_Withdrawal struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Index     *uint64 `protobuf:"varint,1,req,name=index" json:"index,omitempty"`
+	Validator *uint64 `protobuf:"varint,2,req,name=validator" json:"validator,omitempty"`
+	Address   []byte  `protobuf:"bytes,3,req,name=address" json:"address,omitempty"`
+	// amount in Gwei
+	Amount *uint64 `protobuf:"varint,4,req,name=amount" json:"amount,omitempty"`
+}
+
+func (x *BlockSubstate_Withdrawal) Reset() {
+	*x = BlockSubstate_Withdrawal{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_substate_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlockSubstate_Withdrawal) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockSubstate_Withdrawal) ProtoMessage() {}
+
+func (x *BlockSubstate_Withdrawal) ProtoReflect() protoreflect.Message {
+	mi := &file_substate_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockSubstate_Withdrawal.ProtoReflect.Descriptor instead.
+func (*BlockSubstate_Withdrawal) Descriptor() ([]byte, []int) {
+	return file_substate_proto_rawDescGZIP(), []int{1, 1}
+}
+
+func (x *BlockSubstate_Withdrawal) GetIndex() uint64 {
+	if x != nil && x.Index != nil {
+		return *x.Index
+	}
+	return 0
+}
+
+func (x *BlockSubstate_Withdrawal) GetValidator() uint64 {
+	if x != nil && x.Validator != nil {
+		return *x.Validator
+	}
+	return 0
+}
+
+func (x *BlockSubstate_Withdrawal) GetAddress() []byte {
+	if x != nil {
+		return x.Address
+	}
+	return nil
+}
+
+func (x *BlockSubstate_Withdrawal) GetAmount() uint64 {
+	if x != nil && x.Amount != nil {
+		return *x.Amount
+	}
+	return 0
+}
+
+type TxTrace_CallFrame struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE, CREATE2 or SELFDESTRUCT
+	Type *string `protobuf:"bytes,1,req,name=type" json:"type,omitempty"`
+	From []byte  `protobuf:"bytes,2,req,name=from" json:"from,omitempty"`
+	// created contract address of CREATE and CREATE2, nil if creation failed
+	To *wrapperspb.BytesValue `protobuf:"bytes,3,opt,name=to" json:"to,omitempty"`
+	// nil for STATICCALL
+	Value   *wrapperspb.BytesValue `protobuf:"bytes,4,opt,name=value" json:"value,omitempty"`
+	Gas     *uint64                `protobuf:"varint,5,req,name=gas" json:"gas,omitempty"`
+	GasUsed *uint64                `protobuf:"varint,6,req,name=gas_used,json=gasUsed" json:"gas_used,omitempty"`
+	Input   []byte                 `protobuf:"bytes,7,req,name=input" json:"input,omitempty"`
+	// return data, or revert data of REVERT
+	Output []byte  `protobuf:"bytes,8,req,name=output" json:"output,omitempty"`
+	Error  *string `protobuf:"bytes,9,opt,name=error" json:"error,omitempty"`
+	// reason string of Error(string) in revert data
+	RevertReason *string              `protobuf:"bytes,10,opt,name=revert_reason,json=revertReason" json:"revert_reason,omitempty"`
+	Calls        []*TxTrace_CallFrame `protobuf:"bytes,11,rep,name=calls" json:"calls,omitempty"`
+}
+
+func (x *TxTrace_CallFrame) Reset() {
+	*x = TxTrace_CallFrame{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_substate_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TxTrace_CallFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxTrace_CallFrame) ProtoMessage() {}
+
+func (x *TxTrace_CallFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_substate_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxTrace_CallFrame.ProtoReflect.Descriptor instead.
+func (*TxTrace_CallFrame) Descriptor() ([]byte, []int) {
+	return file_substate_proto_rawDescGZIP(), []int{2, 0}
+}
+
+func (x *TxTrace_CallFrame) GetType() string {
+	if x != nil && x.Type != nil {
+		return *x.Type
+	}
+	return ""
+}
+
+func (x *TxTrace_CallFrame) GetFrom() []byte {
+	if x != nil {
+		return x.From
+	}
+	return nil
+}
+
+func (x *TxTrace_CallFrame) GetTo() *wrapperspb.BytesValue {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+func (x *TxTrace_CallFrame) GetValue() *wrapperspb.BytesValue {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *TxTrace_CallFrame) GetGas() uint64 {
+	if x != nil && x.Gas != nil {
+		return *x.Gas
+	}
+	return 0
+}
+
+func (x *TxTrace_CallFrame) GetGasUsed() uint64 {
+	if x != nil && x.GasUsed != nil {
+		return *x.GasUsed
+	}
+	return 0
+}
+
+func (x *TxTrace_CallFrame) GetInput() []byte {
+	if x != nil {
+		return x.Input
+	}
+	return nil
+}
+
+func (x *TxTrace_CallFrame) GetOutput() []byte {
+	if x != nil {
+		return x.Output
+	}
+	return nil
+}
+
+func (x *TxTrace_CallFrame) GetError() string {
+	if x != nil && x.Error != nil {
+		return *x.Error
+	}
+	return ""
+}
+
+func (x *TxTrace_CallFrame) GetRevertReason() string {
+	if x != nil && x.RevertReason != nil {
+		return *x.RevertReason
+	}
+	return ""
+}
+
+func (x *TxTrace_CallFrame) GetCalls() []*TxTrace_CallFrame {
+	if x != nil {
+		return x.Calls
+	}
+	return nil
+}
+
 var File_substate_proto protoreflect.FileDescriptor
 
 var file_substate_proto_rawDesc = []byte{
@@ -1110,8 +1537,82 @@ var file_substate_proto_rawDesc = []byte{
 	0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x02, 0x28, 0x0c, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72,
 	0x65, 0x73, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x73, 0x18, 0x02, 0x20,
 	0x03, 0x28, 0x0c, 0x52, 0x06, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x64,
-	0x61, 0x74, 0x61, 0x18, 0x03, 0x20, 0x02, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x42,
-	0x0d, 0x5a, 0x0b, 0x2e, 0x2e, 0x2f, 0x72, 0x65, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68,
+	0x61, 0x74, 0x61, 0x18, 0x03, 0x20, 0x02, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22,
+	0xfd, 0x05, 0x0a, 0x0d, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x75, 0x62, 0x73, 0x74, 0x61, 0x74,
+	0x65, 0x12, 0x38, 0x0a, 0x09, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x65, 0x6e, 0x76, 0x18, 0x01,
+	0x20, 0x02, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x72, 0x65, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x2e,
+	0x53, 0x75, 0x62, 0x73, 0x74, 0x61, 0x74, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x45, 0x6e,
+	0x76, 0x52, 0x08, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x45, 0x6e, 0x76, 0x12, 0x45, 0x0a, 0x12, 0x70,
+	0x72, 0x65, 0x5f, 0x74, 0x78, 0x5f, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x5f, 0x61, 0x6c, 0x6c, 0x6f,
+	0x63, 0x18, 0x02, 0x20, 0x02, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x72, 0x65, 0x73, 0x65, 0x61, 0x72,
+	0x63, 0x68, 0x2e, 0x53, 0x75, 0x62, 0x73, 0x74, 0x61, 0x74, 0x65, 0x2e, 0x41, 0x6c, 0x6c, 0x6f,
+	0x63, 0x52, 0x0f, 0x70, 0x72, 0x65, 0x54, 0x78, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x41, 0x6c, 0x6c,
+	0x6f, 0x63, 0x12, 0x47, 0x0a, 0x13, 0x70, 0x72, 0x65, 0x5f, 0x74, 0x78, 0x5f, 0x6f, 0x75, 0x74,
+	0x70, 0x75, 0x74, 0x5f, 0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x18, 0x03, 0x20, 0x02, 0x28, 0x0b, 0x32,
+	0x18, 0x2e, 0x72, 0x65, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x2e, 0x53, 0x75, 0x62, 0x73, 0x74,
+	0x61, 0x74, 0x65, 0x2e, 0x41, 0x6c, 0x6c, 0x6f, 0x63, 0x52, 0x10, 0x70, 0x72, 0x65, 0x54, 0x78,
+	0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x41, 0x6c, 0x6c, 0x6f, 0x63, 0x12, 0x22, 0x0a, 0x0d, 0x64,
+	0x61, 0x6f, 0x5f, 0x68, 0x61, 0x72, 0x64, 0x5f, 0x66, 0x6f, 0x72, 0x6b, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x0b, 0x64, 0x61, 0x6f, 0x48, 0x61, 0x72, 0x64, 0x46, 0x6f, 0x72, 0x6b, 0x12,
+	0x3c, 0x0a, 0x0b, 0x62, 0x65, 0x61, 0x63, 0x6f, 0x6e, 0x5f, 0x72, 0x6f, 0x6f, 0x74, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x42, 0x79, 0x74, 0x65, 0x73, 0x56, 0x61, 0x6c, 0x75,
+	0x65, 0x52, 0x0a, 0x62, 0x65, 0x61, 0x63, 0x6f, 0x6e, 0x52, 0x6f, 0x6f, 0x74, 0x12, 0x47, 0x0a,
+	0x13, 0x70, 0x6f, 0x73, 0x74, 0x5f, 0x74, 0x78, 0x5f, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x5f, 0x61,
+	0x6c, 0x6c, 0x6f, 0x63, 0x18, 0x06, 0x20, 0x02, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x72, 0x65, 0x73,
+	0x65, 0x61, 0x72, 0x63, 0x68, 0x2e, 0x53, 0x75, 0x62, 0x73, 0x74, 0x61, 0x74, 0x65, 0x2e, 0x41,
+	0x6c, 0x6c, 0x6f, 0x63, 0x52, 0x10, 0x70, 0x6f, 0x73, 0x74, 0x54, 0x78, 0x49, 0x6e, 0x70, 0x75,
+	0x74, 0x41, 0x6c, 0x6c, 0x6f, 0x63, 0x12, 0x49, 0x0a, 0x14, 0x70, 0x6f, 0x73, 0x74, 0x5f, 0x74,
+	0x78, 0x5f, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x5f, 0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x18, 0x07,
+	0x20, 0x02, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x72, 0x65, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x2e,
+	0x53, 0x75, 0x62, 0x73, 0x74, 0x61, 0x74, 0x65, 0x2e, 0x41, 0x6c, 0x6c, 0x6f, 0x63, 0x52, 0x11,
+	0x70, 0x6f, 0x73, 0x74, 0x54, 0x78, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x41, 0x6c, 0x6c, 0x6f,
+	0x63, 0x12, 0x35, 0x0a, 0x06, 0x75, 0x6e, 0x63, 0x6c, 0x65, 0x73, 0x18, 0x08, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x1d, 0x2e, 0x72, 0x65, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x2e, 0x42, 0x6c, 0x6f,
+	0x63, 0x6b, 0x53, 0x75, 0x62, 0x73, 0x74, 0x61, 0x74, 0x65, 0x2e, 0x55, 0x6e, 0x63, 0x6c, 0x65,
+	0x52, 0x06, 0x75, 0x6e, 0x63, 0x6c, 0x65, 0x73, 0x12, 0x44, 0x0a, 0x0b, 0x77, 0x69, 0x74, 0x68,
+	0x64, 0x72, 0x61, 0x77, 0x61, 0x6c, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x22, 0x2e,
+	0x72, 0x65, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x75,
+	0x62, 0x73, 0x74, 0x61, 0x74, 0x65, 0x2e, 0x57, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x61,
+	0x6c, 0x52, 0x0b, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x61, 0x6c, 0x73, 0x1a, 0x3b,
+	0x0a, 0x05, 0x55, 0x6e, 0x63, 0x6c, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x6f, 0x69, 0x6e, 0x62,
+	0x61, 0x73, 0x65, 0x18, 0x01, 0x20, 0x02, 0x28, 0x0c, 0x52, 0x08, 0x63, 0x6f, 0x69, 0x6e, 0x62,
+	0x61, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x02, 0x20,
+	0x02, 0x28, 0x04, 0x52, 0x06, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x1a, 0x72, 0x0a, 0x0a, 0x57,
+	0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x61, 0x6c, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6e, 0x64,
+	0x65, 0x78, 0x18, 0x01, 0x20, 0x02, 0x28, 0x04, 0x52, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x12,
+	0x1c, 0x0a, 0x09, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x6f, 0x72, 0x18, 0x02, 0x20, 0x02,
+	0x28, 0x04, 0x52, 0x09, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x6f, 0x72, 0x12, 0x18, 0x0a,
+	0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x03, 0x20, 0x02, 0x28, 0x0c, 0x52, 0x07,
+	0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x61, 0x6d, 0x6f, 0x75, 0x6e,
+	0x74, 0x18, 0x04, 0x20, 0x02, 0x28, 0x04, 0x52, 0x06, 0x61, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x22,
+	0x99, 0x03, 0x0a, 0x07, 0x54, 0x78, 0x54, 0x72, 0x61, 0x63, 0x65, 0x12, 0x2f, 0x0a, 0x04, 0x63,
+	0x61, 0x6c, 0x6c, 0x18, 0x01, 0x20, 0x02, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x72, 0x65, 0x73, 0x65,
+	0x61, 0x72, 0x63, 0x68, 0x2e, 0x54, 0x78, 0x54, 0x72, 0x61, 0x63, 0x65, 0x2e, 0x43, 0x61, 0x6c,
+	0x6c, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x52, 0x04, 0x63, 0x61, 0x6c, 0x6c, 0x1a, 0xdc, 0x02, 0x0a,
+	0x09, 0x43, 0x61, 0x6c, 0x6c, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x18, 0x01, 0x20, 0x02, 0x28, 0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x12,
+	0x0a, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x02, 0x20, 0x02, 0x28, 0x0c, 0x52, 0x04, 0x66, 0x72,
+	0x6f, 0x6d, 0x12, 0x2b, 0x0a, 0x02, 0x74, 0x6f, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x42, 0x79, 0x74, 0x65, 0x73, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x02, 0x74, 0x6f, 0x12,
+	0x31, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x42, 0x79, 0x74, 0x65, 0x73, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x67, 0x61, 0x73, 0x18, 0x05, 0x20, 0x02, 0x28, 0x04, 0x52,
+	0x03, 0x67, 0x61, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x67, 0x61, 0x73, 0x5f, 0x75, 0x73, 0x65, 0x64,
+	0x18, 0x06, 0x20, 0x02, 0x28, 0x04, 0x52, 0x07, 0x67, 0x61, 0x73, 0x55, 0x73, 0x65, 0x64, 0x12,
+	0x14, 0x0a, 0x05, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x18, 0x07, 0x20, 0x02, 0x28, 0x0c, 0x52, 0x05,
+	0x69, 0x6e, 0x70, 0x75, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x18,
+	0x08, 0x20, 0x02, 0x28, 0x0c, 0x52, 0x06, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x12, 0x14, 0x0a,
+	0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72,
+	0x72, 0x6f, 0x72, 0x12, 0x23, 0x0a, 0x0d, 0x72, 0x65, 0x76, 0x65, 0x72, 0x74, 0x5f, 0x72, 0x65,
+	0x61, 0x73, 0x6f, 0x6e, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x72, 0x65, 0x76, 0x65,
+	0x72, 0x74, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x31, 0x0a, 0x05, 0x63, 0x61, 0x6c, 0x6c,
+	0x73, 0x18, 0x0b, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x72, 0x65, 0x73, 0x65, 0x61, 0x72,
+	0x63, 0x68, 0x2e, 0x54, 0x78, 0x54, 0x72, 0x61, 0x63, 0x65, 0x2e, 0x43, 0x61, 0x6c, 0x6c, 0x46,
+	0x72, 0x61, 0x6d, 0x65, 0x52, 0x05, 0x63, 0x61, 0x6c, 0x6c, 0x73, 0x42, 0x0d, 0x5a, 0x0b, 0x2e,
+	0x2e, 0x2f, 0x72, 0x65, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68,
 }
 
 var (
@@ -1127,47 +1628,64 @@ func file_substate_proto_rawDescGZIP() []byte {
 }
 
 var file_substate_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_substate_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_substate_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
 var file_substate_proto_goTypes = []interface{}{
 	(Substate_TxMessage_TxType)(0),             // 0: research.Substate.TxMessage.TxType
 	(*Substate)(nil),                           // 1: research.Substate
-	(*Substate_Account)(nil),                   // 2: research.Substate.Account
-	(*Substate_AllocEntry)(nil),                // 3: research.Substate.AllocEntry
-	(*Substate_Alloc)(nil),                     // 4: research.Substate.Alloc
-	(*Substate_BlockEnv)(nil),                  // 5: research.Substate.BlockEnv
-	(*Substate_TxMessage)(nil),                 // 6: research.Substate.TxMessage
-	(*Substate_Result)(nil),                    // 7: research.Substate.Result
-	(*Substate_Account_StorageEntry)(nil),      // 8: research.Substate.Account.StorageEntry
-	(*Substate_BlockEnv_BlockHashEntry)(nil),   // 9: research.Substate.BlockEnv.BlockHashEntry
-	(*Substate_TxMessage_AccessListEntry)(nil), // 10: research.Substate.TxMessage.AccessListEntry
-	(*Substate_Result_Log)(nil),                // 11: research.Substate.Result.Log
-	(*wrapperspb.BytesValue)(nil),              // 12: google.protobuf.BytesValue
+	(*BlockSubstate)(nil),                      // 2: research.BlockSubstate
+	(*TxTrace)(nil),                            // 3: research.TxTrace
+	(*Substate_Account)(nil),                   // 4: research.Substate.Account
+	(*Substate_AllocEntry)(nil),                // 5: research.Substate.AllocEntry
+	(*Substate_Alloc)(nil),                     // 6: research.Substate.Alloc
+	(*Substate_BlockEnv)(nil),                  // 7: research.Substate.BlockEnv
+	(*Substate_TxMessage)(nil),                 // 8: research.Substate.TxMessage
+	(*Substate_Result)(nil),                    // 9: research.Substate.Result
+	(*Substate_Account_StorageEntry)(nil),      // 10: research.Substate.Account.StorageEntry
+	(*Substate_BlockEnv_BlockHashEntry)(nil),   // 11: research.Substate.BlockEnv.BlockHashEntry
+	(*Substate_TxMessage_AccessListEntry)(nil), // 12: research.Substate.TxMessage.AccessListEntry
+	(*Substate_Result_Log)(nil),                // 13: research.Substate.Result.Log
+	(*BlockSubstate_Uncle)(nil),                // 14: research.BlockSubstate.Uncle
+	(*BlockSubstate_Withdrawal)(nil),           // 15: research.BlockSubstate.Withdrawal
+	(*TxTrace_CallFrame)(nil),                  // 16: research.TxTrace.CallFrame
+	(*wrapperspb.BytesValue)(nil),              // 17: google.protobuf.BytesValue
 }
 var file_substate_proto_depIdxs = []int32{
-	4,  // 0: research.Substate.input_alloc:type_name -> research.Substate.Alloc
-	4,  // 1: research.Substate.output_alloc:type_name -> research.Substate.Alloc
-	5,  // 2: research.Substate.block_env:type_name -> research.Substate.BlockEnv
-	6,  // 3: research.Substate.tx_message:type_name -> research.Substate.TxMessage
-	7,  // 4: research.Substate.result:type_name -> research.Substate.Result
-	8,  // 5: research.Substate.Account.storage:type_name -> research.Substate.Account.StorageEntry
-	2,  // 6: research.Substate.AllocEntry.account:type_name -> research.Substate.Account
-	3,  // 7: research.Substate.Alloc.alloc:type_name -> research.Substate.AllocEntry
-	9,  // 8: research.Substate.BlockEnv.block_hashes:type_name -> research.Substate.BlockEnv.BlockHashEntry
-	12, // 9: research.Substate.BlockEnv.base_fee:type_name -> google.protobuf.BytesValue
-	12, // 10: research.Substate.BlockEnv.random:type_name -> google.protobuf.BytesValue
-	12, // 11: research.Substate.BlockEnv.blob_base_fee:type_name -> google.protobuf.BytesValue
-	12, // 12: research.Substate.TxMessage.to:type_name -> google.protobuf.BytesValue
-	0,  // 13: research.Substate.TxMessage.tx_type:type_name -> research.Substate.TxMessage.TxType
-	10, // 14: research.Substate.TxMessage.access_list:type_name -> research.Substate.TxMessage.AccessListEntry
-	12, // 15: research.Substate.TxMessage.gas_fee_cap:type_name -> google.protobuf.BytesValue
-	12, // 16: research.Substate.TxMessage.gas_tip_cap:type_name -> google.protobuf.BytesValue
-	12, // 17: research.Substate.TxMessage.blob_gas_fee_cap:type_name -> google.protobuf.BytesValue
-	11, // 18: research.Substate.Result.logs:type_name -> research.Substate.Result.Log
-	19, // [19:19] is the sub-list for method output_type
-	19, // [19:19] is the sub-list for method input_type
-	19, // [19:19] is the sub-list for extension type_name
-	19, // [19:19] is the sub-list for extension extendee
-	0,  // [0:19] is the sub-list for field type_name
+	6,  // 0: research.Substate.input_alloc:type_name -> research.Substate.Alloc
+	6,  // 1: research.Substate.output_alloc:type_name -> research.Substate.Alloc
+	7,  // 2: research.Substate.block_env:type_name -> research.Substate.BlockEnv
+	8,  // 3: research.Substate.tx_message:type_name -> research.Substate.TxMessage
+	9,  // 4: research.Substate.result:type_name -> research.Substate.Result
+	7,  // 5: research.BlockSubstate.block_env:type_name -> research.Substate.BlockEnv
+	6,  // 6: research.BlockSubstate.pre_tx_input_alloc:type_name -> research.Substate.Alloc
+	6,  // 7: research.BlockSubstate.pre_tx_output_alloc:type_name -> research.Substate.Alloc
+	17, // 8: research.BlockSubstate.beacon_root:type_name -> google.protobuf.BytesValue
+	6,  // 9: research.BlockSubstate.post_tx_input_alloc:type_name -> research.Substate.Alloc
+	6,  // 10: research.BlockSubstate.post_tx_output_alloc:type_name -> research.Substate.Alloc
+	14, // 11: research.BlockSubstate.uncles:type_name -> research.BlockSubstate.Uncle
+	15, // 12: research.BlockSubstate.withdrawals:type_name -> research.BlockSubstate.Withdrawal
+	16, // 13: research.TxTrace.call:type_name -> research.TxTrace.CallFrame
+	10, // 14: research.Substate.Account.storage:type_name -> research.Substate.Account.StorageEntry
+	4,  // 15: research.Substate.AllocEntry.account:type_name -> research.Substate.Account
+	5,  // 16: research.Substate.Alloc.alloc:type_name -> research.Substate.AllocEntry
+	11, // 17: research.Substate.BlockEnv.block_hashes:type_name -> research.Substate.BlockEnv.BlockHashEntry
+	17, // 18: research.Substate.BlockEnv.base_fee:type_name -> google.protobuf.BytesValue
+	17, // 19: research.Substate.BlockEnv.random:type_name -> google.protobuf.BytesValue
+	17, // 20: research.Substate.BlockEnv.blob_base_fee:type_name -> google.protobuf.BytesValue
+	17, // 21: research.Substate.TxMessage.to:type_name -> google.protobuf.BytesValue
+	0,  // 22: research.Substate.TxMessage.tx_type:type_name -> research.Substate.TxMessage.TxType
+	12, // 23: research.Substate.TxMessage.access_list:type_name -> research.Substate.TxMessage.AccessListEntry
+	17, // 24: research.Substate.TxMessage.gas_fee_cap:type_name -> google.protobuf.BytesValue
+	17, // 25: research.Substate.TxMessage.gas_tip_cap:type_name -> google.protobuf.BytesValue
+	17, // 26: research.Substate.TxMessage.blob_gas_fee_cap:type_name -> google.protobuf.BytesValue
+	13, // 27: research.Substate.Result.logs:type_name -> research.Substate.Result.Log
+	17, // 28: research.TxTrace.CallFrame.to:type_name -> google.protobuf.BytesValue
+	17, // 29: research.TxTrace.CallFrame.value:type_name -> google.protobuf.BytesValue
+	16, // 30: research.TxTrace.CallFrame.calls:type_name -> research.TxTrace.CallFrame
+	31, // [31:31] is the sub-list for method output_type
+	31, // [31:31] is the sub-list for method input_type
+	31, // [31:31] is the sub-list for extension type_name
+	31, // [31:31] is the sub-list for extension extendee
+	0,  // [0:31] is the sub-list for field type_name
 }
 
 func init() { file_substate_proto_init() }
@@ -1189,7 +1707,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_Account); i {
+			switch v := v.(*BlockSubstate); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1201,7 +1719,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_AllocEntry); i {
+			switch v := v.(*TxTrace); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1213,7 +1731,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_Alloc); i {
+			switch v := v.(*Substate_Account); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1225,7 +1743,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_BlockEnv); i {
+			switch v := v.(*Substate_AllocEntry); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1237,7 +1755,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_TxMessage); i {
+			switch v := v.(*Substate_Alloc); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1249,7 +1767,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_Result); i {
+			switch v := v.(*Substate_BlockEnv); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1261,7 +1779,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_Account_StorageEntry); i {
+			switch v := v.(*Substate_TxMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1273,7 +1791,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_BlockEnv_BlockHashEntry); i {
+			switch v := v.(*Substate_Result); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1285,7 +1803,7 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Substate_TxMessage_AccessListEntry); i {
+			switch v := v.(*Substate_Account_StorageEntry); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1297,6 +1815,30 @@ func file_substate_proto_init() {
 			}
 		}
 		file_substate_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Substate_BlockEnv_BlockHashEntry); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_substate_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Substate_TxMessage_AccessListEntry); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_substate_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*Substate_Result_Log); i {
 			case 0:
 				return &v.state
@@ -1308,12 +1850,48 @@ func file_substate_proto_init() {
 				return nil
 			}
 		}
+		file_substate_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BlockSubstate_Uncle); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_substate_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BlockSubstate_Withdrawal); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_substate_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TxTrace_CallFrame); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
-	file_substate_proto_msgTypes[1].OneofWrappers = []interface{}{
+	file_substate_proto_msgTypes[3].OneofWrappers = []interface{}{
 		(*Substate_Account_Code)(nil),
 		(*Substate_Account_CodeHash)(nil),
 	}
-	file_substate_proto_msgTypes[5].OneofWrappers = []interface{}{
+	file_substate_proto_msgTypes[7].OneofWrappers = []interface{}{
 		(*Substate_TxMessage_Data)(nil),
 		(*Substate_TxMessage_InitCodeHash)(nil),
 	}
@@ -1323,7 +1901,7 @@ func file_substate_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_substate_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   11,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,106 @@
+package research
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// DefaultBackend is used when --substate-db is a plain path without "backend,"
+const DefaultBackend = "leveldb"
+
+// BackendOpenFunc opens a backend database of substate DB at the given URI
+type BackendOpenFunc func(uri string, readOnly bool) (BackendDatabase, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendOpenFunc)
+)
+
+func init() {
+	openLevelDB := func(uri string, readOnly bool) (BackendDatabase, error) {
+		return rawdb.NewLevelDBDatabase(uri, 1024, 100, "substatedb", readOnly)
+	}
+	RegisterBackend("leveldb", openLevelDB)
+	RegisterBackend("goleveldb", openLevelDB)
+
+	RegisterBackend("pebble", func(uri string, readOnly bool) (BackendDatabase, error) {
+		return rawdb.NewPebbleDBDatabase(uri, 1024, 100, "substatedb", readOnly, false)
+	})
+
+	// memory backend ignores URI and always starts with an empty substate DB
+	RegisterBackend("memory", func(uri string, readOnly bool) (BackendDatabase, error) {
+		return rawdb.NewMemoryDatabase(), nil
+	})
+}
+
+// RegisterBackend makes a substate DB backend available by the provided name.
+// It panics if the name is already registered or open is nil.
+func RegisterBackend(name string, open BackendOpenFunc) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if open == nil {
+		panic(fmt.Errorf("record-replay: RegisterBackend %s: open function is nil", name))
+	}
+	if _, dup := backends[name]; dup {
+		panic(fmt.Errorf("record-replay: RegisterBackend called twice for backend %s", name))
+	}
+	backends[name] = open
+}
+
+// Backends returns a sorted list of names of registered backends
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSubstateDbArg splits "backend,URI" value of --substate-db.
+// A value without a registered backend name before the first comma is a path
+// of DefaultBackend for backward compatibility, even if the path has commas.
+func ParseSubstateDbArg(arg string) (backend string, uri string) {
+	if backend, uri, found := strings.Cut(arg, ","); found {
+		backend = strings.TrimSpace(backend)
+
+		backendsMu.RLock()
+		_, ok := backends[backend]
+		backendsMu.RUnlock()
+		if ok {
+			return backend, uri
+		}
+	}
+	return DefaultBackend, arg
+}
+
+// OpenBackendDatabase opens a backend database from "backend,URI" string
+func OpenBackendDatabase(arg string, readOnly bool) (BackendDatabase, error) {
+	name, uri := ParseSubstateDbArg(arg)
+
+	backendsMu.RLock()
+	open, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown substate DB backend %q (available: %s)", name, strings.Join(Backends(), ", "))
+	}
+
+	return open(uri, readOnly)
+}
+
+// OpenSubstateDBBackend opens a substate DB from "backend,URI" string
+func OpenSubstateDBBackend(arg string, readOnly bool) (*SubstateDB, error) {
+	backend, err := OpenBackendDatabase(arg, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return NewSubstateDB(backend), nil
+}
@@ -0,0 +1,202 @@
+package research
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"google.golang.org/protobuf/proto"
+)
+
+func Stage1BlockKey(block uint64) []byte {
+	prefix := []byte(Stage1BlockPrefix)
+
+	blockBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(blockBytes[0:8], block)
+
+	return append(prefix, blockBytes...)
+}
+
+func DecodeStage1BlockKey(key []byte) (block uint64, err error) {
+	prefix := Stage1BlockPrefix
+	if len(key) != len(prefix)+8 {
+		err = fmt.Errorf("invalid length of stage1 block key: %v", len(key))
+		return
+	}
+	if p := string(key[:len(prefix)]); p != prefix {
+		err = fmt.Errorf("invalid prefix of stage1 block key: %#x", p)
+		return
+	}
+	block = binary.BigEndian.Uint64(key[len(prefix):])
+	return
+}
+
+// (*BlockSubstate).allocs returns all allocs of the block substate
+func (x *BlockSubstate) allocs() []*Substate_Alloc {
+	return []*Substate_Alloc{
+		x.PreTxInputAlloc,
+		x.PreTxOutputAlloc,
+		x.PostTxInputAlloc,
+		x.PostTxOutputAlloc,
+	}
+}
+
+// (*BlockSubstate).HashMap returns codeHash -> code from unhashed block substate
+func (x *BlockSubstate) HashMap() map[common.Hash][]byte {
+	if x == nil {
+		return nil
+	}
+
+	z := make(map[common.Hash][]byte)
+	for _, alloc := range x.allocs() {
+		for _, entry := range alloc.GetAlloc() {
+			if code := entry.Account.GetCode(); code != nil {
+				z[CodeHash(code)] = code
+			}
+		}
+	}
+	return z
+}
+
+// (*BlockSubstate).HashKeys returns a set of code hashes from hashed block substate
+func (x *BlockSubstate) HashKeys() map[common.Hash]struct{} {
+	if x == nil {
+		return nil
+	}
+
+	z := make(map[common.Hash]struct{})
+	for _, alloc := range x.allocs() {
+		for _, entry := range alloc.GetAlloc() {
+			if codeHash := BytesToHash(entry.Account.GetCodeHash()); codeHash != nil {
+				z[*codeHash] = struct{}{}
+			}
+		}
+	}
+	return z
+}
+
+// (*BlockSubstate).ProtoClone returns a deep copy from proto.Clone
+func (x *BlockSubstate) ProtoClone() *BlockSubstate {
+	return proto.Clone(x).(*BlockSubstate)
+}
+
+// (*BlockSubstate).HashedCopy returns a copy of block substate with code hashes in accounts
+func (x *BlockSubstate) HashedCopy() *BlockSubstate {
+	y := proto.Clone(x).(*BlockSubstate)
+
+	if y == nil {
+		return nil
+	}
+
+	for _, alloc := range y.allocs() {
+		for _, entry := range alloc.GetAlloc() {
+			account := entry.Account
+			if code := account.GetCode(); code != nil {
+				codeHash := CodeHash(code)
+				account.Contract = &Substate_Account_CodeHash{
+					CodeHash: HashToBytes(&codeHash),
+				}
+			}
+		}
+	}
+
+	return y
+}
+
+// (*BlockSubstate).UnhashedCopy returns a copy of block substate with code.
+// z is codeHash -> code mappings e.g., return value of from x.HashMap() before hashed
+func (x *BlockSubstate) UnhashedCopy(z map[common.Hash][]byte) *BlockSubstate {
+	y := proto.Clone(x).(*BlockSubstate)
+
+	if y == nil {
+		return nil
+	}
+
+	for _, alloc := range y.allocs() {
+		for _, entry := range alloc.GetAlloc() {
+			account := entry.Account
+			if codeHash := BytesToHash(account.GetCodeHash()); codeHash != nil {
+				account.Contract = &Substate_Account_Code{
+					Code: z[*codeHash],
+				}
+			}
+		}
+	}
+
+	return y
+}
+
+// DiffBlockSubstate returns precise differences between block substates a and b
+// like DiffSubstate, e.g., recorded and replayed block substates.
+func DiffBlockSubstate(a, b *BlockSubstate) SubstateDiff {
+	var d SubstateDiff
+	d = append(d, diffMessage("blockEnv", "", a.GetBlockEnv().ProtoReflect(), b.GetBlockEnv().ProtoReflect())...)
+	d = append(d, DiffAlloc("preTxInputAlloc", a.GetPreTxInputAlloc(), b.GetPreTxInputAlloc())...)
+	d = append(d, DiffAlloc("preTxOutputAlloc", a.GetPreTxOutputAlloc(), b.GetPreTxOutputAlloc())...)
+	d = append(d, DiffAlloc("postTxInputAlloc", a.GetPostTxInputAlloc(), b.GetPostTxInputAlloc())...)
+	d = append(d, DiffAlloc("postTxOutputAlloc", a.GetPostTxOutputAlloc(), b.GetPostTxOutputAlloc())...)
+	if a.GetDaoHardFork() != b.GetDaoHardFork() {
+		d = append(d, &SubstateDiffEntry{Part: "daoHardFork", Kind: DiffChanged,
+			A: fmt.Sprint(a.GetDaoHardFork()), B: fmt.Sprint(b.GetDaoHardFork())})
+	}
+	return d
+}
+
+func (db *SubstateDB) HasBlockSubstate(block uint64) bool {
+	has, _ := db.backend.Has(Stage1BlockKey(block))
+	return has
+}
+
+// GetBlockSubstate returns the block substate of the block, or nil if the block
+// was recorded without block substates
+func (db *SubstateDB) GetBlockSubstate(block uint64) *BlockSubstate {
+	key := Stage1BlockKey(block)
+	if has, _ := db.backend.Has(key); !has {
+		return nil
+	}
+	value, err := db.backend.Get(key)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error getting block substate %v from substate DB: %v", block, err))
+	}
+
+	hashedBlockSubstate := &BlockSubstate{}
+	err = proto.Unmarshal(value, hashedBlockSubstate)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error decoding block substate %v: %v", block, err))
+	}
+
+	hashMap := make(map[common.Hash][]byte)
+	for codeHash := range hashedBlockSubstate.HashKeys() {
+		hashMap[codeHash] = db.GetCode(codeHash)
+	}
+
+	return hashedBlockSubstate.UnhashedCopy(hashMap)
+}
+
+func (db *SubstateDB) PutBlockSubstate(block uint64, blockSubstate *BlockSubstate) {
+	db.putBlockSubstate(block, blockSubstate, func(codeHash common.Hash, code []byte) {
+		db.PutCode(code)
+	})
+}
+
+// putBlockSubstate puts the block substate with putCode called for each
+// bytecode it has
+func (db *SubstateDB) putBlockSubstate(block uint64, blockSubstate *BlockSubstate, putCode func(codeHash common.Hash, code []byte)) {
+	for codeHash, code := range blockSubstate.HashMap() {
+		if codeHash != EmptyCodeHash {
+			putCode(codeHash, code)
+		}
+	}
+
+	value, err := proto.Marshal(blockSubstate.HashedCopy())
+	if err == nil {
+		err = db.updateBlockIndex(func(idx *blockIndex, batch ethdb.Batch) error {
+			idx.add(block, 0)
+			return batch.Put(Stage1BlockKey(block), value)
+		})
+	}
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error putting block substate %v into substate DB: %v", block, err))
+	}
+}
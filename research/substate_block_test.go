@@ -0,0 +1,103 @@
+package research
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newTestBlockSubstate returns a block substate of a beacon root system call
+// to a contract with the bytecode, a block reward of the coinbase and an uncle,
+// and a withdrawal
+func newTestBlockSubstate(block uint64, code []byte) *BlockSubstate {
+	beaconRoots, coinbase, recipient := []byte{0x0b}, []byte{0x03}, []byte{0x04}
+	account := func(balance []byte, storage []*Substate_Account_StorageEntry, code []byte) *Substate_Account {
+		a := &Substate_Account{Nonce: proto.Uint64(0), Balance: balance, Storage: storage}
+		if code != nil {
+			a.Contract = &Substate_Account_Code{Code: code}
+		}
+		return a
+	}
+	rootSlot := []*Substate_Account_StorageEntry{{Key: []byte{byte(block)}, Value: []byte{0x01}}}
+	return &BlockSubstate{
+		BlockEnv: &Substate_BlockEnv{
+			Coinbase:   coinbase,
+			Difficulty: []byte{},
+			GasLimit:   proto.Uint64(30_000_000),
+			Number:     proto.Uint64(block),
+			Timestamp:  proto.Uint64(block * 12),
+		},
+		PreTxInputAlloc: &Substate_Alloc{Alloc: []*Substate_AllocEntry{
+			{Address: beaconRoots, Account: account([]byte{}, nil, code)},
+		}},
+		PreTxOutputAlloc: &Substate_Alloc{Alloc: []*Substate_AllocEntry{
+			{Address: beaconRoots, Account: account([]byte{}, rootSlot, code)},
+		}},
+		BeaconRoot: wrapperspb.Bytes([]byte{0x01}),
+		PostTxInputAlloc: &Substate_Alloc{Alloc: []*Substate_AllocEntry{
+			{Address: coinbase, Account: account([]byte{0x10}, nil, nil)},
+			{Address: recipient, Account: account([]byte{}, nil, nil)},
+		}},
+		PostTxOutputAlloc: &Substate_Alloc{Alloc: []*Substate_AllocEntry{
+			{Address: coinbase, Account: account([]byte{0x12}, nil, nil)},
+			{Address: recipient, Account: account([]byte{0x3b, 0x9a, 0xca, 0x00}, nil, nil)},
+		}},
+		Uncles: []*BlockSubstate_Uncle{
+			{Coinbase: []byte{0x05}, Number: proto.Uint64(block - 1)},
+		},
+		Withdrawals: []*BlockSubstate_Withdrawal{
+			{Index: proto.Uint64(0), Validator: proto.Uint64(1), Address: recipient, Amount: proto.Uint64(1)},
+		},
+	}
+}
+
+func TestBlockSubstateDB(t *testing.T) {
+	db := NewSubstateDB(rawdb.NewMemoryDatabase())
+	defer db.Close()
+
+	code := []byte{0x60, 0x01}
+	blockSubstate := newTestBlockSubstate(2, code)
+
+	if db.HasBlockSubstate(2) || db.GetBlockSubstate(2) != nil {
+		t.Fatal("block substate found in empty substate DB")
+	}
+	db.PutSubstate(2, 0, transferSubstate(2))
+	db.PutBlockSubstate(2, blockSubstate)
+	if !db.HasBlockSubstate(2) {
+		t.Fatal("block substate is not put")
+	}
+	// bytecode is stored once with code hash
+	if !db.HasCode(CodeHash(code)) {
+		t.Fatal("bytecode of block substate is not put")
+	}
+	got := db.GetBlockSubstate(2)
+	if !proto.Equal(got, blockSubstate) {
+		t.Fatalf("block substate mismatch:\n%s", DiffBlockSubstate(blockSubstate, got))
+	}
+	// block substate is not a tx substate
+	if n := len(db.GetBlockSubstates(2)); n != 1 {
+		t.Fatalf("GetBlockSubstates(2) returned %v substates, want 1", n)
+	}
+
+	if block, err := DecodeStage1BlockKey(Stage1BlockKey(2)); err != nil || block != 2 {
+		t.Fatalf("DecodeStage1BlockKey = %v, %v", block, err)
+	}
+	if _, err := DecodeStage1BlockKey(Stage1SubstateKey(2, 0)); err == nil {
+		t.Fatal("error is not raised for substate key")
+	}
+
+	replayed := blockSubstate.ProtoClone()
+	replayed.PostTxOutputAlloc.Alloc[0].Account.Balance = []byte{0x11}
+	replayed.DaoHardFork = proto.Bool(true)
+	d := DiffBlockSubstate(blockSubstate, replayed)
+	if len(d) != 2 || d[0].Part != "postTxOutputAlloc" || d[0].Field != "balance" || d[1].Part != "daoHardFork" {
+		t.Fatalf("unexpected differences:\n%s", d)
+	}
+
+	db.DeleteBlockSubstates(2)
+	if db.HasBlockSubstate(2) || db.HasSubstate(2, 0) {
+		t.Fatal("substates of block 2 are not deleted")
+	}
+}
@@ -0,0 +1,150 @@
+package research
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/params"
+	cli "github.com/urfave/cli/v2"
+)
+
+const DefaultChainConfigName = "mainnet"
+
+var namedChainConfigs = map[string]*params.ChainConfig{
+	"mainnet": params.MainnetChainConfig,
+	"sepolia": params.SepoliaChainConfig,
+	"holesky": params.HoleskyChainConfig,
+	"goerli":  params.GoerliChainConfig,
+}
+
+// ChainConfigByName returns the chain config of a public network
+func ChainConfigByName(name string) (*params.ChainConfig, error) {
+	config, ok := namedChainConfigs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain %q, use --genesis for other chains", name)
+	}
+	return config, nil
+}
+
+// ChainConfigByID returns the chain config of a public network with the chain ID,
+// or nil if the chain ID is not of a public network
+func ChainConfigByID(chainID *big.Int) *params.ChainConfig {
+	for _, config := range namedChainConfigs {
+		if config.ChainID.Cmp(chainID) == 0 {
+			return config
+		}
+	}
+	return nil
+}
+
+// ReadGenesisChainConfig reads the chain config ("config" field) of a genesis JSON file
+func ReadGenesisChainConfig(path string) (*params.ChainConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	genesis := struct {
+		Config *params.ChainConfig `json:"config"`
+	}{}
+	if err := json.Unmarshal(b, &genesis); err != nil {
+		return nil, fmt.Errorf("error parsing genesis %s: %w", path, err)
+	}
+	if genesis.Config == nil {
+		return nil, fmt.Errorf("genesis %s has no chain config", path)
+	}
+	return genesis.Config, nil
+}
+
+// ReplayChainConfig returns a copy of the chain config to replay substates.
+// DAOForkSupport is disabled, otherwise account states will be overwritten.
+func ReplayChainConfig(config *params.ChainConfig) *params.ChainConfig {
+	chainConfig := &params.ChainConfig{}
+	*chainConfig = *config
+	chainConfig.DAOForkSupport = false
+	return chainConfig
+}
+
+// PutChainConfig records the chain config of substates into substate DB
+func (db *SubstateDB) PutChainConfig(config *params.ChainConfig) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error encoding chain config: %v", err))
+	}
+	err = db.backend.Put([]byte(Stage1ChainConfigKey), b)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error putting chain config into substate DB: %v", err))
+	}
+}
+
+// GetChainConfig returns the chain config recorded in substate DB, or nil if
+// substate DB has no chain config.
+func (db *SubstateDB) GetChainConfig() *params.ChainConfig {
+	key := []byte(Stage1ChainConfigKey)
+	if has, _ := db.backend.Has(key); !has {
+		return nil
+	}
+	b, err := db.backend.Get(key)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error getting chain config from substate DB: %v", err))
+	}
+	config := &params.ChainConfig{}
+	err = json.Unmarshal(b, config)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error decoding chain config: %v", err))
+	}
+	return config
+}
+
+func PutChainConfig(config *params.ChainConfig) {
+	staticSubstateDB.PutChainConfig(config)
+}
+
+func GetChainConfig() *params.ChainConfig {
+	return staticSubstateDB.GetChainConfig()
+}
+
+// NewChainConfigCli selects the chain config to replay substates in the order of
+// --genesis, --chain, the chain config recorded in substate DB, and mainnet.
+// The static substate DB must be opened before calling it.
+func NewChainConfigCli(cmdName string, ctx *cli.Context) (*params.ChainConfig, error) {
+	var (
+		config *params.ChainConfig
+		source string
+		err    error
+	)
+
+	genesis := ctx.Path(GenesisFlag.Name)
+	chain := ctx.String(ChainFlag.Name)
+	switch {
+	case genesis != "" && chain != "":
+		return nil, fmt.Errorf("--%s and --%s are exclusive", ChainFlag.Name, GenesisFlag.Name)
+	case genesis != "":
+		config, err = ReadGenesisChainConfig(genesis)
+		source = fmt.Sprintf("--%s=%s", GenesisFlag.Name, genesis)
+	case chain != "":
+		config, err = ChainConfigByName(chain)
+		source = fmt.Sprintf("--%s=%s", ChainFlag.Name, chain)
+	default:
+		if config = GetChainConfig(); config != nil {
+			source = "substate DB"
+		} else {
+			config = namedChainConfigs[DefaultChainConfigName]
+			source = "default " + DefaultChainConfigName
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// warn if the selected chain config differs from the recorded one
+	if recorded := GetChainConfig(); recorded != nil && source != "substate DB" {
+		if recorded.ChainID == nil || config.ChainID == nil || recorded.ChainID.Cmp(config.ChainID) != 0 {
+			fmt.Printf("%s: warning: chain ID %v of %s differs from recorded chain ID %v\n", cmdName, config.ChainID, source, recorded.ChainID)
+		}
+	}
+
+	fmt.Printf("%s: chain ID %v from %s\n", cmdName, config.ChainID, source)
+	return ReplayChainConfig(config), nil
+}
@@ -0,0 +1,66 @@
+package research
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestChainConfig(t *testing.T) {
+	db := NewSubstateDB(rawdb.NewMemoryDatabase())
+	defer db.Close()
+
+	if config := db.GetChainConfig(); config != nil {
+		t.Fatalf("unexpected chain config in empty substate DB: %v", config)
+	}
+	db.PutSubstate(1, 0, transferSubstate(1))
+	db.PutChainConfig(params.SepoliaChainConfig)
+	config := db.GetChainConfig()
+	if config == nil || config.ChainID.Cmp(params.SepoliaChainConfig.ChainID) != 0 {
+		t.Fatalf("unexpected chain config: %v", config)
+	}
+	if config.ShanghaiTime == nil || *config.ShanghaiTime != *params.SepoliaChainConfig.ShanghaiTime {
+		t.Fatalf("unexpected Shanghai time: %v", config.ShanghaiTime)
+	}
+	// metadata is not a substate of any block
+	if n := len(db.GetBlockSubstates(1)); n != 1 {
+		t.Fatalf("GetBlockSubstates(1) returned %v substates, want 1", n)
+	}
+
+	if _, err := ChainConfigByName("nosuchchain"); err == nil {
+		t.Fatal("error is not raised for unknown chain")
+	}
+	if config := ChainConfigByID(big.NewInt(11155111)); config != params.SepoliaChainConfig {
+		t.Fatalf("ChainConfigByID(11155111) = %v, want sepolia", config)
+	}
+	if config := ChainConfigByID(big.NewInt(12345)); config != nil {
+		t.Fatalf("ChainConfigByID(12345) = %v, want nil", config)
+	}
+
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	genesis := `{"config": {"chainId": 12345, "homesteadBlock": 0, "daoForkBlock": 0, "daoForkSupport": true, "eip150Block": 0, "byzantiumBlock": 0}, "alloc": {}}`
+	if err := os.WriteFile(path, []byte(genesis), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadGenesisChainConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ChainID.Uint64() != 12345 || config.ByzantiumBlock == nil || !config.DAOForkSupport {
+		t.Fatalf("unexpected genesis chain config: %v", config)
+	}
+	if replayConfig := ReplayChainConfig(config); replayConfig.DAOForkSupport || !config.DAOForkSupport {
+		t.Fatal("ReplayChainConfig must disable DAOForkSupport of a copy")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"alloc": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadGenesisChainConfig(path); err == nil {
+		t.Fatal("error is not raised for genesis without chain config")
+	}
+}
@@ -0,0 +1,91 @@
+package research
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CheckpointInterval is the minimum interval between checkpoint file updates
+const CheckpointInterval = 10 * time.Second
+
+// Checkpoint is the progress of a task pool saved in a JSON file.
+// All blocks from First to Next-1 are completed, and Next is the first block
+// to execute when the task pool resumes.
+type Checkpoint struct {
+	Name  string `json:"name"`
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+	Next  uint64 `json:"next"`
+}
+
+// LoadCheckpoint reads a checkpoint file, it returns nil without error if the file doesn't exist
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// Save atomically replaces the checkpoint file with a temporary file,
+// so the checkpoint file is always complete even if the process is killed.
+func (cp *Checkpoint) Save(path string) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ResumeSegment returns the first block to execute in the segment.
+// If resume is true, it continues from the checkpoint of the same task pool
+// name and segment. Otherwise, it starts from segment.First.
+func ResumeSegment(path string, resume bool, name string, segment *BlockSegment) (uint64, error) {
+	if !resume {
+		return segment.First, nil
+	}
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		return 0, err
+	}
+	if cp == nil {
+		fmt.Printf("%s: no checkpoint at %s, start from %v\n", name, path, segment.First)
+		return segment.First, nil
+	}
+	if cp.Name != name || cp.First != segment.First || cp.Last != segment.Last {
+		return 0, fmt.Errorf("%s: checkpoint %s is for %s %v-%v, not %v-%v", name, path, cp.Name, cp.First, cp.Last, segment.First, segment.Last)
+	}
+	if cp.Next < segment.First || (cp.Next > segment.Last && cp.Next != segment.Last+1) {
+		return 0, fmt.Errorf("%s: checkpoint %s has next block %v out of block segment %v-%v", name, path, cp.Next, segment.First, segment.Last)
+	}
+	fmt.Printf("%s: resume from %v with checkpoint %s\n", name, cp.Next, path)
+	return cp.Next, nil
+}
@@ -0,0 +1,115 @@
+package research
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// transferSubstate returns a substate of a plain value transfer, for tests
+// that only need a transaction in each block
+func transferSubstate(block uint64) *Substate {
+	from, to := []byte{0x01}, []byte{0x02}
+	return &Substate{
+		InputAlloc: &Substate_Alloc{
+			Alloc: []*Substate_AllocEntry{
+				{Address: from, Account: &Substate_Account{Nonce: proto.Uint64(block), Balance: []byte{0x64}}},
+			},
+		},
+		OutputAlloc: &Substate_Alloc{
+			Alloc: []*Substate_AllocEntry{
+				{Address: from, Account: &Substate_Account{Nonce: proto.Uint64(block + 1), Balance: []byte{0x63}}},
+				{Address: to, Account: &Substate_Account{Nonce: proto.Uint64(0), Balance: []byte{0x01}}},
+			},
+		},
+		BlockEnv: &Substate_BlockEnv{
+			Coinbase:   []byte{},
+			Difficulty: []byte{},
+			GasLimit:   proto.Uint64(30_000_000),
+			Number:     proto.Uint64(block),
+			Timestamp:  proto.Uint64(block * 12),
+		},
+		TxMessage: &Substate_TxMessage{
+			Nonce:    proto.Uint64(block),
+			GasPrice: []byte{},
+			Gas:      proto.Uint64(21_000),
+			From:     from,
+			To:       wrapperspb.Bytes(to),
+			Value:    []byte{0x01},
+			Input:    &Substate_TxMessage_Data{Data: []byte{}},
+			TxType:   Substate_TxMessage_TXTYPE_LEGACY.Enum(),
+		},
+		Result: &Substate_Result{
+			Status:  proto.Uint64(1),
+			Bloom:   make([]byte, 256),
+			GasUsed: proto.Uint64(21_000),
+		},
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	backend, _ := OpenBackendDatabase("memory,", false)
+	db := NewSubstateDB(backend)
+	defer db.Close()
+	for block := uint64(1); block <= 10; block++ {
+		db.PutSubstate(block, 0, transferSubstate(block))
+	}
+
+	var mu sync.Mutex
+	executed := make(map[uint64]int)
+	failBlock := uint64(6)
+	taskFunc := func(block uint64, tx int, substate *Substate, taskPool *SubstateTaskPool) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if block == failBlock {
+			return errors.New("killed")
+		}
+		executed[block]++
+		return nil
+	}
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	pool := &SubstateTaskPool{
+		Name:     "test",
+		TaskFunc: taskFunc,
+		Config:   &SubstateTaskConfig{Workers: 1, CheckpointPath: path},
+		DB:       db,
+	}
+	segment := NewBlockSegment(1, 10)
+
+	if err := pool.ExecuteSegment(segment); err == nil {
+		t.Fatal("expected error at block 6")
+	}
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Next != 6 {
+		t.Fatalf("unexpected checkpoint %+v, want next block 6", cp)
+	}
+
+	// resume from block 6
+	failBlock = 0
+	pool.Config.Resume = true
+	if err := pool.ExecuteSegment(segment); err != nil {
+		t.Fatal(err)
+	}
+	// blocks after the checkpoint may have been executed before the error
+	for block := uint64(1); block <= 10; block++ {
+		if (block < 6 && executed[block] != 1) || executed[block] < 1 {
+			t.Fatalf("block %v executed %v times", block, executed[block])
+		}
+	}
+	if cp, _ := LoadCheckpoint(path); cp.Next != 11 {
+		t.Fatalf("unexpected checkpoint %+v, want next block 11", cp)
+	}
+
+	// checkpoint of another block segment is rejected
+	if err := pool.ExecuteSegment(NewBlockSegment(1, 20)); err == nil {
+		t.Fatal("resumed with checkpoint of another block segment")
+	}
+}
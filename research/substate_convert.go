@@ -0,0 +1,111 @@
+package research
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// encodeSubstate returns deterministic Protobuf encoding of unhashed substate
+func encodeSubstate(substate *Substate) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(substate)
+}
+
+// SubstateConverter copies substates, block substates, call traces, bytecodes
+// and metadata from a substate DB to another substate DB which may use a
+// different backend. Substates already stored in the destination with the same
+// encoding are skipped, and bytecodes already stored in the destination are
+// not written again, so an interrupted conversion resumes by running it again.
+type SubstateConverter struct {
+	src, dst *SubstateDB
+
+	// Verify reads back each converted substate and block substate from the
+	// destination and compares it with the source
+	Verify bool
+
+	NumConverted int64 // number of converted substates
+	NumSkipped   int64 // number of substates skipped as already converted
+
+	codeHashes sync.Map // bytecodes known to be in the destination
+}
+
+// NewSubstateConverter returns a SubstateConverter from src to dst
+func NewSubstateConverter(src, dst *SubstateDB, verify bool) *SubstateConverter {
+	return &SubstateConverter{
+		src:    src,
+		dst:    dst,
+		Verify: verify,
+	}
+}
+
+// putCode puts the bytecode to the destination unless it is already there
+func (c *SubstateConverter) putCode(codeHash common.Hash, code []byte) {
+	if _, ok := c.codeHashes.Load(codeHash); ok {
+		return
+	}
+	if !c.dst.HasCode(codeHash) {
+		c.dst.PutCode(code)
+	}
+	c.codeHashes.Store(codeHash, struct{}{})
+}
+
+// TaskFunc is SubstateTaskFunc converting the substate and its call trace
+func (c *SubstateConverter) TaskFunc(block uint64, tx int, substate *Substate, taskPool *SubstateTaskPool) error {
+	srcBytes, err := encodeSubstate(substate)
+	if err != nil {
+		return fmt.Errorf("error encoding src substate: %w", err)
+	}
+
+	// call traces are stored as is in every backend
+	if trace := c.src.GetTxTrace(block, tx); trace != nil {
+		c.dst.PutTxTrace(block, tx, trace)
+	}
+
+	// skip substates converted before
+	if c.dst.HasSubstate(block, tx) {
+		dstBytes, err := encodeSubstate(c.dst.GetSubstate(block, tx))
+		if err != nil {
+			return fmt.Errorf("error encoding dst substate: %w", err)
+		}
+		if bytes.Equal(srcBytes, dstBytes) {
+			atomic.AddInt64(&c.NumSkipped, 1)
+			return nil
+		}
+	}
+
+	c.dst.putSubstate(block, tx, substate, c.putCode)
+
+	if c.Verify {
+		dstBytes, err := encodeSubstate(c.dst.GetSubstate(block, tx))
+		if err != nil {
+			return fmt.Errorf("error encoding dst substate: %w", err)
+		}
+		if !bytes.Equal(srcBytes, dstBytes) {
+			return fmt.Errorf("verification failed: dst substate is different from src substate")
+		}
+	}
+
+	atomic.AddInt64(&c.NumConverted, 1)
+	return nil
+}
+
+// BlockTaskFunc is SubstateBlockTaskFunc converting the block substate
+func (c *SubstateConverter) BlockTaskFunc(block uint64, blockSubstate *BlockSubstate, taskPool *SubstateTaskPool) error {
+	if blockSubstate == nil {
+		return nil
+	}
+	c.dst.putBlockSubstate(block, blockSubstate, c.putCode)
+	if c.Verify && !proto.Equal(blockSubstate, c.dst.GetBlockSubstate(block)) {
+		return fmt.Errorf("verification failed: dst block substate is different from src block substate")
+	}
+	return nil
+}
+
+// CopyMetadata copies metadata of the converted segment
+func (c *SubstateConverter) CopyMetadata(segment *BlockSegment) error {
+	return CopyMetadata(c.src, c.dst, segment)
+}
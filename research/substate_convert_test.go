@@ -0,0 +1,150 @@
+package research
+
+import (
+	"bytes"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// callSubstate returns a substate of a call to a contract with the bytecode,
+// so that substates calling the same contract share its bytecode
+func callSubstate(block uint64, code []byte) *Substate {
+	caller, contract := []byte{0x01}, []byte{0x02}
+	alloc := func(nonce uint64) *Substate_Alloc {
+		return &Substate_Alloc{
+			Alloc: []*Substate_AllocEntry{
+				{Address: caller, Account: &Substate_Account{Nonce: proto.Uint64(nonce), Balance: []byte{0x10}}},
+				{Address: contract, Account: &Substate_Account{
+					Nonce:    proto.Uint64(1),
+					Balance:  []byte{},
+					Contract: &Substate_Account_Code{Code: code},
+				}},
+			},
+		}
+	}
+	return &Substate{
+		InputAlloc:  alloc(block),
+		OutputAlloc: alloc(block + 1),
+		BlockEnv: &Substate_BlockEnv{
+			Coinbase:   []byte{0x03},
+			Difficulty: []byte{},
+			GasLimit:   proto.Uint64(30_000_000),
+			Number:     proto.Uint64(block),
+			Timestamp:  proto.Uint64(block * 12),
+		},
+		TxMessage: &Substate_TxMessage{
+			Nonce:    proto.Uint64(block),
+			GasPrice: []byte{},
+			Gas:      proto.Uint64(100_000),
+			From:     caller,
+			To:       wrapperspb.Bytes(contract),
+			Value:    []byte{},
+			Input:    &Substate_TxMessage_Data{Data: []byte{}},
+			TxType:   Substate_TxMessage_TXTYPE_LEGACY.Enum(),
+		},
+		Result: &Substate_Result{
+			Status:  proto.Uint64(1),
+			Bloom:   make([]byte, 256),
+			GasUsed: proto.Uint64(21_006),
+		},
+	}
+}
+
+// codeCountingBackend counts bytecodes written to the backend
+type codeCountingBackend struct {
+	BackendDatabase
+	codePuts int64
+}
+
+func (b *codeCountingBackend) Put(key []byte, value []byte) error {
+	if bytes.HasPrefix(key, []byte(Stage1CodePrefix)) {
+		atomic.AddInt64(&b.codePuts, 1)
+	}
+	return b.BackendDatabase.Put(key, value)
+}
+
+func TestSubstateConverter(t *testing.T) {
+	code1, code2 := []byte{0x60, 0x01}, []byte{0x60, 0x02}
+	src := NewSubstateDB(rawdb.NewMemoryDatabase())
+	m := &SubstateMetadata{ChainID: big.NewInt(1)}
+	m.AddRange(1, 3)
+	src.PutMetadata(m)
+	for block := uint64(1); block <= 3; block++ {
+		code := code1
+		if block == 3 {
+			code = code2
+		}
+		for tx := 0; tx < 2; tx++ {
+			src.PutSubstate(block, tx, callSubstate(block, code))
+		}
+	}
+	src.PutTxTrace(2, 0, newTestTxTrace())
+	src.PutBlockSubstate(2, newTestBlockSubstate(2, code1))
+
+	backend := &codeCountingBackend{BackendDatabase: rawdb.NewMemoryDatabase()}
+	dst := NewSubstateDB(backend)
+	convert := func(segment *BlockSegment) *SubstateConverter {
+		t.Helper()
+		c := NewSubstateConverter(src, dst, true)
+		pool := &SubstateTaskPool{
+			Name:          "test",
+			TaskFunc:      c.TaskFunc,
+			BlockTaskFunc: c.BlockTaskFunc,
+			Config:        &SubstateTaskConfig{Workers: 2},
+			DB:            src,
+		}
+		if err := pool.ExecuteSegment(segment); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.CopyMetadata(segment); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// blocks 1-2 first, then resume with 1-3
+	c := convert(NewBlockSegment(1, 2))
+	if c.NumConverted != 4 || c.NumSkipped != 0 {
+		t.Fatalf("converted %v, skipped %v", c.NumConverted, c.NumSkipped)
+	}
+	if n := atomic.LoadInt64(&backend.codePuts); n != 1 {
+		t.Fatalf("code1 written %v times", n)
+	}
+	c = convert(NewBlockSegment(1, 3))
+	if c.NumConverted != 2 || c.NumSkipped != 4 {
+		t.Fatalf("resumed: converted %v, skipped %v", c.NumConverted, c.NumSkipped)
+	}
+	// code1 is already in dst
+	if n := atomic.LoadInt64(&backend.codePuts); n != 2 {
+		t.Fatalf("bytecodes written %v times", n)
+	}
+
+	for block := uint64(1); block <= 3; block++ {
+		for tx := 0; tx < 2; tx++ {
+			if !proto.Equal(dst.GetSubstate(block, tx), src.GetSubstate(block, tx)) {
+				t.Fatalf("substate %v_%v is not converted", block, tx)
+			}
+		}
+	}
+	if !proto.Equal(dst.GetTxTrace(2, 0), src.GetTxTrace(2, 0)) {
+		t.Fatal("call trace is not converted")
+	}
+	if !proto.Equal(dst.GetBlockSubstate(2), src.GetBlockSubstate(2)) {
+		t.Fatal("block substate is not converted")
+	}
+	if got := dst.GetMetadata(); got.ChainID.Cmp(big.NewInt(1)) != 0 || rangesString(got.Ranges) != "1-3," {
+		t.Fatalf("metadata %+v", got)
+	}
+
+	// a different substate in dst is converted again
+	dst.PutSubstate(1, 0, callSubstate(1, code2))
+	c = convert(NewBlockSegment(1, 1))
+	if c.NumConverted != 1 || c.NumSkipped != 1 || !proto.Equal(dst.GetSubstate(1, 0), src.GetSubstate(1, 0)) {
+		t.Fatalf("changed substate: converted %v, skipped %v", c.NumConverted, c.NumSkipped)
+	}
+}
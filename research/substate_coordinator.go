@@ -0,0 +1,409 @@
+package research
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	cli "github.com/urfave/cli/v2"
+)
+
+// CoordinatorNamespace is the JSON-RPC namespace of SubstateCoordinator
+const CoordinatorNamespace = "coordinator"
+
+// MinLeaseTimeout is the minimum lease timeout, so that workers sending
+// heartbeats every third of the timeout are not re-queued by the coordinator
+// checking leases every second.
+const MinLeaseTimeout = 3 * time.Second
+
+// RangeLease is a block range assigned to a worker.
+// Segment is nil when the worker should wait and request again,
+// and Done is true when there is no more range to execute.
+// The worker sends heartbeats within LeaseTimeout to keep the range.
+type RangeLease struct {
+	Segment      *BlockSegment `json:"segment"`
+	Done         bool          `json:"done"`
+	LeaseTimeout time.Duration `json:"leaseTimeout"`
+}
+
+// RangeReport is a result of a block range executed by a worker
+type RangeReport struct {
+	Worker   string        `json:"worker"`
+	Segment  *BlockSegment `json:"segment"`
+	NumBlock int64         `json:"numBlock"`
+	NumTx    int64         `json:"numTx"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type rangeLeaseState struct {
+	segment  *BlockSegment
+	worker   string
+	deadline time.Time
+}
+
+type workerStat struct {
+	numRange, numBlock, numTx int64
+	duration                  time.Duration
+}
+
+// SubstateCoordinator splits a block segment into block ranges and hands them
+// out to workers over JSON-RPC. Ranges leased by workers that stop sending
+// heartbeats are re-queued for other workers.
+type SubstateCoordinator struct {
+	Name         string
+	Segment      *BlockSegment
+	RangeSize    uint64
+	LeaseTimeout time.Duration
+
+	mu        sync.Mutex
+	pending   []*BlockSegment
+	leases    map[uint64]*rangeLeaseState // first block -> lease
+	completed map[uint64]struct{}         // first block of completed ranges
+	numRange  int
+	workers   map[string]*workerStat
+	joined    map[string]bool // worker -> whether it has been told done
+	failure   *RangeReport
+	doneChan  chan struct{}
+	doneOnce  sync.Once
+}
+
+func NewSubstateCoordinator(name string, segment *BlockSegment, rangeSize uint64, leaseTimeout time.Duration) *SubstateCoordinator {
+	c := &SubstateCoordinator{
+		Name:         name,
+		Segment:      segment,
+		RangeSize:    rangeSize,
+		LeaseTimeout: leaseTimeout,
+
+		leases:    make(map[uint64]*rangeLeaseState),
+		completed: make(map[uint64]struct{}),
+		workers:   make(map[string]*workerStat),
+		joined:    make(map[string]bool),
+		doneChan:  make(chan struct{}),
+	}
+	if c.RangeSize == 0 {
+		c.RangeSize = 1
+	}
+	for first := segment.First; first <= segment.Last; {
+		last := first + c.RangeSize - 1
+		if last > segment.Last || last < first {
+			last = segment.Last
+		}
+		c.pending = append(c.pending, NewBlockSegment(first, last))
+		c.numRange++
+		if last == segment.Last {
+			break
+		}
+		first = last + 1
+	}
+	return c
+}
+
+func NewSubstateCoordinatorCli(name string, segment *BlockSegment, ctx *cli.Context) *SubstateCoordinator {
+	return NewSubstateCoordinator(name, segment, ctx.Uint64(RangeSizeFlag.Name), ctx.Duration(LeaseTimeoutFlag.Name))
+}
+
+// finish closes doneChan if all ranges are completed, or a range failed
+// and no range is leased. c.mu must be held.
+func (c *SubstateCoordinator) finish() {
+	if len(c.completed) == c.numRange || (c.failure != nil && len(c.leases) == 0) {
+		c.doneOnce.Do(func() { close(c.doneChan) })
+	}
+}
+
+// RequestRange leases the next pending block range to the worker
+func (c *SubstateCoordinator) RequestRange(worker string) RangeLease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.joined[worker]; !ok {
+		fmt.Printf("%s: %s joined\n", c.Name, worker)
+	}
+	if c.failure != nil || len(c.completed) == c.numRange {
+		c.joined[worker] = true
+		return RangeLease{Done: true}
+	}
+	c.joined[worker] = false
+	if len(c.pending) == 0 {
+		// all ranges are leased, wait for re-queued ranges
+		return RangeLease{}
+	}
+
+	segment := c.pending[0]
+	c.pending = c.pending[1:]
+	c.leases[segment.First] = &rangeLeaseState{
+		segment:  segment,
+		worker:   worker,
+		deadline: time.Now().Add(c.LeaseTimeout),
+	}
+	fmt.Printf("%s: lease %v-%v to %s\n", c.Name, segment.First, segment.Last, worker)
+	return RangeLease{Segment: segment, LeaseTimeout: c.LeaseTimeout}
+}
+
+// Heartbeat extends the lease of the block range starting at first
+func (c *SubstateCoordinator) Heartbeat(worker string, first uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lease, ok := c.leases[first]
+	if !ok || lease.worker != worker {
+		return false
+	}
+	lease.deadline = time.Now().Add(c.LeaseTimeout)
+	return true
+}
+
+// ReportRange completes a block range executed by a worker
+func (c *SubstateCoordinator) ReportRange(report RangeReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	segment := report.Segment
+	if segment == nil {
+		return
+	}
+	if _, ok := c.completed[segment.First]; ok {
+		// the range was re-queued and completed by another worker
+		return
+	}
+	if lease, ok := c.leases[segment.First]; ok && lease.worker == report.Worker {
+		delete(c.leases, segment.First)
+	}
+
+	if report.Error != "" {
+		fmt.Printf("%s: %s failed %v-%v: %s\n", c.Name, report.Worker, segment.First, segment.Last, report.Error)
+		if c.failure == nil || segment.First < c.failure.Segment.First {
+			r := report
+			c.failure = &r
+		}
+		c.finish()
+		return
+	}
+
+	// remove the range from pending if it was re-queued but not leased again
+	for i, s := range c.pending {
+		if s.First == segment.First {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+	for first, lease := range c.leases {
+		if first == segment.First {
+			fmt.Printf("%s: %s completed %v-%v leased to %s\n", c.Name, report.Worker, segment.First, segment.Last, lease.worker)
+			delete(c.leases, first)
+		}
+	}
+	c.completed[segment.First] = struct{}{}
+
+	stat := c.workers[report.Worker]
+	if stat == nil {
+		stat = &workerStat{}
+		c.workers[report.Worker] = stat
+	}
+	stat.numRange++
+	stat.numBlock += report.NumBlock
+	stat.numTx += report.NumTx
+	stat.duration += report.Duration
+
+	fmt.Printf("%s: %s completed %v-%v (%v/%v ranges)\n", c.Name, report.Worker, segment.First, segment.Last, len(c.completed), c.numRange)
+	c.finish()
+}
+
+// requeueExpired moves leases without heartbeats before their deadlines back to pending
+func (c *SubstateCoordinator) requeueExpired(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expired []*BlockSegment
+	for first, lease := range c.leases {
+		if now.After(lease.deadline) {
+			fmt.Printf("%s: re-queue %v-%v from %s\n", c.Name, lease.segment.First, lease.segment.Last, lease.worker)
+			expired = append(expired, lease.segment)
+			delete(c.leases, first)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].First < expired[j].First })
+	c.pending = append(expired, c.pending...)
+	c.finish()
+}
+
+// Run serves JSON-RPC at addr until all block ranges are completed or failed
+func (c *SubstateCoordinator) Run(addr string) error {
+	if c.LeaseTimeout < MinLeaseTimeout {
+		return fmt.Errorf("%s: lease timeout %v is less than %v", c.Name, c.LeaseTimeout, MinLeaseTimeout)
+	}
+	start := time.Now()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName(CoordinatorNamespace, c); err != nil {
+		return err
+	}
+	defer server.Stop()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%s: error listening %s: %w", c.Name, addr, err)
+	}
+	httpServer := &http.Server{Handler: server}
+	go httpServer.Serve(listener)
+	defer httpServer.Shutdown(context.Background())
+
+	fmt.Printf("%s: block segment = %v-%v\n", c.Name, c.Segment.First, c.Segment.Last)
+	fmt.Printf("%s: coordinating %v ranges of %v blocks at http://%s\n", c.Name, c.numRange, c.RangeSize, listener.Addr())
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case now := <-ticker.C:
+			c.requeueExpired(now)
+		case <-c.doneChan:
+			done = true
+		}
+	}
+
+	// keep serving for a while until all workers are told done
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		c.mu.Lock()
+		left := true
+		for _, done := range c.joined {
+			left = left && done
+		}
+		c.mu.Unlock()
+		if left {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	duration := time.Since(start) + 1*time.Nanosecond
+	sec := duration.Seconds()
+	workers := make([]string, 0, len(c.workers))
+	var nb, nt int64
+	for worker, stat := range c.workers {
+		workers = append(workers, worker)
+		nb += stat.numBlock
+		nt += stat.numTx
+	}
+	sort.Strings(workers)
+	for _, worker := range workers {
+		stat := c.workers[worker]
+		fmt.Printf("%s: worker %s: #range = %v, #block = %v, #tx = %v, busy %v\n", c.Name, worker, stat.numRange, stat.numBlock, stat.numTx, stat.duration.Round(1*time.Millisecond))
+	}
+	fmt.Printf("%s: block segment = %v-%v\n", c.Name, c.Segment.First, c.Segment.Last)
+	fmt.Printf("%s: total #block = %v\n", c.Name, nb)
+	fmt.Printf("%s: total #tx    = %v\n", c.Name, nt)
+	fmt.Printf("%s: %.2f blk/s, %.2f tx/s\n", c.Name, float64(nb)/sec, float64(nt)/sec)
+	fmt.Printf("%s done in %v\n", c.Name, duration.Round(1*time.Millisecond))
+
+	if c.failure != nil {
+		return fmt.Errorf("%s: first failure in %v-%v from %s: %s", c.Name, c.failure.Segment.First, c.failure.Segment.Last, c.failure.Worker, c.failure.Error)
+	}
+	return nil
+}
+
+// workerName returns hostname-pid to identify a worker process
+func workerName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// ExecuteJoin requests block ranges from the coordinator at url
+// and executes them with ExecuteSegment until the coordinator is done.
+func (pool *SubstateTaskPool) ExecuteJoin(url string) error {
+	// coordinator re-queues unfinished ranges instead of checkpoints
+	if pool.Config.CheckpointPath != "" {
+		return fmt.Errorf("%s: --checkpoint is not supported with --join", pool.Name)
+	}
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	client, err := rpc.Dial(url)
+	if err != nil {
+		return fmt.Errorf("%s: error joining coordinator %s: %w", pool.Name, url, err)
+	}
+	defer client.Close()
+
+	// retry calls while coordinator is starting or temporarily unreachable
+	call := func(result interface{}, method string, args ...interface{}) error {
+		var err error
+		for retry := 0; retry < 30; retry++ {
+			err = client.CallContext(context.Background(), result, CoordinatorNamespace+"_"+method, args...)
+			if _, ok := err.(rpc.Error); err == nil || ok {
+				return err
+			}
+			time.Sleep(time.Second)
+		}
+		return err
+	}
+
+	worker := workerName()
+	fmt.Printf("%s: joined coordinator %s as %s\n", pool.Name, url, worker)
+	for {
+		var lease RangeLease
+		if err := call(&lease, "requestRange", worker); err != nil {
+			return fmt.Errorf("%s: error requesting range: %w", pool.Name, err)
+		}
+		if lease.Done {
+			return nil
+		}
+		if lease.Segment == nil {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		// send heartbeats while executing the range, three times per lease timeout
+		heartbeatInterval := lease.LeaseTimeout / 3
+		if lease.LeaseTimeout < MinLeaseTimeout {
+			heartbeatInterval = MinLeaseTimeout / 3
+		}
+		stopHeartbeat := make(chan struct{})
+		go func(first uint64) {
+			ticker := time.NewTicker(heartbeatInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					var ok bool
+					call(&ok, "heartbeat", worker, first)
+				case <-stopHeartbeat:
+					return
+				}
+			}
+		}(lease.Segment.First)
+
+		start := time.Now()
+		nb, nt, err := pool.executeSegment(lease.Segment)
+		close(stopHeartbeat)
+
+		report := RangeReport{
+			Worker:   worker,
+			Segment:  lease.Segment,
+			NumBlock: nb,
+			NumTx:    nt,
+			Duration: time.Since(start),
+		}
+		if err != nil {
+			report.Error = err.Error()
+		}
+		if err := call(nil, "reportRange", report); err != nil {
+			return fmt.Errorf("%s: error reporting range: %w", pool.Name, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
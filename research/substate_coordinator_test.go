@@ -0,0 +1,100 @@
+package research
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubstateCoordinatorRanges(t *testing.T) {
+	c := NewSubstateCoordinator("test", NewBlockSegment(1, 10), 3, time.Minute)
+	want := []BlockSegment{{1, 3}, {4, 6}, {7, 9}, {10, 10}}
+	if len(c.pending) != len(want) {
+		t.Fatalf("got %v ranges, want %v", len(c.pending), len(want))
+	}
+	for i, seg := range c.pending {
+		if *seg != want[i] {
+			t.Fatalf("range %v: got %v, want %v", i, *seg, want[i])
+		}
+	}
+}
+
+func TestSubstateCoordinatorRequeue(t *testing.T) {
+	c := NewSubstateCoordinator("test", NewBlockSegment(1, 6), 3, time.Minute)
+
+	l1 := c.RequestRange("w1")
+	l2 := c.RequestRange("w2")
+	if l1.Segment.First != 1 || l2.Segment.First != 4 {
+		t.Fatalf("unexpected leases %v, %v", l1.Segment, l2.Segment)
+	}
+	if l1.LeaseTimeout != time.Minute {
+		t.Fatalf("lease timeout %v, want %v", l1.LeaseTimeout, time.Minute)
+	}
+	if l := c.RequestRange("w3"); l.Segment != nil || l.Done {
+		t.Fatalf("w3 should wait, got %+v", l)
+	}
+
+	// w2 keeps sending heartbeats, w1 crashed
+	if !c.Heartbeat("w2", 4) {
+		t.Fatal("heartbeat of w2 is rejected")
+	}
+	c.requeueExpired(time.Now().Add(2 * time.Minute))
+	if len(c.pending) != 2 {
+		t.Fatalf("got %v pending ranges, want 2", len(c.pending))
+	}
+	if c.Heartbeat("w1", 1) {
+		t.Fatal("heartbeat of expired lease is accepted")
+	}
+
+	l3 := c.RequestRange("w3")
+	if l3.Segment == nil || l3.Segment.First != 1 {
+		t.Fatalf("re-queued range is not leased to w3: %+v", l3)
+	}
+	c.ReportRange(RangeReport{Worker: "w3", Segment: l3.Segment, NumBlock: 3, NumTx: 5})
+	// late report of the same range from w1 is ignored
+	c.ReportRange(RangeReport{Worker: "w1", Segment: l1.Segment, NumBlock: 3, NumTx: 5})
+	if c.workers["w1"] != nil {
+		t.Fatal("duplicated report is counted")
+	}
+
+	l4 := c.RequestRange("w3")
+	c.ReportRange(RangeReport{Worker: "w3", Segment: l4.Segment, NumBlock: 3, NumTx: 4})
+	select {
+	case <-c.doneChan:
+	default:
+		t.Fatal("coordinator is not done after all ranges are completed")
+	}
+	if l := c.RequestRange("w2"); !l.Done {
+		t.Fatal("coordinator leases a range after done")
+	}
+	if stat := c.workers["w3"]; stat.numRange != 2 || stat.numTx != 9 {
+		t.Fatalf("unexpected stat of w3: %+v", stat)
+	}
+}
+
+func TestSubstateCoordinatorFailure(t *testing.T) {
+	c := NewSubstateCoordinator("test", NewBlockSegment(1, 9), 3, time.Minute)
+
+	l1 := c.RequestRange("w1")
+	l2 := c.RequestRange("w2")
+	c.ReportRange(RangeReport{Worker: "w2", Segment: l2.Segment, Error: "inconsistent output"})
+	if l := c.RequestRange("w2"); !l.Done {
+		t.Fatal("coordinator leases a range after failure")
+	}
+	select {
+	case <-c.doneChan:
+		t.Fatal("coordinator is done before leased ranges are reported")
+	default:
+	}
+	c.ReportRange(RangeReport{Worker: "w1", Segment: l1.Segment, Error: "earlier failure"})
+	<-c.doneChan
+	if c.failure.Segment.First != 1 {
+		t.Fatalf("first failure is %v, want range from 1", c.failure.Segment)
+	}
+}
+
+func TestSubstateCoordinatorLeaseTimeout(t *testing.T) {
+	c := NewSubstateCoordinator("test", NewBlockSegment(1, 6), 3, time.Second)
+	if err := c.Run("127.0.0.1:0"); err == nil {
+		t.Fatal("coordinator runs with lease timeout less than MinLeaseTimeout")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -14,6 +16,13 @@ import (
 const (
 	Stage1SubstatePrefix = "1s" // stage1SubstatePrefix + block (64-bit) + tx (64-bit) -> substateRLP
 	Stage1CodePrefix     = "1c" // stage1CodePrefix + codeHash (256-bit) -> code
+	Stage1MetadataPrefix = "1m" // stage1MetadataPrefix + name -> metadata JSON
+	Stage1BlockPrefix    = "1b" // stage1BlockPrefix + block (64-bit) -> blockSubstateProto
+	Stage1TracePrefix    = "1t" // stage1TracePrefix + block (64-bit) + tx (64-bit) -> txTraceProto
+
+	Stage1ChainConfigKey = Stage1MetadataPrefix + "chainconfig" // chain config of recorded substates
+	Stage1MetadataKey    = Stage1MetadataPrefix + "metadata"    // SubstateMetadata of recorded substates
+	Stage1BlockIndexKey  = Stage1MetadataPrefix + "blockindex"  // runs of blocks with substates or block substates
 )
 
 func Stage1SubstateKey(block uint64, tx int) []byte {
@@ -75,17 +84,33 @@ type BackendDatabase interface {
 	ethdb.KeyValueWriter
 	ethdb.Batcher
 	ethdb.Iteratee
-	ethdb.Stater
+	ethdb.KeyValueStater
 	ethdb.Compacter
 	io.Closer
 }
 
 type SubstateDB struct {
 	backend BackendDatabase
+
+	indexMu      sync.Mutex
+	index        *blockIndex // nil without a block index
+	indexLoaded  bool
+	indexDirty   bool       // stored block index is deleted until FlushBlockIndex
+	indexFlushed time.Time  // last time the block index was written
+	indexWriting int        // number of batches being written outside indexMu
+	indexWritten *sync.Cond // signaled when a batch is written outside indexMu
+	indexWarned  bool       // warned that substate DB has no block index
+
+	// metadata recorded into the static substate DB, see RecordMetadata
+	recordOnce     sync.Once
+	recordMu       sync.Mutex
+	recordMetadata *SubstateMetadata
 }
 
 func NewSubstateDB(backend BackendDatabase) *SubstateDB {
-	return &SubstateDB{backend: backend}
+	db := &SubstateDB{backend: backend}
+	db.indexWritten = sync.NewCond(&db.indexMu)
+	return db
 }
 
 func (db *SubstateDB) Compact(start []byte, limit []byte) error {
@@ -93,6 +118,10 @@ func (db *SubstateDB) Compact(start []byte, limit []byte) error {
 }
 
 func (db *SubstateDB) Close() error {
+	if err := db.FlushBlockIndex(); err != nil {
+		db.backend.Close()
+		return err
+	}
 	return db.backend.Close()
 }
 
@@ -234,6 +263,13 @@ func (db *SubstateDB) GetBlockSubstatesWithTxList(block uint64, config *Substate
 }
 
 func (db *SubstateDB) PutSubstate(block uint64, tx int, substate *Substate) {
+	db.putSubstate(block, tx, substate, func(codeHash common.Hash, code []byte) {
+		db.PutCode(code)
+	})
+}
+
+// putSubstate puts the substate with putCode called for each bytecode it has
+func (db *SubstateDB) putSubstate(block uint64, tx int, substate *Substate, putCode func(codeHash common.Hash, code []byte)) {
 	var err error
 
 	// replace code to code hashes in accounts and messages
@@ -243,7 +279,7 @@ func (db *SubstateDB) PutSubstate(block uint64, tx int, substate *Substate) {
 	// put deployed/creation code
 	for codeHash, code := range hashMap {
 		if codeHash != EmptyCodeHash {
-			db.PutCode(code)
+			putCode(codeHash, code)
 		}
 	}
 
@@ -258,16 +294,88 @@ func (db *SubstateDB) PutSubstate(block uint64, tx int, substate *Substate) {
 	if err != nil {
 		panic(err)
 	}
-	err = db.backend.Put(key, value)
-	if err != nil {
-		panic(err)
-	}
+	err = db.updateBlockIndex(func(idx *blockIndex, batch ethdb.Batch) error {
+		if idx == nil {
+			return batch.Put(key, value)
+		}
+		// only blocks in the block index before their substates were
+		// tracked need to check whether the substate is overwritten
+		if !idx.addTx(block, tx) {
+			if has, _ := db.backend.Has(key); !has {
+				idx.add(block, 1)
+			}
+		}
+		return batch.Put(key, value)
+	})
 }
 
+// DeleteSubstate deletes the substate of the transaction, bytecodes it references
+// are kept until CollectCodeGarbage.
 func (db *SubstateDB) DeleteSubstate(block uint64, tx int) {
 	key := Stage1SubstateKey(block, tx)
-	err := db.backend.Delete(key)
+	err := db.updateBlockIndex(func(idx *blockIndex, batch ethdb.Batch) error {
+		if has, _ := db.backend.Has(key); has && idx != nil {
+			// remove the block if this is its last substate without block substate
+			segment := NewBlockSegment(block, block)
+			if db.HasBlockSubstate(block) || db.countSubstates(segment) > 1 {
+				idx.removeTx(block, tx)
+			} else {
+				idx.remove(segment, db.countSubstates)
+			}
+		}
+		return batch.Delete(key)
+	})
 	if err != nil {
 		panic(err)
 	}
 }
+
+// DeleteBlockSubstates deletes all substates of the block including its block
+// substate and call traces, bytecodes are kept because they may be shared with
+// substates of other blocks.
+func (db *SubstateDB) DeleteBlockSubstates(block uint64) {
+	keys := [][]byte{Stage1BlockKey(block)}
+	for _, prefix := range [][]byte{Stage1SubstateBlockPrefix(block), Stage1TraceBlockPrefix(block)} {
+		iter := db.backend.NewIterator(prefix, nil)
+		for iter.Next() {
+			keys = append(keys, common.CopyBytes(iter.Key()))
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			panic(fmt.Errorf("record-replay: error iterating substates of block %v: %v", block, err))
+		}
+	}
+
+	err := db.updateBlockIndex(func(idx *blockIndex, batch ethdb.Batch) error {
+		idx.remove(NewBlockSegment(block, block), db.countSubstates)
+		for _, key := range keys {
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error deleting substates of block %v: %v", block, err))
+	}
+}
+
+// iterateSegment calls fn with key-value pairs of the prefix whose keys start
+// with a block number in the segment
+func (db *SubstateDB) iterateSegment(prefix string, segment *BlockSegment, fn func(key, value []byte) error) error {
+	start := make([]byte, 8)
+	binary.BigEndian.PutUint64(start, segment.First)
+
+	iter := db.backend.NewIterator([]byte(prefix), start)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) >= len(prefix)+8 && binary.BigEndian.Uint64(key[len(prefix):]) > segment.Last {
+			break
+		}
+		if err := fn(key, iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
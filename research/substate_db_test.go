@@ -0,0 +1,102 @@
+package research_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/research"
+	"github.com/ethereum/go-ethereum/research/researchtest"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseSubstateDbArg(t *testing.T) {
+	tests := []struct {
+		arg     string
+		backend string
+		uri     string
+	}{
+		{"substate.ethereum", "leveldb", "substate.ethereum"},
+		{"leveldb,/path/to/db", "leveldb", "/path/to/db"},
+		{"pebble,/path/to/db", "pebble", "/path/to/db"},
+		{"memory,", "memory", ""},
+		{" pebble,/path/to/db", "pebble", "/path/to/db"},
+		// a path with commas is a LevelDB path unless it starts with a backend name
+		{"/path/to/substate,v2", "leveldb", "/path/to/substate,v2"},
+		{"nosuchdb,foo", "leveldb", "nosuchdb,foo"},
+	}
+	for _, tt := range tests {
+		backend, uri := research.ParseSubstateDbArg(tt.arg)
+		if backend != tt.backend || uri != tt.uri {
+			t.Errorf("ParseSubstateDbArg(%q) = (%q, %q), want (%q, %q)", tt.arg, backend, uri, tt.backend, tt.uri)
+		}
+	}
+}
+
+func TestOpenBackendDatabaseCommaPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "substate,v2")
+	db, err := research.OpenSubstateDBBackend(path, false)
+	if err != nil {
+		t.Fatalf("error opening LevelDB path with comma: %v", err)
+	}
+	db.PutSubstate(1, 0, researchtest.NewSubstate(1, nil))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = research.OpenSubstateDBBackend("leveldb,"+path, true)
+	if err != nil {
+		t.Fatalf("error reopening LevelDB path with comma: %v", err)
+	}
+	defer db.Close()
+	if !db.HasSubstate(1, 0) {
+		t.Fatal("substate 1_0 not found")
+	}
+}
+
+func TestSubstateDBBackends(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{
+		"memory,",
+		"leveldb," + filepath.Join(dir, "leveldb"),
+		"pebble," + filepath.Join(dir, "pebble"),
+	}
+	for _, arg := range args {
+		db, err := research.OpenSubstateDBBackend(arg, false)
+		if err != nil {
+			t.Fatalf("%s: error opening substate DB: %v", arg, err)
+		}
+
+		code := []byte{0x60, 0x00, 0x60, 0x00}
+		for block := uint64(1); block <= 3; block++ {
+			for tx := 0; tx < 2; tx++ {
+				db.PutSubstate(block, tx, researchtest.NewSubstate(block, code))
+			}
+		}
+
+		if !db.HasSubstate(2, 1) {
+			t.Fatalf("%s: substate 2_1 not found", arg)
+		}
+		if db.HasSubstate(4, 0) {
+			t.Fatalf("%s: unexpected substate 4_0", arg)
+		}
+		if !db.HasCode(research.CodeHash(code)) {
+			t.Fatalf("%s: code not found", arg)
+		}
+		if got, want := db.GetSubstate(2, 1), researchtest.NewSubstate(2, code); !proto.Equal(got, want) {
+			t.Fatalf("%s: substate mismatch\n got: %v\nwant: %v", arg, got, want)
+		}
+		if n := len(db.GetBlockSubstates(3)); n != 2 {
+			t.Fatalf("%s: GetBlockSubstates(3) returned %v substates, want 2", arg, n)
+		}
+
+		db.DeleteSubstate(2, 1)
+		if db.HasSubstate(2, 1) {
+			t.Fatalf("%s: substate 2_1 is not deleted", arg)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(fmt.Errorf("%s: error closing substate DB: %w", arg, err))
+		}
+	}
+}
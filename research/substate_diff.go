@@ -0,0 +1,313 @@
+package research
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Kinds of SubstateDiffEntry
+const (
+	DiffAdded   = "added"   // only in substate b
+	DiffRemoved = "removed" // only in substate a
+	DiffChanged = "changed" // different values in substates a and b
+)
+
+// SubstateDiffEntry is one difference between substates a and b
+type SubstateDiffEntry struct {
+	// Part is one of inputAlloc, outputAlloc, blockEnv, txMessage, and result
+	Part string `json:"part"`
+	// Path is the location of the difference in Part,
+	// e.g., [0x...].storage[0x...] in alloc, logs[0].topics[1] in result
+	Path string `json:"path"`
+	// Field is the last field name of Path, e.g., account, nonce, balance, code,
+	// storage, gasUsed, status, topics
+	Field string `json:"field"`
+	Kind  string `json:"kind"`
+	A     string `json:"a,omitempty"`
+	B     string `json:"b,omitempty"`
+}
+
+func (e *SubstateDiffEntry) String() string {
+	path := e.Part
+	if strings.HasPrefix(e.Path, "[") {
+		path += e.Path
+	} else if e.Path != "" {
+		path += "." + e.Path
+	}
+	switch e.Kind {
+	case DiffAdded:
+		return fmt.Sprintf("%s: added %s", path, e.B)
+	case DiffRemoved:
+		return fmt.Sprintf("%s: removed %s", path, e.A)
+	default:
+		return fmt.Sprintf("%s: %s -> %s", path, e.A, e.B)
+	}
+}
+
+// SubstateDiff is a list of differences between substates a and b
+type SubstateDiff []*SubstateDiffEntry
+
+// Equal returns true if there is no difference
+func (d SubstateDiff) Equal() bool {
+	return len(d) == 0
+}
+
+// Part returns differences in the given part
+func (d SubstateDiff) Part(part string) SubstateDiff {
+	var r SubstateDiff
+	for _, e := range d {
+		if e.Part == part {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// String renders differences as human-readable text, one difference per line
+func (d SubstateDiff) String() string {
+	sb := &strings.Builder{}
+	for _, e := range d {
+		sb.WriteString(e.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// JSON renders differences as a JSON array
+func (d SubstateDiff) JSON() ([]byte, error) {
+	if d == nil {
+		d = SubstateDiff{}
+	}
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// DiffSubstate returns precise differences between substates a and b,
+// e.g., recorded and replayed substates. Accounts are compared by address and
+// storage slots by key, and bytecode is compared by code hash so that hashed
+// and unhashed substates can be compared.
+func DiffSubstate(a, b *Substate) SubstateDiff {
+	var d SubstateDiff
+	d = append(d, DiffAlloc("inputAlloc", a.GetInputAlloc(), b.GetInputAlloc())...)
+	d = append(d, DiffAlloc("outputAlloc", a.GetOutputAlloc(), b.GetOutputAlloc())...)
+	d = append(d, diffMessage("blockEnv", "", a.GetBlockEnv().ProtoReflect(), b.GetBlockEnv().ProtoReflect())...)
+	d = append(d, diffMessage("txMessage", "", a.GetTxMessage().ProtoReflect(), b.GetTxMessage().ProtoReflect())...)
+	d = append(d, diffMessage("result", "", a.GetResult().ProtoReflect(), b.GetResult().ProtoReflect())...)
+	return d
+}
+
+func allocMap(alloc *Substate_Alloc) map[common.Address]*Substate_Account {
+	m := make(map[common.Address]*Substate_Account)
+	for _, entry := range alloc.GetAlloc() {
+		m[common.BytesToAddress(entry.Address)] = entry.Account
+	}
+	return m
+}
+
+func storageMap(account *Substate_Account) map[common.Hash]common.Hash {
+	m := make(map[common.Hash]common.Hash)
+	for _, entry := range account.GetStorage() {
+		m[common.BytesToHash(entry.Key)] = common.BytesToHash(entry.Value)
+	}
+	return m
+}
+
+// accountCodeHash returns code hash of both unhashed and hashed accounts
+func accountCodeHash(account *Substate_Account) common.Hash {
+	switch contract := account.GetContract().(type) {
+	case *Substate_Account_Code:
+		return CodeHash(contract.Code)
+	case *Substate_Account_CodeHash:
+		return common.BytesToHash(contract.CodeHash)
+	}
+	return CodeHash(nil)
+}
+
+func accountString(account *Substate_Account) string {
+	return fmt.Sprintf("{nonce: %v, balance: %v, codeHash: %v, storage: %v slots}",
+		account.GetNonce(), new(big.Int).SetBytes(account.GetBalance()), accountCodeHash(account).Hex(), len(account.GetStorage()))
+}
+
+// DiffAlloc returns differences of accounts between alloc a and b
+func DiffAlloc(part string, a, b *Substate_Alloc) SubstateDiff {
+	var d SubstateDiff
+
+	am, bm := allocMap(a), allocMap(b)
+	addrs := make([]common.Address, 0, len(am)+len(bm))
+	for addr := range am {
+		addrs = append(addrs, addr)
+	}
+	for addr := range bm {
+		if _, ok := am[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Cmp(addrs[j]) < 0 })
+
+	for _, addr := range addrs {
+		path := fmt.Sprintf("[%s]", addr.Hex())
+		aa, bb := am[addr], bm[addr]
+		switch {
+		case bb == nil:
+			d = append(d, &SubstateDiffEntry{Part: part, Path: path, Field: "account", Kind: DiffRemoved, A: accountString(aa)})
+			continue
+		case aa == nil:
+			d = append(d, &SubstateDiffEntry{Part: part, Path: path, Field: "account", Kind: DiffAdded, B: accountString(bb)})
+			continue
+		}
+
+		if aa.GetNonce() != bb.GetNonce() {
+			d = append(d, &SubstateDiffEntry{Part: part, Path: path + ".nonce", Field: "nonce", Kind: DiffChanged,
+				A: fmt.Sprint(aa.GetNonce()), B: fmt.Sprint(bb.GetNonce())})
+		}
+		if ab, bb := new(big.Int).SetBytes(aa.GetBalance()), new(big.Int).SetBytes(bb.GetBalance()); ab.Cmp(bb) != 0 {
+			d = append(d, &SubstateDiffEntry{Part: part, Path: path + ".balance", Field: "balance", Kind: DiffChanged,
+				A: ab.String(), B: bb.String()})
+		}
+		if ah, bh := accountCodeHash(aa), accountCodeHash(bb); ah != bh {
+			d = append(d, &SubstateDiffEntry{Part: part, Path: path + ".code", Field: "code", Kind: DiffChanged,
+				A: ah.Hex(), B: bh.Hex()})
+		}
+
+		as, bs := storageMap(aa), storageMap(bb)
+		keys := make([]common.Hash, 0, len(as)+len(bs))
+		for k := range as {
+			keys = append(keys, k)
+		}
+		for k := range bs {
+			if _, ok := as[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Cmp(keys[j]) < 0 })
+		for _, k := range keys {
+			av, aok := as[k]
+			bv, bok := bs[k]
+			e := &SubstateDiffEntry{Part: part, Path: fmt.Sprintf("%s.storage[%s]", path, k.Hex()), Field: "storage"}
+			switch {
+			case !bok:
+				e.Kind, e.A = DiffRemoved, av.Hex()
+			case !aok:
+				e.Kind, e.B = DiffAdded, bv.Hex()
+			case av != bv:
+				e.Kind, e.A, e.B = DiffChanged, av.Hex(), bv.Hex()
+			default:
+				continue
+			}
+			d = append(d, e)
+		}
+	}
+
+	return d
+}
+
+// isBytesValue returns whether the message is google.protobuf.BytesValue
+func isBytesValue(m protoreflect.Message) bool {
+	return m.Descriptor().FullName() == "google.protobuf.BytesValue"
+}
+
+// scalarEqual compares values of a non-message field
+func scalarEqual(fd protoreflect.FieldDescriptor, a, b protoreflect.Value) bool {
+	if fd.Kind() == protoreflect.BytesKind {
+		return bytes.Equal(a.Bytes(), b.Bytes())
+	}
+	return a.Interface() == b.Interface()
+}
+
+// valueString renders a scalar field value, bytes in hex
+func valueString(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.BytesKind:
+		return hexutil.Encode(v.Bytes())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return fmt.Sprint(v.Enum())
+	case protoreflect.MessageKind:
+		if m := v.Message(); isBytesValue(m) {
+			return hexutil.Encode(m.Get(m.Descriptor().Fields().ByName("value")).Bytes())
+		}
+	}
+	return v.String()
+}
+
+// diffMessage returns differences of fields between messages a and b
+func diffMessage(part, path string, a, b protoreflect.Message) SubstateDiff {
+	var d SubstateDiff
+
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	fields := a.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := fd.JSONName()
+		fpath := join(name)
+		ahas, bhas := a.IsValid() && a.Has(fd), b.IsValid() && b.Has(fd)
+		if !ahas && !bhas {
+			continue
+		}
+
+		if fd.IsList() {
+			al, bl := a.Get(fd).List(), b.Get(fd).List()
+			for j := 0; j < al.Len() || j < bl.Len(); j++ {
+				epath := fmt.Sprintf("%s[%d]", fpath, j)
+				switch {
+				case j >= bl.Len():
+					if fd.Kind() == protoreflect.MessageKind {
+						d = append(d, diffMessage(part, epath, al.Get(j).Message(), al.Get(j).Message().Type().Zero())...)
+					} else {
+						d = append(d, &SubstateDiffEntry{Part: part, Path: epath, Field: name, Kind: DiffRemoved, A: valueString(fd, al.Get(j))})
+					}
+				case j >= al.Len():
+					if fd.Kind() == protoreflect.MessageKind {
+						d = append(d, diffMessage(part, epath, bl.Get(j).Message().Type().Zero(), bl.Get(j).Message())...)
+					} else {
+						d = append(d, &SubstateDiffEntry{Part: part, Path: epath, Field: name, Kind: DiffAdded, B: valueString(fd, bl.Get(j))})
+					}
+				case fd.Kind() == protoreflect.MessageKind:
+					d = append(d, diffMessage(part, epath, al.Get(j).Message(), bl.Get(j).Message())...)
+				case !scalarEqual(fd, al.Get(j), bl.Get(j)):
+					d = append(d, &SubstateDiffEntry{Part: part, Path: epath, Field: name, Kind: DiffChanged,
+						A: valueString(fd, al.Get(j)), B: valueString(fd, bl.Get(j))})
+				}
+			}
+			continue
+		}
+
+		if fd.Kind() == protoreflect.MessageKind && !isBytesValue(a.Get(fd).Message()) {
+			d = append(d, diffMessage(part, fpath, a.Get(fd).Message(), b.Get(fd).Message())...)
+			continue
+		}
+
+		switch {
+		case !bhas:
+			d = append(d, &SubstateDiffEntry{Part: part, Path: fpath, Field: name, Kind: DiffRemoved, A: valueString(fd, a.Get(fd))})
+		case !ahas:
+			d = append(d, &SubstateDiffEntry{Part: part, Path: fpath, Field: name, Kind: DiffAdded, B: valueString(fd, b.Get(fd))})
+		case fd.Kind() == protoreflect.MessageKind:
+			if !proto.Equal(a.Get(fd).Message().Interface(), b.Get(fd).Message().Interface()) {
+				d = append(d, &SubstateDiffEntry{Part: part, Path: fpath, Field: name, Kind: DiffChanged,
+					A: valueString(fd, a.Get(fd)), B: valueString(fd, b.Get(fd))})
+			}
+		case !scalarEqual(fd, a.Get(fd), b.Get(fd)):
+			d = append(d, &SubstateDiffEntry{Part: part, Path: fpath, Field: name, Kind: DiffChanged,
+				A: valueString(fd, a.Get(fd)), B: valueString(fd, b.Get(fd))})
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,130 @@
+package research
+
+import (
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestDiffSubstate(t *testing.T) {
+	// a call from 0x01 to contract 0x02 which writes a storage slot
+	a := &Substate{
+		InputAlloc: &Substate_Alloc{
+			Alloc: []*Substate_AllocEntry{
+				{Address: []byte{0x01}, Account: &Substate_Account{Nonce: proto.Uint64(1), Balance: []byte{0x10}}},
+				{Address: []byte{0x02}, Account: &Substate_Account{
+					Nonce:    proto.Uint64(0),
+					Balance:  []byte{},
+					Storage:  []*Substate_Account_StorageEntry{{Key: []byte{0x01}, Value: []byte{0x02}}},
+					Contract: &Substate_Account_Code{Code: []byte{0x60, 0x00}},
+				}},
+			},
+		},
+		OutputAlloc: &Substate_Alloc{
+			Alloc: []*Substate_AllocEntry{
+				{Address: []byte{0x01}, Account: &Substate_Account{Nonce: proto.Uint64(2), Balance: []byte{0x0f}}},
+				{Address: []byte{0x02}, Account: &Substate_Account{
+					Nonce:    proto.Uint64(0),
+					Balance:  []byte{0x01},
+					Storage:  []*Substate_Account_StorageEntry{{Key: []byte{0x01}, Value: []byte{0x03}}},
+					Contract: &Substate_Account_Code{Code: []byte{0x60, 0x00}},
+				}},
+			},
+		},
+		BlockEnv: &Substate_BlockEnv{
+			Coinbase:   []byte{0x03},
+			Difficulty: []byte{},
+			GasLimit:   proto.Uint64(30_000_000),
+			Number:     proto.Uint64(1),
+			Timestamp:  proto.Uint64(1),
+		},
+		TxMessage: &Substate_TxMessage{
+			Nonce:    proto.Uint64(1),
+			GasPrice: []byte{0x01},
+			Gas:      proto.Uint64(50_000),
+			From:     []byte{0x01},
+			To:       wrapperspb.Bytes([]byte{0x02}),
+			Value:    []byte{0x01},
+			Input:    &Substate_TxMessage_Data{Data: []byte{}},
+			TxType:   Substate_TxMessage_TXTYPE_LEGACY.Enum(),
+		},
+		Result: &Substate_Result{
+			Status:  proto.Uint64(1),
+			Bloom:   make([]byte, 256),
+			GasUsed: proto.Uint64(21_000),
+		},
+	}
+
+	if d := DiffSubstate(a, a.ProtoClone()); !d.Equal() {
+		t.Fatalf("unexpected differences of equal substates:\n%s", d)
+	}
+	// hashed and unhashed substates have the same code hashes
+	if d := DiffSubstate(a, a.HashedCopy()); !d.Equal() {
+		t.Fatalf("unexpected differences of hashed substate:\n%s", d)
+	}
+
+	b := a.ProtoClone()
+	b.OutputAlloc.Alloc[0].Account.Nonce = proto.Uint64(3)
+	b.OutputAlloc.Alloc[0].Account.Balance = []byte{0x00, 0x0f} // leading zero
+	b.OutputAlloc.Alloc[1].Account.Storage[0].Value = []byte{0x04}
+	b.OutputAlloc.Alloc[1].Account.Storage = append(b.OutputAlloc.Alloc[1].Account.Storage,
+		&Substate_Account_StorageEntry{Key: []byte{0x02}, Value: []byte{0x05}})
+	b.OutputAlloc.Alloc[1].Account.Contract = &Substate_Account_Code{Code: []byte{0x00}}
+	b.OutputAlloc.Alloc = append(b.OutputAlloc.Alloc, &Substate_AllocEntry{
+		Address: []byte{0x04},
+		Account: &Substate_Account{Nonce: proto.Uint64(0), Balance: []byte{0x01}},
+	})
+	b.BlockEnv.BaseFee = wrapperspb.Bytes([]byte{0x07})
+	b.Result.Status = proto.Uint64(0)
+	b.Result.GasUsed = proto.Uint64(21_001)
+	b.Result.Logs = []*Substate_Result_Log{
+		{Address: []byte{0x02}, Topics: [][]byte{{0x01}}, Data: []byte{0x02}},
+	}
+	a.Result.Logs = []*Substate_Result_Log{
+		{Address: []byte{0x02}, Topics: [][]byte{{0x01}, {0x02}}, Data: []byte{0x03}},
+	}
+
+	d := DiffSubstate(a, b)
+	want := []struct {
+		part, field, kind string
+	}{
+		{"outputAlloc", "nonce", DiffChanged},
+		{"outputAlloc", "code", DiffChanged},
+		{"outputAlloc", "storage", DiffChanged},
+		{"outputAlloc", "storage", DiffAdded},
+		{"outputAlloc", "account", DiffAdded},
+		{"blockEnv", "baseFee", DiffAdded},
+		{"result", "status", DiffChanged},
+		{"result", "topics", DiffRemoved},
+		{"result", "data", DiffChanged},
+		{"result", "gasUsed", DiffChanged},
+	}
+	if len(d) != len(want) {
+		t.Fatalf("got %v differences, want %v:\n%s", len(d), len(want), d)
+	}
+	for i, w := range want {
+		if d[i].Part != w.part || d[i].Field != w.field || d[i].Kind != w.kind {
+			t.Errorf("difference %v: got %s %s %s, want %s %s %s", i, d[i].Part, d[i].Field, d[i].Kind, w.part, w.field, w.kind)
+		}
+	}
+	if got := d[0].String(); got != "outputAlloc[0x0000000000000000000000000000000000000001].nonce: 2 -> 3" {
+		t.Errorf("unexpected text: %s", got)
+	}
+	if got := d[7].String(); got != "result.logs[0].topics[1]: removed 0x02" {
+		t.Errorf("unexpected text: %s", got)
+	}
+
+	b2, err := d.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []*SubstateDiffEntry
+	if err := json.Unmarshal(b2, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(d) || *entries[9] != *d[9] {
+		t.Fatalf("JSON roundtrip mismatch: %s", b2)
+	}
+}
@@ -7,20 +7,26 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	cli "github.com/urfave/cli/v2"
 )
 
 var (
-	SubstateDirFlag = &cli.PathFlag{
-		Name: "substatedir",
+	SubstateDbFlag = &cli.StringFlag{
+		Name: "substate-db",
 		Aliases: []string{
-			"substate-db",
+			"substatedir",
 		},
-		Usage: "Data directory for substate recorder/replayer",
+		Usage: "Substate DB for substate recorder/replayer in \"backend,URI\" format (e.g., \"pebble,/path/to/db\", \"memory,\"), a path without backend is a LevelDB path",
 		Value: "substate.ethereum",
 	}
-	substateDir      = SubstateDirFlag.Value
+	// SubstateDirFlag is the former name of SubstateDbFlag.
+	//
+	// Deprecated: use SubstateDbFlag.
+	SubstateDirFlag = SubstateDbFlag
+
+	substateDb       = SubstateDbFlag.Value
 	staticSubstateDB *SubstateDB
 
 	AsyncDbWriteFlag = &cli.BoolFlag{
@@ -63,6 +69,46 @@ var (
 		Name:  "tx-list",
 		Usage: "Path of txt file with block numbers (e.g., 1001) and/or tx indexes (e.g., 1001_0 or 1001,1) to replay",
 	}
+	CheckpointFlag = &cli.PathFlag{
+		Name:  "checkpoint",
+		Usage: "Path of JSON file to periodically save the last contiguously completed block of the block segment",
+	}
+	ResumeFlag = &cli.BoolFlag{
+		Name:  "resume",
+		Usage: "Resume the block segment from --checkpoint file",
+	}
+)
+
+var (
+	CoordinatorFlag = &cli.StringFlag{
+		Name:  "coordinator",
+		Usage: "Listening address (e.g., 0.0.0.0:8646) to coordinate workers joining with --join instead of executing the block segment",
+	}
+	JoinFlag = &cli.StringFlag{
+		Name:  "join",
+		Usage: "Coordinator URL (e.g., host:8646) to request block ranges from instead of --block-segment",
+	}
+	RangeSizeFlag = &cli.Uint64Flag{
+		Name:  "range-size",
+		Usage: "Number of blocks in a block range leased to a worker by coordinator",
+		Value: 10000,
+	}
+	LeaseTimeoutFlag = &cli.DurationFlag{
+		Name:  "lease-timeout",
+		Usage: "Coordinator re-queues a block range if its worker sends no heartbeat within the timeout (at least 3s)",
+		Value: 1 * time.Minute,
+	}
+)
+
+var (
+	ChainFlag = &cli.StringFlag{
+		Name:  "chain",
+		Usage: "Chain config of substates: mainnet, sepolia, holesky or goerli (default: chain config recorded in substate DB, otherwise mainnet)",
+	}
+	GenesisFlag = &cli.PathFlag{
+		Name:  "genesis",
+		Usage: "Genesis JSON file with chain config of substates (e.g., private networks)",
+	}
 )
 
 type BlockSegment struct {
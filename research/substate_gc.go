@@ -0,0 +1,144 @@
+package research
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"google.golang.org/protobuf/proto"
+)
+
+// hashedMessage is a hashed substate or block substate referencing bytecodes
+// by code hashes
+type hashedMessage interface {
+	proto.Message
+	HashKeys() map[common.Hash]struct{}
+}
+
+// ReferencedCodeHashes returns code hashes referenced by all substates and
+// block substates of substate DB. It returns an error if any of them cannot
+// be decoded because its references are unknown.
+func (db *SubstateDB) ReferencedCodeHashes() (map[common.Hash]struct{}, error) {
+	z := make(map[common.Hash]struct{})
+	for _, prefix := range []string{Stage1SubstatePrefix, Stage1BlockPrefix} {
+		iter := db.backend.NewIterator([]byte(prefix), nil)
+		for iter.Next() {
+			var m hashedMessage = &Substate{}
+			if prefix == Stage1BlockPrefix {
+				m = &BlockSubstate{}
+			}
+			// references of values without required fields are still known
+			if err := (proto.UnmarshalOptions{AllowPartial: true}).Unmarshal(iter.Value(), m); err != nil {
+				err = fmt.Errorf("error decoding value of key %#x: %w", iter.Key(), err)
+				iter.Release()
+				return nil, err
+			}
+			for codeHash := range m.HashKeys() {
+				z[codeHash] = struct{}{}
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, fmt.Errorf("error iterating %q: %w", prefix, err)
+		}
+	}
+	return z, nil
+}
+
+// CodeGCResult is the result of SubstateDB.CollectCodeGarbage
+type CodeGCResult struct {
+	NumCodes       int                // number of bytecodes before garbage collection
+	NumOrphans     int                // number of bytecodes not referenced by any substate
+	ReclaimedBytes common.StorageSize // key-value bytes of orphaned bytecodes
+}
+
+// CollectCodeGarbage marks bytecodes referenced by HashKeys of all substates
+// and block substates, and sweeps the other bytecodes. If dryRun is true,
+// orphaned bytecodes are only counted without being deleted. Substates must
+// not be put into substate DB during garbage collection.
+func (db *SubstateDB) CollectCodeGarbage(dryRun bool) (*CodeGCResult, error) {
+	referenced, err := db.ReferencedCodeHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CodeGCResult{}
+	batch := db.backend.NewBatch()
+	iter := db.backend.NewIterator([]byte(Stage1CodePrefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		result.NumCodes++
+		codeHash, err := DecodeStage1CodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := referenced[codeHash]; ok {
+			continue
+		}
+		result.NumOrphans++
+		result.ReclaimedBytes += common.StorageSize(len(key) + len(iter.Value()))
+		if dryRun {
+			continue
+		}
+		if err := batch.Delete(common.CopyBytes(key)); err != nil {
+			return nil, err
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return nil, fmt.Errorf("error deleting bytecodes: %w", err)
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("error iterating bytecodes: %w", err)
+	}
+	if err := batch.Write(); err != nil {
+		return nil, fmt.Errorf("error deleting bytecodes: %w", err)
+	}
+
+	return result, nil
+}
+
+// DeleteSegment deletes substates, block substates and call traces of blocks
+// in the segment, removes the blocks from the recorded ranges of metadata, and
+// returns the number of deleted keys. Bytecodes are kept like
+// DeleteBlockSubstates, CollectCodeGarbage deletes bytecodes no longer referenced.
+func (db *SubstateDB) DeleteSegment(segment *BlockSegment) (int, error) {
+	n := 0
+	// the block index is written with the last batch, a crash before it leaves
+	// deleted blocks in the block index but never misses remaining blocks
+	err := db.updateBlockIndex(func(idx *blockIndex, batch ethdb.Batch) error {
+		idx.remove(segment, db.countSubstates)
+		for _, prefix := range []string{Stage1SubstatePrefix, Stage1BlockPrefix, Stage1TracePrefix} {
+			err := db.iterateSegment(prefix, segment, func(key, value []byte) error {
+				n++
+				if err := batch.Delete(common.CopyBytes(key)); err != nil {
+					return err
+				}
+				if batch.ValueSize() >= ethdb.IdealBatchSize {
+					if err := batch.Write(); err != nil {
+						return err
+					}
+					batch.Reset()
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("error deleting %q keys: %w", prefix, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	if m := db.GetMetadata(); m != nil {
+		m.RemoveRange(segment.First, segment.Last)
+		db.PutMetadata(m)
+	}
+
+	return n, nil
+}
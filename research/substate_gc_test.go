@@ -0,0 +1,78 @@
+package research
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+func TestCollectCodeGarbage(t *testing.T) {
+	db := NewSubstateDB(rawdb.NewMemoryDatabase())
+	defer db.Close()
+
+	code1, code2, code3 := []byte{0x60, 0x01}, []byte{0x60, 0x02}, []byte{0x60, 0x03}
+	db.PutSubstate(1, 0, callSubstate(1, code1))
+	db.PutSubstate(2, 0, callSubstate(2, code2))
+	db.PutSubstate(2, 1, callSubstate(2, code2))
+	db.PutBlockSubstate(3, newTestBlockSubstate(3, code3))
+	db.PutTxTrace(2, 0, newTestTxTrace())
+	m := &SubstateMetadata{}
+	m.AddRange(1, 3)
+	db.PutMetadata(m)
+
+	// nothing to collect before deletion
+	result, err := db.CollectCodeGarbage(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NumCodes != 3 || result.NumOrphans != 0 || result.ReclaimedBytes != 0 {
+		t.Fatalf("unexpected result before deletion: %+v", result)
+	}
+
+	n, err := db.DeleteSegment(NewBlockSegment(2, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("%v keys deleted, want 4", n)
+	}
+	if db.HasSubstate(2, 0) || db.HasSubstate(2, 1) || db.HasBlockSubstate(3) || db.HasTxTrace(2, 0) {
+		t.Fatal("substates of deleted blocks remain")
+	}
+	if !db.HasSubstate(1, 0) {
+		t.Fatal("substate of block 1 is deleted")
+	}
+	if s := rangesString(db.GetMetadata().Ranges); s != "1-1," {
+		t.Fatalf("ranges = %s, want 1-1,", s)
+	}
+
+	// dry run only counts orphaned bytecodes
+	wantBytes := 2 * (len(Stage1CodeKey(CodeHash(code2))) + 2)
+	result, err = db.CollectCodeGarbage(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NumOrphans != 2 || int(result.ReclaimedBytes) != wantBytes {
+		t.Fatalf("unexpected result of dry run: %+v", result)
+	}
+	if !db.HasCode(CodeHash(code2)) || !db.HasCode(CodeHash(code3)) {
+		t.Fatal("bytecodes deleted by dry run")
+	}
+
+	result, err = db.CollectCodeGarbage(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NumCodes != 3 || result.NumOrphans != 2 || int(result.ReclaimedBytes) != wantBytes {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if !db.HasCode(CodeHash(code1)) || db.HasCode(CodeHash(code2)) || db.HasCode(CodeHash(code3)) {
+		t.Fatal("wrong bytecodes collected")
+	}
+
+	// undecodable substates stop garbage collection
+	db.backend.Put(Stage1SubstateKey(4, 0), []byte{0xff})
+	if _, err := db.CollectCodeGarbage(false); err == nil {
+		t.Fatal("garbage collected with undecodable substate")
+	}
+}
@@ -0,0 +1,476 @@
+package research
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// blockIndexRange is a run of consecutive blocks with substates or block
+// substates, and the number of substates of the blocks
+type blockIndexRange struct {
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+	Txs   int64  `json:"txs"`
+}
+
+// blockIndex is sorted and non-adjacent runs of recorded blocks, which is
+// updated with substates and block substates. A block index may have blocks
+// whose keys were partially deleted before a crash, but never misses a block
+// with substates or block substates.
+type blockIndex struct {
+	Ranges []*blockIndexRange `json:"ranges"`
+
+	// substates of blocks added to the block index, which are tracked until
+	// the block index is written twice without them being updated. Other
+	// blocks may have substates not known here.
+	txs, oldTxs map[uint64]map[int]struct{}
+}
+
+func newBlockIndex() *blockIndex {
+	return &blockIndex{txs: make(map[uint64]map[int]struct{})}
+}
+
+// find returns the index of the first range with Last >= block, and true if
+// the range has the block
+func (idx *blockIndex) find(block uint64) (int, bool) {
+	i := sort.Search(len(idx.Ranges), func(i int) bool { return idx.Ranges[i].Last >= block })
+	return i, i < len(idx.Ranges) && idx.Ranges[i].First <= block
+}
+
+// add adds a block with txs more substates to the block index, or removes
+// substates of the block if txs is negative
+func (idx *blockIndex) add(block uint64, txs int64) {
+	if idx == nil {
+		return
+	}
+	i, ok := idx.find(block)
+	if ok {
+		idx.Ranges[i].Txs += txs
+		return
+	}
+	if txs < 0 {
+		return
+	}
+
+	// a block not in the block index has no substates yet
+	idx.txs[block] = make(map[int]struct{})
+
+	r := &blockIndexRange{First: block, Last: block, Txs: txs}
+	idx.Ranges = append(idx.Ranges, nil)
+	copy(idx.Ranges[i+1:], idx.Ranges[i:])
+	idx.Ranges[i] = r
+	// merge with adjacent ranges
+	if next := i + 1; next < len(idx.Ranges) && idx.Ranges[next].First == block+1 {
+		r.Last = idx.Ranges[next].Last
+		r.Txs += idx.Ranges[next].Txs
+		idx.Ranges = append(idx.Ranges[:next], idx.Ranges[next+1:]...)
+	}
+	if prev := i - 1; prev >= 0 && idx.Ranges[prev].Last+1 == block {
+		idx.Ranges[prev].Last = r.Last
+		idx.Ranges[prev].Txs += r.Txs
+		idx.Ranges = append(idx.Ranges[:i], idx.Ranges[i+1:]...)
+	}
+}
+
+// addTx adds substate tx of the block to the block index if it is new. It
+// returns false without adding it if the block index doesn't know whether the
+// block has the substate.
+func (idx *blockIndex) addTx(block uint64, tx int) bool {
+	if idx == nil {
+		return true
+	}
+	if _, ok := idx.find(block); !ok {
+		idx.add(block, 1)
+		idx.txs[block][tx] = struct{}{}
+		return true
+	}
+	txs, ok := idx.tracked(block)
+	if !ok {
+		return false
+	}
+	if _, ok := txs[tx]; !ok {
+		txs[tx] = struct{}{}
+		idx.add(block, 1)
+	}
+	return true
+}
+
+// removeTx removes substate tx of the block from the block index
+func (idx *blockIndex) removeTx(block uint64, tx int) {
+	if idx == nil {
+		return
+	}
+	idx.add(block, -1)
+	if txs, ok := idx.tracked(block); ok {
+		delete(txs, tx)
+	}
+}
+
+// tracked returns substates of the block if they are tracked
+func (idx *blockIndex) tracked(block uint64) (map[int]struct{}, bool) {
+	if txs, ok := idx.txs[block]; ok {
+		return txs, true
+	}
+	txs, ok := idx.oldTxs[block]
+	if ok {
+		delete(idx.oldTxs, block)
+		idx.txs[block] = txs
+	}
+	return txs, ok
+}
+
+// age stops tracking substates of blocks not updated since the last age, it
+// is called when the block index is written
+func (idx *blockIndex) age() {
+	idx.oldTxs, idx.txs = idx.txs, make(map[uint64]map[int]struct{})
+}
+
+// remove removes blocks of the segment from the block index. count returns the
+// number of substates of a block segment, which is called before substates of
+// the segment are deleted.
+func (idx *blockIndex) remove(segment *BlockSegment, count func(*BlockSegment) int64) {
+	if idx == nil {
+		return
+	}
+	for _, txs := range []map[uint64]map[int]struct{}{idx.txs, idx.oldTxs} {
+		for block := range txs {
+			if segment.First <= block && block <= segment.Last {
+				delete(txs, block)
+			}
+		}
+	}
+	ranges := make([]*blockIndexRange, 0, len(idx.Ranges)+1)
+	for _, r := range idx.Ranges {
+		if r.Last < segment.First || r.First > segment.Last {
+			ranges = append(ranges, r)
+			continue
+		}
+		var left, right *blockIndexRange
+		if r.First < segment.First {
+			left = &blockIndexRange{First: r.First, Last: segment.First - 1}
+		}
+		if r.Last > segment.Last {
+			right = &blockIndexRange{First: segment.Last + 1, Last: r.Last}
+		}
+		if left == nil && right == nil {
+			continue
+		}
+
+		// count the deleted blocks, and the smaller one of the kept blocks
+		// if the range is split
+		first, last := segment.First, segment.Last
+		if left == nil {
+			first = r.First
+		}
+		if right == nil {
+			last = r.Last
+		}
+		kept := r.Txs - count(NewBlockSegment(first, last))
+		switch {
+		case right == nil:
+			left.Txs = kept
+		case left == nil:
+			right.Txs = kept
+		case left.Last-left.First <= right.Last-right.First:
+			left.Txs = count(NewBlockSegment(left.First, left.Last))
+			right.Txs = kept - left.Txs
+		default:
+			right.Txs = count(NewBlockSegment(right.First, right.Last))
+			left.Txs = kept - right.Txs
+		}
+		for _, kr := range []*blockIndexRange{left, right} {
+			if kr != nil {
+				ranges = append(ranges, kr)
+			}
+		}
+	}
+	idx.Ranges = ranges
+}
+
+// countSubstates returns the number of substate keys of blocks in the segment
+func (db *SubstateDB) countSubstates(segment *BlockSegment) int64 {
+	var n int64
+	err := db.iterateSegment(Stage1SubstatePrefix, segment, func(key, value []byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error counting substates of blocks %v-%v: %v", segment.First, segment.Last, err))
+	}
+	return n
+}
+
+// scanBlockIndex builds the block index from substate and block substate keys
+func (db *SubstateDB) scanBlockIndex() *blockIndex {
+	idx := newBlockIndex()
+	for _, prefix := range []string{Stage1SubstatePrefix, Stage1BlockPrefix} {
+		txs := int64(0)
+		if prefix == Stage1SubstatePrefix {
+			txs = 1
+		}
+		iter := db.backend.NewIterator([]byte(prefix), nil)
+		for iter.Next() {
+			key := iter.Key()
+			if len(key) >= len(prefix)+8 {
+				idx.add(binary.BigEndian.Uint64(key[len(prefix):]), txs)
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			panic(fmt.Errorf("record-replay: error building block index: %v", err))
+		}
+	}
+	// substates of the scanned blocks are not tracked
+	idx.txs = make(map[uint64]map[int]struct{})
+	return idx
+}
+
+// blockIndex returns the block index read from substate DB, an empty block
+// index if substate DB has no substates and block substates yet, or nil if
+// substate DB was recorded without a block index. The caller must hold indexMu.
+func (db *SubstateDB) blockIndex() *blockIndex {
+	if db.indexLoaded {
+		return db.index
+	}
+	db.indexLoaded = true
+
+	key := []byte(Stage1BlockIndexKey)
+	if has, _ := db.backend.Has(key); !has {
+		empty := true
+		for _, prefix := range []string{Stage1SubstatePrefix, Stage1BlockPrefix} {
+			iter := db.backend.NewIterator([]byte(prefix), nil)
+			empty = empty && !iter.Next()
+			iter.Release()
+		}
+		if empty {
+			db.index = newBlockIndex()
+		}
+		return db.index
+	}
+
+	b, err := db.backend.Get(key)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error getting block index from substate DB: %v", err))
+	}
+	idx := newBlockIndex()
+	if err := json.Unmarshal(b, idx); err != nil {
+		panic(fmt.Errorf("record-replay: error decoding block index: %v", err))
+	}
+	db.index, db.indexFlushed = idx, time.Now()
+	return idx
+}
+
+// blockIndexFlushInterval is the interval to write the block index updated in
+// memory with substates and block substates
+const blockIndexFlushInterval = time.Minute
+
+// updateBlockIndex calls update with a batch and the block index, which is nil
+// if substate DB has no block index, and writes the batch. The block index is
+// updated in memory under indexMu, and the batch is written outside it unless
+// the block index is written with the batch at most once per
+// blockIndexFlushInterval when no other batch is being written. Otherwise the
+// stored block index is deleted with batches until one of them is written, so
+// substate DB has no block index after a crash rather than one missing blocks.
+func (db *SubstateDB) updateBlockIndex(update func(idx *blockIndex, batch ethdb.Batch) error) error {
+	batch := db.backend.NewBatch()
+
+	db.indexMu.Lock()
+	idx := db.blockIndex()
+	if err := update(idx, batch); err != nil {
+		db.invalidateBlockIndex()
+		db.indexMu.Unlock()
+		return err
+	}
+	if idx == nil {
+		db.indexMu.Unlock()
+		return batch.Write()
+	}
+	if db.indexWriting == 0 && time.Since(db.indexFlushed) >= blockIndexFlushInterval {
+		defer db.indexMu.Unlock()
+		err := db.writeBlockIndex(batch)
+		if err == nil {
+			err = batch.Write()
+		}
+		if err != nil {
+			db.invalidateBlockIndex()
+			return err
+		}
+		db.indexDirty, db.indexFlushed = false, time.Now()
+		idx.age()
+		return nil
+	}
+	deleting := !db.indexDirty
+	if deleting {
+		if err := batch.Delete([]byte(Stage1BlockIndexKey)); err != nil {
+			db.invalidateBlockIndex()
+			db.indexMu.Unlock()
+			return err
+		}
+	}
+	db.indexWriting++
+	db.indexMu.Unlock()
+
+	err := batch.Write()
+
+	db.indexMu.Lock()
+	defer db.indexMu.Unlock()
+	db.indexWriting--
+	db.indexWritten.Broadcast()
+	if err != nil {
+		db.invalidateBlockIndex()
+		return err
+	}
+	if deleting {
+		db.indexDirty = true
+	}
+	return nil
+}
+
+// invalidateBlockIndex drops the block index which is no longer the same as
+// keys in substate DB after an error, and deletes the stored block index, so
+// substate DB has no block index until BuildBlockIndex even if other batches
+// being written did not delete it. The caller must hold indexMu.
+func (db *SubstateDB) invalidateBlockIndex() {
+	if db.index == nil {
+		return
+	}
+	db.index = nil
+	if err := db.backend.Delete([]byte(Stage1BlockIndexKey)); err != nil {
+		fmt.Printf("record-replay: error deleting block index from substate DB: %v\n", err)
+	}
+}
+
+// writeBlockIndex puts the block index into the batch. The caller must hold
+// indexMu.
+func (db *SubstateDB) writeBlockIndex(batch ethdb.KeyValueWriter) error {
+	b, err := json.Marshal(db.index)
+	if err != nil {
+		return err
+	}
+	return batch.Put([]byte(Stage1BlockIndexKey), b)
+}
+
+// FlushBlockIndex writes the block index updated in memory after batches being
+// written by other goroutines, Close calls it too
+func (db *SubstateDB) FlushBlockIndex() error {
+	db.indexMu.Lock()
+	defer db.indexMu.Unlock()
+
+	for db.indexWriting > 0 {
+		db.indexWritten.Wait()
+	}
+	if !db.indexDirty || db.index == nil {
+		return nil
+	}
+	if err := db.writeBlockIndex(db.backend); err != nil {
+		return fmt.Errorf("error putting block index into substate DB: %w", err)
+	}
+	db.indexDirty, db.indexFlushed = false, time.Now()
+	db.index.age()
+	return nil
+}
+
+// BuildBlockIndex builds and stores the block index from all substate and
+// block substate keys, e.g., of substate DB recorded without a block index
+func (db *SubstateDB) BuildBlockIndex() {
+	db.indexMu.Lock()
+	defer db.indexMu.Unlock()
+
+	for db.indexWriting > 0 {
+		db.indexWritten.Wait()
+	}
+	idx := db.scanBlockIndex()
+	b, err := json.Marshal(idx)
+	if err == nil {
+		err = db.backend.Put([]byte(Stage1BlockIndexKey), b)
+	}
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error putting block index into substate DB: %v", err))
+	}
+	db.index, db.indexLoaded = idx, true
+	db.indexDirty, db.indexFlushed = false, time.Now()
+}
+
+// readBlockIndex returns a copy of the block index, or builds a temporary one
+// from all substate and block substate keys if substate DB has no block index
+func (db *SubstateDB) readBlockIndex() *blockIndex {
+	db.indexMu.Lock()
+	defer db.indexMu.Unlock()
+
+	idx := db.blockIndex()
+	if idx == nil {
+		if !db.indexWarned {
+			db.indexWarned = true
+			fmt.Printf("record-replay: warning: substate DB has no block index, run substate-cli db-index to build it\n")
+		}
+		return db.scanBlockIndex()
+	}
+	// copy ranges updated by writers
+	c := &blockIndex{Ranges: make([]*blockIndexRange, 0, len(idx.Ranges))}
+	for _, r := range idx.Ranges {
+		cr := *r
+		c.Ranges = append(c.Ranges, &cr)
+	}
+	return c
+}
+
+// HasBlockIndex returns true if substate DB has a block index. Without it,
+// BlockRanges and CountTxs iterate all substate and block substate keys, and
+// the block index is not updated until BuildBlockIndex.
+func (db *SubstateDB) HasBlockIndex() bool {
+	db.indexMu.Lock()
+	defer db.indexMu.Unlock()
+
+	return db.blockIndex() != nil
+}
+
+// BlockRanges returns sorted and non-adjacent ranges of blocks with substates
+// or block substates. Unlike the recorded block ranges of SubstateMetadata,
+// blocks without transactions are not included unless they have block substates.
+func (db *SubstateDB) BlockRanges() []*BlockSegment {
+	idx := db.readBlockIndex()
+	ranges := make([]*BlockSegment, 0, len(idx.Ranges))
+	for _, r := range idx.Ranges {
+		ranges = append(ranges, NewBlockSegment(r.First, r.Last))
+	}
+	return ranges
+}
+
+// CountTxs returns the number of substates of blocks in the segment. Blocks of
+// ranges partially in the segment are counted by iterating the smaller one of
+// their substates in or out of the segment.
+func (db *SubstateDB) CountTxs(segment *BlockSegment) int64 {
+	var n int64
+	idx := db.readBlockIndex()
+	for i, _ := idx.find(segment.First); i < len(idx.Ranges) && idx.Ranges[i].First <= segment.Last; i++ {
+		r := idx.Ranges[i]
+		first, last := r.First, r.Last
+		if first < segment.First {
+			first = segment.First
+		}
+		if last > segment.Last {
+			last = segment.Last
+		}
+		switch {
+		case first == r.First && last == r.Last:
+			n += r.Txs
+		case last-first <= (r.Last-r.First)/2:
+			n += db.countSubstates(NewBlockSegment(first, last))
+		default:
+			in := r.Txs
+			if r.First < first {
+				in -= db.countSubstates(NewBlockSegment(r.First, first-1))
+			}
+			if r.Last > last {
+				in -= db.countSubstates(NewBlockSegment(last+1, r.Last))
+			}
+			n += in
+		}
+	}
+	return n
+}
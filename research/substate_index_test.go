@@ -0,0 +1,199 @@
+package research
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// blockIndexString returns ranges of the block index like "1-3:4,5-5:0,"
+func blockIndexString(idx *blockIndex) string {
+	s := ""
+	for _, r := range idx.Ranges {
+		s += fmt.Sprintf("%v-%v:%v,", r.First, r.Last, r.Txs)
+	}
+	return s
+}
+
+func TestBlockIndex(t *testing.T) {
+	db := NewSubstateDB(rawdb.NewMemoryDatabase())
+	defer db.Close()
+
+	// the block index must be the same as the one built from all keys
+	check := func(want string) {
+		t.Helper()
+		got := blockIndexString(db.readBlockIndex())
+		if scanned := blockIndexString(db.scanBlockIndex()); got != scanned {
+			t.Fatalf("block index %s, scanned %s", got, scanned)
+		}
+		if got != want {
+			t.Fatalf("block index %s, want %s", got, want)
+		}
+	}
+
+	for _, block := range []uint64{10, 1, 3, 2, 7, 8} {
+		db.PutSubstate(block, 0, transferSubstate(block))
+	}
+	db.PutSubstate(2, 1, transferSubstate(2))
+	db.PutSubstate(2, 1, transferSubstate(2)) // overwritten
+	db.PutBlockSubstate(5, newTestBlockSubstate(5, nil))
+	if !db.HasBlockIndex() {
+		t.Fatal("block index is not stored")
+	}
+	check("1-3:4,5-5:0,7-8:2,10-10:1,")
+	if s := rangesString(db.BlockRanges()); s != "1-3,5-5,7-8,10-10," {
+		t.Fatalf("block ranges %s", s)
+	}
+
+	for _, c := range []struct {
+		first, last uint64
+		txs         int64
+	}{
+		{1, 10, 7}, {2, 2, 2}, {2, 9, 5}, {3, 7, 2}, {4, 6, 0}, {11, 20, 0},
+	} {
+		if n := db.CountTxs(NewBlockSegment(c.first, c.last)); n != c.txs {
+			t.Fatalf("CountTxs(%v-%v) = %v, want %v", c.first, c.last, n, c.txs)
+		}
+	}
+
+	db.PutBlockSubstate(4, newTestBlockSubstate(4, nil))
+	check("1-5:4,7-8:2,10-10:1,")
+	db.DeleteSubstate(2, 0)
+	check("1-5:3,7-8:2,10-10:1,")
+	db.DeleteSubstate(2, 1)
+	check("1-1:1,3-5:1,7-8:2,10-10:1,")
+	db.DeleteSubstate(2, 1) // not found
+	check("1-1:1,3-5:1,7-8:2,10-10:1,")
+	db.DeleteBlockSubstates(4)
+	check("1-1:1,3-3:1,5-5:0,7-8:2,10-10:1,")
+	if _, err := db.DeleteSegment(NewBlockSegment(5, 7)); err != nil {
+		t.Fatal(err)
+	}
+	check("1-1:1,3-3:1,8-8:1,10-10:1,")
+
+	// the block index updated in memory is not stored until it is flushed, a
+	// crash leaves no block index rather than one missing blocks
+	if !db.HasBlockIndex() || NewSubstateDB(db.backend).HasBlockIndex() {
+		t.Fatal("block index updated in memory is stored")
+	}
+	if err := db.FlushBlockIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen substate DB with the stored block index
+	db = NewSubstateDB(db.backend)
+	if !db.HasBlockIndex() {
+		t.Fatal("block index is not stored by FlushBlockIndex")
+	}
+	check("1-1:1,3-3:1,8-8:1,10-10:1,")
+
+	// substates of blocks indexed before reopening are checked in substate DB
+	db.PutSubstate(3, 0, transferSubstate(3)) // overwritten
+	db.PutSubstate(3, 1, transferSubstate(3))
+	check("1-1:1,3-3:2,8-8:1,10-10:1,")
+	db.DeleteSubstate(3, 1)
+	check("1-1:1,3-3:1,8-8:1,10-10:1,")
+
+	// substate DB without a block index is not indexed until BuildBlockIndex
+	db.backend.Delete([]byte(Stage1BlockIndexKey))
+	db = NewSubstateDB(db.backend)
+	db.PutSubstate(9, 0, transferSubstate(9))
+	if db.HasBlockIndex() {
+		t.Fatal("block index stored without BuildBlockIndex")
+	}
+	check("1-1:1,3-3:1,8-10:3,")
+	db.BuildBlockIndex()
+	if !db.HasBlockIndex() {
+		t.Fatal("block index is not stored by BuildBlockIndex")
+	}
+	db.PutSubstate(9, 0, transferSubstate(9)) // overwritten
+	check("1-1:1,3-3:1,8-10:3,")
+	db.DeleteSubstate(9, 0)
+	check("1-1:1,3-3:1,8-8:1,10-10:1,")
+}
+
+// hasCountingBackend counts Has calls of substates
+type hasCountingBackend struct {
+	BackendDatabase
+	mu   sync.Mutex
+	hits int
+}
+
+func (b *hasCountingBackend) Has(key []byte) (bool, error) {
+	if bytes.HasPrefix(key, []byte(Stage1SubstatePrefix)) {
+		b.mu.Lock()
+		b.hits++
+		b.mu.Unlock()
+	}
+	return b.BackendDatabase.Has(key)
+}
+
+func TestBlockIndexConcurrentPuts(t *testing.T) {
+	backend := &hasCountingBackend{BackendDatabase: rawdb.NewMemoryDatabase()}
+	db := NewSubstateDB(backend)
+
+	// workers put and overwrite substates of blocks concurrently
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := uint64(1); block <= 20; block++ {
+				for tx := 0; tx < 5; tx++ {
+					db.PutSubstate(block, tx, transferSubstate(block))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if backend.hits != 0 {
+		t.Fatalf("%v Has calls to put substates of new blocks", backend.hits)
+	}
+	if got, want := blockIndexString(db.readBlockIndex()), "1-20:100,"; got != want {
+		t.Fatalf("block index %s, want %s", got, want)
+	}
+	if err := db.FlushBlockIndex(); err != nil {
+		t.Fatal(err)
+	}
+	db = NewSubstateDB(backend.BackendDatabase)
+	defer db.Close()
+	if got, want := blockIndexString(db.readBlockIndex()), "1-20:100,"; got != want {
+		t.Fatalf("stored block index %s, want %s", got, want)
+	}
+}
+
+func TestExecuteSegmentBlockIndex(t *testing.T) {
+	db := NewSubstateDB(rawdb.NewMemoryDatabase())
+	defer db.Close()
+	db.PutSubstate(3, 0, transferSubstate(3))
+	db.PutSubstate(1000, 0, transferSubstate(1000))
+	db.PutBlockSubstate(500, newTestBlockSubstate(500, nil))
+
+	var mu sync.Mutex
+	var blocks []uint64
+	pool := &SubstateTaskPool{
+		Name: "test",
+		TaskFunc: func(block uint64, tx int, substate *Substate, taskPool *SubstateTaskPool) error {
+			return nil
+		},
+		BlockTaskFunc: func(block uint64, blockSubstate *BlockSubstate, taskPool *SubstateTaskPool) error {
+			mu.Lock()
+			defer mu.Unlock()
+			blocks = append(blocks, block)
+			return nil
+		},
+		Config: &SubstateTaskConfig{Workers: 1},
+		DB:     db,
+	}
+	numBlock, numTx, err := pool.executeSegment(NewBlockSegment(1, 2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numBlock != 3 || numTx != 2 || fmt.Sprint(blocks) != "[3 500 1000]" {
+		t.Fatalf("executed blocks %v, %v blocks and %v txs", blocks, numBlock, numTx)
+	}
+}
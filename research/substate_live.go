@@ -0,0 +1,77 @@
+package research
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PendingBlockDepth is the number of blocks below the last committed block
+// whose pending substates are kept for reorgs
+const PendingBlockDepth = 128
+
+// pendingBlock is substates of a processed block that is not canonical yet
+type pendingBlock struct {
+	number        uint64
+	substates     map[int]*Substate
+	blockSubstate *BlockSubstate   // nil without --record-block-substate
+	traces        map[int]*TxTrace // nil without --record-traces
+}
+
+// substates buffered by block hash while recording live from a syncing node
+var (
+	pendingBlocksMu sync.Mutex
+	pendingBlocks   = make(map[common.Hash]*pendingBlock)
+)
+
+// BufferBlockSubstates keeps substates, the block substate (if not nil) and
+// call traces of a processed block until the block becomes canonical, because
+// a block can be processed on a side chain.
+func BufferBlockSubstates(hash common.Hash, number uint64, substates map[int]*Substate, blockSubstate *BlockSubstate, traces map[int]*TxTrace) {
+	pendingBlocksMu.Lock()
+	defer pendingBlocksMu.Unlock()
+
+	pendingBlocks[hash] = &pendingBlock{number: number, substates: substates, blockSubstate: blockSubstate, traces: traces}
+}
+
+// CommitBlockSubstates writes buffered substates of a block that became
+// canonical, replacing substates of the previous canonical block of the same
+// number. It returns false if the block has no buffered substates.
+func CommitBlockSubstates(hash common.Hash, number uint64) bool {
+	pendingBlocksMu.Lock()
+	defer pendingBlocksMu.Unlock()
+
+	pending, ok := pendingBlocks[hash]
+	if !ok {
+		return false
+	}
+	delete(pendingBlocks, hash)
+
+	DeleteBlockSubstates(number)
+	for tx, substate := range pending.substates {
+		PutSubstate(number, tx, substate)
+	}
+	if pending.blockSubstate != nil {
+		PutBlockSubstate(number, pending.blockSubstate)
+	}
+	for tx, trace := range pending.traces {
+		PutTxTrace(number, tx, trace)
+	}
+	RecordBlock(number)
+
+	// drop substates of side chain blocks that will not become canonical
+	for h, p := range pendingBlocks {
+		if p.number+PendingBlockDepth < number {
+			delete(pendingBlocks, h)
+		}
+	}
+	return true
+}
+
+// RevertBlockSubstates deletes substates of a block that is no longer canonical
+func RevertBlockSubstates(number uint64) {
+	fmt.Printf("record-replay: delete substates of non-canonical block %v\n", number)
+	DeleteBlockSubstates(number)
+	UnrecordBlock(number)
+}
@@ -0,0 +1,72 @@
+package research
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestLiveRecordReorg(t *testing.T) {
+	OpenFakeSubstateDB()
+	defer CloseFakeSubstateDB()
+	RecordMetadata(params.TestChainConfig, common.HexToHash("0x01"))
+
+	code := []byte{0x60, 0x00}
+	hashA, hashB := common.HexToHash("0x0a"), common.HexToHash("0x0b")
+	BufferBlockSubstates(hashA, 1, map[int]*Substate{0: createSubstate(1, code), 1: transferSubstate(1)}, newTestBlockSubstate(1, code), map[int]*TxTrace{0: newTestTxTrace()})
+	BufferBlockSubstates(hashB, 1, map[int]*Substate{0: transferSubstate(1)}, nil, nil)
+
+	if CommitBlockSubstates(common.HexToHash("0x0c"), 1) {
+		t.Fatal("committed a block without buffered substates")
+	}
+	if !CommitBlockSubstates(hashA, 1) {
+		t.Fatal("substates of block A are not buffered")
+	}
+	if n := len(GetBlockSubstates(1)); n != 2 {
+		t.Fatalf("block 1 has %v substates after commit of block A, want 2", n)
+	}
+	if GetBlockSubstate(1) == nil {
+		t.Fatal("block substate of block A is not committed")
+	}
+	if GetTxTrace(1, 0) == nil {
+		t.Fatal("call trace of block A is not committed")
+	}
+
+	// reorg from block A to block B
+	RevertBlockSubstates(1)
+	if n := len(GetBlockSubstates(1)); n != 0 {
+		t.Fatalf("block 1 has %v substates after revert, want 0", n)
+	}
+	if GetBlockSubstate(1) != nil {
+		t.Fatal("block substate of block A is not deleted after revert")
+	}
+	if GetTxTrace(1, 0) != nil {
+		t.Fatal("call trace of block A is not deleted after revert")
+	}
+	if !CommitBlockSubstates(hashB, 1) {
+		t.Fatal("substates of block B are not buffered")
+	}
+	if n := len(GetBlockSubstates(1)); n != 1 {
+		t.Fatalf("block 1 has %v substates after commit of block B, want 1", n)
+	}
+	if got := rangesString(GetMetadata().Ranges); got != "1-1," {
+		t.Fatalf("recorded ranges = %s, want 1-1,", got)
+	}
+
+	// reorg to a shorter chain
+	BufferBlockSubstates(common.HexToHash("0x02"), 2, map[int]*Substate{}, nil, nil)
+	CommitBlockSubstates(common.HexToHash("0x02"), 2)
+	RevertBlockSubstates(2)
+	if got := rangesString(GetMetadata().Ranges); got != "1-1," {
+		t.Fatalf("recorded ranges = %s, want 1-1,", got)
+	}
+
+	// side chain blocks far below the canonical head are dropped
+	BufferBlockSubstates(common.HexToHash("0x03"), 3, map[int]*Substate{}, nil, nil)
+	BufferBlockSubstates(common.HexToHash("0x04"), 3+PendingBlockDepth+1, map[int]*Substate{}, nil, nil)
+	CommitBlockSubstates(common.HexToHash("0x04"), 3+PendingBlockDepth+1)
+	if _, ok := pendingBlocks[common.HexToHash("0x03")]; ok {
+		t.Fatal("pending substates of an old side chain block are not dropped")
+	}
+}
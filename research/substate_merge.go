@@ -0,0 +1,361 @@
+package research
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"google.golang.org/protobuf/proto"
+)
+
+// Policies of SubstateMerger for blocks with conflicting substates
+const (
+	MergeFail        = "fail"         // stop merging at the first conflict
+	MergePreferFirst = "prefer-first" // keep the block of the first merged substate DB
+	MergePreferNewer = "prefer-newer" // replace the block with the one of the later merged substate DB
+)
+
+// MergePolicies is the list of policies of SubstateMerger
+var MergePolicies = []string{MergeFail, MergePreferFirst, MergePreferNewer}
+
+// mergeBlock is everything recorded for a block in a substate DB
+type mergeBlock struct {
+	substates     map[int]*Substate
+	blockSubstate *BlockSubstate
+	traces        map[int]*TxTrace
+}
+
+func (db *SubstateDB) getMergeBlock(block uint64) *mergeBlock {
+	b := &mergeBlock{
+		substates:     db.GetBlockSubstates(block),
+		blockSubstate: db.GetBlockSubstate(block),
+		traces:        make(map[int]*TxTrace),
+	}
+	for tx := range b.substates {
+		if trace := db.GetTxTrace(block, tx); trace != nil {
+			b.traces[tx] = trace
+		}
+	}
+	return b
+}
+
+func (b *mergeBlock) empty() bool {
+	return len(b.substates) == 0 && b.blockSubstate == nil
+}
+
+// mergeConflict describes the first conflict between blocks a and b, or
+// returns "" if a and b are the same except block substate or call traces
+// recorded only in one of them
+func mergeConflict(a, b *mergeBlock) string {
+	txs := make([]int, 0, len(a.substates))
+	for tx := range a.substates {
+		txs = append(txs, tx)
+	}
+	for tx := range b.substates {
+		if _, ok := a.substates[tx]; !ok {
+			txs = append(txs, tx)
+		}
+	}
+	sort.Ints(txs)
+	for _, tx := range txs {
+		sa, sb := a.substates[tx], b.substates[tx]
+		if sa == nil || sb == nil {
+			return fmt.Sprintf("tx %v is recorded only in one substate DB", tx)
+		}
+		if !proto.Equal(sa, sb) {
+			return fmt.Sprintf("tx %v, different substates", tx)
+		}
+		if ta, tb := a.traces[tx], b.traces[tx]; ta != nil && tb != nil && !proto.Equal(ta, tb) {
+			return fmt.Sprintf("tx %v, different call traces", tx)
+		}
+	}
+	if a.blockSubstate != nil && b.blockSubstate != nil && !proto.Equal(a.blockSubstate, b.blockSubstate) {
+		return "different block substates"
+	}
+	return ""
+}
+
+// mergeCovers returns true if a has the block substate and all call traces of b
+func mergeCovers(a, b *mergeBlock) bool {
+	if a.blockSubstate == nil && b.blockSubstate != nil {
+		return false
+	}
+	for tx := range b.traces {
+		if _, ok := a.traces[tx]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// blockNumbers returns sorted numbers of blocks with substates or block substates
+func (db *SubstateDB) blockNumbers() ([]uint64, error) {
+	var blocks []uint64
+	for _, prefix := range []string{Stage1SubstatePrefix, Stage1BlockPrefix} {
+		// keys of a prefix are sorted by block numbers
+		var prefixBlocks []uint64
+		iter := db.backend.NewIterator([]byte(prefix), nil)
+		for iter.Next() {
+			key := iter.Key()
+			if len(key) < len(prefix)+8 {
+				continue
+			}
+			block := binary.BigEndian.Uint64(key[len(prefix):])
+			if n := len(prefixBlocks); n == 0 || prefixBlocks[n-1] != block {
+				prefixBlocks = append(prefixBlocks, block)
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, fmt.Errorf("error iterating %q: %w", prefix, err)
+		}
+		blocks = mergeBlockNumbers(blocks, prefixBlocks)
+	}
+	return blocks, nil
+}
+
+// mergeBlockNumbers merges sorted and unique block numbers of a and b
+func mergeBlockNumbers(a, b []uint64) []uint64 {
+	z := make([]uint64, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			z, a = append(z, a[0]), a[1:]
+		case a[0] > b[0]:
+			z, b = append(z, b[0]), b[1:]
+		default:
+			z, a, b = append(z, a[0]), a[1:], b[1:]
+		}
+	}
+	z = append(z, a...)
+	return append(z, b...)
+}
+
+// putMergeBlock puts parts of block b that are not in d of the substate DB, or
+// replaces the whole block with b if replace is true, in a batch updating the
+// block index once
+func (db *SubstateDB) putMergeBlock(block uint64, b, d *mergeBlock, replace bool) error {
+	var deleted [][]byte
+	if replace {
+		d = &mergeBlock{}
+		deleted = append(deleted, Stage1BlockKey(block))
+		for _, prefix := range [][]byte{Stage1SubstateBlockPrefix(block), Stage1TraceBlockPrefix(block)} {
+			iter := db.backend.NewIterator(prefix, nil)
+			for iter.Next() {
+				deleted = append(deleted, common.CopyBytes(iter.Key()))
+			}
+			iter.Release()
+			if err := iter.Error(); err != nil {
+				return fmt.Errorf("error iterating keys of block %v: %w", block, err)
+			}
+		}
+	}
+
+	putCodes := func(hashMap map[common.Hash][]byte) {
+		for codeHash, code := range hashMap {
+			if codeHash != EmptyCodeHash {
+				db.PutCode(code)
+			}
+		}
+	}
+	return db.updateBlockIndex(func(idx *blockIndex, batch ethdb.Batch) error {
+		if replace {
+			idx.remove(NewBlockSegment(block, block), db.countSubstates)
+		}
+		// keys are put after deleted keys in the batch
+		for _, key := range deleted {
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		indexed := false
+		for tx, substate := range b.substates {
+			if _, ok := d.substates[tx]; ok {
+				continue
+			}
+			putCodes(substate.HashMap())
+			value, err := proto.Marshal(substate.HashedCopy())
+			if err != nil {
+				return err
+			}
+			if err := batch.Put(Stage1SubstateKey(block, tx), value); err != nil {
+				return err
+			}
+			if !idx.addTx(block, tx) {
+				idx.add(block, 1)
+			}
+			indexed = true
+		}
+		if b.blockSubstate != nil && d.blockSubstate == nil {
+			putCodes(b.blockSubstate.HashMap())
+			value, err := proto.Marshal(b.blockSubstate.HashedCopy())
+			if err != nil {
+				return err
+			}
+			if err := batch.Put(Stage1BlockKey(block), value); err != nil {
+				return err
+			}
+			if !indexed {
+				idx.add(block, 0)
+			}
+		}
+		for tx, trace := range b.traces {
+			if _, ok := d.traces[tx]; ok {
+				continue
+			}
+			value, err := proto.Marshal(trace)
+			if err != nil {
+				return err
+			}
+			if err := batch.Put(Stage1TraceKey(block, tx), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SubstateMerger merges substates, block substates, call traces, bytecodes
+// and metadata of substate DBs into an empty substate DB. Blocks recorded in
+// more than one substate DB are merged if their substates are the same, and
+// resolved by Policy otherwise. Substate DBs are merged from the oldest to the
+// newest one.
+type SubstateMerger struct {
+	dst *SubstateDB
+
+	Policy string
+	// Report is called with each conflicting block if not nil
+	Report func(block uint64, conflict string)
+
+	NumBlocks    int // number of merged blocks
+	NumConflicts int // number of conflicts resolved by Policy
+
+	conflicts map[uint64]struct{}
+}
+
+// NewSubstateMerger returns a SubstateMerger into dst, which must have no
+// substates or block substates
+func NewSubstateMerger(dst *SubstateDB, policy string) (*SubstateMerger, error) {
+	valid := false
+	for _, p := range MergePolicies {
+		valid = valid || p == policy
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid merge policy %q, must be one of %q", policy, MergePolicies)
+	}
+	blocks, err := dst.blockNumbers()
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) > 0 {
+		return nil, fmt.Errorf("destination substate DB is not empty, it has substates of %v blocks", len(blocks))
+	}
+	return &SubstateMerger{
+		dst:       dst,
+		Policy:    policy,
+		conflicts: make(map[uint64]struct{}),
+	}, nil
+}
+
+// Merge merges all blocks and metadata of src into the destination substate DB
+func (m *SubstateMerger) Merge(src *SubstateDB) error {
+	err := CopyMetadata(src, m.dst, NewBlockSegment(0, math.MaxUint64))
+	if err != nil {
+		return fmt.Errorf("error merging metadata: %w", err)
+	}
+
+	blocks, err := src.blockNumbers()
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		b := src.getMergeBlock(block)
+		d := m.dst.getMergeBlock(block)
+		if d.empty() {
+			if err := m.dst.putMergeBlock(block, b, d, false); err != nil {
+				return fmt.Errorf("error merging block %v: %w", block, err)
+			}
+			m.NumBlocks++
+			continue
+		}
+
+		conflict := mergeConflict(d, b)
+		if conflict == "" {
+			if err := m.dst.putMergeBlock(block, b, d, false); err != nil {
+				return fmt.Errorf("error merging block %v: %w", block, err)
+			}
+			continue
+		}
+		if m.Report != nil {
+			m.Report(block, conflict)
+		}
+		m.conflicts[block] = struct{}{}
+		switch m.Policy {
+		case MergeFail:
+			return fmt.Errorf("block %v: %s", block, conflict)
+		case MergePreferFirst:
+		case MergePreferNewer:
+			if err := m.dst.putMergeBlock(block, b, d, true); err != nil {
+				return fmt.Errorf("error replacing block %v: %w", block, err)
+			}
+		}
+		m.NumConflicts++
+	}
+	return nil
+}
+
+// Verify checks that the destination substate DB has every block of merged
+// substate DBs in the given order, except blocks resolved by Policy in favor of
+// another substate DB, and that SubstateVerifier finds no issues. Bytecodes of
+// blocks replaced by MergePreferNewer may be orphaned, CollectCodeGarbage
+// deletes them.
+func (m *SubstateMerger) Verify(sources []*SubstateDB) error {
+	// a conflicting block is taken from the first or the last substate DB
+	// having the block, which is checked first
+	order := make([]int, len(sources))
+	for i := range order {
+		order[i] = i
+		if m.Policy == MergePreferNewer {
+			order[i] = len(sources) - 1 - i
+		}
+	}
+	checked := make(map[uint64]struct{})
+	for _, i := range order {
+		blocks, err := sources[i].blockNumbers()
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if _, ok := m.conflicts[block]; ok {
+				if _, ok := checked[block]; ok {
+					continue
+				}
+				checked[block] = struct{}{}
+			}
+			b := sources[i].getMergeBlock(block)
+			d := m.dst.getMergeBlock(block)
+			if conflict := mergeConflict(d, b); conflict != "" {
+				return fmt.Errorf("block %v of substate DB %v is not merged: %s", block, i, conflict)
+			}
+			if !mergeCovers(d, b) {
+				return fmt.Errorf("block %v of substate DB %v is not merged: missing block substate or call traces", block, i)
+			}
+		}
+	}
+
+	v := NewSubstateVerifier(m.dst)
+	if err := v.VerifySegment(NewBlockSegment(0, math.MaxUint64)); err != nil {
+		return err
+	}
+	if err := v.VerifyCode(false); err != nil {
+		return err
+	}
+	if n := v.NumIssues(); n > 0 {
+		return fmt.Errorf("%v issues in merged substate DB: %v", n, v.Issues)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package research
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+func TestSubstateMerger(t *testing.T) {
+	code1, code2 := []byte{0x60, 0x01}, []byte{0x60, 0x02}
+	newSource := func(first, last uint64) *SubstateDB {
+		db := NewSubstateDB(rawdb.NewMemoryDatabase())
+		m := &SubstateMetadata{ChainID: big.NewInt(1)}
+		m.AddRange(first, last)
+		db.PutMetadata(m)
+		return db
+	}
+
+	// blocks 1-2
+	a := newSource(1, 2)
+	a.PutSubstate(1, 0, callSubstate(1, code1))
+	a.PutSubstate(2, 0, callSubstate(2, code1))
+	// blocks 2-3, block 2 with call trace and block substate
+	b := newSource(2, 3)
+	b.PutSubstate(2, 0, callSubstate(2, code1))
+	b.PutTxTrace(2, 0, newTestTxTrace())
+	b.PutBlockSubstate(2, newTestBlockSubstate(2, code1))
+	b.PutSubstate(3, 0, callSubstate(3, code1))
+	// block 3 conflicting with b
+	c := newSource(3, 3)
+	c.PutSubstate(3, 0, callSubstate(3, code2))
+	sources := []*SubstateDB{a, b, c}
+
+	merge := func(policy string) (*SubstateDB, *SubstateMerger, error) {
+		dst := NewSubstateDB(rawdb.NewMemoryDatabase())
+		m, err := NewSubstateMerger(dst, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, src := range sources {
+			if err := m.Merge(src); err != nil {
+				return dst, m, err
+			}
+		}
+		return dst, m, m.Verify(sources)
+	}
+
+	if _, _, err := merge(MergeFail); err == nil {
+		t.Fatal("conflicting substates merged with policy fail")
+	}
+
+	for _, policy := range []string{MergePreferFirst, MergePreferNewer} {
+		dst, m, err := merge(policy)
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if m.NumBlocks != 3 || m.NumConflicts != 1 {
+			t.Fatalf("%s: %v blocks and %v conflicts merged", policy, m.NumBlocks, m.NumConflicts)
+		}
+		if !dst.HasTxTrace(2, 0) || !dst.HasBlockSubstate(2) {
+			t.Fatalf("%s: call trace or block substate of block 2 not merged", policy)
+		}
+		want := code1
+		if policy == MergePreferNewer {
+			want = code2
+		}
+		if got := dst.GetSubstate(3, 0).InputAlloc.Alloc[1].Account.GetCode(); string(got) != string(want) {
+			t.Fatalf("%s: block 3 has code %x, want %x", policy, got, want)
+		}
+		if s := rangesString(dst.GetMetadata().Ranges); s != "1-3," {
+			t.Fatalf("%s: ranges = %s, want 1-3,", policy, s)
+		}
+
+		// verification finds blocks missing in the merged substate DB
+		dst.DeleteBlockSubstates(1)
+		if err := m.Verify(sources); err == nil {
+			t.Fatalf("%s: verified without block 1", policy)
+		}
+
+		// substate DB must be empty to merge into
+		if _, err := NewSubstateMerger(dst, policy); err == nil {
+			t.Fatalf("%s: merged into non-empty substate DB", policy)
+		}
+	}
+
+	if _, err := NewSubstateMerger(NewSubstateDB(rawdb.NewMemoryDatabase()), "prefer-last"); err == nil {
+		t.Fatal("invalid merge policy accepted")
+	}
+
+	// substates of another chain are not merged
+	other := NewSubstateDB(rawdb.NewMemoryDatabase())
+	other.PutMetadata(&SubstateMetadata{ChainID: big.NewInt(5)})
+	m, err := NewSubstateMerger(NewSubstateDB(rawdb.NewMemoryDatabase()), MergeFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Merge(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Merge(other); err == nil {
+		t.Fatal("substate DB of another chain merged")
+	}
+}
+
+func TestSubstateMergerConflicts(t *testing.T) {
+	code1, code2 := []byte{0x60, 0x01}, []byte{0x60, 0x02}
+	// block 1 with 2 txs, a call trace and a block substate
+	older := NewSubstateDB(rawdb.NewMemoryDatabase())
+	older.PutSubstate(1, 0, callSubstate(1, code1))
+	older.PutSubstate(1, 1, callSubstate(1, code1))
+	older.PutTxTrace(1, 1, newTestTxTrace())
+	older.PutBlockSubstate(1, newTestBlockSubstate(1, code1))
+	older.PutSubstate(2, 0, callSubstate(2, code1))
+	// block 1 with a different tx and nothing else
+	newer := NewSubstateDB(rawdb.NewMemoryDatabase())
+	newer.PutSubstate(1, 0, callSubstate(1, code2))
+	newer.PutSubstate(2, 0, callSubstate(2, code1))
+
+	for _, policy := range []string{MergePreferFirst, MergePreferNewer} {
+		dst := NewSubstateDB(rawdb.NewMemoryDatabase())
+		m, err := NewSubstateMerger(dst, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reported []uint64
+		m.Report = func(block uint64, conflict string) { reported = append(reported, block) }
+		for _, src := range []*SubstateDB{older, newer} {
+			if err := m.Merge(src); err != nil {
+				t.Fatalf("%s: %v", policy, err)
+			}
+		}
+		if err := m.Verify([]*SubstateDB{older, newer}); err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if len(reported) != 1 || reported[0] != 1 || m.NumConflicts != 1 || m.NumBlocks != 2 {
+			t.Fatalf("%s: reported %v, %v conflicts, %v blocks", policy, reported, m.NumConflicts, m.NumBlocks)
+		}
+
+		want := older.getMergeBlock(1)
+		if policy == MergePreferNewer {
+			want = newer.getMergeBlock(1)
+		}
+		got := dst.getMergeBlock(1)
+		if conflict := mergeConflict(got, want); conflict != "" || !mergeCovers(got, want) || !mergeCovers(want, got) {
+			t.Fatalf("%s: block 1 is not taken from the preferred substate DB: %s", policy, conflict)
+		}
+		// the block index has the transactions of the preferred block
+		if n := dst.CountTxs(NewBlockSegment(1, 1)); n != int64(len(want.substates)) {
+			t.Fatalf("%s: block 1 has %v indexed txs, want %v", policy, n, len(want.substates))
+		}
+		if got := blockIndexString(dst.readBlockIndex()); got != blockIndexString(dst.scanBlockIndex()) {
+			t.Fatalf("%s: block index %s is different from the scanned one", policy, got)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package research
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// SubstateMetadata describes who recorded substates of which chain and blocks
+type SubstateMetadata struct {
+	RecordReplayVersion string          `json:"recordReplayVersion"`
+	GethVersion         string          `json:"gethVersion"`
+	ChainID             *big.Int        `json:"chainId"`
+	GenesisHash         common.Hash     `json:"genesisHash"`
+	Ranges              []*BlockSegment `json:"ranges"` // sorted and non-adjacent recorded block ranges
+}
+
+// AddRange adds blocks first-last to the recorded ranges, merging overlapping
+// and adjacent ranges.
+func (m *SubstateMetadata) AddRange(first, last uint64) {
+	ranges := append(m.Ranges, NewBlockSegment(first, last))
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].First < ranges[j].First
+	})
+
+	merged := make([]*BlockSegment, 0, len(ranges))
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && (r.First <= merged[n-1].Last || r.First-1 == merged[n-1].Last) {
+			if r.Last > merged[n-1].Last {
+				merged[n-1].Last = r.Last
+			}
+			continue
+		}
+		merged = append(merged, NewBlockSegment(r.First, r.Last))
+	}
+	m.Ranges = merged
+}
+
+// RemoveRange removes blocks first-last from the recorded ranges
+func (m *SubstateMetadata) RemoveRange(first, last uint64) {
+	ranges := make([]*BlockSegment, 0, len(m.Ranges)+1)
+	for _, r := range m.Ranges {
+		if r.Last < first || r.First > last {
+			ranges = append(ranges, r)
+			continue
+		}
+		if r.First < first {
+			ranges = append(ranges, NewBlockSegment(r.First, first-1))
+		}
+		if r.Last > last {
+			ranges = append(ranges, NewBlockSegment(last+1, r.Last))
+		}
+	}
+	m.Ranges = ranges
+}
+
+// MissingRanges returns block ranges of the segment that are not recorded
+func (m *SubstateMetadata) MissingRanges(segment *BlockSegment) []*BlockSegment {
+	var missing []*BlockSegment
+	next := segment.First
+	for _, r := range m.Ranges {
+		if r.Last < next {
+			continue
+		}
+		if r.First > segment.Last {
+			break
+		}
+		if r.First > next {
+			missing = append(missing, NewBlockSegment(next, r.First-1))
+		}
+		if r.Last >= segment.Last {
+			return missing
+		}
+		next = r.Last + 1
+	}
+	return append(missing, NewBlockSegment(next, segment.Last))
+}
+
+// clone returns a copy of the metadata with copied ranges
+func (m *SubstateMetadata) clone() *SubstateMetadata {
+	c := *m
+	c.Ranges = make([]*BlockSegment, len(m.Ranges))
+	for i, r := range m.Ranges {
+		c.Ranges[i] = NewBlockSegment(r.First, r.Last)
+	}
+	return &c
+}
+
+// Intersect returns a copy of the metadata whose ranges are within the segment
+func (m *SubstateMetadata) Intersect(segment *BlockSegment) *SubstateMetadata {
+	c := *m
+	c.Ranges = nil
+	for _, r := range m.Ranges {
+		first, last := r.First, r.Last
+		if first < segment.First {
+			first = segment.First
+		}
+		if last > segment.Last {
+			last = segment.Last
+		}
+		if first <= last {
+			c.Ranges = append(c.Ranges, NewBlockSegment(first, last))
+		}
+	}
+	return &c
+}
+
+// Merge adds ranges of other metadata recorded from the same chain.
+// Versions are replaced with the ones of other metadata.
+func (m *SubstateMetadata) Merge(other *SubstateMetadata) error {
+	if m.ChainID != nil && other.ChainID != nil && m.ChainID.Cmp(other.ChainID) != 0 {
+		return fmt.Errorf("chain ID %v is different from chain ID %v", other.ChainID, m.ChainID)
+	}
+	if m.GenesisHash != (common.Hash{}) && other.GenesisHash != (common.Hash{}) && m.GenesisHash != other.GenesisHash {
+		return fmt.Errorf("genesis hash %v is different from genesis hash %v", other.GenesisHash.Hex(), m.GenesisHash.Hex())
+	}
+	if other.ChainID != nil {
+		m.ChainID = other.ChainID
+	}
+	if other.GenesisHash != (common.Hash{}) {
+		m.GenesisHash = other.GenesisHash
+	}
+	m.RecordReplayVersion = other.RecordReplayVersion
+	m.GethVersion = other.GethVersion
+	for _, r := range other.Ranges {
+		m.AddRange(r.First, r.Last)
+	}
+	return nil
+}
+
+// PutMetadata writes metadata of substates into substate DB
+func (db *SubstateDB) PutMetadata(m *SubstateMetadata) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error encoding metadata: %v", err))
+	}
+	err = db.backend.Put([]byte(Stage1MetadataKey), b)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error putting metadata into substate DB: %v", err))
+	}
+}
+
+// GetMetadata returns metadata of substates, or nil if substate DB has no metadata
+func (db *SubstateDB) GetMetadata() *SubstateMetadata {
+	key := []byte(Stage1MetadataKey)
+	if has, _ := db.backend.Has(key); !has {
+		return nil
+	}
+	b, err := db.backend.Get(key)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error getting metadata from substate DB: %v", err))
+	}
+	m := &SubstateMetadata{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error decoding metadata: %v", err))
+	}
+	return m
+}
+
+// CopyMetadata copies the chain config and metadata of the segment from src
+// to dst substate DB, e.g., after cloning substates of the segment.
+func CopyMetadata(src, dst *SubstateDB, segment *BlockSegment) error {
+	if config := src.GetChainConfig(); config != nil {
+		dst.PutChainConfig(config)
+	}
+	m := src.GetMetadata()
+	if m == nil {
+		return nil
+	}
+	m = m.Intersect(segment)
+	if dstMetadata := dst.GetMetadata(); dstMetadata != nil {
+		if err := dstMetadata.Merge(m); err != nil {
+			return err
+		}
+		m = dstMetadata
+	}
+	dst.PutMetadata(m)
+	return nil
+}
+
+// RecordMetadata starts recording metadata of the static substate DB with the
+// chain config and genesis hash of the recorded chain. It is done once for each
+// opened static substate DB, and it panics if the substate DB was recorded from
+// another chain.
+func RecordMetadata(config *params.ChainConfig, genesisHash common.Hash) {
+	db := staticSubstateDB
+	db.recordOnce.Do(func() {
+		db.recordMu.Lock()
+		defer db.recordMu.Unlock()
+
+		m := db.GetMetadata()
+		if m == nil {
+			m = &SubstateMetadata{}
+		}
+		err := m.Merge(&SubstateMetadata{
+			RecordReplayVersion: params.VersionMeta,
+			GethVersion:         params.Version,
+			ChainID:             config.ChainID,
+			GenesisHash:         genesisHash,
+		})
+		if err != nil {
+			panic(fmt.Errorf("record-replay: substate DB %s was recorded from another chain: %v", substateDb, err))
+		}
+
+		db.PutChainConfig(config)
+		db.PutMetadata(m)
+		db.recordMetadata = m
+	})
+}
+
+// RecordBlock adds a block to the recorded ranges of metadata and writes the
+// metadata after substates of the block.
+func RecordBlock(block uint64) {
+	db := staticSubstateDB
+	db.recordMu.Lock()
+	defer db.recordMu.Unlock()
+
+	if db.recordMetadata != nil {
+		db.recordMetadata.AddRange(block, block)
+		putMetadata(db.recordMetadata.clone())
+	}
+}
+
+// UnrecordBlock removes a block from the recorded ranges of metadata
+func UnrecordBlock(block uint64) {
+	db := staticSubstateDB
+	db.recordMu.Lock()
+	defer db.recordMu.Unlock()
+
+	if db.recordMetadata != nil {
+		db.recordMetadata.RemoveRange(block, block)
+		putMetadata(db.recordMetadata.clone())
+	}
+}
+
+func GetMetadata() *SubstateMetadata {
+	return staticSubstateDB.GetMetadata()
+}
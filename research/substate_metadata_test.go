@@ -0,0 +1,108 @@
+package research
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func rangesString(ranges []*BlockSegment) string {
+	s := ""
+	for _, r := range ranges {
+		s += fmt.Sprintf("%v-%v,", r.First, r.Last)
+	}
+	return s
+}
+
+func TestSubstateMetadataRanges(t *testing.T) {
+	m := &SubstateMetadata{}
+	for _, block := range []uint64{5, 3, 4, 10, 1, 12, 11} {
+		m.AddRange(block, block)
+	}
+	if got, want := rangesString(m.Ranges), "1-1,3-5,10-12,"; got != want {
+		t.Fatalf("ranges = %s, want %s", got, want)
+	}
+	m.AddRange(2, 9)
+	if got, want := rangesString(m.Ranges), "1-12,"; got != want {
+		t.Fatalf("ranges = %s, want %s", got, want)
+	}
+	m.AddRange(20, 30)
+
+	tests := []struct {
+		first, last uint64
+		missing     string
+	}{
+		{1, 12, ""},
+		{3, 5, ""},
+		{0, 12, "0-0,"},
+		{10, 25, "13-19,"},
+		{5, 35, "13-19,31-35,"},
+		{40, 50, "40-50,"},
+	}
+	for _, tt := range tests {
+		got := rangesString(m.MissingRanges(NewBlockSegment(tt.first, tt.last)))
+		if got != tt.missing {
+			t.Errorf("MissingRanges(%v-%v) = %s, want %s", tt.first, tt.last, got, tt.missing)
+		}
+	}
+
+	if got, want := rangesString(m.Intersect(NewBlockSegment(10, 25)).Ranges), "10-12,20-25,"; got != want {
+		t.Errorf("Intersect(10-25) = %s, want %s", got, want)
+	}
+	if got, want := rangesString(m.Ranges), "1-12,20-30,"; got != want {
+		t.Errorf("Intersect modified ranges: %s, want %s", got, want)
+	}
+}
+
+func TestCopyMetadata(t *testing.T) {
+	src := NewSubstateDB(rawdb.NewMemoryDatabase())
+	defer src.Close()
+	dst := NewSubstateDB(rawdb.NewMemoryDatabase())
+	defer dst.Close()
+
+	// no metadata to copy
+	if err := CopyMetadata(src, dst, NewBlockSegment(1, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if m := dst.GetMetadata(); m != nil {
+		t.Fatalf("unexpected metadata: %v", m)
+	}
+
+	m := &SubstateMetadata{
+		RecordReplayVersion: params.VersionMeta,
+		GethVersion:         params.Version,
+		ChainID:             big.NewInt(1),
+		GenesisHash:         params.MainnetGenesisHash,
+	}
+	m.AddRange(1, 100)
+	src.PutMetadata(m)
+	src.PutChainConfig(params.MainnetChainConfig)
+
+	if err := CopyMetadata(src, dst, NewBlockSegment(51, 60)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyMetadata(src, dst, NewBlockSegment(91, 200)); err != nil {
+		t.Fatal(err)
+	}
+	got := dst.GetMetadata()
+	if got == nil || got.GenesisHash != params.MainnetGenesisHash || got.ChainID.Int64() != 1 {
+		t.Fatalf("unexpected metadata: %v", got)
+	}
+	if s := rangesString(got.Ranges); s != "51-60,91-100," {
+		t.Fatalf("ranges = %s, want 51-60,91-100,", s)
+	}
+	if dst.GetChainConfig() == nil {
+		t.Fatal("chain config is not copied")
+	}
+
+	// metadata of another chain cannot be merged
+	m.GenesisHash = common.HexToHash("0x01")
+	src.PutMetadata(m)
+	if err := CopyMetadata(src, dst, NewBlockSegment(1, 10)); err == nil {
+		t.Fatal("error is not raised for different genesis hash")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,6 +15,10 @@ import (
 
 type SubstateTaskFunc func(block uint64, tx int, substate *Substate, taskPool *SubstateTaskPool) error
 
+// SubstateBlockTaskFunc is called with the block substate of each block, which
+// is nil if the block was recorded without block substates
+type SubstateBlockTaskFunc func(block uint64, blockSubstate *BlockSubstate, taskPool *SubstateTaskPool) error
+
 type SubstateTaskConfig struct {
 	Workers int
 
@@ -26,6 +31,9 @@ type SubstateTaskConfig struct {
 	BlockSet      map[uint64]struct{}     // list of blocks from tx list file
 	TxSet         map[TxListElem]struct{} // list of block,tx indexes from tx list file
 	TxBlockSet    map[uint64]struct{}     // list of blocks from tx set
+
+	CheckpointPath string
+	Resume         bool
 }
 
 func NewSubstateTaskConfigCli(ctx *cli.Context) *SubstateTaskConfig {
@@ -35,6 +43,9 @@ func NewSubstateTaskConfigCli(ctx *cli.Context) *SubstateTaskConfig {
 		SkipTransferTxs: ctx.Bool(SkipTransferTxsFlag.Name),
 		SkipCallTxs:     ctx.Bool(SkipCallTxsFlag.Name),
 		SkipCreateTxs:   ctx.Bool(SkipCreateTxsFlag.Name),
+
+		CheckpointPath: ctx.Path(CheckpointFlag.Name),
+		Resume:         ctx.Bool(ResumeFlag.Name),
 	}
 
 	config.TxListPath = ctx.Path(TxListFlag.Name)
@@ -81,6 +92,9 @@ type SubstateTaskPool struct {
 	TaskFunc SubstateTaskFunc
 	Config   *SubstateTaskConfig
 
+	// BlockTaskFunc is called before TaskFunc of each block if not nil
+	BlockTaskFunc SubstateBlockTaskFunc
+
 	DB *SubstateDB
 }
 
@@ -123,6 +137,13 @@ func (pool *SubstateTaskPool) NumWorkers() int {
 
 // ExecuteBlock function iterates on substates of a given block call TaskFunc
 func (pool *SubstateTaskPool) ExecuteBlock(block uint64) (numTx int64, err error) {
+	if pool.BlockTaskFunc != nil && pool.Config.IsBlockListed(block) {
+		err = pool.BlockTaskFunc(block, pool.DB.GetBlockSubstate(block), pool)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v: %v", pool.Name, block, err)
+		}
+	}
+
 	txSubstatesMap := pool.DB.GetBlockSubstatesWithTxList(block, pool.Config)
 
 	for tx, substate := range txSubstatesMap {
@@ -173,16 +194,51 @@ func (pool *SubstateTaskPool) ExecuteBlock(block uint64) (numTx int64, err error
 	return numTx, nil
 }
 
-// Execute function spawns worker goroutines and schedule tasks.
+// ExecuteSegment function spawns worker goroutines and schedule tasks.
 func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
+	_, _, err := pool.executeSegment(segment)
+	return err
+}
+
+// executeSegment returns number of executed blocks and transactions with error
+func (pool *SubstateTaskPool) executeSegment(segment *BlockSegment) (numBlock, numTx int64, err error) {
 	start := time.Now()
 
+	// first block to execute, and save checkpoint of contiguously completed blocks
+	first := segment.First
+	if pool.Config.Resume && pool.Config.CheckpointPath == "" {
+		return 0, 0, fmt.Errorf("%s: --resume requires --checkpoint", pool.Name)
+	}
+	if pool.Config.CheckpointPath != "" {
+		first, err = ResumeSegment(pool.Config.CheckpointPath, pool.Config.Resume, pool.Name, segment)
+		if err != nil {
+			return 0, 0, err
+		}
+		if first > segment.Last {
+			fmt.Printf("%s: block segment %v-%v is already completed\n", pool.Name, segment.First, segment.Last)
+			return 0, 0, nil
+		}
+	}
+	checkpoint := &Checkpoint{Name: pool.Name, First: segment.First, Last: segment.Last, Next: first}
+	lastCheckpoint := start
+	saveCheckpoint := func(next uint64) {
+		if pool.Config.CheckpointPath == "" || next == checkpoint.Next {
+			return
+		}
+		checkpoint.Next = next
+		if err := checkpoint.Save(pool.Config.CheckpointPath); err != nil {
+			fmt.Printf("%s: error saving checkpoint %s: %v\n", pool.Name, pool.Config.CheckpointPath, err)
+		}
+		lastCheckpoint = time.Now()
+	}
+
 	var totalNumBlock, totalNumTx int64
 	defer func() {
 		duration := time.Since(start) + 1*time.Nanosecond
 		sec := duration.Seconds()
 
 		nb, nt := atomic.LoadInt64(&totalNumBlock), atomic.LoadInt64(&totalNumTx)
+		numBlock, numTx = nb, nt
 		blkPerSec := float64(nb) / sec
 		txPerSec := float64(nt) / sec
 		fmt.Printf("%s: block segment = %v-%v\n", pool.Name, segment.First, segment.Last)
@@ -192,6 +248,29 @@ func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 		fmt.Printf("%s done in %v\n", pool.Name, duration.Round(1*time.Millisecond))
 	}()
 
+	// skip blocks without substates and block substates by the block index
+	// instead of reading each of them
+	var ranges []*BlockSegment
+	indexed := pool.DB.HasBlockIndex()
+	if indexed {
+		ranges = pool.DB.BlockRanges()
+	}
+	// next returns the first block to execute from the given block, or the
+	// block after segment.Last
+	next := func(block uint64) uint64 {
+		if !indexed {
+			return block
+		}
+		i := sort.Search(len(ranges), func(i int) bool { return ranges[i].Last >= block })
+		if i == len(ranges) || ranges[i].First > segment.Last {
+			return segment.Last + 1
+		}
+		if ranges[i].First > block {
+			return ranges[i].First
+		}
+		return block
+	}
+
 	numWorkers := pool.NumWorkers()
 	// numProcs = numWorkers + work producer (1) + main thread (1)
 	numProcs := numWorkers + 2
@@ -251,7 +330,7 @@ func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 	go func() {
 		defer wg.Done()
 
-		for block := segment.First; block <= segment.Last; block++ {
+		for block := next(first); block <= segment.Last; block = next(block + 1) {
 			select {
 
 			case workChan <- block:
@@ -268,13 +347,25 @@ func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 	var lastSec float64
 	var lastNumBlock, lastNumTx int64
 	waitMap := make(map[uint64]struct{})
-	for block := segment.First; block <= segment.Last; {
+	for block := first; block <= segment.Last; {
 
 		// Count finshed blocks from waitMap in order
 		if _, ok := waitMap[block]; ok {
 			delete(waitMap, block)
 
 			block++
+			if block > segment.Last || time.Since(lastCheckpoint) > CheckpointInterval {
+				saveCheckpoint(block)
+			}
+			continue
+		}
+
+		// Count skipped blocks as finished
+		if n := next(block); n != block {
+			block = n
+			if block > segment.Last || time.Since(lastCheckpoint) > CheckpointInterval {
+				saveCheckpoint(block)
+			}
 			continue
 		}
 
@@ -305,7 +396,8 @@ func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 
 		case error:
 			err := data.(error)
-			return err
+			saveCheckpoint(block)
+			return 0, 0, err
 
 		default:
 			panic(fmt.Errorf("%s: unknown type %T value from doneChan", pool.Name, t))
@@ -313,5 +405,5 @@ func (pool *SubstateTaskPool) ExecuteSegment(segment *BlockSegment) error {
 		}
 	}
 
-	return nil
+	return 0, 0, nil
 }
@@ -0,0 +1,120 @@
+package research
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/protobuf/proto"
+)
+
+func Stage1TraceKey(block uint64, tx int) []byte {
+	prefix := []byte(Stage1TracePrefix)
+
+	blockTx := make([]byte, 16)
+	binary.BigEndian.PutUint64(blockTx[0:8], block)
+	binary.BigEndian.PutUint64(blockTx[8:16], uint64(tx))
+
+	return append(prefix, blockTx...)
+}
+
+func DecodeStage1TraceKey(key []byte) (block uint64, tx int, err error) {
+	prefix := Stage1TracePrefix
+	if len(key) != len(prefix)+8+8 {
+		err = fmt.Errorf("invalid length of stage1 trace key: %v", len(key))
+		return
+	}
+	if p := string(key[:len(prefix)]); p != prefix {
+		err = fmt.Errorf("invalid prefix of stage1 trace key: %#x", p)
+		return
+	}
+	blockTx := key[len(prefix):]
+	block = binary.BigEndian.Uint64(blockTx[0:8])
+	tx = int(binary.BigEndian.Uint64(blockTx[8:16]))
+	return
+}
+
+func Stage1TraceBlockPrefix(block uint64) []byte {
+	prefix := []byte(Stage1TracePrefix)
+
+	blockBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(blockBytes[0:8], block)
+
+	return append(prefix, blockBytes...)
+}
+
+// (*TxTrace).ProtoClone returns a deep copy from proto.Clone
+func (x *TxTrace) ProtoClone() *TxTrace {
+	return proto.Clone(x).(*TxTrace)
+}
+
+// (*TxTrace).ReturnData returns the return data of the transaction, or the
+// revert data if the transaction was reverted
+func (x *TxTrace) ReturnData() []byte {
+	return x.GetCall().GetOutput()
+}
+
+// (*TxTrace).RevertReason returns the reason string of the reverted
+// transaction, or "" if it has no reason string
+func (x *TxTrace) RevertReason() string {
+	return x.GetCall().GetRevertReason()
+}
+
+// (*TxTrace).ContractAddress returns the address of the contract created by
+// the transaction, or nil if it is not a successful contract creation
+func (x *TxTrace) ContractAddress() *common.Address {
+	call := x.GetCall()
+	if call.GetType() != "CREATE" || call.Error != nil {
+		return nil
+	}
+	return BytesValueToAddress(call.GetTo())
+}
+
+// DiffTxTrace returns precise differences between call traces a and b like
+// DiffSubstate, e.g., recorded and replayed call traces.
+func DiffTxTrace(a, b *TxTrace) SubstateDiff {
+	return diffMessage("call", "", a.GetCall().ProtoReflect(), b.GetCall().ProtoReflect())
+}
+
+func (db *SubstateDB) HasTxTrace(block uint64, tx int) bool {
+	has, _ := db.backend.Has(Stage1TraceKey(block, tx))
+	return has
+}
+
+// HasTxTraces returns whether the substate DB has any call traces, i.e., some
+// transactions were recorded with --record-traces
+func (db *SubstateDB) HasTxTraces() bool {
+	iter := db.backend.NewIterator([]byte(Stage1TracePrefix), nil)
+	defer iter.Release()
+	return iter.Next()
+}
+
+// GetTxTrace returns the call trace of the transaction, or nil if the
+// transaction was recorded without call traces
+func (db *SubstateDB) GetTxTrace(block uint64, tx int) *TxTrace {
+	key := Stage1TraceKey(block, tx)
+	if has, _ := db.backend.Has(key); !has {
+		return nil
+	}
+	value, err := db.backend.Get(key)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error getting call trace %v_%v from substate DB: %v", block, tx, err))
+	}
+
+	trace := &TxTrace{}
+	err = proto.Unmarshal(value, trace)
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error decoding call trace %v_%v: %v", block, tx, err))
+	}
+	return trace
+}
+
+func (db *SubstateDB) PutTxTrace(block uint64, tx int, trace *TxTrace) {
+	value, err := proto.Marshal(trace)
+	if err == nil {
+		err = db.backend.Put(Stage1TraceKey(block, tx), value)
+	}
+	if err != nil {
+		panic(fmt.Errorf("record-replay: error putting call trace %v_%v into substate DB: %v", block, tx, err))
+	}
+}
@@ -0,0 +1,133 @@
+package research
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newTestTxTrace() *TxTrace {
+	created := common.HexToAddress("0x02")
+	return &TxTrace{
+		Call: &TxTrace_CallFrame{
+			Type:    proto.String("CREATE"),
+			From:    AddressToBytes(&common.Address{0x01}),
+			To:      AddressToBytesValue(&created),
+			Value:   wrapperspb.Bytes([]byte{}),
+			Gas:     proto.Uint64(100000),
+			GasUsed: proto.Uint64(60000),
+			Input:   []byte{0x60, 0x00},
+			Output:  []byte{0x00},
+			Calls: []*TxTrace_CallFrame{
+				{
+					Type:         proto.String("CALL"),
+					From:         AddressToBytes(&created),
+					To:           wrapperspb.Bytes([]byte{0x03}),
+					Gas:          proto.Uint64(1000),
+					GasUsed:      proto.Uint64(1000),
+					Input:        []byte{},
+					Output:       []byte{},
+					Error:        proto.String("execution reverted"),
+					RevertReason: proto.String("reason"),
+				},
+			},
+		},
+	}
+}
+
+// createSubstate returns a substate of a contract creation with the init code,
+// which deploys contract 0x02 with bytecode 0x00 as in newTestTxTrace
+func createSubstate(block uint64, initCode []byte) *Substate {
+	creator, created := []byte{0x01}, []byte{0x02}
+	return &Substate{
+		InputAlloc: &Substate_Alloc{
+			Alloc: []*Substate_AllocEntry{
+				{Address: creator, Account: &Substate_Account{Nonce: proto.Uint64(0), Balance: []byte{0x10}}},
+			},
+		},
+		OutputAlloc: &Substate_Alloc{
+			Alloc: []*Substate_AllocEntry{
+				{Address: creator, Account: &Substate_Account{Nonce: proto.Uint64(1), Balance: []byte{0x10}}},
+				{Address: created, Account: &Substate_Account{
+					Nonce:    proto.Uint64(1),
+					Balance:  []byte{},
+					Contract: &Substate_Account_Code{Code: []byte{0x00}},
+				}},
+			},
+		},
+		BlockEnv: &Substate_BlockEnv{
+			Coinbase:   []byte{},
+			Difficulty: []byte{},
+			GasLimit:   proto.Uint64(30_000_000),
+			Number:     proto.Uint64(block),
+			Timestamp:  proto.Uint64(block * 12),
+		},
+		TxMessage: &Substate_TxMessage{
+			Nonce:    proto.Uint64(0),
+			GasPrice: []byte{},
+			Gas:      proto.Uint64(100_000),
+			From:     creator,
+			Value:    []byte{},
+			Input:    &Substate_TxMessage_Data{Data: initCode},
+			TxType:   Substate_TxMessage_TXTYPE_LEGACY.Enum(),
+		},
+		Result: &Substate_Result{
+			Status:  proto.Uint64(1),
+			Bloom:   make([]byte, 256),
+			GasUsed: proto.Uint64(60_000),
+		},
+	}
+}
+
+func TestTxTraceDB(t *testing.T) {
+	db := NewSubstateDB(rawdb.NewMemoryDatabase())
+	defer db.Close()
+
+	trace := newTestTxTrace()
+
+	db.PutSubstate(3, 0, createSubstate(3, trace.Call.Input))
+	if db.HasTxTraces() || db.HasTxTrace(3, 0) || db.GetTxTrace(3, 0) != nil {
+		t.Fatal("call trace found without --record-traces")
+	}
+	db.PutTxTrace(3, 0, trace)
+	if !db.HasTxTraces() || !db.HasTxTrace(3, 0) {
+		t.Fatal("call trace is not put")
+	}
+	got := db.GetTxTrace(3, 0)
+	if !proto.Equal(got, trace) {
+		t.Fatalf("call trace mismatch:\n%s", DiffTxTrace(trace, got))
+	}
+	// call trace is not a tx substate
+	if n := len(db.GetBlockSubstates(3)); n != 1 {
+		t.Fatalf("GetBlockSubstates(3) returned %v substates, want 1", n)
+	}
+
+	if addr := got.ContractAddress(); addr == nil || *addr != common.HexToAddress("0x02") {
+		t.Fatalf("ContractAddress = %v, want 0x02", addr)
+	}
+	if got.RevertReason() != "" || len(got.ReturnData()) != 1 {
+		t.Fatalf("unexpected revert reason %q or return data %x", got.RevertReason(), got.ReturnData())
+	}
+
+	if block, tx, err := DecodeStage1TraceKey(Stage1TraceKey(3, 1)); err != nil || block != 3 || tx != 1 {
+		t.Fatalf("DecodeStage1TraceKey = %v, %v, %v", block, tx, err)
+	}
+	if _, _, err := DecodeStage1TraceKey(Stage1SubstateKey(3, 1)); err == nil {
+		t.Fatal("error is not raised for substate key")
+	}
+
+	replayed := trace.ProtoClone()
+	replayed.Call.Calls[0].GasUsed = proto.Uint64(999)
+	d := DiffTxTrace(trace, replayed)
+	if len(d) != 1 || d[0].Path != "calls[0].gasUsed" {
+		t.Fatalf("unexpected differences:\n%s", d)
+	}
+
+	db.DeleteBlockSubstates(3)
+	if db.HasTxTrace(3, 0) || db.HasSubstate(3, 0) {
+		t.Fatal("call traces of block 3 are not deleted")
+	}
+}
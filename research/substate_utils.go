@@ -199,21 +199,20 @@ func (x *Substate) HashKeys() map[common.Hash]struct{} {
 
 	z := make(map[common.Hash]struct{})
 
-	for _, entry := range x.InputAlloc.Alloc {
-		account := entry.Account
-		if codeHash := BytesToHash(account.GetCodeHash()); codeHash != nil {
+	// getters are used for substates decoded without required fields
+	for _, entry := range x.GetInputAlloc().GetAlloc() {
+		if codeHash := BytesToHash(entry.GetAccount().GetCodeHash()); codeHash != nil {
 			z[*codeHash] = struct{}{}
 		}
 	}
 
-	for _, entry := range x.OutputAlloc.Alloc {
-		account := entry.Account
-		if codeHash := BytesToHash(account.GetCodeHash()); codeHash != nil {
+	for _, entry := range x.GetOutputAlloc().GetAlloc() {
+		if codeHash := BytesToHash(entry.GetAccount().GetCodeHash()); codeHash != nil {
 			z[*codeHash] = struct{}{}
 		}
 	}
 
-	if codeHash := BytesToHash(x.TxMessage.GetInitCodeHash()); codeHash != nil {
+	if codeHash := BytesToHash(x.GetTxMessage().GetInitCodeHash()); codeHash != nil {
 		z[*codeHash] = struct{}{}
 	}
 
@@ -377,34 +376,36 @@ func (rr *ResearchReceipt) LoadSubstate(substate *Substate) {
 	rr.GasUsed = *re.GasUsed
 }
 
+// EqualResult returns whether results x and y are semantically equal.
+// Log addresses and topics are compared as common.Address and common.Hash.
 func EqualResult(x, y *Substate_Result) bool {
 	if x == y {
 		return true
 	}
 
-	if (x == nil || y != nil) || (x != nil && y == nil) {
+	if x == nil || y == nil {
 		return false
 	}
 
-	eq := *x.Status == *y.Status ||
-		bytes.Equal(x.Bloom, y.Bloom) ||
-		len(x.Logs) == len(y.Logs) ||
-		*x.GasUsed == *y.GasUsed
+	eq := x.GetStatus() == y.GetStatus() &&
+		bytes.Equal(x.Bloom, y.Bloom) &&
+		len(x.Logs) == len(y.Logs) &&
+		x.GetGasUsed() == y.GetGasUsed()
 	if !eq {
 		return false
 	}
 
 	for i, xl := range x.Logs {
 		yl := y.Logs[i]
-		eq = bytes.Equal(xl.Address, yl.Address) ||
-			len(xl.Topics) == len(yl.Topics) ||
+		eq = common.BytesToAddress(xl.Address) == common.BytesToAddress(yl.Address) &&
+			len(xl.Topics) == len(yl.Topics) &&
 			bytes.Equal(xl.Data, yl.Data)
 		if !eq {
 			return false
 		}
 		for j, xt := range xl.Topics {
 			yt := yl.Topics[j]
-			if !bytes.Equal(xt, yt) {
+			if common.BytesToHash(xt) != common.BytesToHash(yt) {
 				return false
 			}
 		}
@@ -412,3 +413,56 @@ func EqualResult(x, y *Substate_Result) bool {
 
 	return true
 }
+
+// EqualAccount returns whether accounts x and y are semantically equal.
+// Balances with leading zeros are equal, empty and nil storage are equal,
+// and bytecode and its code hash are equal.
+func EqualAccount(x, y *Substate_Account) bool {
+	if x == y {
+		return true
+	}
+
+	if x == nil || y == nil {
+		return false
+	}
+
+	eq := x.GetNonce() == y.GetNonce() &&
+		new(big.Int).SetBytes(x.Balance).Cmp(new(big.Int).SetBytes(y.Balance)) == 0 &&
+		accountCodeHash(x) == accountCodeHash(y) &&
+		len(x.Storage) == len(y.Storage)
+	if !eq {
+		return false
+	}
+
+	xs, ys := storageMap(x), storageMap(y)
+	if len(xs) != len(ys) {
+		return false
+	}
+	for k, xv := range xs {
+		if yv, ok := ys[k]; !ok || xv != yv {
+			return false
+		}
+	}
+
+	return true
+}
+
+// EqualAlloc returns whether allocs x and y have semantically equal accounts
+// regardless of the order of accounts. Nil and empty allocs are equal.
+func EqualAlloc(x, y *Substate_Alloc) bool {
+	if len(x.GetAlloc()) != len(y.GetAlloc()) {
+		return false
+	}
+
+	xm, ym := allocMap(x), allocMap(y)
+	if len(xm) != len(ym) {
+		return false
+	}
+	for addr, xa := range xm {
+		if ya, ok := ym[addr]; !ok || !EqualAccount(xa, ya) {
+			return false
+		}
+	}
+
+	return true
+}
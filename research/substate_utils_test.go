@@ -0,0 +1,134 @@
+package research
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEqualResult(t *testing.T) {
+	newResult := func() *Substate_Result {
+		return &Substate_Result{
+			Status: proto.Uint64(1),
+			Bloom:  make([]byte, 256),
+			Logs: []*Substate_Result_Log{
+				{Address: []byte{0x02}, Topics: [][]byte{{0x01}, {0x02}}, Data: []byte{0x03}},
+			},
+			GasUsed: proto.Uint64(21_000),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *Substate_Result) *Substate_Result
+		equal  bool
+	}{
+		{"same", func(r *Substate_Result) *Substate_Result { return r }, true},
+		{"nil", func(r *Substate_Result) *Substate_Result { return nil }, false},
+		{"status", func(r *Substate_Result) *Substate_Result { r.Status = proto.Uint64(0); return r }, false},
+		{"gas used", func(r *Substate_Result) *Substate_Result { r.GasUsed = proto.Uint64(21_001); return r }, false},
+		{"bloom", func(r *Substate_Result) *Substate_Result { r.Bloom[0] = 1; return r }, false},
+		{"no logs", func(r *Substate_Result) *Substate_Result { r.Logs = nil; return r }, false},
+		{"log address", func(r *Substate_Result) *Substate_Result { r.Logs[0].Address = []byte{0x03}; return r }, false},
+		{"log topic", func(r *Substate_Result) *Substate_Result { r.Logs[0].Topics[1] = []byte{0x03}; return r }, false},
+		{"log topics", func(r *Substate_Result) *Substate_Result { r.Logs[0].Topics = r.Logs[0].Topics[:1]; return r }, false},
+		{"log data", func(r *Substate_Result) *Substate_Result { r.Logs[0].Data = []byte{}; return r }, false},
+		{"padded log address", func(r *Substate_Result) *Substate_Result {
+			r.Logs[0].Address = make([]byte, 20)
+			r.Logs[0].Address[19] = 0x02
+			return r
+		}, true},
+	}
+	for _, tt := range tests {
+		x, y := newResult(), tt.modify(newResult())
+		if got := EqualResult(x, y); got != tt.equal {
+			t.Errorf("%s: EqualResult(x, y) = %v, want %v", tt.name, got, tt.equal)
+		}
+		if got := EqualResult(y, x); got != tt.equal {
+			t.Errorf("%s: EqualResult(y, x) = %v, want %v", tt.name, got, tt.equal)
+		}
+	}
+	if !EqualResult(nil, nil) {
+		t.Error("EqualResult(nil, nil) = false")
+	}
+}
+
+func TestEqualAlloc(t *testing.T) {
+	code := []byte{0x60, 0x00}
+	// an account and a contract with a storage slot
+	newAlloc := func() *Substate_Alloc {
+		return &Substate_Alloc{
+			Alloc: []*Substate_AllocEntry{
+				{Address: []byte{0x01}, Account: &Substate_Account{Nonce: proto.Uint64(2), Balance: []byte{0x0f}}},
+				{Address: []byte{0x02}, Account: &Substate_Account{
+					Nonce:    proto.Uint64(1),
+					Balance:  []byte{0x01},
+					Storage:  []*Substate_Account_StorageEntry{{Key: []byte{0x01}, Value: []byte{0x03}}},
+					Contract: &Substate_Account_Code{Code: code},
+				}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(a *Substate_Alloc) *Substate_Alloc
+		equal  bool
+	}{
+		{"same", func(a *Substate_Alloc) *Substate_Alloc { return a }, true},
+		{"reordered", func(a *Substate_Alloc) *Substate_Alloc {
+			a.Alloc[0], a.Alloc[1] = a.Alloc[1], a.Alloc[0]
+			return a
+		}, true},
+		{"leading-zero balance", func(a *Substate_Alloc) *Substate_Alloc {
+			a.Alloc[0].Account.Balance = []byte{0x00, 0x00, 0x0f}
+			return a
+		}, true},
+		{"hashed code", func(a *Substate_Alloc) *Substate_Alloc {
+			a.Alloc[1].Account.Contract = &Substate_Account_CodeHash{CodeHash: CodeHash(code).Bytes()}
+			return a
+		}, true},
+		{"balance", func(a *Substate_Alloc) *Substate_Alloc { a.Alloc[0].Account.Balance = []byte{0x10}; return a }, false},
+		{"nonce", func(a *Substate_Alloc) *Substate_Alloc { a.Alloc[0].Account.Nonce = proto.Uint64(3); return a }, false},
+		{"code", func(a *Substate_Alloc) *Substate_Alloc {
+			a.Alloc[1].Account.Contract = &Substate_Account_Code{Code: []byte{0x00}}
+			return a
+		}, false},
+		{"no code", func(a *Substate_Alloc) *Substate_Alloc { a.Alloc[1].Account.Contract = nil; return a }, false},
+		{"storage value", func(a *Substate_Alloc) *Substate_Alloc {
+			a.Alloc[1].Account.Storage[0].Value = []byte{0x04}
+			return a
+		}, false},
+		{"storage key", func(a *Substate_Alloc) *Substate_Alloc {
+			a.Alloc[1].Account.Storage[0].Key = []byte{0x02}
+			return a
+		}, false},
+		{"no storage", func(a *Substate_Alloc) *Substate_Alloc { a.Alloc[1].Account.Storage = nil; return a }, false},
+		{"removed account", func(a *Substate_Alloc) *Substate_Alloc { a.Alloc = a.Alloc[:1]; return a }, false},
+		{"replaced account", func(a *Substate_Alloc) *Substate_Alloc { a.Alloc[0].Address = []byte{0x05}; return a }, false},
+		{"nil", func(a *Substate_Alloc) *Substate_Alloc { return nil }, false},
+	}
+	for _, tt := range tests {
+		x, y := newAlloc(), tt.modify(newAlloc())
+		if got := EqualAlloc(x, y); got != tt.equal {
+			t.Errorf("%s: EqualAlloc(x, y) = %v, want %v", tt.name, got, tt.equal)
+		}
+		if got := EqualAlloc(y, x); got != tt.equal {
+			t.Errorf("%s: EqualAlloc(y, x) = %v, want %v", tt.name, got, tt.equal)
+		}
+		// DiffAlloc reports differences if and only if allocs are not equal
+		if got := DiffAlloc("alloc", x, y).Equal(); got != tt.equal {
+			t.Errorf("%s: DiffAlloc(x, y).Equal() = %v, want %v", tt.name, got, tt.equal)
+		}
+	}
+
+	// empty and nil storage
+	x := &Substate_Account{Nonce: proto.Uint64(0), Balance: []byte{}, Storage: []*Substate_Account_StorageEntry{}}
+	y := &Substate_Account{Nonce: proto.Uint64(0), Balance: nil}
+	if !EqualAccount(x, y) {
+		t.Error("accounts with empty and nil storage are not equal")
+	}
+	if !EqualAlloc(nil, &Substate_Alloc{}) {
+		t.Error("nil and empty allocs are not equal")
+	}
+}
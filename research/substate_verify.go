@@ -0,0 +1,180 @@
+package research
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kinds of SubstateVerifyIssue
+const (
+	VerifyInvalidKey   = "invalid-key"        // key cannot be decoded
+	VerifyInvalidValue = "invalid-value"      // value cannot be decoded
+	VerifyMissingField = "missing-field"      // required proto field is not set
+	VerifyMissingCode  = "missing-code"       // code hash has no "1c" entry
+	VerifyCodeHash     = "code-hash-mismatch" // "1c" value does not hash to its key
+	VerifyOrphanedCode = "orphaned-code"      // "1c" entry is not referenced by any substate
+)
+
+// SubstateVerifyIssue is a structural problem of a key-value pair in substate DB
+type SubstateVerifyIssue struct {
+	Key    []byte
+	Kind   string
+	Detail string
+}
+
+func (i *SubstateVerifyIssue) String() string {
+	return fmt.Sprintf("%#x: %s: %s", i.Key, i.Kind, i.Detail)
+}
+
+// SubstateVerifier checks structural integrity of substate DB without
+// executing transactions. Substates, block substates and call traces are
+// checked by VerifySegment, and bytecodes by VerifyCode.
+type SubstateVerifier struct {
+	db *SubstateDB
+
+	// Report is called with each issue if not nil
+	Report func(issue *SubstateVerifyIssue)
+	// Issues counts issues by their kinds
+	Issues map[string]int
+
+	NumSubstates      int
+	NumBlockSubstates int
+	NumTraces         int
+	NumCodes          int
+
+	hasCode map[common.Hash]bool // cached results of HasCode
+}
+
+func NewSubstateVerifier(db *SubstateDB) *SubstateVerifier {
+	return &SubstateVerifier{
+		db:      db,
+		Issues:  make(map[string]int),
+		hasCode: make(map[common.Hash]bool),
+	}
+}
+
+// NumIssues returns the total number of issues found so far
+func (v *SubstateVerifier) NumIssues() int {
+	n := 0
+	for _, count := range v.Issues {
+		n += count
+	}
+	return n
+}
+
+func (v *SubstateVerifier) report(key []byte, kind string, format string, args ...interface{}) {
+	v.Issues[kind]++
+	if v.Report != nil {
+		v.Report(&SubstateVerifyIssue{Key: common.CopyBytes(key), Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+}
+
+// verifyValue decodes a value into m and checks its required fields and
+// referenced bytecodes
+func (v *SubstateVerifier) verifyValue(key, value []byte, m proto.Message) {
+	if err := (proto.UnmarshalOptions{AllowPartial: true}).Unmarshal(value, m); err != nil {
+		v.report(key, VerifyInvalidValue, "%v", err)
+		return
+	}
+	if err := proto.CheckInitialized(m); err != nil {
+		v.report(key, VerifyMissingField, "%v", err)
+	}
+	hashed, ok := m.(hashedMessage)
+	if !ok {
+		return
+	}
+	for codeHash := range hashed.HashKeys() {
+		if codeHash == EmptyCodeHash {
+			continue
+		}
+		has, ok := v.hasCode[codeHash]
+		if !ok {
+			has = v.db.HasCode(codeHash)
+			v.hasCode[codeHash] = has
+		}
+		if !has {
+			v.report(key, VerifyMissingCode, "no bytecode of code hash %s", codeHash.Hex())
+		}
+	}
+}
+
+// VerifySegment checks keys, required fields and referenced bytecodes of
+// substates, block substates and call traces of blocks in the segment
+func (v *SubstateVerifier) VerifySegment(segment *BlockSegment) error {
+	err := v.db.iterateSegment(Stage1SubstatePrefix, segment, func(key, value []byte) error {
+		v.NumSubstates++
+		if _, _, err := DecodeStage1SubstateKey(key); err != nil {
+			v.report(key, VerifyInvalidKey, "%v", err)
+		}
+		v.verifyValue(key, value, &Substate{})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error iterating substates: %w", err)
+	}
+
+	err = v.db.iterateSegment(Stage1BlockPrefix, segment, func(key, value []byte) error {
+		v.NumBlockSubstates++
+		if _, err := DecodeStage1BlockKey(key); err != nil {
+			v.report(key, VerifyInvalidKey, "%v", err)
+		}
+		v.verifyValue(key, value, &BlockSubstate{})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error iterating block substates: %w", err)
+	}
+
+	err = v.db.iterateSegment(Stage1TracePrefix, segment, func(key, value []byte) error {
+		v.NumTraces++
+		if _, _, err := DecodeStage1TraceKey(key); err != nil {
+			v.report(key, VerifyInvalidKey, "%v", err)
+		}
+		v.verifyValue(key, value, &TxTrace{})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error iterating call traces: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyCode checks that all bytecodes hash to their keys. If orphans is true,
+// it also finds bytecodes that no substates and block substates reference by
+// ReferencedCodeHashes, which fails if any of them cannot be decoded.
+func (v *SubstateVerifier) VerifyCode(orphans bool) error {
+	var referenced map[common.Hash]struct{}
+	if orphans {
+		var err error
+		referenced, err = v.db.ReferencedCodeHashes()
+		if err != nil {
+			return fmt.Errorf("error finding referenced bytecodes: %w", err)
+		}
+	}
+
+	iter := v.db.backend.NewIterator([]byte(Stage1CodePrefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		v.NumCodes++
+		codeHash, err := DecodeStage1CodeKey(key)
+		if err != nil {
+			v.report(key, VerifyInvalidKey, "%v", err)
+			continue
+		}
+		if h := CodeHash(value); h != codeHash {
+			v.report(key, VerifyCodeHash, "bytecode hashes to %s", h.Hex())
+		}
+		if _, ok := referenced[codeHash]; orphans && !ok {
+			v.report(key, VerifyOrphanedCode, "bytecode of %v bytes is not referenced by any substate", len(value))
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("error iterating bytecodes: %w", err)
+	}
+
+	return nil
+}
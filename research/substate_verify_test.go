@@ -0,0 +1,73 @@
+package research
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSubstateVerifier(t *testing.T) {
+	backend := rawdb.NewMemoryDatabase()
+	db := NewSubstateDB(backend)
+	defer db.Close()
+
+	code1, code2 := []byte{0x60, 0x01}, []byte{0x60, 0x02}
+	db.PutSubstate(1, 0, callSubstate(1, code1))
+	db.PutSubstate(2, 0, callSubstate(2, code2))
+	db.PutBlockSubstate(2, newTestBlockSubstate(2, code1))
+	db.PutTxTrace(2, 0, newTestTxTrace())
+
+	verify := func(segment *BlockSegment, orphans bool) *SubstateVerifier {
+		v := NewSubstateVerifier(db)
+		v.Report = func(issue *SubstateVerifyIssue) { t.Log(issue) }
+		if err := v.VerifySegment(segment); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.VerifyCode(orphans); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	v := verify(NewBlockSegment(1, 2), true)
+	if v.NumIssues() != 0 {
+		t.Fatalf("%v issues found in valid substate DB", v.NumIssues())
+	}
+	if v.NumSubstates != 2 || v.NumBlockSubstates != 1 || v.NumTraces != 1 || v.NumCodes != 2 {
+		t.Fatalf("verified %v substates, %v block substates, %v call traces, %v bytecodes",
+			v.NumSubstates, v.NumBlockSubstates, v.NumTraces, v.NumCodes)
+	}
+
+	// missing bytecode of block 2, bytecode with wrong hash, orphaned bytecode
+	backend.Delete(Stage1CodeKey(CodeHash(code2)))
+	backend.Put(Stage1CodeKey(CodeHash(code1)), code2)
+	backend.Put(Stage1CodeKey(CodeHash([]byte{0x60, 0x03})), []byte{0x60, 0x03})
+	// invalid key, value without required fields, value that cannot be decoded
+	value, _ := backend.Get(Stage1SubstateKey(1, 0))
+	backend.Put(append(Stage1SubstateBlockPrefix(3), 0x00), value)
+	partial, _ := proto.MarshalOptions{AllowPartial: true}.Marshal(&Substate{InputAlloc: &Substate_Alloc{}})
+	backend.Put(Stage1SubstateKey(3, 1), partial)
+	backend.Put(Stage1TraceKey(3, 0), []byte{0xff})
+
+	v = verify(NewBlockSegment(2, 3), true)
+	want := map[string]int{
+		VerifyMissingCode:  1,
+		VerifyCodeHash:     1,
+		VerifyOrphanedCode: 1,
+		VerifyInvalidKey:   1,
+		VerifyMissingField: 1,
+		VerifyInvalidValue: 1,
+	}
+	for kind, n := range want {
+		if v.Issues[kind] != n {
+			t.Errorf("%v %s issues, want %v", v.Issues[kind], kind, n)
+		}
+	}
+
+	// substates out of the segment are not verified, orphans are not found
+	v = verify(NewBlockSegment(1, 1), false)
+	if v.NumIssues() != 1 || v.Issues[VerifyCodeHash] != 1 {
+		t.Fatalf("unexpected issues of block 1: %v", v.Issues)
+	}
+}